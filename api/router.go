@@ -1,7 +1,11 @@
 package api
 
 import (
+	"github.com/SlpAus/noita-spells-tier-backend/internal/account"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/achievement"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/leaderboard"
 	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/config"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/ratelimit"
 	"github.com/SlpAus/noita-spells-tier-backend/internal/report"
 	"github.com/SlpAus/noita-spells-tier-backend/internal/spell"
 	"github.com/SlpAus/noita-spells-tier-backend/internal/user"
@@ -26,13 +30,27 @@ func SetupRoutes(router *gin.Engine, cfg config.AppConfig) {
 			// 候选人相关的路由组
 			spellRoutes.GET("/ranking", spell.GetRanking)
 			spellRoutes.GET("/:id", spell.GetSpellByID)
-			spellRoutes.GET("/pair", user.EnsureUserCookieMiddleware(), spell.GetSpellPair)
+			spellRoutes.GET("/pair", ratelimit.PairMiddleware(), user.EnsureUserCookieMiddleware(), vote.PairChallengeMiddleware(), spell.GetSpellPair)
 
 			// 投票相关的路由
 			spellRoutes.POST("/vote", user.LoadUserMiddleware(), vote.SubmitVote)
+			spellRoutes.POST("/vote/undo", user.LoadUserMiddleware(), vote.UndoVote)
 
 			// 报告相关的路由
 			spellRoutes.GET("/report", user.LoadUserMiddleware(), report.GetReport)
+
+			// 排行榜相关的路由
+			spellRoutes.GET("/leaderboard", user.LoadUserMiddleware(), leaderboard.GetLeaderboard)
+			spellRoutes.PUT("/me/nickname", user.LoadUserMiddleware(), leaderboard.SetMyNickname)
+			spellRoutes.DELETE("/me/nickname", user.LoadUserMiddleware(), leaderboard.ClearMyNickname)
+
+			// 用户身份相关的路由
+			spellRoutes.POST("/me/recovery-code", user.LoadUserMiddleware(), account.IssueRecoveryCode)
+			spellRoutes.POST("/me/recover", user.LoadUserMiddleware(), account.RedeemRecoveryCode)
+			spellRoutes.GET("/me/votes", user.LoadUserMiddleware(), account.GetMyVotes)
+			spellRoutes.GET("/me/achievements", user.LoadUserMiddleware(), achievement.GetMyAchievements)
+			spellRoutes.GET("/me/export", user.LoadUserMiddleware(), account.ExportMyData)
+			spellRoutes.DELETE("/me", user.LoadUserMiddleware(), account.DeleteMyData)
 		}
 	}
 }
@@ -127,10 +127,8 @@ func preprocessSpells(appCfg config.AppConfig) ([]spell.Spell, error) {
 }
 
 func dropUserTablesExcept(db *gorm.DB, tablesToKeep []string) error {
-	// 1. 获取数据库中所有表的名称
-	var tableNames []string
-	// 避开SQLite的元数据
-	err := db.Raw("SELECT name FROM sqlite_master WHERE type='table' AND name NOT LIKE 'sqlite_%';").Scan(&tableNames).Error
+	// 1. 获取数据库中所有表的名称，PostgreSQL只列出当前schema中的表
+	tableNames, err := db.Migrator().GetTables()
 	if err != nil {
 		return fmt.Errorf("无法获取表列表: %w", err)
 	}
@@ -143,6 +141,10 @@ func dropUserTablesExcept(db *gorm.DB, tablesToKeep []string) error {
 
 	// 3. 遍历所有表，如果不在保留列表中，则使用Migrator删除它
 	for _, tableName := range tableNames {
+		// 避开SQLite的元数据
+		if strings.HasPrefix(tableName, "sqlite_") {
+			continue
+		}
 		if !keepMap[tableName] {
 			fmt.Printf("正在删除表: %s\n", tableName)
 			if err := db.Migrator().DropTable(tableName); err != nil {
@@ -178,7 +180,7 @@ func parseTableName(db *gorm.DB, model interface{}) (string, error) {
 }
 
 // buildDatabase 使用处理好的法术/天赋数据填充数据库
-func buildDatabase(appCfg config.AppConfig, dbCfg config.SqliteConfig) {
+func buildDatabase(appCfg config.AppConfig, dbCfg config.DatabaseConfig) {
 	fmt.Println("开始构建数据库...")
 	dbSpells, err := preprocessSpells(appCfg)
 	if err != nil {
@@ -207,7 +209,7 @@ func buildDatabase(appCfg config.AppConfig, dbCfg config.SqliteConfig) {
 }
 
 // cleanDatabase 重置所有法术/天赋的分数和战绩
-func cleanDatabase(dbCfg config.SqliteConfig) {
+func cleanDatabase(dbCfg config.DatabaseConfig) {
 	fmt.Println("开始重置数据库...")
 	database.InitDB(dbCfg)
 
@@ -239,7 +241,7 @@ func cleanDatabase(dbCfg config.SqliteConfig) {
 }
 
 // extendDatabase 保留数据库中的动态数据，使用处理好的数据更新静态字段或拓展新条目
-func extendDatabase(appCfg config.AppConfig, dbCfg config.SqliteConfig) {
+func extendDatabase(appCfg config.AppConfig, dbCfg config.DatabaseConfig) {
 	fmt.Println("开始构建数据库...")
 	dbSpells, err := preprocessSpells(appCfg)
 	if err != nil {
@@ -277,11 +279,11 @@ func main() {
 
 	switch *task {
 	case "build":
-		buildDatabase(config.App, config.Database.Sqlite)
+		buildDatabase(config.App, config.Database)
 	case "clean":
-		cleanDatabase(config.Database.Sqlite)
+		cleanDatabase(config.Database)
 	case "extend":
-		extendDatabase(config.App, config.Database.Sqlite)
+		extendDatabase(config.App, config.Database)
 	default:
 		fmt.Println("未知的任务:", *task)
 		fmt.Println("可用任务: 'build', 'clean', 'extend'")
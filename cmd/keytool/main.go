@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/config"
+	"github.com/SlpAus/noita-spells-tier-backend/pkg/token"
+)
+
+// resolveKeyFile 确定要操作的密钥环文件路径：优先使用命令行参数，否则读取配置文件
+func resolveKeyFile(flagValue string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatalf("加载配置失败: %v", err)
+	}
+	if cfg.Token.KeyFile == "" {
+		log.Fatalf("未指定 -file，且配置中的 token.keyFile 为空")
+	}
+	return cfg.Token.KeyFile
+}
+
+// initKeyring 创建一个只包含一把活跃密钥的新密钥环文件
+func initKeyring(path string) {
+	if _, err := os.Stat(path); err == nil {
+		log.Fatalf("密钥环文件 %s 已存在，拒绝覆盖", path)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		log.Fatalf("无法检查密钥环文件 %s: %v", path, err)
+	}
+
+	key, err := token.NewKey()
+	if err != nil {
+		log.Fatalf("生成密钥失败: %v", err)
+	}
+	kf := &token.KeyringFile{ActiveKeyID: key.ID, Keys: []token.Key{key}}
+	if err := token.SaveKeyringFile(path, kf); err != nil {
+		log.Fatalf("保存密钥环失败: %v", err)
+	}
+	fmt.Printf("已创建密钥环 %s，活跃密钥: %s\n", path, key.ID)
+}
+
+// addKey 向密钥环加入一把仅用于验证的新密钥，它是两阶段轮换的第一步
+func addKey(path string) {
+	kf := mustLoad(path)
+	key, err := token.NewKey()
+	if err != nil {
+		log.Fatalf("生成密钥失败: %v", err)
+	}
+	kf.Add(key)
+	mustSave(path, kf)
+	fmt.Printf("已添加验证密钥: %s\n", key.ID)
+	fmt.Println("待所有实例重新加载密钥环后，再使用 -task=activate 启用它。")
+}
+
+// activateKey 将指定的密钥设为活跃密钥，它是两阶段轮换的第二步
+func activateKey(path, id string) {
+	if id == "" {
+		log.Fatalf("activate 任务需要 -id 参数")
+	}
+	kf := mustLoad(path)
+	if err := kf.Activate(id); err != nil {
+		log.Fatalf("启用密钥失败: %v", err)
+	}
+	mustSave(path, kf)
+	fmt.Printf("活跃密钥已切换为: %s\n", id)
+}
+
+// rotateKey 生成一把新密钥并立即启用，旧密钥保留用于验证。适用于单实例部署。
+func rotateKey(path string) {
+	kf := mustLoad(path)
+	key, err := token.NewKey()
+	if err != nil {
+		log.Fatalf("生成密钥失败: %v", err)
+	}
+	kf.Add(key)
+	if err := kf.Activate(key.ID); err != nil {
+		log.Fatalf("启用密钥失败: %v", err)
+	}
+	mustSave(path, kf)
+	fmt.Printf("密钥已轮换，新的活跃密钥: %s\n", key.ID)
+}
+
+// retireKey 移除一把不再需要的验证密钥
+func retireKey(path, id string) {
+	if id == "" {
+		log.Fatalf("retire 任务需要 -id 参数")
+	}
+	kf := mustLoad(path)
+	if err := kf.Retire(id); err != nil {
+		log.Fatalf("移除密钥失败: %v", err)
+	}
+	mustSave(path, kf)
+	fmt.Printf("已移除密钥: %s\n", id)
+}
+
+// listKeys 打印密钥环中的所有密钥（不含密钥内容）
+func listKeys(path string) {
+	kf := mustLoad(path)
+	for _, k := range kf.Keys {
+		marker := " "
+		if k.ID == kf.ActiveKeyID {
+			marker = "*"
+		}
+		fmt.Printf("%s %s  (创建于 %s)\n", marker, k.ID, k.CreatedAt.Format("2006-01-02 15:04:05"))
+	}
+}
+
+func mustLoad(path string) *token.KeyringFile {
+	kf, err := token.LoadKeyringFile(path)
+	if err != nil {
+		log.Fatalf("加载密钥环失败: %v", err)
+	}
+	return kf
+}
+
+func mustSave(path string, kf *token.KeyringFile) {
+	if err := token.SaveKeyringFile(path, kf); err != nil {
+		log.Fatalf("保存密钥环失败: %v", err)
+	}
+}
+
+func main() {
+	task := flag.String("task", "list", "要执行的任务: 'init', 'add', 'activate', 'rotate', 'retire' 或 'list'")
+	file := flag.String("file", "", "密钥环文件路径，默认使用配置中的 token.keyFile")
+	id := flag.String("id", "", "activate 和 retire 任务要操作的密钥ID")
+	flag.Parse()
+
+	path := resolveKeyFile(*file)
+
+	switch *task {
+	case "init":
+		initKeyring(path)
+	case "add":
+		addKey(path)
+	case "activate":
+		activateKey(path, *id)
+	case "rotate":
+		rotateKey(path)
+	case "retire":
+		retireKey(path, *id)
+	case "list":
+		listKeys(path)
+	default:
+		fmt.Println("未知的任务:", *task)
+		fmt.Println("可用任务: 'init', 'add', 'activate', 'rotate', 'retire', 'list'")
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/backup"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/config"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/vote"
+)
+
+// resolveBackup 确定要操作的备份文件：优先使用命令行参数，否则使用最新的备份
+func resolveBackup(flagValue string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	backups, err := backup.ListFileBackups()
+	if err != nil {
+		log.Fatalf("列出备份失败: %v", err)
+	}
+	if len(backups) == 0 {
+		log.Fatalf("备份目录中没有可用的备份")
+	}
+	return backups[0].Path
+}
+
+// listBackups 打印备份目录中的所有完整备份，最新的在前
+func listBackups() {
+	backups, err := backup.ListFileBackups()
+	if err != nil {
+		log.Fatalf("列出备份失败: %v", err)
+	}
+	for _, b := range backups {
+		fmt.Printf("%s  %s  %.1f MiB\n", b.CreatedAt.Local().Format("2006-01-02 15:04:05"), b.Path, float64(b.Size)/(1<<20))
+	}
+}
+
+// verifyBackup 校验备份文件本身及其引用的投票归档文件
+func verifyBackup(path string) backup.BackupInfo {
+	info, err := backup.VerifyFileBackup(path)
+	if err != nil {
+		log.Fatalf("备份校验失败: %v", err)
+	}
+	db, err := backup.OpenFileBackup(path)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	archives, err := vote.VerifyArchives(db)
+	if sqlDB, dbErr := db.DB(); dbErr == nil {
+		sqlDB.Close()
+	}
+	if err != nil {
+		log.Fatalf("备份引用的投票归档不可用: %v", err)
+	}
+	fmt.Printf("备份 %s 校验通过: 快照截至投票 %d (%s)，引用 %d 个归档文件\n",
+		path, info.LastSnapshotVoteID, info.LastSnapshotTime.Local().Format("2006-01-02 15:04:05"), archives)
+	return info
+}
+
+// restoreBackup 把一个经过校验的备份放到数据库文件的位置，原数据库文件被重命名保留
+func restoreBackup(path, fileName string) {
+	// 1. 持有数据库文件锁，确保没有正在运行的服务器
+	lock, err := database.LockSqliteFile(fileName)
+	if errors.Is(err, database.ErrDatabaseInUse) {
+		log.Fatalf("数据库 %s 正被运行中的服务器使用，请先停止服务器", fileName)
+	}
+	if err != nil {
+		log.Fatalf("无法锁定数据库文件: %v", err)
+	}
+	defer lock.Close()
+
+	// 2. 找回备份引用、但已被改写替换的归档文件，再校验备份
+	restored, err := backup.RestoreArchiveFiles(path)
+	if err != nil {
+		log.Fatalf("恢复归档文件失败: %v", err)
+	}
+	if restored > 0 {
+		fmt.Printf("已从备份目录恢复 %d 个归档文件\n", restored)
+	}
+	verifyBackup(path)
+
+	// 3. 放置备份文件
+	previous, err := backup.RestoreFileBackup(path, fileName, time.Now())
+	if err != nil {
+		log.Fatalf("恢复数据库失败: %v", err)
+	}
+
+	fmt.Printf("已从 %s 恢复数据库 %s，原数据库文件已保留为 %s\n", path, fileName, previous)
+	fmt.Println("服务器启动时会从恢复后的快照重建Redis缓存。")
+}
+
+func main() {
+	task := flag.String("task", "list", "要执行的任务: 'list', 'verify' 或 'restore'")
+	file := flag.String("file", "", "要校验或恢复的备份文件，默认使用最新的备份")
+	flag.Parse()
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatalf("加载配置失败: %v", err)
+	}
+	if cfg.Database.Driver != config.DatabaseDriverSqlite {
+		log.Fatalf("数据库文件备份仅适用于SQLite，PostgreSQL请使用 pg_dump / pg_restore")
+	}
+	backup.ConfigureFileBackups(cfg.Database.Sqlite, cfg.Vote.Archive)
+	vote.ConfigureModule(cfg.App.Mode, cfg.Vote)
+
+	switch *task {
+	case "list":
+		listBackups()
+	case "verify":
+		verifyBackup(resolveBackup(*file))
+	case "restore":
+		restoreBackup(resolveBackup(*file), cfg.Database.Sqlite.FileName)
+	default:
+		fmt.Println("未知的任务:", *task)
+		fmt.Println("可用任务: 'list', 'verify', 'restore'")
+		os.Exit(1)
+	}
+}
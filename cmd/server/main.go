@@ -2,14 +2,19 @@ package main
 
 import (
 	"fmt"
+	"log/slog"
 	"net/http"
 	"time"
 
 	"github.com/SlpAus/noita-spells-tier-backend/api"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/achievement"
 	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/backup"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/clientip"
 	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/config"
 	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
 	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/health"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/logging"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/metrics"
 	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/shutdown"
 	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/startup"
 	"github.com/SlpAus/noita-spells-tier-backend/internal/vote"
@@ -25,21 +30,25 @@ func main() {
 	if err != nil {
 		panic(fmt.Sprintf("加载配置失败: %v", err))
 	}
+	logging.Configure(cfg.Log)
 
 	// --- 2. 初始设置 ---
-	token.GenerateSecretKey()
-	database.InitDB(cfg.Database.Sqlite)
+	if err := token.InitializeKeyring(cfg.Token.Keys, cfg.Token.KeyFile); err != nil {
+		panic(fmt.Sprintf("加载HMAC密钥环失败: %v", err))
+	}
+	database.InitDB(cfg.Database)
 	database.InitRedis(cfg.Database.Redis)
 	health.InitializeRunID()
 
 	// --- 3. 数据库和缓存初始化 ---
-	startup.ConfigureAppMode(cfg.App.Mode)
+	startup.ConfigureModules(cfg)
+	health.ConfigureReadiness(cfg.Health)
 
 	if err := startup.InitializeApplication(); err != nil {
 		panic(fmt.Sprintf("应用初始化失败，无法启动: %v", err))
 	}
 
-	fmt.Println("正在执行启动后健康检查...")
+	slog.Info("正在执行启动后健康检查...")
 	health.PerformCheck()
 
 	// --- 3. 创建生命周期和停机管理器 ---
@@ -56,6 +65,14 @@ func main() {
 	}
 	go backup.StartBackupScheduler(backupHandle)
 
+	if cfg.Database.Driver == config.DatabaseDriverSqlite && cfg.Database.Sqlite.Backup.Enabled {
+		fileBackupHandle, err := forcefulManager.NewServiceHandle("FileBackupScheduler")
+		if err != nil {
+			panic(err)
+		}
+		go backup.StartFileBackupScheduler(fileBackupHandle)
+	}
+
 	voteGracefulHandle, err := gracefulManager.NewServiceHandle("VoteProcessor")
 	if err != nil {
 		panic(err)
@@ -68,6 +85,40 @@ func main() {
 		panic(fmt.Sprintf("启动 Vote Processor 失败: %v", err))
 	}
 
+	achievementHandle, err := gracefulManager.NewServiceHandle("AchievementEvaluator")
+	if err != nil {
+		panic(err)
+	}
+	go achievement.StartEvaluator(achievementHandle)
+
+	if cfg.Token.Keys == "" && cfg.Token.KeyFile != "" {
+		keyringHandle, err := forcefulManager.NewServiceHandle("KeyringWatcher")
+		if err != nil {
+			panic(err)
+		}
+		go token.StartKeyringWatcher(keyringHandle, cfg.Token.KeyFile, 30*time.Second)
+	}
+
+	replayPrunerHandle, err := forcefulManager.NewServiceHandle("ReplayPruner")
+	if err != nil {
+		panic(err)
+	}
+	go vote.StartReplayPruner(replayPrunerHandle)
+
+	rejectionPrunerHandle, err := forcefulManager.NewServiceHandle("RejectionPruner")
+	if err != nil {
+		panic(err)
+	}
+	go vote.StartRejectionPruner(rejectionPrunerHandle)
+
+	if cfg.Vote.Archive.Enabled {
+		archiverHandle, err := forcefulManager.NewServiceHandle("VoteArchiver")
+		if err != nil {
+			panic(err)
+		}
+		go vote.StartArchiver(archiverHandle)
+	}
+
 	healthHandle, err := forcefulManager.NewServiceHandle("HealthChecker")
 	if err != nil {
 		panic(err)
@@ -76,14 +127,19 @@ func main() {
 
 	// --- 6. 创建并配置Web服务器 ---
 	gin.SetMode(string(cfg.Server.Mode))
-	r := gin.Default()
+	// 不使用 gin.Default()，以便访问日志也通过slog输出并带上请求ID
+	r := gin.New()
+	r.Use(logging.RequestIDMiddleware(), logging.AccessLogMiddleware("/healthz", "/readyz"), gin.Recovery(), metrics.GinMiddleware())
+	if err := clientip.Configure(r, cfg.Server); err != nil {
+		panic(fmt.Sprintf("配置客户端IP解析失败: %v", err))
+	}
 
 	if len(cfg.Server.Cors.AllowedOrigins) > 0 {
 		r.Use(cors.New(cors.Config{
 			AllowOrigins:     cfg.Server.Cors.AllowedOrigins,
-			AllowMethods:     []string{"GET", "POST", "OPTIONS"},
-			AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
-			ExposeHeaders:    []string{"Content-Length"},
+			AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+			AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", logging.RequestIDHeader},
+			ExposeHeaders:    []string{"Content-Length", logging.RequestIDHeader},
 			AllowCredentials: true,
 			MaxAge:           12 * time.Hour,
 		}))
@@ -98,6 +154,10 @@ func main() {
 		}
 	}
 
+	// 供负载均衡和编排系统使用的存活与就绪探针
+	r.GET("/healthz", health.Liveness)
+	r.GET("/readyz", health.Readiness)
+
 	api.SetupRoutes(r, cfg.App)
 
 	server := &http.Server{
@@ -105,14 +165,33 @@ func main() {
 		Handler: r,
 	}
 
+	servers := []*http.Server{server}
+
+	// Prometheus指标只在内部监听地址上提供，不经过对外的路由
+	if cfg.Server.InternalAddress != "" {
+		internalMux := http.NewServeMux()
+		internalMux.Handle("/metrics", metrics.Handler())
+		internalServer := &http.Server{
+			Addr:    cfg.Server.InternalAddress,
+			Handler: internalMux,
+		}
+		servers = append(servers, internalServer)
+		go func() {
+			slog.Info("内部监听地址已启动。", slog.String("address", cfg.Server.InternalAddress))
+			if err := internalServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				panic("无法启动内部HTTP服务器: " + err.Error())
+			}
+		}()
+	}
+
 	// --- 7. 启动Web服务器并等待停机信号 ---
 	go func() {
-		fmt.Printf("服务器已准备就绪，开始监听 %s\n", cfg.Server.Address)
+		slog.Info("服务器已准备就绪，开始监听。", slog.String("address", cfg.Server.Address))
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			panic("无法启动Gin服务器: " + err.Error())
 		}
 	}()
 
 	// 这一步是阻塞的，程序将在这里等待，直到收到关闭信号
-	shutdownCoordinator.ListenForSignalsAndShutdown(server)
+	shutdownCoordinator.ListenForSignalsAndShutdown(servers...)
 }
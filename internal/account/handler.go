@@ -0,0 +1,296 @@
+package account
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/achievement"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/logging"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/report"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/user"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/vote"
+	"github.com/SlpAus/noita-spells-tier-backend/pkg/token"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// RecoveryCodeResponse 是签发恢复码的API响应
+type RecoveryCodeResponse struct {
+	UserID    string    `json:"userId"`
+	Code      string    `json:"code"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// RedeemRecoveryCodeRequestBody 是兑换恢复码的请求体
+type RedeemRecoveryCodeRequestBody struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// RedeemRecoveryCodeResponse 是兑换恢复码的API响应
+type RedeemRecoveryCodeResponse struct {
+	UserID string `json:"userId"`
+	// Merged 表示当前浏览器原有的身份是否被合并到了恢复的身份中
+	Merged bool `json:"merged"`
+}
+
+// currentUserID 从Gin上下文中取出有效的用户ID，无效时返回空字符串
+func currentUserID(c *gin.Context) string {
+	userID := c.GetString(user.UserIDKey)
+	if !user.IsValidUUID(userID) {
+		return ""
+	}
+	return userID
+}
+
+// IssueRecoveryCode 为当前用户签发一个恢复码，用于在其他浏览器或设备上找回身份。
+// 每个用户同一时间只有一个有效的恢复码，签发新的恢复码会使旧的失效。
+func IssueRecoveryCode(c *gin.Context) {
+	userID := currentUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "当前没有可恢复的用户身份"})
+		return
+	}
+
+	code, claims, err := token.GenerateRecoveryCode(userID, time.Now().Add(recoveryCodeTTL))
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "生成恢复码失败", logging.Err(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成恢复码失败"})
+		return
+	}
+	record := user.RecoveryCode{Nonce: claims.Nonce, UserIdentifier: userID, ExpiresAt: claims.ExpiresAt}
+	if err := user.ReplaceRecoveryCode(database.DB, record); err != nil {
+		slog.ErrorContext(c.Request.Context(), "保存恢复码失败", logging.Err(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成恢复码失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, RecoveryCodeResponse{UserID: userID, Code: code, ExpiresAt: claims.ExpiresAt})
+}
+
+// RedeemRecoveryCode 兑换恢复码：将当前浏览器切换到恢复码对应的身份，
+// 如果当前浏览器已有另一个身份，则将其投票历史合并到恢复的身份中。
+// 恢复码兑换后即失效；目标身份已被删除或合并时拒绝兑换。
+func RedeemRecoveryCode(c *gin.Context) {
+	var body RedeemRecoveryCodeRequestBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求格式错误: " + err.Error()})
+		return
+	}
+
+	now := time.Now()
+	claims, ok := token.ParseRecoveryCode(body.Code, now)
+	if !ok || !user.IsValidUUID(claims.UserID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "恢复码无效或已失效"})
+		return
+	}
+	targetID := claims.UserID
+
+	sourceID := currentUserID(c)
+	merged, err := vote.RedeemUser(sourceID, targetID, func(tx *gorm.DB) error {
+		return user.ConsumeRecoveryCode(tx, targetID, claims.Nonce, now)
+	})
+	if errors.Is(err, user.ErrRecoveryCodeInvalid) || errors.Is(err, vote.ErrUserGone) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "恢复码无效或已失效"})
+		return
+	}
+	if err != nil {
+		if !database.IsRedisHealthy() {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "服务暂时不可用，请稍后重试"})
+			return
+		}
+		slog.ErrorContext(c.Request.Context(), "兑换恢复码失败", slog.String("target_user_id", targetID), logging.Err(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "合并用户数据失败"})
+		return
+	}
+
+	if merged {
+		if err := report.InvalidateReportCache(sourceID, targetID); err != nil {
+			slog.WarnContext(c.Request.Context(), "清除用户报告缓存失败", logging.Err(err))
+		}
+	}
+
+	user.SetUserCookie(c, targetID)
+	c.JSON(http.StatusOK, RedeemRecoveryCodeResponse{UserID: targetID, Merged: merged})
+}
+
+// exportHeader 是JSON导出中投票列表之前的部分
+type exportHeader struct {
+	UserID     string         `json:"userId"`
+	ExportedAt time.Time      `json:"exportedAt"`
+	Stats      user.UserStats `json:"stats"`
+	// Nickname 是用户在排行榜上的昵称
+	Nickname string `json:"nickname,omitempty"`
+	// Achievements 是用户已获得的成就
+	Achievements []achievement.Earned `json:"achievements,omitempty"`
+}
+
+// ExportMyData 以流的形式导出当前用户的统计数据和全部投票记录。
+// 查询参数 format 可选 json (默认) 或 csv；CSV格式只包含投票记录。
+func ExportMyData(c *gin.Context) {
+	userID := currentUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "当前没有可导出的用户身份"})
+		return
+	}
+
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "csv" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format 只能为 json 或 csv"})
+		return
+	}
+
+	stats, err := getUserStats(userID)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "导出数据时获取统计失败", logging.Err(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "导出数据失败"})
+		return
+	}
+	nickname, err := getNickname(userID)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "导出数据时获取昵称失败", logging.Err(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "导出数据失败"})
+		return
+	}
+	achievements, err := achievement.GetEarned(userID)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "导出数据时获取成就失败", logging.Err(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "导出数据失败"})
+		return
+	}
+
+	// 响应头发出后无法再更改状态码，流中途出错时只能截断输出并记录日志
+	filename := fmt.Sprintf("votes-%s.%s", userID, format)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Header("Cache-Control", "no-store")
+
+	if format == "csv" {
+		err = streamCSV(c, userID)
+	} else {
+		err = streamJSON(c, exportHeader{UserID: userID, ExportedAt: time.Now(), Stats: stats, Nickname: nickname, Achievements: achievements})
+	}
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "导出数据时中断", logging.Err(err))
+	}
+	logDataRequest(DataRequestExport, userID, err == nil)
+}
+
+// streamJSON 以 {"userId":..., "stats":..., "votes":[...]} 的形式逐批写出数据
+func streamJSON(c *gin.Context, header exportHeader) error {
+	c.Header("Content-Type", "application/json; charset=utf-8")
+	c.Status(http.StatusOK)
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	// 去掉结尾的 '}'，在同一个对象中接着写出投票列表
+	if _, err := c.Writer.Write(headerJSON[:len(headerJSON)-1]); err != nil {
+		return err
+	}
+	if _, err := c.Writer.WriteString(`,"votes":[`); err != nil {
+		return err
+	}
+
+	first := true
+	err = forEachUserVote(header.UserID, func(batch []ExportedVote) error {
+		for _, v := range batch {
+			voteJSON, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			if !first {
+				if _, err := c.Writer.WriteString(","); err != nil {
+					return err
+				}
+			}
+			first = false
+			if _, err := c.Writer.Write(voteJSON); err != nil {
+				return err
+			}
+		}
+		c.Writer.Flush()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = c.Writer.WriteString("]}")
+	return err
+}
+
+// streamCSV 逐批写出投票记录，每行一条
+func streamCSV(c *gin.Context, userID string) error {
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Status(http.StatusOK)
+
+	w := csv.NewWriter(c.Writer)
+	if err := w.Write([]string{"id", "spell_a", "spell_a_name", "spell_b", "spell_b_name", "result", "user_ip", "multiplier", "vote_time", "undo_of", "undone_by"}); err != nil {
+		return err
+	}
+
+	err := forEachUserVote(userID, func(batch []ExportedVote) error {
+		for _, v := range batch {
+			record := []string{
+				strconv.FormatUint(uint64(v.ID), 10),
+				v.SpellAID,
+				v.SpellAName,
+				v.SpellBID,
+				v.SpellBName,
+				string(v.Result),
+				v.UserIP,
+				strconv.FormatFloat(v.Multiplier, 'f', -1, 64),
+				v.VoteTime.Format(time.RFC3339),
+				strconv.FormatUint(uint64(v.UndoOfID), 10),
+				strconv.FormatUint(uint64(v.UndoneByID), 10),
+			}
+			if err := w.Write(record); err != nil {
+				return err
+			}
+		}
+		w.Flush()
+		c.Writer.Flush()
+		return w.Error()
+	})
+	if err != nil {
+		return err
+	}
+
+	w.Flush()
+	return w.Error()
+}
+
+// DeleteMyData 删除当前用户的个人数据。投票会被匿名化保留，以维持评分的完整性。
+func DeleteMyData(c *gin.Context) {
+	userID := currentUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "当前没有可删除的用户身份"})
+		return
+	}
+	if !database.IsRedisHealthy() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "服务暂时不可用，请稍后重试"})
+		return
+	}
+
+	if err := vote.ForgetUser(userID); err != nil {
+		slog.ErrorContext(c.Request.Context(), "删除用户数据失败", logging.Err(err))
+		logDataRequest(DataRequestDelete, userID, false)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除数据失败"})
+		return
+	}
+	if err := report.InvalidateReportCache(userID); err != nil {
+		slog.WarnContext(c.Request.Context(), "清除用户报告缓存失败", logging.Err(err))
+	}
+	logDataRequest(DataRequestDelete, userID, true)
+
+	// 清除浏览器中的身份，下次访问时将分配新的用户ID
+	user.ClearUserCookie(c)
+	c.JSON(http.StatusOK, gin.H{"message": "个人数据已删除"})
+}
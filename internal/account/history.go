@@ -0,0 +1,218 @@
+package account
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/metadata"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/spell"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/vote"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	defaultHistoryPageSize = 20
+	maxHistoryPageSize     = 100
+
+	// historyArchiveBatchSize 是从归档中查询投票历史时每批读取的投票数
+	historyArchiveBatchSize = 1000
+)
+
+// VoteHistoryQuery 描述了一次投票历史查询
+type VoteHistoryQuery struct {
+	UserID string
+	// Before 是游标：只返回ID小于它的投票，为0时从最新的投票开始
+	Before  uint
+	Limit   int
+	Result  vote.VoteResult // 为空时不过滤
+	SpellID string          // 为空时不过滤
+}
+
+// HistorySpellDTO 是投票历史中一个法术的信息，包括它当前的社区排名
+type HistorySpellDTO struct {
+	ID   string
+	Name string
+	// CurrentRank 是1-based的当前排名，为0时表示未知
+	CurrentRank int64
+}
+
+// VoteHistoryItemDTO 是投票历史中的一条记录
+type VoteHistoryItemDTO struct {
+	ID       uint
+	SpellA   HistorySpellDTO
+	SpellB   HistorySpellDTO
+	Result   vote.VoteResult
+	VoteTime time.Time
+	// CommunityAgrees 表示用户选出的胜者目前是否排名更高，对双输和跳过为nil
+	CommunityAgrees *bool
+}
+
+// VoteHistoryPageDTO 是一页投票历史
+type VoteHistoryPageDTO struct {
+	Items []VoteHistoryItemDTO
+	// NextCursor 是获取下一页时使用的游标，为0时表示没有更多记录
+	NextCursor uint
+}
+
+// GetVoteHistory 按投票ID倒序分页查询用户的投票历史，并附上法术名称和当前排名
+func GetVoteHistory(query VoteHistoryQuery) (*VoteHistoryPageDTO, error) {
+	if query.Limit <= 0 {
+		query.Limit = defaultHistoryPageSize
+	}
+	query.Limit = min(query.Limit, maxHistoryPageSize)
+
+	// 1. 多取一条，用于判断是否还有下一页。先查votes表，不够时再从归档中补足
+	db := database.DB.Model(&vote.Vote{}).Scopes(vote.EffectiveVotesAsOf(0)).Where("user_identifier = ?", query.UserID)
+	if query.Before > 0 {
+		db = db.Where("id < ?", query.Before)
+	}
+	if query.Result != "" {
+		db = db.Where("result = ?", query.Result)
+	}
+	if query.SpellID != "" {
+		db = db.Where("(spella_id = ? OR spellb_id = ?)", query.SpellID, query.SpellID)
+	}
+	var votes []vote.Vote
+	if err := db.Order("id desc").Limit(query.Limit + 1).Find(&votes).Error; err != nil {
+		return nil, fmt.Errorf("查询投票历史失败: %w", err)
+	}
+	if len(votes) <= query.Limit {
+		archived, err := findArchivedHistory(query, query.Limit+1-len(votes))
+		if err != nil {
+			return nil, err
+		}
+		votes = append(votes, archived...)
+	}
+
+	page := &VoteHistoryPageDTO{}
+	if len(votes) > query.Limit {
+		votes = votes[:query.Limit]
+		page.NextCursor = votes[len(votes)-1].ID
+	}
+
+	// 2. 获取本页涉及的法术的当前排名
+	spellIDs := make([]string, 0, len(votes)*2)
+	for _, v := range votes {
+		spellIDs = append(spellIDs, v.SpellA_ID, v.SpellB_ID)
+	}
+	ranks, err := getCurrentRanks(spellIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	// 3. 组装结果
+	page.Items = make([]VoteHistoryItemDTO, 0, len(votes))
+	for _, v := range votes {
+		item := VoteHistoryItemDTO{
+			ID:       v.ID,
+			SpellA:   HistorySpellDTO{ID: v.SpellA_ID, Name: getSpellName(v.SpellA_ID), CurrentRank: ranks[v.SpellA_ID]},
+			SpellB:   HistorySpellDTO{ID: v.SpellB_ID, Name: getSpellName(v.SpellB_ID), CurrentRank: ranks[v.SpellB_ID]},
+			Result:   v.Result,
+			VoteTime: v.VoteTime,
+		}
+		item.CommunityAgrees = communityAgrees(item)
+		page.Items = append(page.Items, item)
+	}
+	return page, nil
+}
+
+// findArchivedHistory 从归档中按ID倒序读取最多limit张满足查询条件的投票。
+// 归档文件只能顺序读取，因此按ID升序遍历游标之前的全部归档投票，只保留ID最大的limit张。
+func findArchivedHistory(query VoteHistoryQuery, limit int) ([]vote.Vote, error) {
+	throughID, err := metadata.GetArchivedThroughVoteID(database.DB)
+	if err != nil {
+		return nil, fmt.Errorf("无法获取归档水位: %w", err)
+	}
+	upToID := throughID
+	if query.Before > 0 {
+		upToID = min(upToID, query.Before-1)
+	}
+	if upToID == 0 {
+		return nil, nil
+	}
+
+	var matched []vote.Vote
+	filter := vote.VoteFilter{UserID: query.UserID, UpToID: upToID}
+	err = vote.ForEachVote(filter, historyArchiveBatchSize, func(batch []vote.Vote) error {
+		for _, v := range batch {
+			if !v.IsEffective() || (query.Result != "" && v.Result != query.Result) ||
+				(query.SpellID != "" && v.SpellA_ID != query.SpellID && v.SpellB_ID != query.SpellID) {
+				continue
+			}
+			matched = append(matched, v)
+		}
+		if len(matched) > limit {
+			matched = append(matched[:0], matched[len(matched)-limit:]...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("从归档中查询投票历史失败: %w", err)
+	}
+	slices.Reverse(matched)
+	return matched, nil
+}
+
+// communityAgrees 判断用户选出的胜者目前是否排名更高
+func communityAgrees(item VoteHistoryItemDTO) *bool {
+	if item.SpellA.CurrentRank == 0 || item.SpellB.CurrentRank == 0 {
+		return nil
+	}
+	var agrees bool
+	switch item.Result {
+	case vote.ResultAWins:
+		agrees = item.SpellA.CurrentRank < item.SpellB.CurrentRank
+	case vote.ResultBWins:
+		agrees = item.SpellB.CurrentRank < item.SpellA.CurrentRank
+	default:
+		return nil
+	}
+	return &agrees
+}
+
+// getCurrentRanks 返回法术的1-based当前排名。Redis可用时读取实时排名，否则读取SQLite快照。
+func getCurrentRanks(spellIDs []string) (map[string]int64, error) {
+	ranks := make(map[string]int64, len(spellIDs))
+	if len(spellIDs) == 0 {
+		return ranks, nil
+	}
+
+	unique := make([]string, 0, len(spellIDs))
+	for _, id := range spellIDs {
+		if _, seen := ranks[id]; !seen {
+			ranks[id] = 0
+			unique = append(unique, id)
+		}
+	}
+
+	if database.IsRedisHealthy() {
+		pipe := database.RDB.Pipeline()
+		cmds := make([]*redis.IntCmd, len(unique))
+		for i, id := range unique {
+			cmds[i] = pipe.ZRevRank(database.Ctx, spell.RankingKey, id)
+		}
+		if _, err := pipe.Exec(database.Ctx); err != nil && err != redis.Nil {
+			return nil, fmt.Errorf("从Redis获取法术排名失败: %w", err)
+		}
+		for i, id := range unique {
+			if rank, err := cmds[i].Result(); err == nil {
+				ranks[id] = rank + 1
+			}
+		}
+		return ranks, nil
+	}
+
+	var rows []struct {
+		SpellID string
+		Rank    int64
+	}
+	if err := database.DB.Model(&spell.Spell{}).Where("spell_id IN ?", unique).Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("从SQLite获取法术排名失败: %w", err)
+	}
+	for _, row := range rows {
+		ranks[row.SpellID] = row.Rank
+	}
+	return ranks, nil
+}
@@ -0,0 +1,136 @@
+package account
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/config"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/vote"
+	"github.com/gin-gonic/gin"
+)
+
+// --- 模式 ---
+var appMode config.AppMode
+
+// spellFilterParam 是按法术过滤时使用的查询参数名，天赋模式下为 perk
+var spellFilterParam string
+
+func initHandlerMode(mode config.AppMode) {
+	appMode = mode
+	switch mode {
+	case config.AppModeSpell:
+		spellFilterParam = "spell"
+	case config.AppModePerk:
+		spellFilterParam = "perk"
+	}
+}
+
+// --- API响应模型 ---
+type HistorySpellResponse struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	CurrentRank int64  `json:"currentRank"`
+}
+
+type SpellVoteHistoryItemResponse struct {
+	ID              uint                 `json:"id"`
+	SpellA          HistorySpellResponse `json:"spellA"`
+	SpellB          HistorySpellResponse `json:"spellB"`
+	Result          vote.VoteResult      `json:"result"`
+	VoteTime        time.Time            `json:"voteTime"`
+	CommunityAgrees *bool                `json:"communityAgrees"`
+}
+type SpellVoteHistoryResponse struct {
+	Items      []SpellVoteHistoryItemResponse `json:"items"`
+	NextCursor *uint                          `json:"nextCursor,omitempty"`
+}
+
+type PerkVoteHistoryItemResponse struct {
+	ID              uint                 `json:"id"`
+	SpellA          HistorySpellResponse `json:"perkA"` // 改名
+	SpellB          HistorySpellResponse `json:"perkB"` // 改名
+	Result          vote.VoteResult      `json:"result"`
+	VoteTime        time.Time            `json:"voteTime"`
+	CommunityAgrees *bool                `json:"communityAgrees"`
+}
+type PerkVoteHistoryResponse struct {
+	Items      []PerkVoteHistoryItemResponse `json:"items"`
+	NextCursor *uint                         `json:"nextCursor,omitempty"`
+}
+
+func formatHistoryItem(dto VoteHistoryItemDTO) SpellVoteHistoryItemResponse {
+	return SpellVoteHistoryItemResponse{
+		ID:              dto.ID,
+		SpellA:          HistorySpellResponse(dto.SpellA),
+		SpellB:          HistorySpellResponse(dto.SpellB),
+		Result:          dto.Result,
+		VoteTime:        dto.VoteTime,
+		CommunityAgrees: dto.CommunityAgrees,
+	}
+}
+
+// GetMyVotes 分页返回当前用户的投票历史。
+// 查询参数: cursor (上一页返回的nextCursor)、limit、result 以及按法术过滤的 spell / perk。
+func GetMyVotes(c *gin.Context) {
+	userID := currentUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusOK, SpellVoteHistoryResponse{Items: []SpellVoteHistoryItemResponse{}})
+		return
+	}
+
+	// 1. 解析查询参数
+	query := VoteHistoryQuery{UserID: userID, SpellID: c.Query(spellFilterParam)}
+	if cursor := c.Query("cursor"); cursor != "" {
+		before, err := strconv.ParseUint(cursor, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "cursor 格式错误"})
+			return
+		}
+		query.Before = uint(before)
+	}
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit 必须为正整数"})
+			return
+		}
+		query.Limit = n
+	}
+	if result := c.Query("result"); result != "" {
+		switch r := vote.VoteResult(result); r {
+		case vote.ResultAWins, vote.ResultBWins, vote.ResultDraw, vote.ResultSkip:
+			query.Result = r
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "result 无效"})
+			return
+		}
+	}
+
+	// 2. 查询
+	page, err := GetVoteHistory(query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取投票历史失败"})
+		return
+	}
+
+	// 3. 格式化响应
+	var nextCursor *uint
+	if page.NextCursor > 0 {
+		nextCursor = &page.NextCursor
+	}
+	switch appMode {
+	case config.AppModeSpell:
+		items := make([]SpellVoteHistoryItemResponse, 0, len(page.Items))
+		for _, dto := range page.Items {
+			items = append(items, formatHistoryItem(dto))
+		}
+		c.JSON(http.StatusOK, SpellVoteHistoryResponse{Items: items, NextCursor: nextCursor})
+	case config.AppModePerk:
+		items := make([]PerkVoteHistoryItemResponse, 0, len(page.Items))
+		for _, dto := range page.Items {
+			items = append(items, PerkVoteHistoryItemResponse(formatHistoryItem(dto)))
+		}
+		c.JSON(http.StatusOK, PerkVoteHistoryResponse{Items: items, NextCursor: nextCursor})
+	}
+}
@@ -0,0 +1,27 @@
+package account
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DataRequestKind 定义了个人数据请求的类型
+type DataRequestKind string
+
+const (
+	DataRequestExport DataRequestKind = "EXPORT"
+	DataRequestDelete DataRequestKind = "DELETE"
+)
+
+// DataRequest 记录了一次个人数据的导出或删除请求，作为合规审计日志。
+// 它只保存用户ID和请求时间，不保存任何其他个人数据。
+type DataRequest struct {
+	gorm.Model
+
+	Kind           DataRequestKind `gorm:"index"`
+	UserIdentifier string          `gorm:"index"`
+	// Succeeded 表示请求是否已完整执行
+	Succeeded   bool
+	RequestTime time.Time `gorm:"index"`
+}
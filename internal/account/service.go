@@ -0,0 +1,122 @@
+package account
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/logging"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/spell"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/user"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/vote"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+// exportBatchSize 是导出时每批读取的投票数
+const exportBatchSize = 1000
+
+// ExportedVote 是导出数据中的单条投票记录
+type ExportedVote struct {
+	ID         uint            `json:"id"`
+	SpellAID   string          `json:"spellA"`
+	SpellAName string          `json:"spellAName"`
+	SpellBID   string          `json:"spellB"`
+	SpellBName string          `json:"spellBName"`
+	Result     vote.VoteResult `json:"result"`
+	UserIP     string          `json:"userIp"`
+	Multiplier float64         `json:"multiplier"`
+	VoteTime   time.Time       `json:"voteTime"`
+	// UndoOfID 和 UndoneByID 标记了撤销事件及被撤销的投票
+	UndoOfID   uint `json:"undoOf,omitempty"`
+	UndoneByID uint `json:"undoneBy,omitempty"`
+}
+
+// logDataRequest 将一次个人数据请求写入审计日志。这是尽力而为的操作。
+func logDataRequest(kind DataRequestKind, userID string, succeeded bool) {
+	record := DataRequest{
+		Kind:           kind,
+		UserIdentifier: userID,
+		Succeeded:      succeeded,
+		RequestTime:    time.Now(),
+	}
+	if err := database.DB.Create(&record).Error; err != nil {
+		slog.Warn("无法记录个人数据请求", logging.UserID(userID), slog.String("kind", string(kind)), logging.Err(err))
+	}
+}
+
+// getUserStats 获取用户的统计数据：Redis可用时读取实时数据，否则读取SQLite快照
+func getUserStats(userID string) (user.UserStats, error) {
+	if database.IsRedisHealthy() {
+		statsJSON, err := database.RDB.HGet(database.Ctx, user.StatsKey, userID).Result()
+		if err == redis.Nil {
+			return user.UserStats{}, nil
+		}
+		if err != nil {
+			return user.UserStats{}, fmt.Errorf("从Redis获取用户统计数据时出错: %w", err)
+		}
+		var stats user.UserStats
+		if err := json.Unmarshal([]byte(statsJSON), &stats); err != nil {
+			return user.UserStats{}, fmt.Errorf("解析用户统计数据时出错: %w", err)
+		}
+		return stats, nil
+	}
+
+	var record user.User
+	err := database.DB.Where("uuid = ?", userID).First(&record).Error
+	if err == gorm.ErrRecordNotFound {
+		return user.UserStats{}, nil
+	}
+	if err != nil {
+		return user.UserStats{}, fmt.Errorf("从SQLite获取用户统计数据时出错: %w", err)
+	}
+	return user.UserStats{Wins: record.WinsCount, Draw: record.DrawCount, Skip: record.SkipCount}, nil
+}
+
+// getNickname 返回用户在排行榜上的昵称，未设置时返回空字符串
+func getNickname(userID string) (string, error) {
+	var nicknames []string
+	if err := database.DB.Model(&user.User{}).Where("uuid = ?", userID).Pluck("nickname", &nicknames).Error; err != nil {
+		return "", fmt.Errorf("从SQLite获取用户昵称时出错: %w", err)
+	}
+	if len(nicknames) == 0 {
+		return "", nil
+	}
+	return nicknames[0], nil
+}
+
+// getSpellName 返回法术名称，找不到时返回空字符串
+func getSpellName(id string) string {
+	index, ok := spell.GetSpellIndexByID(id)
+	if !ok {
+		return ""
+	}
+	info, _ := spell.GetSpellInfoByIndex(index)
+	return info.Name
+}
+
+// forEachUserVote 按ID顺序分批读取用户的所有投票（包括已归档的投票），并对每一批调用fn。
+// fn返回错误时停止遍历。
+func forEachUserVote(userID string, fn func([]ExportedVote) error) error {
+	return vote.ForEachVote(vote.VoteFilter{UserID: userID}, exportBatchSize, func(batch []vote.Vote) error {
+		exported := make([]ExportedVote, 0, len(batch))
+		for _, v := range batch {
+			exported = append(exported, ExportedVote{
+				ID:         v.ID,
+				SpellAID:   v.SpellA_ID,
+				SpellAName: getSpellName(v.SpellA_ID),
+				SpellBID:   v.SpellB_ID,
+				SpellBName: getSpellName(v.SpellB_ID),
+				Result:     v.Result,
+				UserIP:     v.UserIP,
+				Multiplier: v.Multiplier,
+				VoteTime:   v.VoteTime,
+				UndoOfID:   v.UndoOfID,
+				UndoneByID: v.UndoneByID,
+			})
+		}
+		return fn(exported)
+	})
+}
@@ -0,0 +1,28 @@
+package account
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/config"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
+)
+
+// PrimeModule 负责迁移account模块的数据库表
+func PrimeModule() error {
+	if err := database.DB.AutoMigrate(&DataRequest{}); err != nil {
+		return fmt.Errorf("无法迁移data_requests表: %w", err)
+	}
+	slog.Info("DataRequest数据库表迁移成功。")
+	return nil
+}
+
+// recoveryCodeTTL 是恢复码自签发起的有效期
+var recoveryCodeTTL time.Duration
+
+// ConfigureModule 根据应用模式和恢复码设置配置account模块
+func ConfigureModule(mode config.AppMode, tokenCfg config.TokenConfig) {
+	initHandlerMode(mode)
+	recoveryCodeTTL = tokenCfg.RecoveryCodeTTL
+}
@@ -0,0 +1,168 @@
+package achievement
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/logging"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/user"
+	"github.com/SlpAus/noita-spells-tier-backend/pkg/lifecycle"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// PendingSetKey 是一个 Redis Set 的键，用于存储有新投票被处理、等待评估成就的用户UUID。
+	// 投票处理器只负责把用户加入这个集合，评估在后台任务中进行，不拖慢投票的应用。
+	PendingSetKey = database.KeyPrefix + "achievement:pending"
+
+	// evaluateBatchSize 是每批评估的用户数
+	evaluateBatchSize = 100
+)
+
+// evaluateInterval 是后台评估任务的运行间隔
+var evaluateInterval time.Duration
+
+// StartEvaluator 启动一个后台任务，定期评估待定用户的成就
+func StartEvaluator(handle *lifecycle.Handle) {
+	defer handle.Close()
+	slog.Info("成就评估器已启动。")
+
+	for {
+		if err := handle.Sleep(evaluateInterval); err != nil {
+			slog.Info("成就评估器: 休眠被中断，正在关闭...")
+			return
+		}
+		if !database.IsRedisHealthy() {
+			continue
+		}
+
+		awarded, err := evaluatePending()
+		if err != nil {
+			slog.Error("成就评估器错误", logging.Err(err))
+			continue
+		}
+		if awarded > 0 {
+			slog.Info("成就评估器: 授予了新成就。", slog.Int("awarded", awarded))
+		}
+	}
+}
+
+// evaluatePending 分批取出并评估待定用户，直到集合为空，返回新授予的成就数
+func evaluatePending() (int, error) {
+	total := 0
+	for {
+		userIDs, err := database.RDB.SPopN(database.Ctx, PendingSetKey, evaluateBatchSize).Result()
+		if err != nil {
+			return total, fmt.Errorf("无法从Redis取出待评估的用户: %w", err)
+		}
+		if len(userIDs) == 0 {
+			return total, nil
+		}
+
+		awarded, err := evaluateUsers(userIDs)
+		if err != nil {
+			// 放回集合，留待下次重试
+			if restoreErr := database.RDB.SAdd(database.Ctx, PendingSetKey, userIDs).Err(); restoreErr != nil {
+				slog.Warn("无法将待评估的用户放回集合", slog.Int("users", len(userIDs)), logging.Err(restoreErr))
+			}
+			return total, err
+		}
+		total += awarded
+	}
+}
+
+// evaluateUsers 按用户的实时统计和聚合数据评估一批用户，并把新达成的成就写入SQLite
+func evaluateUsers(userIDs []string) (int, error) {
+	// 与用户合并、删除互斥，避免为已不存在的用户写入成就
+	user.RLockRepository()
+	defer user.RUnlockRepository()
+
+	pipe := database.RDB.Pipeline()
+	statsCmd := pipe.HMGet(database.Ctx, user.StatsKey, userIDs...)
+	aggCmd := pipe.HMGet(database.Ctx, user.AggregatesKey, userIDs...)
+	if _, err := pipe.Exec(database.Ctx); err != nil {
+		return 0, fmt.Errorf("无法从Redis获取用户统计数据: %w", err)
+	}
+	statsData, aggData := statsCmd.Val(), aggCmd.Val()
+
+	var existing []Award
+	if err := database.DB.Select("user_identifier", "code").Where("user_identifier IN ?", userIDs).Find(&existing).Error; err != nil {
+		return 0, fmt.Errorf("查询用户已有的成就失败: %w", err)
+	}
+	alreadyEarned := make(map[string]map[string]bool, len(userIDs))
+	for _, award := range existing {
+		if alreadyEarned[award.UserIdentifier] == nil {
+			alreadyEarned[award.UserIdentifier] = make(map[string]bool)
+		}
+		alreadyEarned[award.UserIdentifier][award.Code] = true
+	}
+
+	now := time.Now()
+	var awards []Award
+	for i, userID := range userIDs {
+		// 用户已被合并或删除
+		if statsData[i] == nil {
+			continue
+		}
+		var p progress
+		if err := json.Unmarshal([]byte(statsData[i].(string)), &p.stats); err != nil {
+			slog.Warn("解析用户统计数据时出错", logging.UserID(userID), logging.Err(err))
+			continue
+		}
+		if aggJSON, ok := aggData[i].(string); ok {
+			agg, err := user.ParseUserAggregates(aggJSON)
+			if err != nil {
+				slog.Warn("解析用户聚合数据时出错", logging.UserID(userID), logging.Err(err))
+				continue
+			}
+			p.agg = agg
+		}
+
+		for _, r := range rules {
+			if alreadyEarned[userID][r.Code] || !r.check(p) {
+				continue
+			}
+			awards = append(awards, Award{
+				UserIdentifier: userID,
+				Code:           r.Code,
+				VoteID:         p.stats.LastVoteID,
+				EarnedAt:       now,
+			})
+		}
+	}
+
+	if len(awards) == 0 {
+		return 0, nil
+	}
+	result := database.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&awards)
+	if result.Error != nil {
+		return 0, fmt.Errorf("保存新成就失败: %w", result.Error)
+	}
+	return int(result.RowsAffected), nil
+}
+
+// enqueueAllUsers 把所有已有统计数据的用户加入待评估集合，用于首次启用成就系统时补发成就
+func enqueueAllUsers() error {
+	userIDs, err := database.RDB.HKeys(database.Ctx, user.StatsKey).Result()
+	if err != nil {
+		return fmt.Errorf("无法从Redis获取用户列表: %w", err)
+	}
+
+	members := make([]interface{}, 0, len(userIDs))
+	for _, userID := range userIDs {
+		if userID != user.TotalStatsKey {
+			members = append(members, userID)
+		}
+	}
+	if len(members) == 0 {
+		return nil
+	}
+	if err := database.RDB.SAdd(database.Ctx, PendingSetKey, members...).Err(); err != nil {
+		return fmt.Errorf("无法将用户加入待评估集合: %w", err)
+	}
+	slog.Info("已将已有用户加入成就评估队列。", slog.Int("users", len(members)))
+	return nil
+}
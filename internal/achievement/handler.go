@@ -0,0 +1,42 @@
+package achievement
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/logging"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/user"
+	"github.com/gin-gonic/gin"
+)
+
+// MyAchievementsResponse 是个人成就接口的API响应
+type MyAchievementsResponse struct {
+	// Achievements 包含全部成就，未获得的成就 Earned 为false
+	Achievements []Status `json:"achievements"`
+	EarnedCount  int      `json:"earnedCount"`
+}
+
+// GetMyAchievements 返回全部成就及当前用户的获得情况。
+// 成就由后台任务定期评估，新投票达成的成就可能会稍后才出现。
+func GetMyAchievements(c *gin.Context) {
+	userID := c.GetString(user.UserIDKey)
+	if !user.IsValidUUID(userID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "当前没有有效的用户身份"})
+		return
+	}
+
+	statuses, err := listStatus(userID)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "获取用户成就失败", logging.Err(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取成就失败"})
+		return
+	}
+
+	response := MyAchievementsResponse{Achievements: statuses}
+	for _, status := range statuses {
+		if status.Earned {
+			response.EarnedCount++
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,19 @@
+package achievement
+
+import (
+	"time"
+)
+
+// Award 记录了用户获得的一项成就。成就一经获得便永久保留，即使之后撤销了投票也不会收回。
+type Award struct {
+	ID uint `gorm:"primarykey"`
+
+	// UserIdentifier 和 Code 共同唯一，每个用户的每项成就只会被授予一次
+	UserIdentifier string `gorm:"type:varchar(36);not null;uniqueIndex:idx_award_user_code"`
+	Code           string `gorm:"type:varchar(32);not null;uniqueIndex:idx_award_user_code"`
+
+	// VoteID 是评估时用户最后一次被处理的投票
+	VoteID uint
+
+	EarnedAt time.Time `gorm:"not null"`
+}
@@ -0,0 +1,113 @@
+package achievement
+
+import (
+	"fmt"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/config"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/spell"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/user"
+)
+
+// Definition 描述了一项成就，名称和描述已按应用模式填入"法术"或"天赋"。
+type Definition struct {
+	Code        string `json:"code"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// progress 是评估成就规则所需的用户数据，均来自投票处理器维护的实时统计
+type progress struct {
+	stats user.UserStats
+	agg   user.UserAggregates
+}
+
+func (p progress) totalVotes() int {
+	return p.stats.Wins + p.stats.Draw + p.stats.Skip
+}
+
+// rule 是一项成就的定义及其达成条件
+type rule struct {
+	Definition
+	check func(p progress) bool
+}
+
+var (
+	// rules 按展示顺序排列，由 loadRules 根据应用模式和配置生成
+	rules []rule
+	// rulesByCode 用于把已保存的成就映射回其定义
+	rulesByCode map[string]Definition
+)
+
+// loadRules 根据应用模式生成成就列表。launchDay 为空时不包含"上线当天投票"成就。
+func loadRules(mode config.AppMode, launchDay string) {
+	noun := "法术"
+	if mode == config.AppModePerk {
+		noun = "天赋"
+	}
+
+	rules = []rule{
+		{
+			Definition: Definition{Code: "first_vote", Name: "初次登场", Description: "完成第一次投票"},
+			check:      func(p progress) bool { return p.totalVotes() >= 1 },
+		},
+		{
+			Definition: Definition{Code: "votes_100", Name: "百票评审", Description: "累计投票100次"},
+			check:      func(p progress) bool { return p.totalVotes() >= 100 },
+		},
+		{
+			Definition: Definition{Code: "votes_1000", Name: "千票宗师", Description: "累计投票1000次"},
+			check:      func(p progress) bool { return p.totalVotes() >= 1000 },
+		},
+		{
+			Definition: Definition{Code: "busy_day", Name: "废寝忘食", Description: "在同一天内投票100次"},
+			check: func(p progress) bool {
+				for _, count := range p.agg.Days {
+					if count >= 100 {
+						return true
+					}
+				}
+				return false
+			},
+		},
+		{
+			Definition: Definition{Code: "met_every", Name: "博览群书", Description: fmt.Sprintf("在投票中遇到过每一个%s", noun)},
+			check: func(p progress) bool {
+				return countSpells(p.agg, func(t user.SpellTally) bool { return t.FirstSeen.VoteID != 0 }) == spell.GetSpellCount()
+			},
+		},
+		{
+			Definition: Definition{Code: "picked_every", Name: "雨露均沾", Description: fmt.Sprintf("每一个%s都至少被你选为胜者一次", noun)},
+			check: func(p progress) bool {
+				return countSpells(p.agg, func(t user.SpellTally) bool { return t.Wins > 0 }) == spell.GetSpellCount()
+			},
+		},
+		{
+			Definition: Definition{Code: "giant_slayer", Name: "以下克上", Description: fmt.Sprintf("选择了一个排名比对手低一半%s总数以上的%s", noun, noun)},
+			check: func(p progress) bool {
+				return p.agg.Subversive != nil && p.agg.Subversive.RankDiff*2 >= spell.GetSpellCount()
+			},
+		},
+	}
+	if launchDay != "" {
+		rules = append(rules, rule{
+			Definition: Definition{Code: "launch_day", Name: "首日见证者", Description: "在上线当天参与了投票"},
+			check:      func(p progress) bool { return p.agg.Days[launchDay] > 0 },
+		})
+	}
+
+	rulesByCode = make(map[string]Definition, len(rules))
+	for _, r := range rules {
+		rulesByCode[r.Code] = r.Definition
+	}
+}
+
+// countSpells 统计聚合数据中满足条件的有效法术数，已下架的法术不计入
+func countSpells(agg user.UserAggregates, match func(user.SpellTally) bool) int {
+	count := 0
+	for spellID, tally := range agg.Spells {
+		if _, ok := spell.GetSpellIndexByID(spellID); ok && match(tally) {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,110 @@
+package achievement
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
+	"gorm.io/gorm"
+)
+
+// Earned 是用户已获得的一项成就
+type Earned struct {
+	Definition
+	EarnedAt time.Time `json:"earnedAt"`
+}
+
+// Status 是完整成就列表中的一项，标明了用户是否已获得
+type Status struct {
+	Definition
+	Earned   bool       `json:"earned"`
+	EarnedAt *time.Time `json:"earnedAt,omitempty"`
+}
+
+// GetEarned 按获得时间顺序返回用户已获得的成就，已不再启用的成就不会返回
+func GetEarned(userID string) ([]Earned, error) {
+	var awards []Award
+	if err := database.DB.Where("user_identifier = ?", userID).Order("earned_at asc, id asc").Find(&awards).Error; err != nil {
+		return nil, fmt.Errorf("查询用户 %s 的成就失败: %w", userID, err)
+	}
+
+	earned := make([]Earned, 0, len(awards))
+	for _, award := range awards {
+		definition, ok := rulesByCode[award.Code]
+		if !ok {
+			continue
+		}
+		earned = append(earned, Earned{Definition: definition, EarnedAt: award.EarnedAt})
+	}
+	return earned, nil
+}
+
+// listStatus 返回全部成就及用户的获得情况，顺序与成就定义一致
+func listStatus(userID string) ([]Status, error) {
+	earned, err := GetEarned(userID)
+	if err != nil {
+		return nil, err
+	}
+	earnedAt := make(map[string]time.Time, len(earned))
+	for _, e := range earned {
+		earnedAt[e.Code] = e.EarnedAt
+	}
+
+	statuses := make([]Status, 0, len(rules))
+	for _, r := range rules {
+		status := Status{Definition: r.Definition}
+		if t, ok := earnedAt[r.Code]; ok {
+			status.Earned = true
+			status.EarnedAt = &t
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// ReassignAwards 在用户合并时把sourceID的成就转给targetID。
+// 双方都获得过的成就只保留一条，获得时间取较早者。调用方需在同一事务中完成其余的合并。
+func ReassignAwards(tx *gorm.DB, sourceID, targetID string) error {
+	var awards []Award
+	if err := tx.Where("user_identifier IN ?", []string{sourceID, targetID}).Find(&awards).Error; err != nil {
+		return fmt.Errorf("读取待合并的成就失败: %w", err)
+	}
+
+	targetAwards := make(map[string]Award)
+	for _, award := range awards {
+		if award.UserIdentifier == targetID {
+			targetAwards[award.Code] = award
+		}
+	}
+	for _, award := range awards {
+		if award.UserIdentifier != sourceID {
+			continue
+		}
+		existing, ok := targetAwards[award.Code]
+		if !ok {
+			if err := tx.Model(&Award{}).Where("id = ?", award.ID).Update("user_identifier", targetID).Error; err != nil {
+				return fmt.Errorf("转移成就 %s 失败: %w", award.Code, err)
+			}
+			continue
+		}
+		if award.EarnedAt.Before(existing.EarnedAt) {
+			err := tx.Model(&Award{}).Where("id = ?", existing.ID).
+				Updates(map[string]interface{}{"earned_at": award.EarnedAt, "vote_id": award.VoteID}).Error
+			if err != nil {
+				return fmt.Errorf("合并成就 %s 失败: %w", award.Code, err)
+			}
+		}
+		if err := tx.Delete(&Award{}, award.ID).Error; err != nil {
+			return fmt.Errorf("删除重复的成就 %s 失败: %w", award.Code, err)
+		}
+	}
+	return nil
+}
+
+// DeleteAwards 删除用户的全部成就，用于删除个人数据
+func DeleteAwards(tx *gorm.DB, userID string) error {
+	if err := tx.Where("user_identifier = ?", userID).Delete(&Award{}).Error; err != nil {
+		return fmt.Errorf("删除用户 %s 的成就失败: %w", userID, err)
+	}
+	return nil
+}
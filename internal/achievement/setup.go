@@ -0,0 +1,33 @@
+package achievement
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/config"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
+)
+
+// ConfigureModule 根据应用模式和配置生成成就列表并设置评估间隔
+func ConfigureModule(mode config.AppMode, cfg config.AchievementConfig) {
+	loadRules(mode, cfg.LaunchDate)
+	evaluateInterval = cfg.EvaluateInterval
+}
+
+// PrimeModule 负责迁移achievement模块的数据库表。
+// 首次创建表时，会把所有已有用户加入评估队列，为他们补发已达成的成就。
+// 它依赖user和vote模块已经准备好Redis中的统计和聚合数据。
+func PrimeModule() error {
+	firstRun := !database.DB.Migrator().HasTable(&Award{})
+	if err := database.DB.AutoMigrate(&Award{}); err != nil {
+		return fmt.Errorf("无法迁移awards表: %w", err)
+	}
+	slog.Info("Award数据库表迁移成功。")
+
+	if firstRun {
+		if err := enqueueAllUsers(); err != nil {
+			return fmt.Errorf("无法为已有用户安排成就评估: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package leaderboard
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/logging"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/user"
+	"github.com/gin-gonic/gin"
+)
+
+// SetNicknameRequestBody 是设置排行榜昵称的请求体
+type SetNicknameRequestBody struct {
+	Nickname string `json:"nickname" binding:"required"`
+}
+
+// SetNicknameResponse 是设置排行榜昵称的API响应，返回规范化后实际保存的昵称
+type SetNicknameResponse struct {
+	Nickname string `json:"nickname"`
+}
+
+// currentUserID 从Gin上下文中取出有效的用户ID，无效时返回空字符串
+func currentUserID(c *gin.Context) string {
+	userID := c.GetString(user.UserIDKey)
+	if !user.IsValidUUID(userID) {
+		return ""
+	}
+	return userID
+}
+
+// GetLeaderboard 返回按总投票数排序的公开排行榜。
+// 只有主动公开昵称的用户会显示昵称，请求者自己的条目和位置会被标出。
+func GetLeaderboard(c *gin.Context) {
+	board, err := getLeaderboard(currentUserID(c))
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "生成排行榜失败", logging.Err(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成排行榜失败"})
+		return
+	}
+	c.JSON(http.StatusOK, board)
+}
+
+// SetMyNickname 审核并设置当前用户的排行榜昵称，同时公开其在排行榜上的身份
+func SetMyNickname(c *gin.Context) {
+	userID := currentUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "当前没有有效的用户身份"})
+		return
+	}
+
+	var body SetNicknameRequestBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求格式错误: " + err.Error()})
+		return
+	}
+
+	nickname, err := setNickname(userID, body.Nickname)
+	if err != nil {
+		switch {
+		case errors.Is(err, errNoVotes):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, errNicknameRejected):
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		default:
+			slog.ErrorContext(c.Request.Context(), "设置昵称失败", logging.Err(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "设置昵称失败"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, SetNicknameResponse{Nickname: nickname})
+}
+
+// ClearMyNickname 删除当前用户的昵称，之后其在排行榜上显示为匿名
+func ClearMyNickname(c *gin.Context) {
+	userID := currentUserID(c)
+	if userID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "当前没有有效的用户身份"})
+		return
+	}
+
+	if err := clearNickname(userID); err != nil {
+		slog.ErrorContext(c.Request.Context(), "清除昵称失败", logging.Err(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "清除昵称失败"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
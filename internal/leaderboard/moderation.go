@@ -0,0 +1,78 @@
+package leaderboard
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/config"
+)
+
+var (
+	nicknameMinLength int
+	nicknameMaxLength int
+	// blockedWords 是经过 foldForMatching 处理的屏蔽词
+	blockedWords []string
+
+	// errNicknameRejected 包装了所有审核不通过的原因
+	errNicknameRejected = errors.New("昵称未通过审核")
+)
+
+func loadNicknamePolicy(cfg config.NicknameConfig) {
+	nicknameMinLength = cfg.MinLength
+	nicknameMaxLength = cfg.MaxLength
+	blockedWords = blockedWords[:0]
+	for _, word := range cfg.BlockedWords {
+		if folded := foldForMatching(word); folded != "" {
+			blockedWords = append(blockedWords, folded)
+		}
+	}
+}
+
+// foldForMatching 将文本转为小写，并去除空白、标点和符号，
+// 使 "B a-d" 这样的变体也能与屏蔽词 "bad" 匹配。
+func foldForMatching(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// moderateNickname 规范化并审核用户提交的昵称，返回可以保存的昵称。
+// 昵称只允许字母（包括中文等文字）、数字、空格以及 _ - . 三种符号，连续的空白会被合并。
+func moderateNickname(raw string) (string, error) {
+	nickname := strings.Join(strings.Fields(raw), " ")
+
+	length := utf8.RuneCountInString(nickname)
+	if length < nicknameMinLength || length > nicknameMaxLength {
+		return "", fmt.Errorf("%w: 长度必须在 %d 到 %d 个字符之间", errNicknameRejected, nicknameMinLength, nicknameMaxLength)
+	}
+
+	hasLetterOrDigit := false
+	for _, r := range nickname {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			hasLetterOrDigit = true
+		case r == ' ' || r == '_' || r == '-' || r == '.':
+		default:
+			return "", fmt.Errorf("%w: 不能包含字符 %q", errNicknameRejected, r)
+		}
+	}
+	if !hasLetterOrDigit {
+		return "", fmt.Errorf("%w: 必须包含字母或数字", errNicknameRejected)
+	}
+
+	folded := foldForMatching(nickname)
+	for _, word := range blockedWords {
+		if strings.Contains(folded, word) {
+			return "", fmt.Errorf("%w: 包含不允许的内容", errNicknameRejected)
+		}
+	}
+
+	return nickname, nil
+}
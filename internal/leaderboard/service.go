@@ -0,0 +1,266 @@
+package leaderboard
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/user"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// leaderboardSize 是排行榜显示的投票者人数
+var leaderboardSize int
+
+var errNoVotes = errors.New("投票之后才能在排行榜上设置昵称")
+
+// Entry 是排行榜上的一个投票者。未公开昵称的投票者只显示名次和票数。
+type Entry struct {
+	Rank       int64  `json:"rank"`
+	Nickname   string `json:"nickname,omitempty"`
+	Anonymous  bool   `json:"anonymous"`
+	TotalVotes int64  `json:"totalVotes"`
+	// IsMe 标记了发出请求的用户自己
+	IsMe bool `json:"isMe,omitempty"`
+}
+
+// MyPosition 是发出请求的用户在排行榜中的位置，无论其是否进入前列
+type MyPosition struct {
+	// Rank 为0表示用户还没有任何投票
+	Rank       int64  `json:"rank"`
+	TotalVotes int64  `json:"totalVotes"`
+	Nickname   string `json:"nickname,omitempty"`
+	OptedIn    bool   `json:"optedIn"`
+}
+
+// Leaderboard 是公开排行榜的API响应
+type Leaderboard struct {
+	GeneratedAt time.Time   `json:"generatedAt"`
+	TotalVoters int64       `json:"totalVoters"`
+	Entries     []Entry     `json:"entries"`
+	Me          *MyPosition `json:"me,omitempty"`
+}
+
+// rankedUser 是排行榜计算中的一个中间结果
+type rankedUser struct {
+	UUID  string
+	Total int64
+}
+
+// profile 是用户公开的排行榜资料
+type profile struct {
+	UUID             string
+	Nickname         string
+	LeaderboardOptIn bool
+}
+
+// getLeaderboard 生成排行榜，userID为空时不包含请求者自己的位置。
+// Redis可用时使用实时的 user:ranking，否则使用SQLite中的快照。
+func getLeaderboard(userID string) (*Leaderboard, error) {
+	var top []rankedUser
+	var totalVoters int64
+	var me *MyPosition
+	var err error
+
+	if database.IsRedisHealthy() {
+		top, totalVoters, me, err = rankingFromRedis(userID)
+	} else {
+		top, totalVoters, me, err = rankingFromSnapshot(userID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// 读取榜上用户和请求者的公开资料
+	uuids := make([]string, 0, len(top)+1)
+	for _, u := range top {
+		uuids = append(uuids, u.UUID)
+	}
+	if me != nil {
+		uuids = append(uuids, userID)
+	}
+	profiles := make(map[string]profile, len(uuids))
+	if len(uuids) > 0 {
+		var rows []profile
+		if err := database.DB.Model(&user.User{}).Select("uuid", "nickname", "leaderboard_opt_in").Where("uuid IN ?", uuids).Find(&rows).Error; err != nil {
+			return nil, fmt.Errorf("查询排行榜用户资料失败: %w", err)
+		}
+		for _, row := range rows {
+			profiles[row.UUID] = row
+		}
+	}
+
+	board := &Leaderboard{
+		GeneratedAt: time.Now(),
+		TotalVoters: totalVoters,
+		Entries:     make([]Entry, 0, len(top)),
+		Me:          me,
+	}
+	for i, u := range top {
+		entry := Entry{
+			Rank:       int64(i + 1),
+			Anonymous:  true,
+			TotalVotes: u.Total,
+			IsMe:       u.UUID == userID,
+		}
+		if p := profiles[u.UUID]; p.LeaderboardOptIn && p.Nickname != "" {
+			entry.Nickname = p.Nickname
+			entry.Anonymous = false
+		}
+		board.Entries = append(board.Entries, entry)
+	}
+	if me != nil {
+		p := profiles[userID]
+		me.Nickname = p.Nickname
+		me.OptedIn = p.LeaderboardOptIn
+	}
+
+	return board, nil
+}
+
+// rankingFromRedis 从 user:ranking 读取排行榜前列和请求者的位置
+func rankingFromRedis(userID string) ([]rankedUser, int64, *MyPosition, error) {
+	pipe := database.RDB.TxPipeline()
+	topCmd := pipe.ZRevRangeWithScores(database.Ctx, user.RankingKey, 0, int64(leaderboardSize-1))
+	totalCmd := pipe.ZCard(database.Ctx, user.RankingKey)
+	var rankCmd *redis.IntCmd
+	var scoreCmd *redis.FloatCmd
+	if userID != "" {
+		rankCmd = pipe.ZRevRank(database.Ctx, user.RankingKey, userID)
+		scoreCmd = pipe.ZScore(database.Ctx, user.RankingKey, userID)
+	}
+	// 用户不在排名中时会返回 redis.Nil
+	if _, err := pipe.Exec(database.Ctx); err != nil && err != redis.Nil {
+		return nil, 0, nil, fmt.Errorf("从Redis获取用户排名时出错: %w", err)
+	}
+
+	zs, err := topCmd.Result()
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("获取排行榜前列时出错: %w", err)
+	}
+	top := make([]rankedUser, 0, len(zs))
+	for _, z := range zs {
+		top = append(top, rankedUser{UUID: z.Member.(string), Total: int64(z.Score)})
+	}
+
+	totalVoters, err := totalCmd.Result()
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("获取投票者总数时出错: %w", err)
+	}
+
+	var me *MyPosition
+	if userID != "" {
+		me = &MyPosition{}
+		if rank, err := rankCmd.Result(); err == nil {
+			me.Rank = rank + 1
+			me.TotalVotes = int64(scoreCmd.Val())
+		} else if err != redis.Nil {
+			return nil, 0, nil, fmt.Errorf("获取用户排名时出错: %w", err)
+		}
+	}
+
+	return top, totalVoters, me, nil
+}
+
+// rankingFromSnapshot 在Redis不可用时，从SQLite的用户快照计算排行榜
+func rankingFromSnapshot(userID string) ([]rankedUser, int64, *MyPosition, error) {
+	const totalExpr = "wins_count + draw_count + skip_count"
+
+	var top []rankedUser
+	err := database.DB.Model(&user.User{}).
+		Select("uuid, " + totalExpr + " AS total").
+		Order("total DESC, uuid DESC").
+		Limit(leaderboardSize).
+		Scan(&top).Error
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("从SQLite获取排行榜前列时出错: %w", err)
+	}
+
+	var totalVoters int64
+	if err := database.DB.Model(&user.User{}).Count(&totalVoters).Error; err != nil {
+		return nil, 0, nil, fmt.Errorf("从SQLite获取投票者总数时出错: %w", err)
+	}
+
+	var me *MyPosition
+	if userID != "" {
+		me = &MyPosition{}
+		var record rankedUser
+		result := database.DB.Model(&user.User{}).Select("uuid, "+totalExpr+" AS total").Where("uuid = ?", userID).Limit(1).Scan(&record)
+		if result.Error != nil {
+			return nil, 0, nil, fmt.Errorf("从SQLite获取用户票数时出错: %w", result.Error)
+		}
+		if result.RowsAffected > 0 {
+			var ahead int64
+			err := database.DB.Model(&user.User{}).
+				Where(totalExpr+" > ? OR ("+totalExpr+" = ? AND uuid > ?)", record.Total, record.Total, userID).
+				Count(&ahead).Error
+			if err != nil {
+				return nil, 0, nil, fmt.Errorf("从SQLite计算用户排名时出错: %w", err)
+			}
+			me.Rank = ahead + 1
+			me.TotalVotes = record.Total
+		}
+	}
+
+	return top, totalVoters, me, nil
+}
+
+// hasVoted 判断用户是否已有被处理的投票
+func hasVoted(userID string) (bool, error) {
+	if database.IsRedisHealthy() {
+		exists, err := database.RDB.HExists(database.Ctx, user.StatsKey, userID).Result()
+		if err != nil {
+			return false, fmt.Errorf("从Redis检查用户统计数据时出错: %w", err)
+		}
+		return exists, nil
+	}
+	var count int64
+	if err := database.DB.Model(&user.User{}).Where("uuid = ?", userID).Count(&count).Error; err != nil {
+		return false, fmt.Errorf("从SQLite检查用户时出错: %w", err)
+	}
+	return count > 0, nil
+}
+
+// setNickname 审核并保存用户的昵称，同时让用户以该昵称出现在排行榜上。
+// 用户行可能尚未被快照写入，此时会先插入一行只含昵称的记录，统计字段由之后的快照填充。
+func setNickname(userID, rawNickname string) (string, error) {
+	nickname, err := moderateNickname(rawNickname)
+	if err != nil {
+		return "", err
+	}
+
+	// 与用户合并、删除互斥，避免为已不存在的用户重新写入一行
+	user.RLockRepository()
+	defer user.RUnlockRepository()
+
+	voted, err := hasVoted(userID)
+	if err != nil {
+		return "", err
+	}
+	if !voted {
+		return "", errNoVotes
+	}
+
+	record := user.User{UUID: userID, Nickname: nickname, LeaderboardOptIn: true}
+	err = database.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "uuid"}},
+		DoUpdates: clause.AssignmentColumns([]string{"nickname", "leaderboard_opt_in", "updated_at"}),
+	}).Create(&record).Error
+	if err != nil {
+		return "", fmt.Errorf("保存用户 %s 的昵称失败: %w", userID, err)
+	}
+	return nickname, nil
+}
+
+// clearNickname 删除用户的昵称，用户之后在排行榜上显示为匿名
+func clearNickname(userID string) error {
+	err := database.DB.Model(&user.User{}).Where("uuid = ?", userID).
+		Updates(map[string]interface{}{"nickname": "", "leaderboard_opt_in": false}).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("清除用户 %s 的昵称失败: %w", userID, err)
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+package leaderboard
+
+import (
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/config"
+)
+
+// ConfigureModule 根据配置设置排行榜大小和昵称审核规则
+func ConfigureModule(cfg config.LeaderboardConfig) {
+	leaderboardSize = cfg.Size
+	loadNicknamePolicy(cfg.Nickname)
+}
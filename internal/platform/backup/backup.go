@@ -4,14 +4,19 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/logging"
 	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/metadata"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/metrics"
 	"github.com/SlpAus/noita-spells-tier-backend/internal/spell"
 	"github.com/SlpAus/noita-spells-tier-backend/internal/user"
 	"github.com/SlpAus/noita-spells-tier-backend/pkg/lifecycle"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/redis/go-redis/v9"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
@@ -21,42 +26,116 @@ const backupInterval = 10 * time.Minute // 定时备份频率
 
 var backupMutex sync.Mutex // 避免意外竞态
 
+// lastSnapshotAt 是本进程内最近一次快照成功的时间（UnixNano），为0表示本进程尚未完成快照
+var lastSnapshotAt atomic.Int64
+
+var (
+	snapshotDuration = metrics.Factory.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "backup",
+		Name:      "snapshot_duration_seconds",
+		Help:      "快照备份的耗时（不含等待快照锁的时间）",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+	})
+
+	snapshotFailures = metrics.Factory.NewCounter(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "backup",
+		Name:      "snapshot_failures_total",
+		Help:      "快照备份失败的次数，因停机而取消的不计入",
+	})
+)
+
+// LockSnapshot 阻止快照备份的执行，供需要同时改写SQLite快照和Redis缓存的操作（如合并用户）使用。
+// 调用方必须在获取任何模块仓库锁之前调用它。
+func LockSnapshot() {
+	backupMutex.Lock()
+}
+
+// UnlockSnapshot 释放由LockSnapshot获取的锁。
+func UnlockSnapshot() {
+	backupMutex.Unlock()
+}
+
 // StartBackupScheduler 启动一个后台Goroutine来定期执行数据库备份
 // 它现在接收一个lifecycle.Handle来管理其生命周期
 func StartBackupScheduler(handle *lifecycle.Handle) {
 	defer handle.Close() // 确保在退出时通知管理器
-	fmt.Println("法术数据备份调度器已启动。")
+	slog.Info("法术数据备份调度器已启动。")
 
 	for {
 		// 使用可中断的休眠来代替ticker。
 		// 这使得整个循环可以在收到停机信号时立刻从休眠中唤醒并退出。
 		if err := handle.Sleep(backupInterval); err != nil {
-			fmt.Printf("备份调度器: 休眠被中断，正在关闭...\n")
+			slog.Info("备份调度器: 休眠被中断，正在关闭...")
 			return
 		}
 
 		if !database.IsRedisHealthy() {
-			fmt.Println("备份调度器: 检测到Redis不可用，跳过本次备份。")
+			slog.Warn("备份调度器: 检测到Redis不可用，跳过本次备份。")
 			continue
 		}
 
-		fmt.Println("备份调度器: 正在执行定时备份...")
+		slog.Info("备份调度器: 正在执行定时备份...")
 		if err := CreateConsistentSnapshotInDB(handle.Ctx()); err != nil {
 			// 如果错误是由于停机信号导致的，则静默退出
 			if err != context.Canceled && err != context.DeadlineExceeded {
-				fmt.Printf("备份调度器错误: 执行快照备份失败: %v\n", err)
+				slog.Error("备份调度器: 执行快照备份失败", logging.Err(err))
 			}
 		} else {
-			fmt.Println("备份调度器: 快照备份成功。")
+			slog.Info("备份调度器: 快照备份成功。")
 		}
 	}
 }
 
+// LastSnapshotTime 返回最近一次快照成功的时间。
+// 本进程尚未完成快照时，从SQLite的元数据中读取上一次运行留下的快照时间。
+func LastSnapshotTime() (time.Time, error) {
+	if nanos := lastSnapshotAt.Load(); nanos != 0 {
+		return time.Unix(0, nanos), nil
+	}
+	return metadata.GetLastSnapshotTime(database.DB)
+}
+
+// takeDirtyUsersScript 原子地取出脏用户集合（改名为处理中集合），并读取这些用户的统计和聚合数据。
+// 返回 {用户ID列表, 统计数据列表, 聚合数据列表}，后两者与用户ID一一对应。
+var takeDirtyUsersScript = redis.NewScript(`
+local ids = redis.call('SMEMBERS', KEYS[1])
+if #ids == 0 then
+  return {{}, {}, {}}
+end
+redis.call('RENAME', KEYS[1], KEYS[2])
+
+local function hmget(key)
+  local values = {}
+  for i = 1, #ids, 1000 do
+    local chunk = redis.call('HMGET', key, unpack(ids, i, math.min(i + 999, #ids)))
+    for _, value in ipairs(chunk) do
+      table.insert(values, value)
+    end
+  end
+  return values
+end
+
+return {ids, hmget(KEYS[3]), hmget(KEYS[4])}
+`)
+
 // CreateConsistentSnapshotInDB 执行一次原子的、一致的快照备份
 func CreateConsistentSnapshotInDB(ctx context.Context) (err error) {
 	backupMutex.Lock()
 	defer backupMutex.Unlock()
 
+	start := time.Now()
+	defer func() {
+		snapshotDuration.Observe(time.Since(start).Seconds())
+		if err == nil {
+			lastSnapshotAt.Store(time.Now().UnixNano())
+		}
+		if err != nil && err != context.Canceled && err != context.DeadlineExceeded {
+			snapshotFailures.Inc()
+		}
+	}()
+
 	var lastVoteIDCmd *redis.StringCmd
 	var totalVotesCmd *redis.StringCmd
 	var statsMapCmd *redis.MapStringStringCmd
@@ -65,45 +144,43 @@ func CreateConsistentSnapshotInDB(ctx context.Context) (err error) {
 
 	var dirtyUserIDs []string
 	var dirtyUserStats []interface{}
+	var dirtyUserAggregates []interface{}
 
 	transferred, err := func() (bool, error) {
-		// user 模块在两批Redis操作期间保持锁定，确保dirtyUserIDs和dirtyUserStats不撕裂
-		user.LockRepository()
-		defer user.UnlockRepository()
-
-		dirtySetExists, err := database.RDB.Exists(ctx, user.DirtySetKey).Result()
-		if err != nil {
-			return false, fmt.Errorf("无法检查Redis中 DirtySetKey 是否存在: %w", err)
-		}
-
-		// 1. 使用原子事务(TxPipeline)从Redis获取快照
+		// 1. 使用原子事务(TxPipeline)从Redis获取快照；脏用户的数据由脚本在同一事务中读取，
+		// 因此无需锁定user模块也能保证它们与检查点处于同一时刻
 		pipe := database.RDB.TxPipeline()
 		lastVoteIDCmd = pipe.Get(database.Ctx, metadata.RedisLastProcessedVoteIDKey)
 		totalVotesCmd = pipe.Get(database.Ctx, metadata.RedisTotalVotesKey)
 		statsMapCmd = pipe.HGetAll(database.Ctx, spell.StatsKey)
 		totalStatsCmd = pipe.HGet(database.Ctx, user.StatsKey, user.TotalStatsKey)
 		sortedIDsCmd = pipe.ZRevRange(database.Ctx, spell.RankingKey, 0, -1)
-		dirtyUserIDsCmd := pipe.SMembers(database.Ctx, user.DirtySetKey)
-		if dirtySetExists > 0 {
-			pipe.Rename(database.Ctx, user.DirtySetKey, user.ProcessingDirtySetKey)
-		}
-		_, err = pipe.Exec(database.Ctx)
+		dirtyUsersCmd := takeDirtyUsersScript.Eval(database.Ctx, pipe,
+			[]string{user.DirtySetKey, user.ProcessingDirtySetKey, user.StatsKey, user.AggregatesKey})
+		_, err := pipe.Exec(database.Ctx)
 
 		if err != nil {
 			return false, fmt.Errorf("无法从Redis原子地获取快照数据: %w", err)
 		}
 		// TxPipeline 成功后，transferred为true，代表 DirtySetKey 已被消费
 
-		dirtyUserIDs, err = dirtyUserIDsCmd.Result()
+		dirtyUsers, err := dirtyUsersCmd.Slice()
 		if err != nil {
-			return true, fmt.Errorf("获取 dirtyUserIDs 的结果时失败: %w", err)
+			return true, fmt.Errorf("获取脏用户数据的结果时失败: %w", err)
 		}
-		if len(dirtyUserIDs) > 0 {
-			dirtyUserStats, err = database.RDB.HMGet(database.Ctx, user.StatsKey, dirtyUserIDs...).Result()
-			if err != nil {
-				return true, fmt.Errorf("获取 dirtyUserStats 的结果时失败: %w", err)
-			}
+		if len(dirtyUsers) != 3 {
+			return true, fmt.Errorf("获取脏用户数据的结果时失败: 无法识别的返回值")
+		}
+		ids, okIDs := dirtyUsers[0].([]interface{})
+		stats, okStats := dirtyUsers[1].([]interface{})
+		aggregates, okAggregates := dirtyUsers[2].([]interface{})
+		if !okIDs || !okStats || !okAggregates {
+			return true, fmt.Errorf("获取脏用户数据的结果时失败: 无法识别的返回值")
 		}
+		for _, id := range ids {
+			dirtyUserIDs = append(dirtyUserIDs, id.(string))
+		}
+		dirtyUserStats, dirtyUserAggregates = stats, aggregates
 
 		return true, nil
 	}()
@@ -200,6 +277,9 @@ func CreateConsistentSnapshotInDB(ctx context.Context) (err error) {
 			DrawCount: userStats.Draw,
 			SkipCount: userStats.Skip,
 		}
+		if aggregatesJSON, ok := dirtyUserAggregates[i].(string); ok {
+			userToUpsert.Aggregates = aggregatesJSON
+		}
 		usersToUpsert = append(usersToUpsert, userToUpsert)
 	}
 
@@ -245,7 +325,7 @@ func CreateConsistentSnapshotInDB(ctx context.Context) (err error) {
 			// 如果UUID已存在，则更新统计字段和updated_at；否则，插入新行。
 			err = tx.Clauses(clause.OnConflict{
 				Columns:   []clause.Column{{Name: "uuid"}},
-				DoUpdates: clause.AssignmentColumns([]string{"wins_count", "draw_count", "skip_count", "updated_at"}),
+				DoUpdates: clause.AssignmentColumns([]string{"wins_count", "draw_count", "skip_count", "aggregates", "updated_at"}),
 			}).Create(&usersToUpsert).Error
 
 			if err != nil {
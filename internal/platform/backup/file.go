@@ -0,0 +1,436 @@
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/config"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/logging"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/metadata"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/metrics"
+	"github.com/SlpAus/noita-spells-tier-backend/pkg/lifecycle"
+	"github.com/prometheus/client_golang/prometheus"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// backupTimeFormat 是备份文件名中的时间戳格式，按字典序排序即按时间排序
+const backupTimeFormat = "20060102T150405Z"
+
+var (
+	fileBackupDir      string
+	fileBackupInterval time.Duration
+	fileBackupKeep     int
+	// fileBackupBase 是备份文件名的前缀，取自数据库文件名
+	fileBackupBase string
+	// voteArchiveDir 是投票归档文件所在的目录，备份会保留它引用的归档文件的副本
+	voteArchiveDir string
+
+	fileBackupMutex sync.Mutex
+)
+
+var fileBackupFailures = metrics.Factory.NewCounter(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Subsystem: "backup",
+	Name:      "file_backup_failures_total",
+	Help:      "数据库文件备份失败的次数",
+})
+
+// BackupFile 描述了备份目录中的一个完整备份
+type BackupFile struct {
+	Path      string
+	CreatedAt time.Time
+	Size      int64
+}
+
+// BackupInfo 是校验一个备份时从中读出的快照信息
+type BackupInfo struct {
+	LastSnapshotVoteID uint
+	LastSnapshotTime   time.Time
+}
+
+// ConfigureFileBackups 读取数据库文件备份的设置
+func ConfigureFileBackups(cfg config.SqliteConfig, archiveCfg config.ArchiveConfig) {
+	fileBackupDir = cfg.Backup.Dir
+	fileBackupInterval = cfg.Backup.Interval
+	fileBackupKeep = cfg.Backup.Keep
+	fileBackupBase = strings.TrimSuffix(filepath.Base(cfg.FileName), filepath.Ext(cfg.FileName))
+	voteArchiveDir = archiveCfg.Dir
+}
+
+// StartFileBackupScheduler 启动一个后台Goroutine，定期把整个SQLite数据库备份到备份目录。
+// 接收一个lifecycle.Handle来管理其生命周期。
+func StartFileBackupScheduler(handle *lifecycle.Handle) {
+	defer handle.Close()
+	slog.Info("数据库文件备份调度器已启动。", slog.String("dir", fileBackupDir))
+
+	for {
+		if err := handle.Sleep(fileBackupInterval); err != nil {
+			slog.Info("数据库文件备份调度器: 休眠被中断，正在关闭...")
+			return
+		}
+
+		path, err := CreateFileBackup(handle.Ctx(), time.Now())
+		if err != nil {
+			// 如果错误是由于停机信号导致的，则静默退出
+			if handle.Ctx().Err() != nil {
+				return
+			}
+			fileBackupFailures.Inc()
+			slog.Error("数据库文件备份调度器: 备份失败", logging.Err(err))
+			continue
+		}
+		slog.Info("数据库文件备份调度器: 备份成功。", slog.String("file", path))
+
+		if err := pruneFileBackups(); err != nil {
+			slog.Warn("数据库文件备份调度器: 清理旧备份失败", logging.Err(err))
+		}
+	}
+}
+
+// CreateFileBackup 使用 VACUUM INTO 在线备份整个数据库，校验后写入备份目录，返回备份文件的路径。
+// 每个备份旁边都有一个记录其SHA-256校验和的 .sha256 文件，没有校验和文件的备份被视为不完整。
+func CreateFileBackup(ctx context.Context, now time.Time) (string, error) {
+	fileBackupMutex.Lock()
+	defer fileBackupMutex.Unlock()
+
+	if err := os.MkdirAll(fileBackupDir, 0o755); err != nil {
+		return "", fmt.Errorf("创建备份目录失败: %w", err)
+	}
+	path := filepath.Join(fileBackupDir, fmt.Sprintf("%s-%s.db", fileBackupBase, now.UTC().Format(backupTimeFormat)))
+	tmp := path + ".tmp"
+	// VACUUM INTO 要求目标文件不存在
+	if err := os.Remove(tmp); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+
+	// 1. VACUUM INTO 在一个读事务中生成数据库的一致副本，不阻塞写入
+	if err := database.DB.WithContext(ctx).Exec("VACUUM INTO ?", tmp).Error; err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("执行 VACUUM INTO 失败: %w", err)
+	}
+
+	// 2. 校验副本的完整性，并保留它引用的投票归档文件：合并或删除用户时归档文件会被改写替换
+	if _, err := inspectBackup(tmp); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	if err := keepArchiveFiles(tmp); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+
+	// 3. 以最终的文件名发布
+	sum, err := fileChecksum(tmp)
+	if err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("发布备份文件失败: %w", err)
+	}
+	if err := os.WriteFile(path+".sha256", []byte(fmt.Sprintf("%s  %s\n", sum, filepath.Base(path))), 0o644); err != nil {
+		os.Remove(path)
+		return "", fmt.Errorf("写入备份校验和失败: %w", err)
+	}
+	return path, nil
+}
+
+// ListFileBackups 列出备份目录中所有完整的备份，按时间从新到旧排列
+func ListFileBackups() ([]BackupFile, error) {
+	dirEntries, err := os.ReadDir(fileBackupDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	prefix := fileBackupBase + "-"
+	var backups []BackupFile
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
+		if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ".db") {
+			continue
+		}
+		createdAt, err := time.Parse(backupTimeFormat, strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".db"))
+		if err != nil {
+			continue
+		}
+		path := filepath.Join(fileBackupDir, name)
+		if _, err := os.Stat(path + ".sha256"); err != nil {
+			continue
+		}
+		info, err := dirEntry.Info()
+		if err != nil {
+			continue
+		}
+		backups = append(backups, BackupFile{Path: path, CreatedAt: createdAt, Size: info.Size()})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].CreatedAt.After(backups[j].CreatedAt) })
+	return backups, nil
+}
+
+// pruneFileBackups 只保留最近的fileBackupKeep个备份，并删除不再被任何备份引用的归档文件副本
+func pruneFileBackups() error {
+	backups, err := ListFileBackups()
+	if err != nil {
+		return err
+	}
+	kept := backups[:min(fileBackupKeep, len(backups))]
+	for _, b := range backups[len(kept):] {
+		// 先删除校验和文件，中途失败时剩下的备份会被视为不完整
+		if err := os.Remove(b.Path + ".sha256"); err != nil {
+			return err
+		}
+		if err := os.Remove(b.Path); err != nil {
+			return err
+		}
+		slog.Info("已删除过期的数据库备份。", slog.String("file", b.Path))
+	}
+
+	referenced := make(map[string]struct{})
+	for _, b := range kept {
+		files, err := backupArchiveFiles(b.Path)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			referenced[file] = struct{}{}
+		}
+	}
+	dirEntries, err := os.ReadDir(archiveCopyDir())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	for _, dirEntry := range dirEntries {
+		if _, ok := referenced[dirEntry.Name()]; !ok {
+			if err := os.Remove(filepath.Join(archiveCopyDir(), dirEntry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// archiveCopyDir 是备份目录中保存投票归档文件副本的子目录
+func archiveCopyDir() string {
+	return filepath.Join(fileBackupDir, "archives")
+}
+
+// backupArchiveFiles 读取一个备份的归档清单中引用的归档文件名
+func backupArchiveFiles(path string) ([]string, error) {
+	db, err := OpenFileBackup(path)
+	if err != nil {
+		return nil, err
+	}
+	if sqlDB, err := db.DB(); err == nil {
+		defer sqlDB.Close()
+	}
+	values, err := metadata.GetValuesWithPrefix(db, metadata.ArchiveFileKeyPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("读取备份 %s 的归档清单失败: %w", path, err)
+	}
+	files := make([]string, 0, len(values))
+	for _, value := range values {
+		// 只需要清单中的文件名，完整的格式由vote模块定义
+		var entry struct {
+			File string `json:"file"`
+		}
+		if err := json.Unmarshal([]byte(value), &entry); err != nil || entry.File == "" {
+			return nil, fmt.Errorf("解析备份 %s 的归档清单失败: %q", path, value)
+		}
+		files = append(files, entry.File)
+	}
+	return files, nil
+}
+
+// keepArchiveFiles 在备份目录中保留备份引用的每个归档文件的副本，已有的副本不会重复复制。
+// 归档文件写入后不再修改，因此优先使用硬链接。
+func keepArchiveFiles(path string) error {
+	files, err := backupArchiveFiles(path)
+	if err != nil || len(files) == 0 {
+		return err
+	}
+	if err := os.MkdirAll(archiveCopyDir(), 0o755); err != nil {
+		return fmt.Errorf("创建归档副本目录失败: %w", err)
+	}
+	for _, file := range files {
+		dst := filepath.Join(archiveCopyDir(), file)
+		if _, err := os.Stat(dst); err == nil {
+			continue
+		}
+		src := filepath.Join(voteArchiveDir, file)
+		if err := os.Link(src, dst); err != nil {
+			if err := copyFile(src, dst); err != nil {
+				os.Remove(dst)
+				return fmt.Errorf("保留归档文件 %s 的副本失败: %w", file, err)
+			}
+		}
+	}
+	return nil
+}
+
+// RestoreArchiveFiles 把备份引用、但已不在归档目录中的归档文件从备份目录中的副本复制回去，返回复制的文件数
+func RestoreArchiveFiles(path string) (int, error) {
+	files, err := backupArchiveFiles(path)
+	if err != nil {
+		return 0, err
+	}
+	restored := 0
+	for _, file := range files {
+		dst := filepath.Join(voteArchiveDir, file)
+		if _, err := os.Stat(dst); err == nil {
+			continue
+		}
+		if err := os.MkdirAll(voteArchiveDir, 0o755); err != nil {
+			return restored, err
+		}
+		if err := copyFile(filepath.Join(archiveCopyDir(), file), dst); err != nil {
+			os.Remove(dst)
+			return restored, fmt.Errorf("恢复归档文件 %s 失败: %w", file, err)
+		}
+		restored++
+	}
+	return restored, nil
+}
+
+// RestoreFileBackup 把备份复制到数据库文件的位置，原数据库文件（连同WAL和共享内存文件）被重命名保留。
+// 返回原数据库文件的新名称。调用方需确保没有进程正在使用数据库，并事先校验备份。
+func RestoreFileBackup(path, fileName string, now time.Time) (string, error) {
+	// 先把备份复制到数据库所在的目录，使最后一步的重命名是原子的
+	tmp := fileName + ".restore.tmp"
+	if err := copyFile(path, tmp); err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("复制备份失败: %w", err)
+	}
+
+	previous := fileName + ".pre-restore-" + now.UTC().Format(backupTimeFormat)
+	for _, ext := range []string{"", "-wal", "-shm"} {
+		if err := os.Rename(fileName+ext, previous+ext); err != nil && !errors.Is(err, os.ErrNotExist) {
+			os.Remove(tmp)
+			return "", fmt.Errorf("重命名原数据库文件失败: %w", err)
+		}
+	}
+	if err := os.Rename(tmp, fileName); err != nil {
+		return previous, fmt.Errorf("放置备份文件失败: %w", err)
+	}
+	return previous, nil
+}
+
+// VerifyFileBackup 校验一个备份：校验和与 .sha256 文件一致、SQLite完整性检查通过，并且包含快照数据
+func VerifyFileBackup(path string) (BackupInfo, error) {
+	recorded, err := os.ReadFile(path + ".sha256")
+	if err != nil {
+		return BackupInfo{}, fmt.Errorf("读取备份校验和失败: %w", err)
+	}
+	fields := strings.Fields(string(recorded))
+	if len(fields) == 0 {
+		return BackupInfo{}, fmt.Errorf("备份校验和文件 %s.sha256 为空", path)
+	}
+	sum, err := fileChecksum(path)
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	if sum != fields[0] {
+		return BackupInfo{}, fmt.Errorf("备份 %s 的校验和不匹配", path)
+	}
+	return inspectBackup(path)
+}
+
+// OpenFileBackup 以只读方式打开一个备份文件
+func OpenFileBackup(path string) (*gorm.DB, error) {
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("file:%s?mode=ro", path)), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		return nil, fmt.Errorf("打开备份 %s 失败: %w", path, err)
+	}
+	return db, nil
+}
+
+// inspectBackup 对备份文件执行SQLite完整性检查，并读取其中的快照信息
+func inspectBackup(path string) (BackupInfo, error) {
+	var info BackupInfo
+	db, err := OpenFileBackup(path)
+	if err != nil {
+		return info, err
+	}
+	if sqlDB, err := db.DB(); err == nil {
+		defer sqlDB.Close()
+	}
+
+	var result string
+	if err := db.Raw("PRAGMA integrity_check").Scan(&result).Error; err != nil {
+		return info, fmt.Errorf("备份 %s 完整性检查失败: %w", path, err)
+	}
+	if result != "ok" {
+		return info, fmt.Errorf("备份 %s 完整性检查失败: %s", path, result)
+	}
+
+	for _, table := range []string{"metadata", "spells", "users", "votes"} {
+		if !db.Migrator().HasTable(table) {
+			return info, fmt.Errorf("备份 %s 缺少数据表 %s", path, table)
+		}
+	}
+	if info.LastSnapshotVoteID, err = metadata.GetLastSnapshotVoteID(db); err != nil {
+		return info, fmt.Errorf("读取备份 %s 的快照ID失败: %w", path, err)
+	}
+	if info.LastSnapshotTime, err = metadata.GetLastSnapshotTime(db); err != nil {
+		return info, fmt.Errorf("读取备份 %s 的快照时间失败: %w", path, err)
+	}
+	return info, nil
+}
+
+// fileChecksum 计算文件内容的SHA-256校验和
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("计算 %s 的校验和失败: %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// copyFile 复制文件并确保内容已写入磁盘
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
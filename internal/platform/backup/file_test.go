@@ -0,0 +1,185 @@
+package backup
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/config"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/metadata"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/spell"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/user"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// setupFileBackupEnv 准备一个带有快照数据的SQLite数据库文件，以及一个空的备份目录
+func setupFileBackupEnv(t *testing.T, keep int) {
+	t.Helper()
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "ranking_spells.db")
+	db, err := gorm.Open(sqlite.Open(fileName), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("打开测试数据库失败: %v", err)
+	}
+	sqlDB, _ := db.DB()
+	t.Cleanup(func() { sqlDB.Close() })
+	database.DB = db
+
+	if err := db.AutoMigrate(&metadata.Metadata{}, &spell.Spell{}, &user.User{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Exec("CREATE TABLE votes (id integer PRIMARY KEY)").Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := metadata.SetLastSnapshotVoteID(db, 42); err != nil {
+		t.Fatal(err)
+	}
+
+	ConfigureFileBackups(config.SqliteConfig{
+		FileName: fileName,
+		Backup:   config.SqliteBackupConfig{Enabled: true, Dir: filepath.Join(dir, "backups"), Interval: time.Hour, Keep: keep},
+	}, config.ArchiveConfig{Dir: filepath.Join(dir, "archive")})
+}
+
+func TestCreateAndVerifyFileBackup(t *testing.T) {
+	setupFileBackupEnv(t, 2)
+
+	path, err := CreateFileBackup(context.Background(), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := VerifyFileBackup(path)
+	if err != nil {
+		t.Fatalf("校验新备份失败: %v", err)
+	}
+	if info.LastSnapshotVoteID != 42 {
+		t.Errorf("备份中的快照ID为 %d, 期望 42", info.LastSnapshotVoteID)
+	}
+
+	// 被篡改的备份无法通过校验
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)/2] ^= 0xff
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyFileBackup(path); err == nil {
+		t.Error("期望被篡改的备份校验失败")
+	}
+}
+
+func TestPruneFileBackups(t *testing.T) {
+	setupFileBackupEnv(t, 2)
+
+	start := time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)
+	var paths []string
+	for i := 0; i < 3; i++ {
+		path, err := CreateFileBackup(context.Background(), start.Add(time.Duration(i)*time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	// 没有校验和文件的备份不完整，不会被列出
+	if err := os.WriteFile(filepath.Join(fileBackupDir, "ranking_spells-20260101T050000Z.db"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pruneFileBackups(); err != nil {
+		t.Fatal(err)
+	}
+	backups, err := ListFileBackups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 || backups[0].Path != paths[2] || backups[1].Path != paths[1] {
+		t.Fatalf("清理后的备份: %+v", backups)
+	}
+	if _, err := os.Stat(paths[0]); !os.IsNotExist(err) {
+		t.Error("最早的备份没有被删除")
+	}
+}
+
+func TestFileBackupKeepsArchiveFiles(t *testing.T) {
+	setupFileBackupEnv(t, 1)
+
+	// 1. 备份时保留它引用的归档文件的副本
+	if err := os.MkdirAll(voteArchiveDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	const archiveFile = "votes-2025-01-1-0123abcd.jsonl.gz"
+	if err := os.WriteFile(filepath.Join(voteArchiveDir, archiveFile), []byte("archived"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := metadata.SetValue(database.DB, metadata.ArchiveFileKeyPrefix+"0000000001", `{"file":"`+archiveFile+`"}`); err != nil {
+		t.Fatal(err)
+	}
+	path, err := CreateFileBackup(context.Background(), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 2. 归档文件被改写替换后，恢复时从副本找回
+	if err := os.Remove(filepath.Join(voteArchiveDir, archiveFile)); err != nil {
+		t.Fatal(err)
+	}
+	if restored, err := RestoreArchiveFiles(path); err != nil || restored != 1 {
+		t.Fatalf("恢复了 %d 个归档文件 (%v), 期望 1", restored, err)
+	}
+	if data, err := os.ReadFile(filepath.Join(voteArchiveDir, archiveFile)); err != nil || string(data) != "archived" {
+		t.Errorf("恢复的归档文件内容为 %q (%v)", data, err)
+	}
+
+	// 3. 放置备份时保留原数据库文件
+	target := filepath.Join(t.TempDir(), "restored.db")
+	if err := os.WriteFile(target, []byte("current"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	previous, err := RestoreFileBackup(path, target, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(previous); string(data) != "current" {
+		t.Errorf("原数据库文件没有被保留: %q", data)
+	}
+	if _, err := VerifyFileBackup(path); err != nil {
+		t.Fatal(err)
+	}
+	if sum, _ := fileChecksum(target); sum != mustChecksum(t, path) {
+		t.Error("恢复后的数据库与备份不一致")
+	}
+
+	// 4. 不再被任何备份引用的副本在清理时被删除
+	if err := database.DB.Where("key = ?", metadata.ArchiveFileKeyPrefix+"0000000001").Delete(&metadata.Metadata{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CreateFileBackup(context.Background(), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := pruneFileBackups(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(archiveCopyDir(), archiveFile)); !os.IsNotExist(err) {
+		t.Error("不再被引用的归档副本没有被删除")
+	}
+}
+
+func mustChecksum(t *testing.T, path string) string {
+	t.Helper()
+	sum, err := fileChecksum(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sum
+}
@@ -0,0 +1,60 @@
+package clientip
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/config"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// ipv4PrefixLen 和 ipv6PrefixLen 决定了在频率限制中被视为同一来源的网段大小
+	ipv4PrefixLen = 32
+	ipv6PrefixLen = 64
+)
+
+// Configure 根据配置设置Gin的可信代理和可信头部，并加载网段聚合参数。
+// 未配置任何可信代理时，Gin将忽略 X-Forwarded-For 等头部，直接使用TCP连接的对端地址。
+func Configure(r *gin.Engine, cfg config.ServerConfig) error {
+	var proxies []string
+	if len(cfg.TrustedProxies) > 0 {
+		proxies = cfg.TrustedProxies
+	}
+	if err := r.SetTrustedProxies(proxies); err != nil {
+		return fmt.Errorf("可信代理列表无效: %w", err)
+	}
+
+	// 可信头部由CDN等平台设置（例如Cloudflare的 CF-Connecting-IP），
+	// 启用后Gin会优先信任该头部，因此只应在源站仅接受平台回源流量时使用
+	r.TrustedPlatform = strings.TrimSpace(cfg.TrustedPlatform)
+
+	ipv4PrefixLen = cfg.IPAggregation.IPv4Prefix
+	ipv6PrefixLen = cfg.IPAggregation.IPv6Prefix
+	return nil
+}
+
+// SubnetKey 将一个IP地址规整为频率限制所使用的来源键。
+// 当前缀长度等于地址长度时直接返回规范化的IP，否则返回CIDR形式的网段，
+// 例如 "2001:db8:1:2::/64" 或 "203.0.113.0/24"。
+func SubnetKey(ip string) (string, error) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return "", errors.New("IP地址无效")
+	}
+
+	if v4 := parsed.To4(); v4 != nil {
+		return maskedKey(v4, ipv4PrefixLen, 32), nil
+	}
+	return maskedKey(parsed.To16(), ipv6PrefixLen, 128), nil
+}
+
+func maskedKey(ip net.IP, prefixLen, bits int) string {
+	if prefixLen >= bits {
+		return ip.String()
+	}
+	network := ip.Mask(net.CIDRMask(prefixLen, bits))
+	return fmt.Sprintf("%s/%d", network.String(), prefixLen)
+}
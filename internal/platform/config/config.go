@@ -1,9 +1,10 @@
 package config
 
 import (
-	"os"
 	"fmt"
+	"os"
 	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -14,16 +15,30 @@ var Cfg *Config
 // Config 结构体定义了应用程序的所有配置项
 // 它与 config.yaml 文件的结构完全对应
 type Config struct {
-	Server   ServerConfig   `mapstructure:"server"`
-	App      AppConfig      `mapstructure:"app"`
-	Database DatabaseConfig `mapstructure:"database"`
+	Server      ServerConfig      `mapstructure:"server"`
+	App         AppConfig         `mapstructure:"app"`
+	Database    DatabaseConfig    `mapstructure:"database"`
+	Vote        VoteConfig        `mapstructure:"vote"`
+	Token       TokenConfig       `mapstructure:"token"`
+	RateLimit   RateLimitConfig   `mapstructure:"rateLimit"`
+	Leaderboard LeaderboardConfig `mapstructure:"leaderboard"`
+	Achievement AchievementConfig `mapstructure:"achievement"`
+	Log         LogConfig         `mapstructure:"log"`
+	Health      HealthConfig      `mapstructure:"health"`
 }
 
 // ServerConfig 定义了服务器相关的配置
 type ServerConfig struct {
 	Mode    ServerMode `mapstructure:"mode"`
 	Address string     `mapstructure:"address"`
-	Cors    CorsConfig `mapstructure:"cors"`
+	// InternalAddress 是内部监听地址，只提供 /metrics 等运维接口，为空时不启用
+	InternalAddress string     `mapstructure:"internalAddress"`
+	Cors            CorsConfig `mapstructure:"cors"`
+	// TrustedProxies 是允许设置 X-Forwarded-For / X-Real-IP 的反向代理地址或CIDR列表，为空时不信任任何代理
+	TrustedProxies []string `mapstructure:"trustedProxies"`
+	// TrustedPlatform 是由CDN平台设置的客户端IP头部，例如 CF-Connecting-IP，为空时不启用
+	TrustedPlatform string              `mapstructure:"trustedPlatform"`
+	IPAggregation   IPAggregationConfig `mapstructure:"ipAggregation"`
 }
 
 type ServerMode string
@@ -39,6 +54,14 @@ type CorsConfig struct {
 	AllowedOrigins []string `mapstructure:"allowedOrigins"`
 }
 
+// IPAggregationConfig 定义了频率限制中IP地址按网段聚合的前缀长度
+type IPAggregationConfig struct {
+	// IPv4Prefix 为32时每个IPv4地址单独计数，为24时同一/24网段共用计数
+	IPv4Prefix int `mapstructure:"ipv4Prefix"`
+	// IPv6Prefix 通常为64，因为一个家庭或设备往往会分得一整个/64网段
+	IPv6Prefix int `mapstructure:"ipv6Prefix"`
+}
+
 // AppConfig 定义了应用模式相关的配置
 type AppConfig struct {
 	Mode AppMode `mapstructure:"mode"`
@@ -53,21 +76,212 @@ const (
 
 // DatabaseConfig 定义了数据库和缓存相关的配置
 type DatabaseConfig struct {
-	Redis  RedisConfig  `mapstructure:"redis"`
-	Sqlite SqliteConfig `mapstructure:"sqlite"`
+	// Driver 是持久化存储的实现: sqlite / postgres
+	Driver   DatabaseDriver `mapstructure:"driver"`
+	Redis    RedisConfig    `mapstructure:"redis"`
+	Sqlite   SqliteConfig   `mapstructure:"sqlite"`
+	Postgres PostgresConfig `mapstructure:"postgres"`
 }
 
+type DatabaseDriver string
+
+const (
+	DatabaseDriverSqlite   DatabaseDriver = "sqlite"
+	DatabaseDriverPostgres DatabaseDriver = "postgres"
+)
+
 // RedisConfig 定义了Redis的配置
 type RedisConfig struct {
+	// Mode 是Redis的部署方式: standalone / sentinel / cluster
+	Mode RedisMode `mapstructure:"mode"`
+	// Address 是单机模式下的服务器地址
 	Address  string `mapstructure:"address"`
 	Password string `mapstructure:"password"`
-	DB       int    `mapstructure:"db"`
+	// DB 是使用的数据库编号，集群模式下只能为0
+	DB int `mapstructure:"db"`
+	// MasterName 是Sentinel模式下被监控的主节点名称
+	MasterName string `mapstructure:"masterName"`
+	// Addresses 是Sentinel模式下的哨兵地址，或集群模式下的种子节点地址
+	Addresses []string `mapstructure:"addresses"`
+	// SentinelPassword 是连接哨兵本身使用的密码，可以与Password不同
+	SentinelPassword string `mapstructure:"sentinelPassword"`
 }
 
+type RedisMode string
+
+const (
+	RedisModeStandalone RedisMode = "standalone"
+	RedisModeSentinel   RedisMode = "sentinel"
+	RedisModeCluster    RedisMode = "cluster"
+)
+
 // SqliteConfig 定义了内存缓存的配置
 type SqliteConfig struct {
-	FileName       string `mapstructure:"fileName"`
-	MaxCacheSizeKB int64  `mapstructure:"maxCacheSizeKB"`
+	FileName       string             `mapstructure:"fileName"`
+	MaxCacheSizeKB int64              `mapstructure:"maxCacheSizeKB"`
+	Backup         SqliteBackupConfig `mapstructure:"backup"`
+}
+
+// SqliteBackupConfig 定义了整个SQLite数据库文件的定期在线备份
+type SqliteBackupConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Dir 是备份文件所在的目录，应与数据库文件位于不同的磁盘或卷
+	Dir string `mapstructure:"dir"`
+	// Interval 是两次备份之间的间隔
+	Interval time.Duration `mapstructure:"interval"`
+	// Keep 是保留的最近备份数量，更早的备份会被删除
+	Keep int `mapstructure:"keep"`
+}
+
+// PostgresConfig 定义了PostgreSQL的连接配置
+type PostgresConfig struct {
+	// DSN 是连接字符串，例如 "host=localhost user=noita password=... dbname=noita sslmode=disable"，
+	// 通常通过环境变量 DATABASE_POSTGRES_DSN 提供
+	DSN string `mapstructure:"dsn"`
+	// MaxOpenConns 是连接池的最大连接数
+	MaxOpenConns int `mapstructure:"maxOpenConns"`
+}
+
+// VoteConfig 定义了投票凭证（pair token）校验相关的配置
+type VoteConfig struct {
+	// TokenTTL 是投票凭证自签发起的有效期
+	TokenTTL time.Duration `mapstructure:"tokenTTL"`
+	// MinThinkTime 是从签发凭证到提交投票之间，被视为人类可能达到的最短间隔
+	MinThinkTime time.Duration `mapstructure:"minThinkTime"`
+	// ReplayBackend 是防重放缓存的实现: auto / bloom / bucket
+	// bloom 需要RedisBloom模块，bucket 只使用原生Redis命令，auto 在启动时自动检测
+	ReplayBackend string `mapstructure:"replayBackend"`
+	// UndoWindow 是投票后允许撤销的时长，为0时禁用撤销
+	UndoWindow time.Duration `mapstructure:"undoWindow"`
+	// BatchSize 是投票处理器一次合并应用的最大连续投票数，为1时逐张处理
+	BatchSize int `mapstructure:"batchSize"`
+	// Challenge 是针对高频投票者的工作量证明挑战设置
+	Challenge ChallengeConfig `mapstructure:"challenge"`
+	// Archive 是投票日志归档的设置
+	Archive ArchiveConfig `mapstructure:"archive"`
+	// Rejections 是被拒绝投票记录的限额和保留设置
+	Rejections RejectionLogConfig `mapstructure:"rejections"`
+}
+
+// RejectionLogConfig 定义了被拒绝投票写入rejected_votes表的限额和保留时长
+type RejectionLogConfig struct {
+	// PerIP 是每个来源IP网段写入拒绝记录的令牌桶，超出的拒绝只计入指标，不再写入数据库
+	PerIP TokenBucketConfig `mapstructure:"perIP"`
+	// Retention 是拒绝记录的保留时长，更早的记录会被后台任务删除
+	Retention time.Duration `mapstructure:"retention"`
+	// PruneInterval 是清理过期拒绝记录的运行间隔
+	PruneInterval time.Duration `mapstructure:"pruneInterval"`
+}
+
+// ArchiveConfig 定义了把旧投票从votes表移入压缩归档文件的后台任务
+type ArchiveConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Dir 是归档文件所在的目录，多实例部署时应指向共享存储
+	Dir string `mapstructure:"dir"`
+	// MinAge 是投票被归档前至少经过的时长，归档以自然月为单位
+	MinAge time.Duration `mapstructure:"minAge"`
+	// Interval 是归档任务的运行间隔
+	Interval time.Duration `mapstructure:"interval"`
+}
+
+// ChallengeConfig 定义了工作量证明挑战的触发条件和难度曲线
+type ChallengeConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Threshold 是触发挑战的近期投票数（按IP网段或用户中较大者计）
+	Threshold int64 `mapstructure:"threshold"`
+	// BaseDifficulty 是刚触发挑战时的难度，单位为前导零比特数
+	BaseDifficulty int `mapstructure:"baseDifficulty"`
+	// DifficultyStep 是难度每增加1比特所需的额外投票数
+	DifficultyStep int64 `mapstructure:"difficultyStep"`
+	// MaxDifficulty 是难度上限
+	MaxDifficulty int `mapstructure:"maxDifficulty"`
+}
+
+// TokenConfig 定义了HMAC签名密钥环的来源
+type TokenConfig struct {
+	// KeyFile 是密钥环JSON文件的路径，多实例部署时应指向同一份文件
+	KeyFile string `mapstructure:"keyFile"`
+	// Keys 是内联的密钥环JSON，通常通过环境变量 TOKEN_KEYS 提供，优先于 KeyFile
+	Keys string `mapstructure:"keys"`
+	// RecoveryCodeTTL 是恢复码自签发起的有效期
+	RecoveryCodeTTL time.Duration `mapstructure:"recoveryCodeTTL"`
+}
+
+// RateLimitConfig 定义了接口限流相关的配置
+type RateLimitConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Backend 是令牌桶状态的存储位置: redis (多实例共享) / memory (进程内)
+	Backend string              `mapstructure:"backend"`
+	Pair    PairRateLimitConfig `mapstructure:"pair"`
+}
+
+// PairRateLimitConfig 定义了获取候选对接口在各维度上的限额
+type PairRateLimitConfig struct {
+	PerIP   TokenBucketConfig `mapstructure:"perIP"`
+	PerUser TokenBucketConfig `mapstructure:"perUser"`
+}
+
+// TokenBucketConfig 描述一个令牌桶，Rate或Burst为0时表示该维度不限流
+type TokenBucketConfig struct {
+	// Rate 是每秒补充的令牌数
+	Rate float64 `mapstructure:"rate"`
+	// Burst 是桶的容量，即允许的最大突发请求数
+	Burst int `mapstructure:"burst"`
+}
+
+// LeaderboardConfig 定义了公开投票者排行榜相关的配置
+type LeaderboardConfig struct {
+	// Size 是排行榜显示的投票者人数
+	Size     int            `mapstructure:"size"`
+	Nickname NicknameConfig `mapstructure:"nickname"`
+}
+
+// NicknameConfig 定义了排行榜昵称的审核规则
+type NicknameConfig struct {
+	MinLength int `mapstructure:"minLength"`
+	MaxLength int `mapstructure:"maxLength"`
+	// BlockedWords 是不允许出现在昵称中的词，匹配时忽略大小写、空白和标点
+	BlockedWords []string `mapstructure:"blockedWords"`
+}
+
+// AchievementConfig 定义了成就系统相关的配置
+type AchievementConfig struct {
+	// LaunchDate 是上线当天的日期（YYYY-MM-DD，服务器本地时间），为空时不启用"上线当天投票"成就
+	LaunchDate string `mapstructure:"launchDate"`
+	// EvaluateInterval 是后台任务评估有新投票的用户成就的间隔
+	EvaluateInterval time.Duration `mapstructure:"evaluateInterval"`
+}
+
+// LogConfig 定义了日志相关的配置
+type LogConfig struct {
+	Level  LogLevel  `mapstructure:"level"`
+	Format LogFormat `mapstructure:"format"`
+	// SlowQueryThreshold 是SQL慢查询的告警阈值，为0时不记录慢查询
+	SlowQueryThreshold time.Duration `mapstructure:"slowQueryThreshold"`
+}
+
+type LogLevel string
+
+const (
+	LogLevelDebug LogLevel = "debug"
+	LogLevelInfo  LogLevel = "info"
+	LogLevelWarn  LogLevel = "warn"
+	LogLevelError LogLevel = "error"
+)
+
+type LogFormat string
+
+const (
+	LogFormatText LogFormat = "text"
+	LogFormatJSON LogFormat = "json"
+)
+
+// HealthConfig 定义了就绪探针 /readyz 的判定阈值
+type HealthConfig struct {
+	// MaxProcessorLag 是允许的最大投票处理积压（按投票ID计），超过时视为未就绪
+	MaxProcessorLag uint64 `mapstructure:"maxProcessorLag"`
+	// MaxSnapshotAge 是距上次成功快照允许的最长时间，超过时视为未就绪
+	MaxSnapshotAge time.Duration `mapstructure:"maxSnapshotAge"`
 }
 
 func (cfg *Config) validate() error {
@@ -77,12 +291,164 @@ func (cfg *Config) validate() error {
 		return fmt.Errorf("cfg.Server.Mode 不能为 %s", cfg.Server.Mode)
 	}
 
+	if cfg.Server.InternalAddress != "" && cfg.Server.InternalAddress == cfg.Server.Address {
+		return fmt.Errorf("cfg.Server.InternalAddress 不能与 Address 相同")
+	}
+	if p := cfg.Server.IPAggregation.IPv4Prefix; p < 8 || p > 32 {
+		return fmt.Errorf("cfg.Server.IPAggregation.IPv4Prefix 必须在 [8, 32] 区间内")
+	}
+	if p := cfg.Server.IPAggregation.IPv6Prefix; p < 16 || p > 128 {
+		return fmt.Errorf("cfg.Server.IPAggregation.IPv6Prefix 必须在 [16, 128] 区间内")
+	}
+
 	switch cfg.App.Mode {
 	case AppModeSpell, AppModePerk:
 	default:
 		return fmt.Errorf("cfg.App.Mode 不能为 %s", cfg.App.Mode)
 	}
 
+	if cfg.Token.RecoveryCodeTTL <= 0 {
+		return fmt.Errorf("cfg.Token.RecoveryCodeTTL 必须为正数")
+	}
+	if cfg.Vote.TokenTTL <= 0 {
+		return fmt.Errorf("cfg.Vote.TokenTTL 必须为正数")
+	}
+	if cfg.Vote.MinThinkTime < 0 || cfg.Vote.MinThinkTime >= cfg.Vote.TokenTTL {
+		return fmt.Errorf("cfg.Vote.MinThinkTime 必须在 [0, TokenTTL) 区间内")
+	}
+	switch redisCfg := cfg.Database.Redis; redisCfg.Mode {
+	case RedisModeStandalone:
+		if redisCfg.Address == "" {
+			return fmt.Errorf("单机模式下 cfg.Database.Redis.Address 不能为空")
+		}
+	case RedisModeSentinel:
+		if redisCfg.MasterName == "" || len(redisCfg.Addresses) == 0 {
+			return fmt.Errorf("Sentinel模式下 cfg.Database.Redis.MasterName 和 Addresses 不能为空")
+		}
+	case RedisModeCluster:
+		if len(redisCfg.Addresses) == 0 {
+			return fmt.Errorf("集群模式下 cfg.Database.Redis.Addresses 不能为空")
+		}
+		if redisCfg.DB != 0 {
+			return fmt.Errorf("集群模式下 cfg.Database.Redis.DB 只能为0")
+		}
+	default:
+		return fmt.Errorf("cfg.Database.Redis.Mode 不能为 %s", redisCfg.Mode)
+	}
+	switch cfg.Database.Driver {
+	case DatabaseDriverSqlite:
+		if b := cfg.Database.Sqlite.Backup; b.Enabled {
+			if b.Dir == "" {
+				return fmt.Errorf("启用备份时 cfg.Database.Sqlite.Backup.Dir 不能为空")
+			}
+			if b.Interval < time.Minute {
+				return fmt.Errorf("cfg.Database.Sqlite.Backup.Interval 不能小于 1m")
+			}
+			if b.Keep < 1 {
+				return fmt.Errorf("cfg.Database.Sqlite.Backup.Keep 必须为正数")
+			}
+		}
+	case DatabaseDriverPostgres:
+		if cfg.Database.Postgres.DSN == "" {
+			return fmt.Errorf("使用PostgreSQL时 cfg.Database.Postgres.DSN 不能为空")
+		}
+		if cfg.Database.Postgres.MaxOpenConns < 1 {
+			return fmt.Errorf("cfg.Database.Postgres.MaxOpenConns 必须为正数")
+		}
+	default:
+		return fmt.Errorf("cfg.Database.Driver 不能为 %s", cfg.Database.Driver)
+	}
+
+	switch cfg.Vote.ReplayBackend {
+	case "auto", "bloom", "bucket":
+	default:
+		return fmt.Errorf("cfg.Vote.ReplayBackend 不能为 %s", cfg.Vote.ReplayBackend)
+	}
+
+	if cfg.Vote.UndoWindow < 0 {
+		return fmt.Errorf("cfg.Vote.UndoWindow 不能为负数")
+	}
+	if cfg.Vote.BatchSize < 1 || cfg.Vote.BatchSize > 10000 {
+		return fmt.Errorf("cfg.Vote.BatchSize 必须在 [1, 10000] 区间内")
+	}
+	if ch := cfg.Vote.Challenge; ch.Enabled {
+		if ch.Threshold < 0 || ch.DifficultyStep <= 0 {
+			return fmt.Errorf("cfg.Vote.Challenge 的 Threshold 不能为负数，DifficultyStep 必须为正数")
+		}
+		if ch.BaseDifficulty < 1 || ch.MaxDifficulty < ch.BaseDifficulty || ch.MaxDifficulty > 32 {
+			return fmt.Errorf("cfg.Vote.Challenge 的难度必须满足 1 <= BaseDifficulty <= MaxDifficulty <= 32")
+		}
+	}
+
+	if r := cfg.Vote.Rejections; r.PerIP.Rate < 0 || r.PerIP.Burst < 0 {
+		return fmt.Errorf("cfg.Vote.Rejections.PerIP 不能为负数")
+	}
+	if cfg.Vote.Rejections.Retention <= 0 || cfg.Vote.Rejections.PruneInterval <= 0 {
+		return fmt.Errorf("cfg.Vote.Rejections 的 Retention 和 PruneInterval 必须为正数")
+	}
+	if a := cfg.Vote.Archive; a.Enabled {
+		if a.Dir == "" {
+			return fmt.Errorf("启用归档时 cfg.Vote.Archive.Dir 不能为空")
+		}
+		if a.MinAge < 24*time.Hour {
+			return fmt.Errorf("cfg.Vote.Archive.MinAge 不能小于 24h")
+		}
+		if a.Interval <= 0 {
+			return fmt.Errorf("cfg.Vote.Archive.Interval 必须为正数")
+		}
+	}
+
+	switch cfg.RateLimit.Backend {
+	case "redis", "memory":
+	default:
+		return fmt.Errorf("cfg.RateLimit.Backend 不能为 %s", cfg.RateLimit.Backend)
+	}
+	for name, bucket := range map[string]TokenBucketConfig{
+		"Pair.PerIP":   cfg.RateLimit.Pair.PerIP,
+		"Pair.PerUser": cfg.RateLimit.Pair.PerUser,
+	} {
+		if bucket.Rate < 0 || bucket.Burst < 0 {
+			return fmt.Errorf("cfg.RateLimit.%s 不能为负数", name)
+		}
+	}
+
+	if cfg.Leaderboard.Size < 1 || cfg.Leaderboard.Size > 500 {
+		return fmt.Errorf("cfg.Leaderboard.Size 必须在 [1, 500] 区间内")
+	}
+	if n := cfg.Leaderboard.Nickname; n.MinLength < 1 || n.MaxLength < n.MinLength || n.MaxLength > 32 {
+		return fmt.Errorf("cfg.Leaderboard.Nickname 的长度必须满足 1 <= MinLength <= MaxLength <= 32")
+	}
+
+	if cfg.Achievement.LaunchDate != "" {
+		if _, err := time.ParseInLocation("2006-01-02", cfg.Achievement.LaunchDate, time.Local); err != nil {
+			return fmt.Errorf("cfg.Achievement.LaunchDate 必须是 YYYY-MM-DD 格式的日期")
+		}
+	}
+	if cfg.Achievement.EvaluateInterval <= 0 {
+		return fmt.Errorf("cfg.Achievement.EvaluateInterval 必须为正数")
+	}
+
+	switch cfg.Log.Level {
+	case LogLevelDebug, LogLevelInfo, LogLevelWarn, LogLevelError:
+	default:
+		return fmt.Errorf("cfg.Log.Level 不能为 %s", cfg.Log.Level)
+	}
+	switch cfg.Log.Format {
+	case LogFormatText, LogFormatJSON:
+	default:
+		return fmt.Errorf("cfg.Log.Format 不能为 %s", cfg.Log.Format)
+	}
+	if cfg.Log.SlowQueryThreshold < 0 {
+		return fmt.Errorf("cfg.Log.SlowQueryThreshold 不能为负数")
+	}
+
+	if cfg.Health.MaxProcessorLag == 0 {
+		return fmt.Errorf("cfg.Health.MaxProcessorLag 必须为正数")
+	}
+	if cfg.Health.MaxSnapshotAge <= 0 {
+		return fmt.Errorf("cfg.Health.MaxSnapshotAge 必须为正数")
+	}
+
 	return nil
 }
 
@@ -98,7 +464,7 @@ func LoadConfig() (*Config, error) {
 
 	// 1. 设置配置文件名和类型
 	v.SetConfigName(configName) // 文件名 (不带扩展名)
-	v.SetConfigType("yaml")   // 文件类型
+	v.SetConfigType("yaml")     // 文件类型
 
 	// 2. 添加配置文件搜索路径
 	// 可以添加多个路径，Viper会按顺序查找
@@ -110,6 +476,62 @@ func LoadConfig() (*Config, error) {
 	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 	v.AutomaticEnv()
 
+	// 为可选的配置项设置默认值
+	v.SetDefault("server.internalAddress", "127.0.0.1:9090")
+	v.SetDefault("server.trustedProxies", []string{})
+	v.SetDefault("server.trustedPlatform", "")
+	v.SetDefault("server.ipAggregation.ipv4Prefix", 32)
+	v.SetDefault("server.ipAggregation.ipv6Prefix", 64)
+	v.SetDefault("database.driver", "sqlite")
+	v.SetDefault("database.redis.mode", "standalone")
+	v.SetDefault("database.redis.masterName", "")
+	v.SetDefault("database.redis.addresses", []string{})
+	v.SetDefault("database.redis.sentinelPassword", "")
+	v.SetDefault("database.sqlite.backup.enabled", false)
+	v.SetDefault("database.sqlite.backup.dir", "backups")
+	v.SetDefault("database.sqlite.backup.interval", "6h")
+	v.SetDefault("database.sqlite.backup.keep", 28)
+	v.SetDefault("database.postgres.dsn", "")
+	v.SetDefault("database.postgres.maxOpenConns", 20)
+	v.SetDefault("vote.tokenTTL", "30m")
+	v.SetDefault("vote.minThinkTime", "500ms")
+	v.SetDefault("vote.replayBackend", "auto")
+	v.SetDefault("vote.undoWindow", "15s")
+	v.SetDefault("vote.batchSize", 256)
+	v.SetDefault("vote.challenge.enabled", true)
+	v.SetDefault("vote.challenge.threshold", 200)
+	v.SetDefault("vote.challenge.baseDifficulty", 16)
+	v.SetDefault("vote.challenge.difficultyStep", 100)
+	v.SetDefault("vote.challenge.maxDifficulty", 24)
+	v.SetDefault("vote.archive.enabled", false)
+	v.SetDefault("vote.archive.dir", "archive")
+	v.SetDefault("vote.archive.minAge", "2160h")
+	v.SetDefault("vote.archive.interval", "24h")
+	v.SetDefault("vote.rejections.perIP.rate", 0.1)
+	v.SetDefault("vote.rejections.perIP.burst", 20)
+	v.SetDefault("vote.rejections.retention", "720h")
+	v.SetDefault("vote.rejections.pruneInterval", "1h")
+	v.SetDefault("token.keyFile", "")
+	v.SetDefault("token.keys", "")
+	v.SetDefault("token.recoveryCodeTTL", "720h")
+	v.SetDefault("rateLimit.enabled", true)
+	v.SetDefault("rateLimit.backend", "redis")
+	v.SetDefault("rateLimit.pair.perIP.rate", 2)
+	v.SetDefault("rateLimit.pair.perIP.burst", 30)
+	v.SetDefault("rateLimit.pair.perUser.rate", 1)
+	v.SetDefault("rateLimit.pair.perUser.burst", 20)
+	v.SetDefault("leaderboard.size", 50)
+	v.SetDefault("leaderboard.nickname.minLength", 2)
+	v.SetDefault("leaderboard.nickname.maxLength", 16)
+	v.SetDefault("leaderboard.nickname.blockedWords", []string{})
+	v.SetDefault("achievement.launchDate", "")
+	v.SetDefault("achievement.evaluateInterval", "1m")
+	v.SetDefault("log.level", "info")
+	v.SetDefault("log.format", "text")
+	v.SetDefault("log.slowQueryThreshold", "200ms")
+	v.SetDefault("health.maxProcessorLag", 1000)
+	v.SetDefault("health.maxSnapshotAge", "30m")
+
 	// 4. 读取配置文件
 	if err := v.ReadInConfig(); err != nil {
 		return nil, err
@@ -3,62 +3,73 @@ package database
 import (
 	"errors"
 	"fmt"
-	"log"
-	"os"
+	"log/slog"
 
 	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/config"
-	"github.com/mattn/go-sqlite3"
-	"gorm.io/driver/sqlite"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/logging"
 	"gorm.io/gorm"
-	"gorm.io/gorm/logger"
 )
 
 var DB *gorm.DB
 
-// InitDB 初始化数据库连接
-func InitDB(cfg config.SqliteConfig) {
-	var err error
-
-	// 根据配置动态构建包含性能优化的DSN字符串
-	// cache_size单位是KiB，负值表示使用KiB。
-	dsn := fmt.Sprintf("file:%s?journal_mode=WAL&cache=shared&cache_size=-%d", cfg.FileName, cfg.MaxCacheSizeKB)
-
-	// GORM日志配置
-	newLogger := logger.New(
-		log.New(os.Stdout, "\r\n", log.LstdFlags),
-		logger.Config{
-			SlowThreshold: 0,
-			LogLevel:      logger.Silent,
-			Colorful:      true,
-		},
-	)
+// InitDB 根据配置的驱动初始化数据库连接
+func InitDB(cfg config.DatabaseConfig) {
+	var dialector gorm.Dialector
+	switch cfg.Driver {
+	case config.DatabaseDriverPostgres:
+		dialector = openPostgres(cfg.Postgres)
+	default:
+		var err error
+		if sqliteLock, err = LockSqliteFile(cfg.Sqlite.FileName); err != nil {
+			slog.Error("无法锁定SQLite数据库文件", slog.String("file", cfg.Sqlite.FileName), logging.Err(err))
+			panic(err)
+		}
+		dialector = openSqlite(cfg.Sqlite)
+	}
 
-	// 连接到SQLite数据库
-	DB, err = gorm.Open(sqlite.Open(dsn), &gorm.Config{
-		Logger:      newLogger,
+	var err error
+	DB, err = gorm.Open(dialector, &gorm.Config{
+		Logger:      logging.GormLogger(), // SQL错误和慢查询通过slog记录
 		PrepareStmt: true,
 	})
+	if err == nil && cfg.Driver == config.DatabaseDriverPostgres {
+		err = configurePostgresPool(DB, cfg.Postgres)
+	}
 
 	if err != nil {
-		fmt.Println("连接数据库失败", err)
+		slog.Error("连接数据库失败", logging.Err(err))
 		panic(err)
 	}
 
-	fmt.Println("数据库连接成功！")
+	slog.Info("数据库连接成功！", slog.String("driver", DB.Dialector.Name()))
+}
+
+// IsPostgres 判断当前连接的是否是PostgreSQL
+func IsPostgres() bool {
+	return DB.Dialector.Name() == "postgres"
+}
+
+// SerializeWrites 在事务tx中取得一把以lockID区分的事务级写锁，事务结束时自动释放。
+// SQLite的写入本身就是串行的，因此只有PostgreSQL需要真正加锁。
+func SerializeWrites(tx *gorm.DB, lockID int64) error {
+	if !IsPostgres() {
+		return nil
+	}
+	if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", lockID).Error; err != nil {
+		return fmt.Errorf("获取写锁 %d 失败: %w", lockID, err)
+	}
+	return nil
 }
 
 // --- 新增的辅助函数 ---
 
 // IsDuplicateKeyError 检查一个错误是否是由于主键或唯一约束冲突引起的。
-// 这是对gorm.ErrDuplicatedKey的一个简单、可读的包装。
+// 除了GORM翻译后的 gorm.ErrDuplicatedKey，还会识别各个驱动的原始错误码。
 func IsDuplicateKeyError(err error) bool {
 	if err == nil {
 		return false
 	}
-	// GORM v2 推荐使用 errors.Is 来检查它定义的标准错误类型。
-	// gorm.ErrDuplicatedKey 已经封装了所有数据库驱动中
-	// 关于“唯一性冲突”的错误，我们无需再检查底层驱动的特定错误码。
-	return errors.Is(err, gorm.ErrDuplicatedKey)
+	return errors.Is(err, gorm.ErrDuplicatedKey) || isSqliteDuplicateKey(err) || isPostgresDuplicateKey(err)
 }
 
 // IsRetryableError 检查一个错误是否是由于数据库暂时锁定或繁忙等可恢复的原因引起的。
@@ -67,11 +78,5 @@ func IsRetryableError(err error) bool {
 	if err == nil {
 		return false
 	}
-
-	var sqliteErr sqlite3.Error
-	if errors.As(err, &sqliteErr) {
-		// SQLITE_BUSY 和 SQLITE_LOCKED 是典型的“请稍后重试”的错误码
-		return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
-	}
-	return false
+	return isSqliteRetryable(err) || isPostgresRetryable(err)
 }
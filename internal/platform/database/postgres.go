@@ -0,0 +1,50 @@
+package database
+
+import (
+	"errors"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/config"
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// PostgreSQL的错误码，见 https://www.postgresql.org/docs/current/errcodes-appendix.html
+const (
+	pgUniqueViolation      = "23505"
+	pgSerializationFailure = "40001"
+	pgDeadlockDetected     = "40P01"
+	pgLockNotAvailable     = "55P03"
+)
+
+func openPostgres(cfg config.PostgresConfig) gorm.Dialector {
+	return postgres.Open(cfg.DSN)
+}
+
+// configurePostgresPool 设置连接池的大小。SQLite的连接都在进程内，无需限制。
+func configurePostgresPool(db *gorm.DB, cfg config.PostgresConfig) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	sqlDB.SetMaxOpenConns(cfg.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(cfg.MaxOpenConns)
+	return nil
+}
+
+func isPostgresDuplicateKey(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation
+}
+
+func isPostgresRetryable(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		// 序列化失败、死锁和锁等待超时都会使事务中止，整个事务重试即可
+		switch pgErr.Code {
+		case pgSerializationFailure, pgDeadlockDetected, pgLockNotAvailable:
+			return true
+		}
+	}
+	return false
+}
@@ -3,26 +3,48 @@ package database
 import (
 	"context"
 	"fmt"
+	"log/slog"
 
 	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/config"
 	"github.com/redis/go-redis/v9"
 )
 
-// RDB 是一个全局的Redis客户端实例，供项目其他部分使用
-var RDB *redis.Client
+// KeyPrefix 是投票数据集所有Redis键的公共前缀。其中的哈希标签使Redis Cluster把这些键
+// 分配到同一个槽位，投票应用脚本、快照事务等多键操作因此可以在集群中原子地执行。
+const KeyPrefix = "{tier}:"
+
+// RDB 是一个全局的Redis客户端实例，供项目其他部分使用。
+// 单机和Sentinel模式下它是 *redis.Client，集群模式下是 *redis.ClusterClient。
+var RDB redis.UniversalClient
 
 // Ctx 是一个全局的上下文，用于Redis操作
 var Ctx = context.Background()
 
 // InitRedis 初始化与Redis数据库的连接
 func InitRedis(cfg config.RedisConfig) {
-	// 创建一个新的Redis客户端
+	// 根据部署模式创建对应的客户端
 	// 使用从配置文件加载的参数
-	RDB = redis.NewClient(&redis.Options{
-		Addr:     cfg.Address,
-		Password: cfg.Password,
-		DB:       cfg.DB,
-	})
+	switch cfg.Mode {
+	case config.RedisModeSentinel:
+		RDB = redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       cfg.MasterName,
+			SentinelAddrs:    cfg.Addresses,
+			SentinelPassword: cfg.SentinelPassword,
+			Password:         cfg.Password,
+			DB:               cfg.DB,
+		})
+	case config.RedisModeCluster:
+		RDB = redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:    cfg.Addresses,
+			Password: cfg.Password,
+		})
+	default:
+		RDB = redis.NewClient(&redis.Options{
+			Addr:     cfg.Address,
+			Password: cfg.Password,
+			DB:       cfg.DB,
+		})
+	}
 
 	// 使用Ping命令来测试连接是否成功
 	_, err := RDB.Ping(Ctx).Result()
@@ -31,5 +53,31 @@ func InitRedis(cfg config.RedisConfig) {
 		panic("无法连接到Redis: " + err.Error())
 	}
 
-	fmt.Println("Redis 连接成功！")
+	slog.Info("Redis 连接成功！", slog.String("mode", string(cfg.Mode)))
+}
+
+// ForEachMaster 对每个Redis主节点执行fn。集群模式下并发地遍历所有主节点，
+// 单机和Sentinel模式下只有当前的主节点。SCAN、INFO等只作用于单个节点的命令需要通过它执行。
+func ForEachMaster(ctx context.Context, fn func(ctx context.Context, client *redis.Client) error) error {
+	switch c := RDB.(type) {
+	case *redis.ClusterClient:
+		return c.ForEachMaster(ctx, fn)
+	case *redis.Client:
+		return fn(ctx, c)
+	default:
+		return fmt.Errorf("未知的Redis客户端类型 %T", RDB)
+	}
+}
+
+// MasterForKey 返回负责key所在槽位的Redis主节点。集群模式下按槽位查找，
+// 单机和Sentinel模式下就是当前的主节点。
+func MasterForKey(ctx context.Context, key string) (*redis.Client, error) {
+	switch c := RDB.(type) {
+	case *redis.ClusterClient:
+		return c.MasterForKey(ctx, key)
+	case *redis.Client:
+		return c, nil
+	default:
+		return nil, fmt.Errorf("未知的Redis客户端类型 %T", RDB)
+	}
 }
@@ -0,0 +1,42 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/config"
+	"github.com/mattn/go-sqlite3"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// ErrDatabaseInUse 表示数据库文件正被另一个进程（通常是运行中的服务器）使用
+var ErrDatabaseInUse = errors.New("数据库文件正被另一个进程使用")
+
+// sqliteLock 是服务器运行期间持有的数据库文件锁，保存引用以免文件被回收关闭
+var sqliteLock *os.File
+
+// openSqlite 根据配置动态构建包含性能优化的DSN字符串
+func openSqlite(cfg config.SqliteConfig) gorm.Dialector {
+	// cache_size单位是KiB，负值表示使用KiB。
+	dsn := fmt.Sprintf("file:%s?journal_mode=WAL&cache=shared&cache_size=-%d", cfg.FileName, cfg.MaxCacheSizeKB)
+	return sqlite.Open(dsn)
+}
+
+func isSqliteDuplicateKey(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey || sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+	}
+	return false
+}
+
+func isSqliteRetryable(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		// SQLITE_BUSY 和 SQLITE_LOCKED 是典型的“请稍后重试”的错误码
+		return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+	}
+	return false
+}
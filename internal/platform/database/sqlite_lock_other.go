@@ -0,0 +1,10 @@
+//go:build !unix
+
+package database
+
+import "os"
+
+// LockSqliteFile 在不支持flock的平台上只创建锁文件，不提供互斥保护。
+func LockSqliteFile(fileName string) (*os.File, error) {
+	return os.OpenFile(fileName+".lock", os.O_CREATE|os.O_RDWR, 0o644)
+}
@@ -0,0 +1,26 @@
+//go:build unix
+
+package database
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// LockSqliteFile 对数据库文件旁的 .lock 文件加一把非阻塞的排他锁，锁在返回的文件关闭或进程退出时释放。
+// 服务器在运行期间一直持有它，恢复备份等离线操作借此确认没有正在运行的服务器。
+func LockSqliteFile(fileName string) (*os.File, error) {
+	f, err := os.OpenFile(fileName+".lock", os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, ErrDatabaseInUse
+		}
+		return nil, err
+	}
+	return f, nil
+}
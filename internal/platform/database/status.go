@@ -1,8 +1,11 @@
 package database
 
 import (
-	"fmt"
+	"log/slog"
 	"sync"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/metrics"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 // statusManager 负责线程安全地管理和提供系统的健康状态。
@@ -17,6 +20,30 @@ var globalStatus = &statusManager{
 	isRedisHealthy: true, // 默认启动时是健康的
 }
 
+// redisHealthTransitions 统计Redis健康状态的翻转次数，标签to为翻转后的状态
+var redisHealthTransitions = metrics.Factory.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Subsystem: "redis",
+	Name:      "health_transitions_total",
+	Help:      "Redis健康状态的翻转次数",
+}, []string{"to"})
+
+func init() {
+	redisHealthTransitions.WithLabelValues("healthy")
+	redisHealthTransitions.WithLabelValues("unhealthy")
+	metrics.Factory.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "redis",
+		Name:      "healthy",
+		Help:      "Redis当前是否可用（1为可用）",
+	}, func() float64 {
+		if IsRedisHealthy() {
+			return 1
+		}
+		return 0
+	})
+}
+
 // IsRedisHealthy 返回当前Redis的健康状态。
 func IsRedisHealthy() bool {
 	globalStatus.mu.RLock()
@@ -42,9 +69,11 @@ func UpdateStatus(isHealthy bool, newRunID string) bool {
 	if wasHealthy != isHealthy {
 		globalStatus.isRedisHealthy = isHealthy
 		if isHealthy {
-			fmt.Println("健康检查: Redis服务状态已更新为 [可用]")
+			redisHealthTransitions.WithLabelValues("healthy").Inc()
+			slog.Info("健康检查: Redis服务状态已更新为 [可用]")
 		} else {
-			fmt.Println("健康检查警告: Redis服务状态已更新为 [不可用]")
+			redisHealthTransitions.WithLabelValues("unhealthy").Inc()
+			slog.Warn("健康检查: Redis服务状态已更新为 [不可用]")
 		}
 	}
 
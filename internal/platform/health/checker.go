@@ -3,12 +3,16 @@ package health
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"regexp"
 	"time"
 
 	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/logging"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/metrics"
 	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/startup"
 	"github.com/SlpAus/noita-spells-tier-backend/pkg/lifecycle"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 const (
@@ -16,16 +20,39 @@ const (
 	pingTimeout   = 2 * time.Second
 )
 
-// getRedisRunID 从Redis服务器信息中提取run_id
+// cacheRebuilds 按结果统计由Redis重启触发的缓存热重建次数
+var cacheRebuilds = metrics.Factory.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Subsystem: "redis",
+	Name:      "cache_rebuilds_total",
+	Help:      "检测到Redis重启后触发的缓存热重建次数",
+}, []string{"result"})
+
+func init() {
+	cacheRebuilds.WithLabelValues("success")
+	cacheRebuilds.WithLabelValues("failure")
+}
+
+// runIDPattern 用于从 INFO server 的输出中提取run_id
+var runIDPattern = regexp.MustCompile(`run_id:([a-f0-9]+)`)
+
+// getRedisRunID 从Redis服务器信息中提取run_id。
+// Sentinel模式下INFO总是发往当前的主节点，主从切换后run_id随之改变；集群模式下只取
+// 负责投票数据集槽位（KeyPrefix中的哈希标签）的主节点。缓存和防重放记录的所有键都在
+// 这个槽位上，只有这个分片重启或发生故障转移才会丢失它们。这两种情况都与单机重启一样触发缓存重建。
 func getRedisRunID() (string, error) {
 	ctx, cancel := context.WithTimeout(database.Ctx, pingTimeout)
 	defer cancel()
-	info, err := database.RDB.Info(ctx, "server").Result()
+
+	client, err := database.MasterForKey(ctx, database.KeyPrefix)
+	if err != nil {
+		return "", err
+	}
+	info, err := client.Info(ctx, "server").Result()
 	if err != nil {
 		return "", err
 	}
-	re := regexp.MustCompile(`run_id:([a-f0-9]+)`)
-	matches := re.FindStringSubmatch(info)
+	matches := runIDPattern.FindStringSubmatch(info)
 	if len(matches) < 2 {
 		return "", fmt.Errorf("无法在Redis INFO中找到run_id")
 	}
@@ -34,38 +61,48 @@ func getRedisRunID() (string, error) {
 
 // InitializeRunID 在应用启动时执行一次，获取并设置初始的run_id。
 func InitializeRunID() {
-	fmt.Println("正在获取初始Redis Run ID...")
+	slog.Info("正在获取初始Redis Run ID...")
 	runID, err := getRedisRunID()
 	if err != nil {
 		panic(fmt.Sprintf("无法在启动时获取Redis Run ID，请检查Redis服务: %v", err))
 	}
 	database.SetInitialRunID(runID)
-	fmt.Printf("获取初始Redis Run ID成功: %s\n", runID)
+	slog.Info("获取初始Redis Run ID成功。", slog.String("run_id", runID))
 }
 
 // triggerAtomicRebuild 执行一次原子的、自校验的缓存重建。
 // 它确保只有在重建期间Redis没有再次重启的情况下，才认为重建成功。
-func triggerAtomicRebuild(idBeforeRebuild string) bool {
-	fmt.Println("健康检查: 正在触发缓存热重建...")
+func triggerAtomicRebuild(idBeforeRebuild string) (success bool) {
+	rebuilding.Store(true)
+	defer rebuilding.Store(false)
+	defer func() {
+		if success {
+			cacheRebuilds.WithLabelValues("success").Inc()
+		} else {
+			cacheRebuilds.WithLabelValues("failure").Inc()
+		}
+	}()
+
+	slog.Info("健康检查: 正在触发缓存热重建...")
 	err := startup.RebuildCache()
 	if err != nil {
-		fmt.Printf("健康检查错误: 缓存热重建失败: %v\n", err)
+		slog.Error("健康检查: 缓存热重建失败", logging.Err(err))
 		return false
 	}
 
 	// 重建后，再次检查run_id以确认原子性
 	idAfterRebuild, err := getRedisRunID()
 	if err != nil {
-		fmt.Println("健康检查错误: 缓存重建后无法连接到Redis，重建无效。")
+		slog.Error("健康检查: 缓存重建后无法连接到Redis，重建无效。", logging.Err(err))
 		return false
 	}
 
 	if idBeforeRebuild != idAfterRebuild {
-		fmt.Printf("健康检查错误: 缓存重建期间检测到Redis再次重启 (run_id: %s -> %s)。重建无效。\n", idBeforeRebuild, idAfterRebuild)
+		slog.Error("健康检查: 缓存重建期间检测到Redis再次重启，重建无效。", slog.String("run_id_before", idBeforeRebuild), slog.String("run_id_after", idAfterRebuild))
 		return false
 	}
 
-	fmt.Println("健康检查: 缓存热重建成功并通过原子性校验。")
+	slog.Info("健康检查: 缓存热重建成功并通过原子性校验。")
 	return true
 }
 
@@ -107,11 +144,11 @@ func PerformCheck() {
 func StartRedisHealthCheck(handle *lifecycle.Handle) {
 	defer handle.Close()
 
-	fmt.Println("Redis高级健康检查器已启动。")
+	slog.Info("Redis高级健康检查器已启动。")
 
 	for {
 		if err := handle.Sleep(checkInterval); err != nil {
-			fmt.Printf("健康检查器: 休眠被中断，正在关闭...\n")
+			slog.Info("健康检查器: 休眠被中断，正在关闭...")
 			return
 		}
 
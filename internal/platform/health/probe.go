@@ -0,0 +1,237 @@
+package health
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/backup"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/config"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/logging"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/vote"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// dbProbeInterval 是数据库写入探测结果的缓存时间，避免频繁的探针请求反复写库
+	dbProbeInterval = 5 * time.Second
+	dbProbeTimeout  = 2 * time.Second
+)
+
+var (
+	startedAt = time.Now()
+
+	maxProcessorLag uint64
+	maxSnapshotAge  time.Duration
+
+	// rebuilding 在缓存热重建期间为true
+	rebuilding atomic.Bool
+
+	dbProbeMutex   sync.Mutex
+	dbProbeAt      time.Time
+	dbProbeErr     error
+	dbProbeLatency time.Duration
+)
+
+// ConfigureReadiness 设置就绪探针的判定阈值
+func ConfigureReadiness(cfg config.HealthConfig) {
+	maxProcessorLag = cfg.MaxProcessorLag
+	maxSnapshotAge = cfg.MaxSnapshotAge
+}
+
+// LivenessResponse 是 /healthz 的响应
+type LivenessResponse struct {
+	Status        string  `json:"status"`
+	UptimeSeconds float64 `json:"uptimeSeconds"`
+}
+
+// CheckResult 是就绪检查中单项检查的结果
+type CheckResult struct {
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// ProcessorLagResult 是投票处理积压检查的结果
+type ProcessorLagResult struct {
+	CheckResult
+	Lag       uint64 `json:"lag"`
+	Threshold uint64 `json:"threshold"`
+}
+
+// SnapshotResult 是快照新鲜度检查的结果
+type SnapshotResult struct {
+	CheckResult
+	LastSuccessAt    *time.Time `json:"lastSuccessAt,omitempty"`
+	AgeSeconds       float64    `json:"ageSeconds"`
+	ThresholdSeconds float64    `json:"thresholdSeconds"`
+}
+
+// DatabaseResult 是数据库写入检查的结果
+type DatabaseResult struct {
+	CheckResult
+	LatencyMs float64   `json:"latencyMs"`
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+// ReadinessChecks 汇总了就绪检查的各项结果
+type ReadinessChecks struct {
+	Redis        CheckResult        `json:"redis"`
+	Database     DatabaseResult     `json:"database"`
+	CacheRebuild CheckResult        `json:"cacheRebuild"`
+	ProcessorLag ProcessorLagResult `json:"processorLag"`
+	Snapshot     SnapshotResult     `json:"snapshot"`
+}
+
+// ReadinessResponse 是 /readyz 的响应
+type ReadinessResponse struct {
+	Status string          `json:"status"`
+	Checks ReadinessChecks `json:"checks"`
+}
+
+// Liveness 是存活探针：只要进程能够处理HTTP请求就返回200。
+// 它不检查任何依赖，依赖故障应由 Readiness 反映，而不是导致进程被重启。
+func Liveness(c *gin.Context) {
+	c.JSON(http.StatusOK, LivenessResponse{
+		Status:        "ok",
+		UptimeSeconds: time.Since(startedAt).Seconds(),
+	})
+}
+
+// Readiness 是就绪探针：所有检查都通过时返回200，否则返回503。
+// 响应体包含每一项检查的详细结果，供人工排查。
+func Readiness(c *gin.Context) {
+	checks := ReadinessChecks{
+		Redis:        checkRedis(),
+		Database:     checkDatabase(),
+		CacheRebuild: checkCacheRebuild(),
+		ProcessorLag: checkProcessorLag(),
+		Snapshot:     checkSnapshot(),
+	}
+
+	response := ReadinessResponse{Status: "ready", Checks: checks}
+	status := http.StatusOK
+	if !checks.Redis.OK || !checks.Database.OK || !checks.CacheRebuild.OK || !checks.ProcessorLag.OK || !checks.Snapshot.OK {
+		response.Status = "not_ready"
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, response)
+}
+
+func checkRedis() CheckResult {
+	if !database.IsRedisHealthy() {
+		return CheckResult{OK: false, Detail: "Redis不可用"}
+	}
+	return CheckResult{OK: true}
+}
+
+// checkDatabase 通过一次不写入数据的事务确认数据库可写，结果在 dbProbeInterval 内复用
+func checkDatabase() DatabaseResult {
+	dbProbeMutex.Lock()
+	defer dbProbeMutex.Unlock()
+
+	if dbProbeAt.IsZero() || time.Since(dbProbeAt) >= dbProbeInterval {
+		dbProbeAt = time.Now()
+		dbProbeErr = probeDatabaseWrite()
+		dbProbeLatency = time.Since(dbProbeAt)
+		if dbProbeErr != nil {
+			slog.Warn("就绪检查: 数据库写入探测失败", logging.Err(dbProbeErr))
+		}
+	}
+
+	result := DatabaseResult{
+		CheckResult: CheckResult{OK: dbProbeErr == nil},
+		LatencyMs:   float64(dbProbeLatency.Microseconds()) / 1000,
+		CheckedAt:   dbProbeAt,
+	}
+	if dbProbeErr != nil {
+		// 就绪检查是公开接口，错误详情只写入日志
+		result.Detail = "数据库不可写"
+	}
+	return result
+}
+
+// probeDatabaseWrite 开启一个事务后回滚，不会真正写入数据。
+// SQLite执行 BEGIN IMMEDIATE，它需要取得数据库的写锁，因此能发现只读文件、锁被长期占用等问题；
+// PostgreSQL把事务设为读写模式，连接到只读的备库时会失败。
+func probeDatabaseWrite() error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbProbeTimeout)
+	defer cancel()
+
+	sqlDB, err := database.DB.DB()
+	if err != nil {
+		return err
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if database.IsPostgres() {
+		if _, err := conn.ExecContext(ctx, "BEGIN READ WRITE"); err != nil {
+			return err
+		}
+		_, err = conn.ExecContext(ctx, "ROLLBACK")
+		return err
+	}
+
+	if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+		if database.IsRetryableError(err) {
+			// 写锁正被其他写入者持有，说明数据库本身是可写的
+			return nil
+		}
+		return err
+	}
+	_, err = conn.ExecContext(ctx, "ROLLBACK")
+	return err
+}
+
+func checkCacheRebuild() CheckResult {
+	if rebuilding.Load() {
+		return CheckResult{OK: false, Detail: "缓存热重建进行中"}
+	}
+	return CheckResult{OK: true}
+}
+
+func checkProcessorLag() ProcessorLagResult {
+	lag := vote.ProcessorLag()
+	result := ProcessorLagResult{
+		CheckResult: CheckResult{OK: lag <= maxProcessorLag},
+		Lag:         lag,
+		Threshold:   maxProcessorLag,
+	}
+	if !result.OK {
+		result.Detail = "投票处理积压超过阈值"
+	}
+	return result
+}
+
+func checkSnapshot() SnapshotResult {
+	result := SnapshotResult{ThresholdSeconds: maxSnapshotAge.Seconds()}
+
+	lastSnapshot, err := backup.LastSnapshotTime()
+	if err != nil {
+		slog.Warn("就绪检查: 无法获取上次快照时间", logging.Err(err))
+		result.Detail = "无法获取上次快照时间"
+		return result
+	}
+
+	// 快照由本进程定期执行，刚启动时上一次运行留下的快照可能已经很旧，
+	// 因此从进程启动时开始计算，给第一次定时快照留出时间
+	reference := lastSnapshot
+	if reference.Before(startedAt) {
+		reference = startedAt
+	}
+	age := time.Since(reference)
+	result.LastSuccessAt = &lastSnapshot
+	result.AgeSeconds = age.Seconds()
+	result.OK = age <= maxSnapshotAge
+	if !result.OK {
+		result.Detail = "距上次成功快照的时间超过阈值"
+	}
+	return result
+}
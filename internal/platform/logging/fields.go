@@ -0,0 +1,37 @@
+package logging
+
+import "log/slog"
+
+// 日志中统一使用的字段名
+const (
+	KeyRequestID = "request_id"
+	KeyUserID    = "user_id"
+	KeyVoteID    = "vote_id"
+	KeyIP        = "ip"
+	KeyError     = "error"
+)
+
+// Err 返回记录错误的字段
+func Err(err error) slog.Attr {
+	return slog.Any(KeyError, err)
+}
+
+// VoteID 返回记录投票ID的字段
+func VoteID(id uint) slog.Attr {
+	return slog.Uint64(KeyVoteID, uint64(id))
+}
+
+// UserID 返回记录用户ID的字段
+func UserID(id string) slog.Attr {
+	return slog.String(KeyUserID, id)
+}
+
+// IP 返回记录客户端IP的字段
+func IP(ip string) slog.Attr {
+	return slog.String(KeyIP, ip)
+}
+
+// RequestID 返回记录请求ID的字段
+func RequestID(id string) slog.Attr {
+	return slog.String(KeyRequestID, id)
+}
@@ -0,0 +1,55 @@
+package logging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// gormLogger 把GORM的日志转发到slog：
+// 执行失败的SQL记为error，超过阈值的慢查询记为warn，其余SQL只在debug级别下记录。
+type gormLogger struct {
+	slowThreshold time.Duration
+}
+
+// GormLogger 返回供 gorm.Config 使用的日志器，慢查询阈值取自 Configure 时的配置
+func GormLogger() logger.Interface {
+	return gormLogger{slowThreshold: slowQueryThreshold}
+}
+
+// LogMode 由GORM在 db.Debug() 等场景调用；日志级别统一由slog控制，这里不做区分
+func (l gormLogger) LogMode(logger.LogLevel) logger.Interface {
+	return l
+}
+
+func (l gormLogger) Info(ctx context.Context, msg string, args ...interface{}) {
+	slog.InfoContext(ctx, fmt.Sprintf(msg, args...))
+}
+
+func (l gormLogger) Warn(ctx context.Context, msg string, args ...interface{}) {
+	slog.WarnContext(ctx, fmt.Sprintf(msg, args...))
+}
+
+func (l gormLogger) Error(ctx context.Context, msg string, args ...interface{}) {
+	slog.ErrorContext(ctx, fmt.Sprintf(msg, args...))
+}
+
+func (l gormLogger) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
+	elapsed := time.Since(begin)
+	switch {
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound):
+		sql, rows := fc()
+		slog.ErrorContext(ctx, "SQL执行失败", slog.String("sql", sql), slog.Int64("rows", rows), slog.Duration("elapsed", elapsed), Err(err))
+	case l.slowThreshold > 0 && elapsed > l.slowThreshold:
+		sql, rows := fc()
+		slog.WarnContext(ctx, "SQL慢查询", slog.String("sql", sql), slog.Int64("rows", rows), slog.Duration("elapsed", elapsed))
+	case slog.Default().Enabled(ctx, slog.LevelDebug):
+		sql, rows := fc()
+		slog.DebugContext(ctx, "SQL", slog.String("sql", sql), slog.Int64("rows", rows), slog.Duration("elapsed", elapsed))
+	}
+}
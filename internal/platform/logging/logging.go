@@ -0,0 +1,74 @@
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/config"
+)
+
+// slowQueryThreshold 是GORM日志记录慢查询的阈值，由 Configure 设置
+var slowQueryThreshold = 200 * time.Millisecond
+
+// Configure 根据配置创建全局的slog日志器，之后所有通过 slog 包函数输出的日志都使用该配置。
+// 它应当在加载配置后、初始化其他模块前尽早调用。
+func Configure(cfg config.LogConfig) {
+	slog.SetDefault(slog.New(newHandler(os.Stdout, cfg)))
+	slowQueryThreshold = cfg.SlowQueryThreshold
+}
+
+func newHandler(w io.Writer, cfg config.LogConfig) slog.Handler {
+	opts := &slog.HandlerOptions{Level: parseLevel(cfg.Level)}
+	var handler slog.Handler
+	switch cfg.Format {
+	case config.LogFormatJSON:
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		handler = slog.NewTextHandler(w, opts)
+	}
+	return contextHandler{Handler: handler}
+}
+
+func parseLevel(level config.LogLevel) slog.Level {
+	switch level {
+	case config.LogLevelDebug:
+		return slog.LevelDebug
+	case config.LogLevelWarn:
+		return slog.LevelWarn
+	case config.LogLevelError:
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// contextHandler 在输出每条日志前，把上下文中携带的请求字段（request_id、user_id）附加到记录上。
+// 因此使用 slog.InfoContext 等带上下文的函数记录日志时，无需手动传递这些字段。
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if ctx != nil {
+		if fields, ok := ctx.Value(fieldsKey{}).(*requestFields); ok {
+			if fields.requestID != "" {
+				record.AddAttrs(slog.String(KeyRequestID, fields.requestID))
+			}
+			if fields.userID != "" {
+				record.AddAttrs(slog.String(KeyUserID, fields.userID))
+			}
+		}
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{Handler: h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,113 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader 是携带请求ID的HTTP头部。客户端或反向代理提供的值会被沿用，否则由服务器生成。
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength 限制沿用的外部请求ID长度，避免超长头部污染日志
+const maxRequestIDLength = 64
+
+type fieldsKey struct{}
+
+// requestFields 是随请求上下文传递的日志字段。
+// 它以指针形式存放，使后续的中间件（如用户识别）可以补充字段，并让访问日志看到这些补充。
+type requestFields struct {
+	requestID string
+	userID    string
+}
+
+// WithRequestID 返回携带指定请求ID的上下文，用于在请求之外（如后台处理投票时）延续请求的日志关联
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	if requestID == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, fieldsKey{}, &requestFields{requestID: requestID})
+}
+
+// RequestIDFromContext 返回上下文中的请求ID，没有时返回空字符串
+func RequestIDFromContext(ctx context.Context) string {
+	if fields, ok := ctx.Value(fieldsKey{}).(*requestFields); ok {
+		return fields.requestID
+	}
+	return ""
+}
+
+// SetUserID 把已识别的用户ID补充到请求上下文的日志字段中。
+// 它只应在处理请求的goroutine中调用。
+func SetUserID(ctx context.Context, userID string) {
+	if fields, ok := ctx.Value(fieldsKey{}).(*requestFields); ok {
+		fields.userID = userID
+	}
+}
+
+// RequestIDMiddleware 为每个请求分配请求ID，写入响应头部并放入请求上下文
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(RequestIDHeader)
+		if !isValidRequestID(requestID) {
+			requestID = uuid.NewString()
+		}
+
+		ctx := context.WithValue(c.Request.Context(), fieldsKey{}, &requestFields{requestID: requestID})
+		c.Request = c.Request.WithContext(ctx)
+		c.Header(RequestIDHeader, requestID)
+		c.Next()
+	}
+}
+
+// isValidRequestID 只接受长度有限、由可打印ASCII字符组成的外部请求ID
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// AccessLogMiddleware 在请求结束后记录一条访问日志，替代Gin默认的文本日志。
+// quietPaths 中的路径（如探针和指标抓取）只在debug级别记录，除非响应为5xx。
+func AccessLogMiddleware(quietPaths ...string) gin.HandlerFunc {
+	quiet := make(map[string]struct{}, len(quietPaths))
+	for _, path := range quietPaths {
+		quiet[path] = struct{}{}
+	}
+
+	return func(c *gin.Context) {
+		start := time.Now()
+		path := c.Request.URL.Path
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		if _, ok := quiet[path]; ok {
+			level = slog.LevelDebug
+		}
+		if status >= 500 {
+			level = slog.LevelError
+		}
+
+		attrs := []slog.Attr{
+			slog.String("method", c.Request.Method),
+			slog.String("path", path),
+			slog.Int("status", status),
+			slog.Duration("latency", time.Since(start)),
+			IP(c.ClientIP()),
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, slog.String(KeyError, c.Errors.String()))
+		}
+		slog.LogAttrs(c.Request.Context(), level, "HTTP请求", attrs...)
+	}
+}
@@ -0,0 +1,366 @@
+// Package memstore 提供一个进程内的键值存储，按Redis的数据模型（字符串、Hash、有序集合和集合）组织数据。
+//
+// 各模块的内存存储实现共享同一个 Store，并使用与Redis实现完全相同的键名和JSON编码，
+// 因此一个模块写入的数据可以被另一个模块读取，测试无需真实的Redis即可端到端运行。
+// 它只实现了各模块实际用到的命令，也不追求与Redis逐字节一致的行为。
+package memstore
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store 是进程内的键值存储，所有操作都通过 Do 在一个互斥锁内原子地执行
+type Store struct {
+	mu sync.Mutex
+
+	strings map[string]string
+	hashes  map[string]map[string]string
+	zsets   map[string]map[string]float64
+	sets    map[string]map[string]struct{}
+
+	// expireAt 是键的过期时间，fieldExpireAt 是Hash字段的过期时间，过期的数据在访问时惰性删除
+	expireAt      map[string]time.Time
+	fieldExpireAt map[string]map[string]time.Time
+}
+
+// New 创建一个空的 Store
+func New() *Store {
+	return &Store{
+		strings:       make(map[string]string),
+		hashes:        make(map[string]map[string]string),
+		zsets:         make(map[string]map[string]float64),
+		sets:          make(map[string]map[string]struct{}),
+		expireAt:      make(map[string]time.Time),
+		fieldExpireAt: make(map[string]map[string]time.Time),
+	}
+}
+
+// Do 在持有锁的情况下执行fn，相当于一个Redis事务。
+// fn返回错误时，已经执行的写入不会被回滚，调用方应当先完成校验再写入。
+func (s *Store) Do(fn func(tx *Tx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return fn(&Tx{s: s, now: time.Now()})
+}
+
+// Z 是有序集合中的一个成员
+type Z struct {
+	Member string
+	Score  float64
+}
+
+// Tx 是 Do 中可用的操作集合，只在fn执行期间有效
+type Tx struct {
+	s   *Store
+	now time.Time
+}
+
+// expire 删除已过期的键和Hash字段
+func (tx *Tx) expire(key string) {
+	if at, ok := tx.s.expireAt[key]; ok && !at.After(tx.now) {
+		tx.delete(key)
+	}
+	if fields, ok := tx.s.fieldExpireAt[key]; ok {
+		for field, at := range fields {
+			if !at.After(tx.now) {
+				delete(tx.s.hashes[key], field)
+				delete(fields, field)
+			}
+		}
+		tx.dropEmpty(key)
+	}
+}
+
+func (tx *Tx) delete(key string) {
+	delete(tx.s.strings, key)
+	delete(tx.s.hashes, key)
+	delete(tx.s.zsets, key)
+	delete(tx.s.sets, key)
+	delete(tx.s.expireAt, key)
+	delete(tx.s.fieldExpireAt, key)
+}
+
+// dropEmpty 与Redis一致，删除已经为空的集合类型的键
+func (tx *Tx) dropEmpty(key string) {
+	if h, ok := tx.s.hashes[key]; ok && len(h) == 0 {
+		tx.delete(key)
+	}
+	if z, ok := tx.s.zsets[key]; ok && len(z) == 0 {
+		tx.delete(key)
+	}
+	if set, ok := tx.s.sets[key]; ok && len(set) == 0 {
+		tx.delete(key)
+	}
+}
+
+// --- 键 ---
+
+// Exists 判断一个键是否存在
+func (tx *Tx) Exists(key string) bool {
+	tx.expire(key)
+	_, isString := tx.s.strings[key]
+	_, isHash := tx.s.hashes[key]
+	_, isZSet := tx.s.zsets[key]
+	_, isSet := tx.s.sets[key]
+	return isString || isHash || isZSet || isSet
+}
+
+// Del 删除若干个键
+func (tx *Tx) Del(keys ...string) {
+	for _, key := range keys {
+		tx.delete(key)
+	}
+}
+
+// DelPrefix 删除所有以prefix开头的键
+func (tx *Tx) DelPrefix(prefix string) {
+	var keys []string
+	keys = appendPrefixed(keys, tx.s.strings, prefix)
+	keys = appendPrefixed(keys, tx.s.hashes, prefix)
+	keys = appendPrefixed(keys, tx.s.zsets, prefix)
+	keys = appendPrefixed(keys, tx.s.sets, prefix)
+	tx.Del(keys...)
+}
+
+func appendPrefixed[V any](keys []string, m map[string]V, prefix string) []string {
+	for key := range m {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// ExpireAt 设置一个键的过期时间，键不存在时不做任何事
+func (tx *Tx) ExpireAt(key string, at time.Time) {
+	if tx.Exists(key) {
+		tx.s.expireAt[key] = at
+	}
+}
+
+// --- 字符串 ---
+
+// Get 返回一个字符串键的值
+func (tx *Tx) Get(key string) (string, bool) {
+	tx.expire(key)
+	v, ok := tx.s.strings[key]
+	return v, ok
+}
+
+// Set 设置一个字符串键的值，并清除其过期时间
+func (tx *Tx) Set(key, value string) {
+	tx.delete(key)
+	tx.s.strings[key] = value
+}
+
+// IncrByFloat 将一个字符串键按浮点数增加delta，返回新值。值无法解析时视为0。
+func (tx *Tx) IncrByFloat(key string, delta float64) float64 {
+	v, _ := tx.Get(key)
+	current, _ := strconv.ParseFloat(v, 64)
+	current += delta
+	tx.s.strings[key] = strconv.FormatFloat(current, 'f', -1, 64)
+	return current
+}
+
+// --- Hash ---
+
+// HGet 返回Hash中一个字段的值
+func (tx *Tx) HGet(key, field string) (string, bool) {
+	tx.expire(key)
+	v, ok := tx.s.hashes[key][field]
+	return v, ok
+}
+
+// HGetAll 返回Hash中所有字段的副本
+func (tx *Tx) HGetAll(key string) map[string]string {
+	tx.expire(key)
+	result := make(map[string]string, len(tx.s.hashes[key]))
+	for field, v := range tx.s.hashes[key] {
+		result[field] = v
+	}
+	return result
+}
+
+// HSet 设置Hash中一个字段的值，并清除该字段的过期时间
+func (tx *Tx) HSet(key, field, value string) {
+	tx.expire(key)
+	h, ok := tx.s.hashes[key]
+	if !ok {
+		h = make(map[string]string)
+		tx.s.hashes[key] = h
+	}
+	h[field] = value
+	delete(tx.s.fieldExpireAt[key], field)
+}
+
+// HDel 删除Hash中的若干字段
+func (tx *Tx) HDel(key string, fields ...string) {
+	for _, field := range fields {
+		delete(tx.s.hashes[key], field)
+		delete(tx.s.fieldExpireAt[key], field)
+	}
+	tx.dropEmpty(key)
+}
+
+// HExpireAt 设置Hash中一个字段的过期时间，字段不存在时不做任何事
+func (tx *Tx) HExpireAt(key, field string, at time.Time) {
+	if _, ok := tx.HGet(key, field); !ok {
+		return
+	}
+	fields, ok := tx.s.fieldExpireAt[key]
+	if !ok {
+		fields = make(map[string]time.Time)
+		tx.s.fieldExpireAt[key] = fields
+	}
+	fields[field] = at
+}
+
+// --- 有序集合 ---
+
+// ZAdd 添加成员或更新已有成员的分数
+func (tx *Tx) ZAdd(key string, members ...Z) {
+	tx.expire(key)
+	z, ok := tx.s.zsets[key]
+	if !ok {
+		z = make(map[string]float64)
+		tx.s.zsets[key] = z
+	}
+	for _, m := range members {
+		z[m.Member] = m.Score
+	}
+}
+
+// ZRem 删除若干成员
+func (tx *Tx) ZRem(key string, members ...string) {
+	for _, member := range members {
+		delete(tx.s.zsets[key], member)
+	}
+	tx.dropEmpty(key)
+}
+
+// ZRevRange 返回按分数从高到低排列的全部成员，分数相同时按成员的字典序从大到小，与Redis一致
+func (tx *Tx) ZRevRange(key string) []Z {
+	tx.expire(key)
+	result := make([]Z, 0, len(tx.s.zsets[key]))
+	for member, score := range tx.s.zsets[key] {
+		result = append(result, Z{Member: member, Score: score})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Score != result[j].Score {
+			return result[i].Score > result[j].Score
+		}
+		return result[i].Member > result[j].Member
+	})
+	return result
+}
+
+// ZRevRank 返回成员在 ZRevRange 中的位置（0-based）
+func (tx *Tx) ZRevRank(key, member string) (int64, bool) {
+	tx.expire(key)
+	score, ok := tx.s.zsets[key][member]
+	if !ok {
+		return 0, false
+	}
+	var rank int64
+	for other, otherScore := range tx.s.zsets[key] {
+		if otherScore > score || otherScore == score && other > member {
+			rank++
+		}
+	}
+	return rank, true
+}
+
+// ZCard 返回成员数量
+func (tx *Tx) ZCard(key string) int64 {
+	tx.expire(key)
+	return int64(len(tx.s.zsets[key]))
+}
+
+// ZCount 返回分数在闭区间[min, max]内的成员数量
+func (tx *Tx) ZCount(key string, min, max float64) int64 {
+	tx.expire(key)
+	var count int64
+	for _, score := range tx.s.zsets[key] {
+		if score >= min && score <= max {
+			count++
+		}
+	}
+	return count
+}
+
+// ZRemRangeByScore 删除分数在闭区间[min, max]内的成员，开区间的上界可以借助 Before 表示
+func (tx *Tx) ZRemRangeByScore(key string, min, max float64) {
+	tx.expire(key)
+	for member, score := range tx.s.zsets[key] {
+		if score >= min && score <= max {
+			delete(tx.s.zsets[key], member)
+		}
+	}
+	tx.dropEmpty(key)
+}
+
+// ZUnionStore 将若干有序集合的并集（分数相加）写入dest，并清除dest的过期时间
+func (tx *Tx) ZUnionStore(dest string, keys ...string) {
+	union := make(map[string]float64)
+	for _, key := range keys {
+		tx.expire(key)
+		for member, score := range tx.s.zsets[key] {
+			union[member] += score
+		}
+	}
+	tx.delete(dest)
+	if len(union) > 0 {
+		tx.s.zsets[dest] = union
+	}
+}
+
+// --- 集合 ---
+
+// SAdd 添加若干成员
+func (tx *Tx) SAdd(key string, members ...string) {
+	tx.expire(key)
+	set, ok := tx.s.sets[key]
+	if !ok {
+		set = make(map[string]struct{})
+		tx.s.sets[key] = set
+	}
+	for _, member := range members {
+		set[member] = struct{}{}
+	}
+}
+
+// SRem 删除若干成员
+func (tx *Tx) SRem(key string, members ...string) {
+	for _, member := range members {
+		delete(tx.s.sets[key], member)
+	}
+	tx.dropEmpty(key)
+}
+
+// SIsMember 判断成员是否在集合中
+func (tx *Tx) SIsMember(key, member string) bool {
+	tx.expire(key)
+	_, ok := tx.s.sets[key][member]
+	return ok
+}
+
+// SMembers 返回集合的全部成员，按字典序排列
+func (tx *Tx) SMembers(key string) []string {
+	tx.expire(key)
+	members := make([]string, 0, len(tx.s.sets[key]))
+	for member := range tx.s.sets[key] {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	return members
+}
+
+// Before 返回严格小于x的最大浮点数，用于以闭区间表示Redis中 "(x" 形式的开区间上界
+func Before(x float64) float64 {
+	return math.Nextafter(x, math.Inf(-1))
+}
@@ -1,5 +1,7 @@
 package metadata
 
+import "github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
+
 // --- SQLite Keys ---
 // These keys are used for the 'key' column in the 'metadata' SQLite table.
 const (
@@ -10,6 +12,14 @@ const (
 	// TotalVotesKey stores the total number of processed votes (excluding skips)
 	// as of the last successful snapshot.
 	SnapshotTotalVotesKey = "snapshot_total_votes"
+
+	// ArchivedThroughVoteIDKey stores the ID of the last vote that has been moved out of
+	// the votes table. Every vote up to and including it lives in an archive file.
+	ArchivedThroughVoteIDKey = "archived_through_vote_id"
+
+	// ArchiveFileKeyPrefix prefixes one key per archive file. The rest of the key is the
+	// zero-padded ID of the first vote in the file, so keys sort in vote ID order.
+	ArchiveFileKeyPrefix = "vote_archive:"
 )
 
 // --- Redis Keys ---
@@ -17,9 +27,27 @@ const (
 const (
 	// RedisLastProcessedVoteIDKey is a Redis String that stores the ID of the last vote
 	// successfully processed by the VoteProcessor. It's the live checkpoint.
-	RedisLastProcessedVoteIDKey = "meta:last_processed_vote_id"
+	RedisLastProcessedVoteIDKey = database.KeyPrefix + "meta:last_processed_vote_id"
 
 	// RedisTotalVotesKey is a Redis String (used as a counter) that stores the live
 	// total number of processed votes (excluding skips).
-	RedisTotalVotesKey = "meta:total_votes"
+	RedisTotalVotesKey = database.KeyPrefix + "meta:total_votes"
 )
+
+// legacyRedisKeys are the keys of the vote dataset written by versions before
+// database.KeyPrefix was introduced. Nothing reads them any more; they are deleted
+// once at startup so they don't linger in Redis after an upgrade.
+var legacyRedisKeys = []string{
+	"meta:last_processed_vote_id",
+	"meta:total_votes",
+	"spell:stats",
+	"spell:ranking",
+	"spell:elo_bounds",
+	"user:stats",
+	"user:ranking",
+	"user:aggregates",
+	"user:dirty",
+	"user:dirty:processing",
+	"report:cache",
+	"achievement:pending",
+}
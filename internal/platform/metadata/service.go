@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
@@ -29,17 +30,27 @@ func GetValue(db *gorm.DB, key string) (string, error) {
 // SetValue creates or updates a value for a given key within a transaction.
 func SetValue(db *gorm.DB, key, value string) error {
 	// Use GORM's OnConflict clause for an efficient and atomic "upsert" operation.
-	// It will update the 'value' column if a record with the same 'key' already exists.
+	// It will update the 'value' and 'updated_at' columns if a record with the same 'key' already exists.
+	// Keeping 'updated_at' fresh matters because GetLastSnapshotTime relies on it.
 	meta := Metadata{
 		Key:   key,
 		Value: value,
 	}
 	return db.Clauses(clause.OnConflict{
 		Columns:   []clause.Column{{Name: "key"}},
-		DoUpdates: clause.AssignmentColumns([]string{"value"}),
+		DoUpdates: clause.AssignmentColumns([]string{"value", "updated_at"}),
 	}).Create(&meta).Error
 }
 
+// GetValuesWithPrefix retrieves all values whose key starts with prefix, ordered by key.
+func GetValuesWithPrefix(db *gorm.DB, prefix string) ([]string, error) {
+	// Escape LIKE wildcards so that '_' in the prefix only matches itself.
+	pattern := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(prefix) + "%"
+	var values []string
+	err := db.Model(&Metadata{}).Where(`key LIKE ? ESCAPE '\'`, pattern).Order("key asc").Pluck("value", &values).Error
+	return values, err
+}
+
 // --- Specific Helpers for Type Conversion ---
 
 // GetLastSnapshotVoteID is a helper that retrieves and parses the last snapshot vote ID.
@@ -64,6 +75,28 @@ func SetLastSnapshotVoteID(db *gorm.DB, voteID uint) error {
 	return SetValue(db, LastSnapshotVoteIDKey, valueStr)
 }
 
+// GetArchivedThroughVoteID is a helper that retrieves and parses the archive watermark.
+func GetArchivedThroughVoteID(db *gorm.DB) (uint, error) {
+	valueStr, err := GetValue(db, ArchivedThroughVoteIDKey)
+	if err != nil {
+		return 0, err
+	}
+	if valueStr == "" {
+		return 0, nil
+	}
+	id, err := strconv.ParseUint(valueStr, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("无法解析元数据 '%s' 的值: %w", ArchivedThroughVoteIDKey, err)
+	}
+	return uint(id), nil
+}
+
+// SetArchivedThroughVoteID is a helper that formats and sets the archive watermark.
+func SetArchivedThroughVoteID(db *gorm.DB, voteID uint) error {
+	valueStr := strconv.FormatUint(uint64(voteID), 10)
+	return SetValue(db, ArchivedThroughVoteIDKey, valueStr)
+}
+
 // GetSnapshotTotalVotes is a helper that retrieves and parses the total votes count.
 func GetSnapshotTotalVotes(db *gorm.DB) (float64, error) {
 	valueStr, err := GetValue(db, SnapshotTotalVotesKey)
@@ -2,6 +2,7 @@ package metadata
 
 import (
 	"fmt"
+	"log/slog"
 
 	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
 )
@@ -11,13 +12,13 @@ func migrateDB() error {
 	if err := database.DB.AutoMigrate(&Metadata{}); err != nil {
 		return fmt.Errorf("无法迁移metadata表: %w", err)
 	}
-	fmt.Println("Metadata数据库表迁移成功。")
+	slog.Info("Metadata数据库表迁移成功。")
 	return nil
 }
 
 // WarmupCache 从SQLite加载元数据并预热到Redis。
 func WarmupCache() error {
-	fmt.Println("正在预热Metadata缓存...")
+	slog.Info("正在预热Metadata缓存...")
 	// 1. 获取持久化的快照Vote ID和总投票数
 	lastSnapshotVoteID, err := GetLastSnapshotVoteID(database.DB)
 	if err != nil {
@@ -28,16 +29,12 @@ func WarmupCache() error {
 		return fmt.Errorf("无法从SQLite读取snapshot_total_votes: %w", err)
 	}
 
-	// 2. 使用Pipeline将这些值写入Redis，作为实时计数器的初始值
-	pipe := database.RDB.Pipeline()
-	pipe.Set(database.Ctx, RedisLastProcessedVoteIDKey, lastSnapshotVoteID, 0)
-	pipe.Set(database.Ctx, RedisTotalVotesKey, snapshotTotalVotes, 0)
-	_, err = pipe.Exec(database.Ctx)
-	if err != nil {
+	// 2. 将这些值写入Redis，作为实时计数器的初始值
+	if err := activeStore.setCounters(lastSnapshotVoteID, snapshotTotalVotes); err != nil {
 		return fmt.Errorf("预热元数据到Redis失败: %w", err)
 	}
 
-	fmt.Println("Metadata缓存预热成功。")
+	slog.Info("Metadata缓存预热成功。")
 	return nil
 }
 
@@ -46,6 +43,10 @@ func PrimeCachedDB() error {
 	if err := migrateDB(); err != nil {
 		return err
 	}
+	// 旧版本留下的无前缀键不会再被使用，启动时删除一次
+	if err := activeStore.deleteKeys(legacyRedisKeys...); err != nil {
+		return fmt.Errorf("删除旧版本的Redis键失败: %w", err)
+	}
 	if err := WarmupCache(); err != nil {
 		return err
 	}
@@ -0,0 +1,43 @@
+package metadata
+
+import (
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
+)
+
+// store 抽象了元数据实时计数器所在的缓存层。
+// 默认使用Redis，测试可以通过 UseMemoryStore 换成进程内的实现。
+type store interface {
+	// setCounters 写入投票处理检查点和实时总投票数
+	setCounters(lastProcessedVoteID uint, totalVotes float64) error
+	// deleteKeys 删除给定的键，不存在的键被忽略
+	deleteKeys(keys ...string) error
+}
+
+// activeStore 是当前使用的缓存层
+var activeStore store = redisStore{}
+
+// UseRedisStore 让metadata模块改回使用Redis缓存层，供测试在进程内的Redis上运行
+func UseRedisStore() {
+	activeStore = redisStore{}
+}
+
+// redisStore 是基于Redis的缓存层
+type redisStore struct{}
+
+func (redisStore) setCounters(lastProcessedVoteID uint, totalVotes float64) error {
+	pipe := database.RDB.Pipeline()
+	pipe.Set(database.Ctx, RedisLastProcessedVoteIDKey, lastProcessedVoteID, 0)
+	pipe.Set(database.Ctx, RedisTotalVotesKey, totalVotes, 0)
+	_, err := pipe.Exec(database.Ctx)
+	return err
+}
+
+// deleteKeys 逐个删除键，使不在同一槽位的键也能在集群中被删除
+func (redisStore) deleteKeys(keys ...string) error {
+	pipe := database.RDB.Pipeline()
+	for _, key := range keys {
+		pipe.Del(database.Ctx, key)
+	}
+	_, err := pipe.Exec(database.Ctx)
+	return err
+}
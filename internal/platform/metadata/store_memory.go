@@ -0,0 +1,32 @@
+package metadata
+
+import (
+	"strconv"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/memstore"
+)
+
+// memoryStore 是基于进程内存储的缓存层，键名和编码与 redisStore 相同
+type memoryStore struct {
+	db *memstore.Store
+}
+
+// UseMemoryStore 让metadata模块改用进程内存储，供测试在没有Redis的环境下使用
+func UseMemoryStore(db *memstore.Store) {
+	activeStore = memoryStore{db: db}
+}
+
+func (s memoryStore) setCounters(lastProcessedVoteID uint, totalVotes float64) error {
+	return s.db.Do(func(tx *memstore.Tx) error {
+		tx.Set(RedisLastProcessedVoteIDKey, strconv.FormatUint(uint64(lastProcessedVoteID), 10))
+		tx.Set(RedisTotalVotesKey, strconv.FormatFloat(totalVotes, 'f', -1, 64))
+		return nil
+	})
+}
+
+func (s memoryStore) deleteKeys(keys ...string) error {
+	return s.db.Do(func(tx *memstore.Tx) error {
+		tx.Del(keys...)
+		return nil
+	})
+}
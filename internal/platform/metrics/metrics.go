@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Namespace 是所有指标名称的公共前缀
+const Namespace = "noita_tier"
+
+var (
+	// Registry 是应用的Prometheus注册表，各模块在包初始化时把自己的指标注册到这里
+	Registry = prometheus.NewRegistry()
+
+	// Factory 用于创建指标并自动注册到 Registry
+	Factory = promauto.With(Registry)
+
+	httpRequestDuration = Factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "按路由模板统计的HTTP请求耗时",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Handler 返回暴露 Registry 中全部指标的HTTP处理器
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// knownMethods 是在指标中原样记录的HTTP方法，其余方法由客户端任意指定，统一记为 OTHER
+var knownMethods = map[string]struct{}{
+	http.MethodGet: {}, http.MethodHead: {}, http.MethodPost: {}, http.MethodPut: {},
+	http.MethodPatch: {}, http.MethodDelete: {}, http.MethodOptions: {},
+}
+
+// methodLabel 把请求方法映射到固定的标签集合
+func methodLabel(method string) string {
+	if _, ok := knownMethods[method]; ok {
+		return method
+	}
+	return "OTHER"
+}
+
+// GinMiddleware 按路由模板（而非原始路径）记录每个请求的耗时，避免路径参数造成标签爆炸；
+// 请求方法同样被映射到固定的集合
+func GinMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		httpRequestDuration.WithLabelValues(methodLabel(c.Request.Method), route, strconv.Itoa(c.Writer.Status())).
+			Observe(time.Since(start).Seconds())
+	}
+}
@@ -2,7 +2,7 @@ package shutdown
 
 import (
 	"context"
-	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/backup"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/logging"
 	"github.com/SlpAus/noita-spells-tier-backend/pkg/lifecycle"
 )
 
@@ -35,48 +36,51 @@ func NewCoordinator(gracefulMgr, forcefulMgr *lifecycle.Manager) *Coordinator {
 }
 
 // ListenForSignalsAndShutdown 启动信号监听并阻塞，直到停机流程完成。
-func (c *Coordinator) ListenForSignalsAndShutdown(server *http.Server) {
+// servers 是需要在停机开始时关闭的HTTP服务，第一个是对外的Gin服务器。
+func (c *Coordinator) ListenForSignalsAndShutdown(servers ...*http.Server) {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 
 	// 阻塞直到接收到停机信号
 	<-sigChan
-	fmt.Println("\n收到关闭信号，开始优雅停机...")
+	slog.Info("收到关闭信号，开始优雅停机...")
 
 	// 第一步：关闭HTTP服务
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), httpTimeout)
 	defer shutdownCancel()
-	if err := server.Shutdown(shutdownCtx); err != nil {
-		fmt.Printf("Gin服务器关闭错误: %v\n", err)
-	} else {
-		fmt.Println("Gin服务器已关闭。")
+	for _, server := range servers {
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			slog.Error("HTTP服务器关闭错误", slog.String("address", server.Addr), logging.Err(err))
+		} else {
+			slog.Info("HTTP服务器已关闭。", slog.String("address", server.Addr))
+		}
 	}
 
 	// 第二步：关闭第一阶段服务
-	fmt.Println("开始关闭第一阶段服务（优雅停机）...")
+	slog.Info("开始关闭第一阶段服务（优雅停机）...")
 	c.GracefulManager.Shutdown()
 	remainingGraceful := c.GracefulManager.WaitWithTimeout(gracefulTimeout)
 	if len(remainingGraceful) > 0 {
-		fmt.Printf("警告：以下优雅停机服务未能按时退出: %v\n", remainingGraceful)
+		slog.Warn("部分优雅停机服务未能按时退出", slog.Any("services", remainingGraceful))
 	}
-	fmt.Println("第一阶段服务关闭完成.")
+	slog.Info("第一阶段服务关闭完成。")
 
 	// 第三步：关闭第二阶段服务
-	fmt.Println("开始关闭第二阶段服务（强制停机）...")
+	slog.Info("开始关闭第二阶段服务（强制停机）...")
 	c.ForcefulManager.Shutdown()
 	remainingForceful := c.ForcefulManager.WaitWithTimeout(forcefulTimeout)
 	if len(remainingForceful) > 0 {
-		fmt.Printf("警告：以下强制停机服务未能按时退出: %v\n", remainingForceful)
+		slog.Warn("部分强制停机服务未能按时退出", slog.Any("services", remainingForceful))
 	}
-	fmt.Println("第二阶段服务关闭完成.")
+	slog.Info("第二阶段服务关闭完成。")
 
 	// 第四步：创建最终数据快照
-	fmt.Println("正在执行停机时快照...")
+	slog.Info("正在执行停机时快照...")
 	if err := backup.CreateConsistentSnapshotInDB(context.Background()); err != nil {
-		fmt.Printf("停机时快照失败: %v\n", err)
+		slog.Error("停机时快照失败", logging.Err(err))
 	} else {
-		fmt.Println("停机时快照成功。")
+		slog.Info("停机时快照成功。")
 	}
 
-	fmt.Println("优雅停机完成。")
+	slog.Info("优雅停机完成。")
 }
@@ -2,30 +2,42 @@ package startup
 
 import (
 	"context"
-	"fmt"
+	"log/slog"
 
+	"github.com/SlpAus/noita-spells-tier-backend/internal/account"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/achievement"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/leaderboard"
 	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/backup"
 	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/config"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/logging"
 	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/metadata"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/ratelimit"
 	"github.com/SlpAus/noita-spells-tier-backend/internal/report"
 	"github.com/SlpAus/noita-spells-tier-backend/internal/spell"
 	"github.com/SlpAus/noita-spells-tier-backend/internal/user"
 	"github.com/SlpAus/noita-spells-tier-backend/internal/vote"
 )
 
-func ConfigureAppMode(mode config.AppMode) {
-	fmt.Println("开始应用模式配置...")
+// ConfigureModules 根据应用模式和各模块的配置，完成所有模块的初始配置
+func ConfigureModules(cfg *config.Config) {
+	slog.Info("开始应用模式配置...")
 
+	mode := cfg.App.Mode
 	spell.ConfigureModule(mode)
-	vote.ConfigureModule(mode)
+	vote.ConfigureModule(mode, cfg.Vote)
 	report.ConfigureModule(mode)
+	account.ConfigureModule(mode, cfg.Token)
+	ratelimit.Configure(cfg.RateLimit)
+	leaderboard.ConfigureModule(cfg.Leaderboard)
+	achievement.ConfigureModule(mode, cfg.Achievement)
+	backup.ConfigureFileBackups(cfg.Database.Sqlite, cfg.Vote.Archive)
 
-	fmt.Println("应用模式配置完成！")
+	slog.Info("应用模式配置完成！")
 }
 
 // InitializeApplication 是应用首次启动时执行的总入口
 func InitializeApplication() error {
-	fmt.Println("开始应用首次初始化...")
+	slog.Info("开始应用首次初始化...")
 
 	if err := metadata.PrimeCachedDB(); err != nil {
 		return err
@@ -39,18 +51,32 @@ func InitializeApplication() error {
 	if err := vote.PrimeModule(); err != nil {
 		return err
 	}
+	if err := account.PrimeModule(); err != nil {
+		return err
+	}
+	if err := achievement.PrimeModule(); err != nil {
+		return err
+	}
 
-	fmt.Println("应用初始化完成！")
+	slog.Info("应用初始化完成！")
 	return nil
 }
 
 // RebuildCache 是一个专门用于在运行时热重建Redis缓存的函数
 func RebuildCache() error {
-	fmt.Println("开始缓存热重建...")
+	slog.Info("开始缓存热重建...")
 
+	// 先让投票应用脚本停止工作，避免它把投票应用到正在重建的数据上
+	if err := vote.InvalidateEloBounds(); err != nil {
+		return err
+	}
 	if err := metadata.WarmupCache(); err != nil {
 		return err
 	}
+	// 已使用的PairID同样随Redis一起丢失，需要从SQLite恢复，否则未过期的凭证可以被重放
+	if err := vote.RecoverReplayDefense(); err != nil {
+		return err
+	}
 
 	err := func() error {
 		spell.LockRepository()
@@ -76,18 +102,18 @@ func RebuildCache() error {
 	}
 
 	// 触发一次新的快照
-	fmt.Println("缓存热重建完成，正在触发一次新的数据快照...")
+	slog.Info("缓存热重建完成，正在触发一次新的数据快照...")
 	if err := backup.CreateConsistentSnapshotInDB(context.Background()); err != nil {
-		fmt.Printf("警告: 缓存热重建后的快照创建失败: %v\n", err)
+		slog.Warn("缓存热重建后的快照创建失败", logging.Err(err))
 	}
-	fmt.Println("快照创建成功！")
+	slog.Info("快照创建成功！")
 
 	return nil
 }
 
 // HandleRedisRecovery 在Redis从不健康状态恢复时，执行必要的清理和恢复操作。
 func HandleRedisRecovery() {
-	fmt.Println("检测到Redis已恢复，正在执行恢复后操作...")
+	slog.Info("检测到Redis已恢复，正在执行恢复后操作...")
 	report.ClearMirrorRepo()
-	fmt.Println("恢复后操作完成。")
+	slog.Info("恢复后操作完成。")
 }
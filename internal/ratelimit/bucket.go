@@ -0,0 +1,148 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
+	"github.com/redis/go-redis/v9"
+)
+
+// Rule 描述一个令牌桶：每秒补充Rate个令牌，最多积攒Burst个
+type Rule struct {
+	Rate  float64
+	Burst int
+}
+
+// enabled 判断该规则是否生效，Rate或Burst不为正时视为不限制
+func (r Rule) enabled() bool {
+	return r.Rate > 0 && r.Burst > 0
+}
+
+// idleTTL 是一个桶从空到满所需的时间，超过这段时间未被访问的桶与新桶等价，可以丢弃
+func (r Rule) idleTTL() time.Duration {
+	return time.Duration(float64(r.Burst)/r.Rate*float64(time.Second)) + time.Second
+}
+
+// limiter 是令牌桶状态的存储后端
+type limiter interface {
+	// take 尝试从key对应的桶中取出一个令牌。不允许时返回需要等待的时长。
+	take(key string, rule Rule, now time.Time) (allowed bool, retryAfter time.Duration, err error)
+}
+
+// --- 进程内实现 ---
+
+type memoryBucket struct {
+	tokens   float64
+	lastSeen time.Time
+	ttl      time.Duration
+}
+
+// memoryLimiter 在进程内保存令牌桶，适用于单实例部署，也作为Redis不可用时的后备
+type memoryLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*memoryBucket
+	lastSweep time.Time
+}
+
+const memorySweepInterval = time.Minute
+
+func newMemoryLimiter() *memoryLimiter {
+	return &memoryLimiter{buckets: make(map[string]*memoryBucket)}
+}
+
+func (m *memoryLimiter) take(key string, rule Rule, now time.Time) (bool, time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if now.Sub(m.lastSweep) > memorySweepInterval {
+		m.sweep(now)
+	}
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &memoryBucket{tokens: float64(rule.Burst), lastSeen: now, ttl: rule.idleTTL()}
+		m.buckets[key] = b
+	}
+
+	elapsed := now.Sub(b.lastSeen).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(float64(rule.Burst), b.tokens+elapsed*rule.Rate)
+		b.lastSeen = now
+	}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0, nil
+	}
+	wait := time.Duration((1 - b.tokens) / rule.Rate * float64(time.Second))
+	return false, wait, nil
+}
+
+// sweep 删除所有已经回满的闲置桶，防止map无限增长
+func (m *memoryLimiter) sweep(now time.Time) {
+	for key, b := range m.buckets {
+		if now.Sub(b.lastSeen) > b.ttl {
+			delete(m.buckets, key)
+		}
+	}
+	m.lastSweep = now
+}
+
+// --- Redis实现 ---
+
+// tokenBucketScript 原子地补充并消费一个令牌，返回 {是否允许, 需等待的毫秒数}
+var tokenBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local ttl = tonumber(ARGV[4])
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+  tokens = burst
+  ts = now
+end
+
+local elapsed = now - ts
+if elapsed > 0 then
+  tokens = math.min(burst, tokens + elapsed * rate)
+  ts = now
+end
+
+local allowed = 0
+local wait = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+else
+  wait = math.ceil((1 - tokens) / rate)
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(ts))
+redis.call('PEXPIRE', KEYS[1], ttl)
+return {allowed, wait}
+`)
+
+// redisLimiter 将令牌桶保存在Redis中，使多个实例共享同一份限额
+type redisLimiter struct {
+	prefix string
+}
+
+func (r *redisLimiter) take(key string, rule Rule, now time.Time) (bool, time.Duration, error) {
+	ratePerMs := rule.Rate / 1000
+	res, err := tokenBucketScript.Run(database.Ctx, database.RDB,
+		[]string{r.prefix + key},
+		ratePerMs, rule.Burst, now.UnixMilli(), rule.idleTTL().Milliseconds(),
+	).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	if len(res) != 2 {
+		return false, 0, errTokenBucketReply
+	}
+	return res[0] == 1, time.Duration(res[1]) * time.Millisecond, nil
+}
@@ -0,0 +1,160 @@
+package ratelimit
+
+import (
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/clientip"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/config"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/logging"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/metrics"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/user"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	BackendRedis  = "redis"
+	BackendMemory = "memory"
+
+	// redisKeyPrefix 是Redis中令牌桶的键名前缀
+	redisKeyPrefix = "ratelimit:"
+)
+
+var errTokenBucketReply = errors.New("令牌桶脚本返回值格式错误")
+
+var (
+	enabled bool
+	primary limiter = fallback
+	// fallback 在Redis不可用或出错时接管限流，宁可限额变为按实例计算，也不放开限制
+	fallback = newMemoryLimiter()
+
+	pairIPRule   Rule
+	pairUserRule Rule
+
+	// decisions 按规则统计放行与拒绝的请求数
+	decisions = metrics.Factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "ratelimit",
+		Name:      "decisions_total",
+		Help:      "按规则和结果统计的限流判定次数",
+	}, []string{"rule", "result"})
+	// backendErrors 统计Redis令牌桶操作失败、退回进程内限流的次数
+	backendErrors = metrics.Factory.NewCounter(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "ratelimit",
+		Name:      "backend_errors_total",
+		Help:      "Redis令牌桶操作失败、退回进程内限流的次数",
+	})
+)
+
+// 限流规则在指标中的名称
+const (
+	rulePairIP   = "pair_ip"
+	rulePairUser = "pair_user"
+)
+
+func init() {
+	for _, rule := range []string{rulePairIP, rulePairUser} {
+		decisions.WithLabelValues(rule, "allowed")
+		decisions.WithLabelValues(rule, "rejected")
+	}
+}
+
+// Configure 根据配置初始化限流模块
+func Configure(cfg config.RateLimitConfig) {
+	enabled = cfg.Enabled
+	switch cfg.Backend {
+	case BackendMemory:
+		primary = fallback
+	default:
+		primary = &redisLimiter{prefix: redisKeyPrefix}
+	}
+	pairIPRule = Rule{Rate: cfg.Pair.PerIP.Rate, Burst: cfg.Pair.PerIP.Burst}
+	pairUserRule = Rule{Rate: cfg.Pair.PerUser.Rate, Burst: cfg.Pair.PerUser.Burst}
+
+	if enabled {
+		slog.Info("限流已启用。",
+			slog.String("backend", string(cfg.Backend)),
+			slog.Group("pair_per_ip", slog.Float64("rate", pairIPRule.Rate), slog.Int("burst", pairIPRule.Burst)),
+			slog.Group("pair_per_user", slog.Float64("rate", pairUserRule.Rate), slog.Int("burst", pairUserRule.Burst)))
+	}
+}
+
+// take 使用主后端取令牌，必要时退回到进程内后端
+func take(key string, rule Rule, now time.Time) (bool, time.Duration) {
+	if primary != fallback && database.IsRedisHealthy() {
+		allowed, wait, err := primary.take(key, rule, now)
+		if err == nil {
+			return allowed, wait
+		}
+		backendErrors.Inc()
+		slog.Warn("限流: Redis令牌桶操作失败，退回进程内限流", logging.Err(err))
+	}
+	allowed, wait, _ := fallback.take(key, rule, now)
+	return allowed, wait
+}
+
+// Allow 从key对应的令牌桶中取出一个令牌，供其他模块限制请求之外的操作（如写入日志记录）。
+// 它不受 rateLimit.enabled 的影响；规则不生效时总是允许。
+func Allow(key string, rule Rule, now time.Time) bool {
+	if !rule.enabled() {
+		return true
+	}
+	allowed, _ := take(key, rule, now)
+	return allowed
+}
+
+// reject 以429响应请求，并在Retry-After中给出建议的等待秒数
+func reject(c *gin.Context, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "请求过于频繁，请稍后再试"})
+}
+
+// PairMiddleware 为获取候选对的接口做限流，先按来源IP网段，再按用户。
+// 它应位于EnsureUserCookieMiddleware之前，使被限流的请求不会再创建临时用户；
+// 因此用户维度只对携带了有效Cookie的请求生效，无Cookie的请求仅受IP维度约束。
+func PairMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !enabled {
+			c.Next()
+			return
+		}
+		now := time.Now()
+
+		if pairIPRule.enabled() {
+			if subnet, err := clientip.SubnetKey(c.ClientIP()); err == nil {
+				allowed, wait := take("pair:ip:"+subnet, pairIPRule, now)
+				if !allowed {
+					decisions.WithLabelValues(rulePairIP, "rejected").Inc()
+					reject(c, wait)
+					return
+				}
+				decisions.WithLabelValues(rulePairIP, "allowed").Inc()
+			}
+		}
+
+		if pairUserRule.enabled() {
+			if userID, err := c.Cookie(user.CookieName); err == nil && user.IsValidUUID(userID) {
+				allowed, wait := take("pair:user:"+userID, pairUserRule, now)
+				if !allowed {
+					decisions.WithLabelValues(rulePairUser, "rejected").Inc()
+					reject(c, wait)
+					return
+				}
+				decisions.WithLabelValues(rulePairUser, "allowed").Inc()
+			}
+		}
+
+		c.Next()
+	}
+}
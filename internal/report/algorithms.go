@@ -6,9 +6,7 @@ import (
 	"time"
 
 	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/config"
-	"github.com/SlpAus/noita-spells-tier-backend/internal/spell"
 	"github.com/SlpAus/noita-spells-tier-backend/internal/user"
-	"github.com/SlpAus/noita-spells-tier-backend/internal/vote"
 )
 
 const (
@@ -72,7 +70,7 @@ func loadAlgorithmConsts(mode config.AppMode) {
 	}
 }
 
-// milestoneNumbers 定义了我们关心的特定投票数里程碑。
+// milestoneNumbers 定义了我们关心的特定投票数里程碑，它们必须包含在 user.MilestoneVoteNumbers 中。
 var milestoneNumbers = []int{25, 50, 100, 250, 500, 1000}
 
 // calculateDecisionRate 根据用户统计数据计算决断率。
@@ -90,106 +88,58 @@ func calculateDecisionRate(stats user.UserStats) float64 {
 }
 
 // calculateCommunityConsistencyIndex 计算社区一致性指数。
-// 指数 = (胜者排名高于败者的次数) / (总胜负次数)
-func calculateCommunityConsistencyIndex(userVotes []userVoteRecord, spellRank map[string]int) (float64, error) {
-	if spellRank == nil {
-		return 0.0, fmt.Errorf("spellRank 不能为nil")
-	}
-
-	var winLossVotes int
-	var consistentWins int
-
-	for _, userVote := range userVotes {
-		if userVote.Result == vote.ResultAWins || userVote.Result == vote.ResultBWins {
-			winLossVotes++
-			winnerRank, ok := spellRank[userVote.SpellA_ID]
-			if !ok {
-				return 0.0, fmt.Errorf("法术 %s 不存在", userVote.SpellA_ID)
-			}
-			loserRank, ok := spellRank[userVote.SpellB_ID]
-			if !ok {
-				return 0.0, fmt.Errorf("法术 %s 不存在", userVote.SpellB_ID)
-			}
-
-			if userVote.Result == vote.ResultBWins {
-				winnerRank, loserRank = loserRank, winnerRank
-			}
-
-			if winnerRank < loserRank {
-				consistentWins++
-			}
-		}
-	}
-
-	if winLossVotes == 0 {
-		return 0.0, nil
+// 指数 = (胜者排名高于败者的次数) / (总胜负次数)，排名以投票被处理时为准。
+func calculateCommunityConsistencyIndex(stats user.UserStats, agg *user.UserAggregates) float64 {
+	if stats.Wins == 0 {
+		return 0.0
 	}
-	return float64(consistentWins) / float64(winLossVotes), nil
+	return float64(agg.Consistent) / float64(stats.Wins)
 }
 
 // calculateUpsetTendency 计算以弱胜强倾向指数。
 // 指数归一化到 [0, 1] 区间，0.5代表无倾向。
-func calculateUpsetTendency(userVotes []userVoteRecord, spellRankScore map[string]float64) (float64, error) {
+// 败者与胜者的分差之和是线性的，可以由每个法术的胜负次数和当前分数直接求出。
+func calculateUpsetTendency(stats user.UserStats, agg *user.UserAggregates, spellRankScore map[string]float64) (float64, error) {
 	if spellRankScore == nil {
 		return 0.0, fmt.Errorf("spellRankScore 不能为nil")
 	}
 
-	var winLossVotes int
-	var upsetScoreSum float64
-
-	for _, userVote := range userVotes {
-		if userVote.Result == vote.ResultAWins || userVote.Result == vote.ResultBWins {
-			winLossVotes++
-			winnerScore, ok := spellRankScore[userVote.SpellA_ID]
-			if !ok {
-				return 0.0, fmt.Errorf("法术 %s 不存在", userVote.SpellA_ID)
-			}
-			loserScore, ok := spellRankScore[userVote.SpellB_ID]
-			if !ok {
-				return 0.0, fmt.Errorf("法术 %s 不存在", userVote.SpellB_ID)
-			}
-
-			if userVote.Result == vote.ResultBWins {
-				winnerScore, loserScore = loserScore, winnerScore
-			}
-
-			upsetScoreSum += (loserScore - winnerScore)
-		}
+	if stats.Wins == 0 {
+		return 0.5, nil // 无胜负记录，返回中值
 	}
 
-	if winLossVotes == 0 {
-		return 0.5, nil // 无胜负记录，返回中值
+	var upsetScoreSum float64
+	for spellID, tally := range agg.Spells {
+		if tally.Wins == 0 && tally.Beaten == 0 {
+			continue
+		}
+		score, ok := spellRankScore[spellID]
+		if !ok {
+			return 0.0, fmt.Errorf("法术 %s 不存在", spellID)
+		}
+		upsetScoreSum += float64(tally.Beaten-tally.Wins) * score
 	}
 
 	// 归一化处理
-	normalized := (upsetScoreSum/float64(winLossVotes))*0.5 + 0.5
+	normalized := (upsetScoreSum/float64(stats.Wins))*0.5 + 0.5
 	return normalized, nil
 }
 
 // calculateMostChosenSpell 计算用户最常选择的法术。
-func calculateMostChosenSpell(userVotes []userVoteRecord) (*MostChosenSpell, error) {
-	spellWinCounts := make(map[string]int)
+// 胜利次数相同时，选择更早遇到的法术。
+func calculateMostChosenSpell(agg *user.UserAggregates) (*MostChosenSpell, error) {
 	var mostChosenSpellID string
-	maxWins := 0
-
-	for _, userVote := range userVotes {
-		var winnerID string
-		if userVote.Result == vote.ResultAWins {
-			winnerID = userVote.SpellA_ID
-		} else if userVote.Result == vote.ResultBWins {
-			winnerID = userVote.SpellB_ID
-		}
+	var mostChosen user.SpellTally
 
-		if winnerID != "" {
-			spellWinCounts[winnerID]++
-			if spellWinCounts[winnerID] > maxWins {
-				maxWins = spellWinCounts[winnerID]
-				mostChosenSpellID = winnerID
-			}
+	for spellID, tally := range agg.Spells {
+		if tally.Wins > mostChosen.Wins ||
+			(tally.Wins == mostChosen.Wins && tally.Wins > 0 && tally.FirstSeen.Number < mostChosen.FirstSeen.Number) {
+			mostChosenSpellID = spellID
+			mostChosen = tally
 		}
 	}
 
-	if maxWins < MinWinsForMostChosen {
+	if mostChosen.Wins < MinWinsForMostChosen {
 		return nil, nil
 	}
 
@@ -201,43 +151,19 @@ func calculateMostChosenSpell(userVotes []userVoteRecord) (*MostChosenSpell, err
 	return &MostChosenSpell{
 		ID:        mostChosenSpellID,
 		Name:      mostChosenSpellName,
-		VoteCount: maxWins,
+		VoteCount: mostChosen.Wins,
 	}, nil
 }
 
-// 从 []userVoteRecord 计算其包含的法术的胜率，排除有效场次小于 MinTotalGamesForWinRate 的法术。
-func calcualteWinRateForSpells(userVotes []userVoteRecord) map[string]float64 {
-	totalSpells := spell.GetSpellCount()
-	spellWinRates := make(map[string]float64, totalSpells)
-	type gameStats struct {
-		wins  int
-		loses int
-	}
-	spellGameCounts := make(map[string]gameStats, totalSpells)
-
-	for _, userVote := range userVotes {
-		statsA := spellGameCounts[userVote.SpellA_ID]
-		statsB := spellGameCounts[userVote.SpellB_ID]
-
-		switch userVote.Result {
-		case vote.ResultAWins:
-			statsA.wins++
-			statsB.loses++
-		case vote.ResultBWins:
-			statsA.loses++
-			statsB.wins++
-		case vote.ResultDraw:
-			statsA.loses++
-			statsB.loses++
-		}
-		spellGameCounts[userVote.SpellA_ID] = statsA
-		spellGameCounts[userVote.SpellB_ID] = statsB
-	}
+// 从用户的聚合数据计算其遇到过的法术的胜率，排除有效场次小于 MinTotalGamesForWinRate 的法术。
+// 双输视为双方都未获胜。
+func calcualteWinRateForSpells(agg *user.UserAggregates) map[string]float64 {
+	spellWinRates := make(map[string]float64, len(agg.Spells))
 
-	for spellID, stats := range spellGameCounts {
-		totalGames := stats.wins + stats.loses
+	for spellID, tally := range agg.Spells {
+		totalGames := tally.Wins + tally.Beaten + tally.Draws
 		if totalGames >= MinTotalGamesForWinRate {
-			spellWinRates[spellID] = float64(stats.wins) / float64(totalGames)
+			spellWinRates[spellID] = float64(tally.Wins) / float64(totalGames)
 		}
 	}
 
@@ -360,44 +286,18 @@ func calculateNemesis(spellWinRates map[string]float64, spellRankScore map[strin
 }
 
 // calculateMostSubversiveVote 计算用户最具颠覆性的一票。
-// 即选择的胜者，其社区排名远低于败者。
-func calculateMostSubversiveVote(userVotes []userVoteRecord, spellRank map[string]int) (*SpellHighlightVote, error) {
+// 即选择的胜者，其社区排名远低于败者；排名差以投票被处理时为准，展示的排名为当前排名。
+func calculateMostSubversiveVote(agg *user.UserAggregates, votes map[uint]userVoteRecord, spellRank map[string]int) (*SpellHighlightVote, error) {
 	if spellRank == nil {
 		return nil, fmt.Errorf("spellRank 不能为nil")
 	}
-
-	maxRankDiff := 0
-	var subversiveVote userVoteRecord
-	subversiveVoteIndex := -1
-
-	for i, userVote := range userVotes {
-		if userVote.Result == vote.ResultAWins || userVote.Result == vote.ResultBWins {
-			winnerID := userVote.SpellA_ID
-			loserID := userVote.SpellB_ID
-			if userVote.Result == vote.ResultBWins {
-				winnerID, loserID = loserID, winnerID
-			}
-
-			winnerRank, ok := spellRank[winnerID]
-			if !ok {
-				return nil, fmt.Errorf("法术 %s 不存在", winnerID)
-			}
-			loserRank, ok := spellRank[loserID]
-			if !ok {
-				return nil, fmt.Errorf("法术 %s 不存在", loserID)
-			}
-
-			rankDiff := winnerRank - loserRank
-			if rankDiff > maxRankDiff {
-				maxRankDiff = rankDiff
-				subversiveVote = userVote
-				subversiveVoteIndex = i
-			}
-		}
+	if agg.Subversive == nil {
+		return nil, nil
 	}
 
-	if subversiveVoteIndex < 0 {
-		return nil, nil
+	subversiveVote, ok := votes[agg.Subversive.VoteID]
+	if !ok {
+		return nil, fmt.Errorf("投票 %d 不存在", agg.Subversive.VoteID)
 	}
 
 	// 填充结果
@@ -411,7 +311,7 @@ func calculateMostSubversiveVote(userVotes []userVoteRecord, spellRank map[strin
 	}
 
 	return &SpellHighlightVote{
-		VoteNumber: subversiveVoteIndex + 1,
+		VoteNumber: agg.Subversive.Number,
 		SpellA: SpellNameRank{
 			ID:   subversiveVote.SpellA_ID,
 			Name: spellAName,
@@ -431,94 +331,79 @@ func truncateToDay(t time.Time) time.Time {
 	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
 }
 
-// calculateFirstVote 获取用户的第一次投票作为里程碑。
-func calculateFirstVote(userVotes []userVoteRecord) (*SpellMilestoneVote, error) {
-	if len(userVotes) == 0 {
-		return nil, nil
+// milestoneVote 将一次被引用的投票转换为里程碑。
+func milestoneVote(ref user.VoteRef, votes map[uint]userVoteRecord) (*SpellMilestoneVote, error) {
+	voteRecord, ok := votes[ref.VoteID]
+	if !ok {
+		return nil, fmt.Errorf("投票 %d 不存在", ref.VoteID)
 	}
-	firstVote := userVotes[0]
-
-	spellAName, err := getSpellNameByID(firstVote.SpellA_ID)
+	spellAName, err := getSpellNameByID(voteRecord.SpellA_ID)
 	if err != nil {
 		return nil, err
 	}
-	spellBName, err := getSpellNameByID(firstVote.SpellB_ID)
+	spellBName, err := getSpellNameByID(voteRecord.SpellB_ID)
 	if err != nil {
 		return nil, err
 	}
 
 	return &SpellMilestoneVote{
-		VoteNumber: 1,
-		SpellA:     SpellNameRank{ID: firstVote.SpellA_ID, Name: spellAName},
-		SpellB:     SpellNameRank{ID: firstVote.SpellB_ID, Name: spellBName},
-		Result:     firstVote.Result,
-		Date:       truncateToDay(firstVote.VoteTime),
+		VoteNumber: ref.Number,
+		SpellA:     SpellNameRank{ID: voteRecord.SpellA_ID, Name: spellAName},
+		SpellB:     SpellNameRank{ID: voteRecord.SpellB_ID, Name: spellBName},
+		Result:     voteRecord.Result,
+		Date:       truncateToDay(voteRecord.VoteTime),
 	}, nil
 }
 
-// calculateMilestones 根据定义的里程碑数字，从用户投票历史中提取里程碑事件。
-func calculateMilestones(userVotes []userVoteRecord) ([]SpellMilestoneVote, error) {
-	var milestones []SpellMilestoneVote
-	totalUserVotes := len(userVotes)
+// calculateFirstVote 获取用户的第一次投票作为里程碑。
+func calculateFirstVote(agg *user.UserAggregates, votes map[uint]userVoteRecord) (*SpellMilestoneVote, error) {
+	ref, ok := agg.Milestone(1)
+	if !ok {
+		return nil, nil
+	}
+	return milestoneVote(ref, votes)
+}
 
-	// 筛选出用户已达成的里程碑
-	achievedMilestones := make([]int, 0)
+// achievedMilestones 返回用户已达成、需要在报告中显示的里程碑。
+func achievedMilestones(agg *user.UserAggregates) []user.VoteRef {
+	achieved := make([]user.VoteRef, 0, len(milestoneNumbers))
 	for _, m := range milestoneNumbers {
-		if totalUserVotes >= m {
-			achievedMilestones = append(achievedMilestones, m)
+		if ref, ok := agg.Milestone(m); ok {
+			achieved = append(achieved, ref)
 		}
 	}
 
 	// 如果达成的里程碑超过最大显示数量，则只取最后的几个
-	if len(achievedMilestones) > MaxMilestones {
-		achievedMilestones = achievedMilestones[len(achievedMilestones)-MaxMilestones:]
+	if len(achieved) > MaxMilestones {
+		achieved = achieved[len(achieved)-MaxMilestones:]
 	}
+	return achieved
+}
 
-	for _, m := range achievedMilestones {
-		voteRecord := userVotes[m-1]
-		spellAName, err := getSpellNameByID(voteRecord.SpellA_ID)
-		if err != nil {
-			return nil, err
-		}
-		spellBName, err := getSpellNameByID(voteRecord.SpellB_ID)
+// calculateMilestones 根据定义的里程碑数字，从用户的聚合数据中提取里程碑事件。
+func calculateMilestones(agg *user.UserAggregates, votes map[uint]userVoteRecord) ([]SpellMilestoneVote, error) {
+	var milestones []SpellMilestoneVote
+
+	for _, ref := range achievedMilestones(agg) {
+		milestone, err := milestoneVote(ref, votes)
 		if err != nil {
 			return nil, err
 		}
-
-		milestones = append(milestones, SpellMilestoneVote{
-			VoteNumber: m,
-			SpellA:     SpellNameRank{ID: voteRecord.SpellA_ID, Name: spellAName},
-			SpellB:     SpellNameRank{ID: voteRecord.SpellB_ID, Name: spellBName},
-			Result:     voteRecord.Result,
-			Date:       truncateToDay(voteRecord.VoteTime),
-		})
+		milestones = append(milestones, *milestone)
 	}
 
 	return milestones, nil
 }
 
-// calculateBusiestDay 使用滑动窗口算法找出用户在24小时内投票最密集的一天。
-func calculateBusiestDay(userVotes []userVoteRecord) *ActivityRecord {
-	if len(userVotes) < MinVotesForBusiestDay {
-		return nil
-	}
-
+// calculateBusiestDay 找出用户投票最多的一天。票数相同时选择更早的一天。
+func calculateBusiestDay(agg *user.UserAggregates) *ActivityRecord {
 	maxVotes := 0
-	var busiestStart, busiestEnd time.Time
-	left := 0
-
-	for right, vote := range userVotes {
-		// 移动左指针，直到窗口小于等于24小时
-		for vote.VoteTime.Sub(userVotes[left].VoteTime) > 24*time.Hour {
-			left++
-		}
+	var busiestDay string
 
-		// 当前窗口的投票数
-		currentVotes := right - left + 1
-		if currentVotes > maxVotes {
-			maxVotes = currentVotes
-			busiestStart = userVotes[left].VoteTime
-			busiestEnd = vote.VoteTime
+	for day, count := range agg.Days {
+		if count > maxVotes || (count == maxVotes && day < busiestDay) {
+			maxVotes = count
+			busiestDay = day
 		}
 	}
 
@@ -526,93 +411,118 @@ func calculateBusiestDay(userVotes []userVoteRecord) *ActivityRecord {
 		return nil
 	}
 
+	date, err := time.ParseInLocation(time.DateOnly, busiestDay, time.Local)
+	if err != nil {
+		return nil
+	}
+
 	return &ActivityRecord{
-		FromDate:  truncateToDay(busiestStart),
-		ToDate:    truncateToDay(busiestEnd),
+		FromDate:  date,
+		ToDate:    date,
 		VoteCount: maxVotes,
 	}
 }
 
-// calculateFirstEncounterTop 查找用户首次遇到顶级法术的投票。
-func calculateFirstEncounterTop(userVotes []userVoteRecord, spellRank map[string]int, rankToSpell []string) (*SpellEncounterRecord, error) {
-	if spellRank == nil || rankToSpell == nil {
-		return nil, fmt.Errorf("传入的map或slice不能为nil")
+// tierSpells 返回排名最靠前（top为true）或最靠后的一部分法术。
+func tierSpells(rankToSpell []string, ratio float64, top bool) map[string]struct{} {
+	count := int(float64(len(rankToSpell)) * ratio)
+	count = min(max(count, 1), len(rankToSpell))
+
+	tier := rankToSpell[:count]
+	if !top {
+		tier = rankToSpell[len(rankToSpell)-count:]
 	}
 
-	count := int(float64(len(rankToSpell)) * TopTierRatio)
-	count = min(max(count, 1), len(rankToSpell))
+	spells := make(map[string]struct{}, count)
+	for _, spellID := range tier {
+		spells[spellID] = struct{}{}
+	}
+	return spells
+}
 
-	topSpells := make(map[string]struct{}, count)
-	for _, spellID := range rankToSpell[:count] {
-		topSpells[spellID] = struct{}{}
-	}
-
-	for i, userVote := range userVotes {
-		_, isAIn := topSpells[userVote.SpellA_ID]
-		_, isBIn := topSpells[userVote.SpellB_ID]
-
-		if isAIn || isBIn {
-			spellAName, err := getSpellNameByID(userVote.SpellA_ID)
-			if err != nil {
-				return nil, err
-			}
-			spellBName, err := getSpellNameByID(userVote.SpellB_ID)
-			if err != nil {
-				return nil, err
-			}
-			return &SpellEncounterRecord{
-				VoteNumber: i + 1,
-				SpellA:     SpellNameRank{ID: userVote.SpellA_ID, Name: spellAName, Rank: int64(spellRank[userVote.SpellA_ID])},
-				SpellB:     SpellNameRank{ID: userVote.SpellB_ID, Name: spellBName, Rank: int64(spellRank[userVote.SpellB_ID])},
-				SpecialA:   isAIn,
-				SpecialB:   isBIn,
-				Result:     userVote.Result,
-				Date:       truncateToDay(userVote.VoteTime),
-			}, nil
+// firstEncounter 返回用户首次遇到给定法术集合中任一法术的投票。
+func firstEncounter(agg *user.UserAggregates, spells map[string]struct{}) (user.VoteRef, bool) {
+	var first user.VoteRef
+	for spellID := range spells {
+		tally, ok := agg.Spells[spellID]
+		if !ok || tally.FirstSeen.VoteID == 0 {
+			continue
+		}
+		if first.VoteID == 0 || tally.FirstSeen.Number < first.Number {
+			first = tally.FirstSeen
 		}
 	}
+	return first, first.VoteID != 0
+}
+
+// calculateFirstEncounter 查找用户首次遇到给定法术集合的投票。
+func calculateFirstEncounter(agg *user.UserAggregates, votes map[uint]userVoteRecord, spellRank map[string]int, spells map[string]struct{}) (*SpellEncounterRecord, error) {
+	ref, ok := firstEncounter(agg, spells)
+	if !ok {
+		return nil, nil
+	}
+	userVote, ok := votes[ref.VoteID]
+	if !ok {
+		return nil, fmt.Errorf("投票 %d 不存在", ref.VoteID)
+	}
+
+	_, isAIn := spells[userVote.SpellA_ID]
+	_, isBIn := spells[userVote.SpellB_ID]
+
+	spellAName, err := getSpellNameByID(userVote.SpellA_ID)
+	if err != nil {
+		return nil, err
+	}
+	spellBName, err := getSpellNameByID(userVote.SpellB_ID)
+	if err != nil {
+		return nil, err
+	}
+	return &SpellEncounterRecord{
+		VoteNumber: ref.Number,
+		SpellA:     SpellNameRank{ID: userVote.SpellA_ID, Name: spellAName, Rank: int64(spellRank[userVote.SpellA_ID])},
+		SpellB:     SpellNameRank{ID: userVote.SpellB_ID, Name: spellBName, Rank: int64(spellRank[userVote.SpellB_ID])},
+		SpecialA:   isAIn,
+		SpecialB:   isBIn,
+		Result:     userVote.Result,
+		Date:       truncateToDay(userVote.VoteTime),
+	}, nil
+}
 
-	return nil, nil
+// calculateFirstEncounterTop 查找用户首次遇到顶级法术的投票。
+func calculateFirstEncounterTop(agg *user.UserAggregates, votes map[uint]userVoteRecord, spellRank map[string]int, rankToSpell []string) (*SpellEncounterRecord, error) {
+	if spellRank == nil || rankToSpell == nil {
+		return nil, fmt.Errorf("传入的map或slice不能为nil")
+	}
+	return calculateFirstEncounter(agg, votes, spellRank, tierSpells(rankToSpell, TopTierRatio, true))
 }
 
 // calculateFirstEncounterBottom 查找用户首次遇到垫底法术的投票。
-func calculateFirstEncounterBottom(userVotes []userVoteRecord, spellRank map[string]int, rankToSpell []string) (*SpellEncounterRecord, error) {
+func calculateFirstEncounterBottom(agg *user.UserAggregates, votes map[uint]userVoteRecord, spellRank map[string]int, rankToSpell []string) (*SpellEncounterRecord, error) {
 	if spellRank == nil || rankToSpell == nil {
 		return nil, fmt.Errorf("传入的map或slice不能为nil")
 	}
+	return calculateFirstEncounter(agg, votes, spellRank, tierSpells(rankToSpell, BottomTierRatio, false))
+}
 
-	count := int(float64(len(rankToSpell)) * BottomTierRatio)
-	count = min(max(count, 1), len(rankToSpell))
-
-	bottomSpells := make(map[string]struct{}, count)
-	for _, spellID := range rankToSpell[len(rankToSpell)-count:] {
-		bottomSpells[spellID] = struct{}{}
-	}
-
-	for i, userVote := range userVotes {
-		_, isAIn := bottomSpells[userVote.SpellA_ID]
-		_, isBIn := bottomSpells[userVote.SpellB_ID]
-
-		if isAIn || isBIn {
-			spellAName, err := getSpellNameByID(userVote.SpellA_ID)
-			if err != nil {
-				return nil, err
-			}
-			spellBName, err := getSpellNameByID(userVote.SpellB_ID)
-			if err != nil {
-				return nil, err
-			}
-			return &SpellEncounterRecord{
-				VoteNumber: i + 1,
-				SpellA:     SpellNameRank{ID: userVote.SpellA_ID, Name: spellAName, Rank: int64(spellRank[userVote.SpellA_ID])},
-				SpellB:     SpellNameRank{ID: userVote.SpellB_ID, Name: spellBName, Rank: int64(spellRank[userVote.SpellB_ID])},
-				SpecialA:   isAIn,
-				SpecialB:   isBIn,
-				Result:     userVote.Result,
-				Date:       truncateToDay(userVote.VoteTime),
-			}, nil
+// referencedVoteIDs 收集报告中需要展示细节的投票ID，以便一次性查询。
+func referencedVoteIDs(agg *user.UserAggregates, rankToSpell []string) []uint {
+	var ids []uint
+	if ref, ok := agg.Milestone(1); ok {
+		ids = append(ids, ref.VoteID)
+	}
+	for _, ref := range achievedMilestones(agg) {
+		ids = append(ids, ref.VoteID)
+	}
+	if agg.Subversive != nil {
+		ids = append(ids, agg.Subversive.VoteID)
+	}
+	if len(rankToSpell) > 0 {
+		if ref, ok := firstEncounter(agg, tierSpells(rankToSpell, TopTierRatio, true)); ok {
+			ids = append(ids, ref.VoteID)
+		}
+		if ref, ok := firstEncounter(agg, tierSpells(rankToSpell, BottomTierRatio, false)); ok {
+			ids = append(ids, ref.VoteID)
 		}
 	}
-
-	return nil, nil
+	return ids
 }
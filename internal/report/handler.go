@@ -1,11 +1,13 @@
 package report
 
 import (
-	"fmt"
+	"log/slog"
 	"net/http"
 	"time"
 
+	"github.com/SlpAus/noita-spells-tier-backend/internal/achievement"
 	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/config"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/logging"
 	"github.com/SlpAus/noita-spells-tier-backend/internal/user"
 	"github.com/SlpAus/noita-spells-tier-backend/internal/vote"
 	"github.com/gin-gonic/gin"
@@ -49,6 +51,9 @@ type SpellUserReport struct {
 	BusiestDay           *ActivityRecord       `json:"busiestDay,omitempty"`           // 最肝的一天/24小时
 	FirstEncounterTop    *SpellEncounterRecord `json:"firstEncounterTop,omitempty"`    // 首次遭遇顶级法术
 	FirstEncounterBottom *SpellEncounterRecord `json:"firstEncounterBottom,omitempty"` // 首次遭遇垫底法术
+
+	// --- 成就 ---
+	Achievements []achievement.Earned `json:"achievements,omitempty"` // 已获得的成就，不随报告缓存
 }
 
 type PerkUserReport struct {
@@ -79,6 +84,9 @@ type PerkUserReport struct {
 	BusiestDay           *ActivityRecord      `json:"busiestDay,omitempty"`           // 最肝的一天/24小时
 	FirstEncounterTop    *PerkEncounterRecord `json:"firstEncounterTop,omitempty"`    // 首次遭遇顶级天赋
 	FirstEncounterBottom *PerkEncounterRecord `json:"firstEncounterBottom,omitempty"` // 首次遭遇垫底天赋
+
+	// --- 成就 ---
+	Achievements []achievement.Earned `json:"achievements,omitempty"` // 已获得的成就，不随报告缓存
 }
 
 // ChoiceCounts 记录了用户做出不同选择的次数。
@@ -180,9 +188,9 @@ func GetReport(c *gin.Context) {
 		userID = ""
 	}
 
-	report, err := GenerateUserReport(userID)
+	report, err := GenerateUserReport(c.Request.Context(), userID)
 	if err != nil {
-		fmt.Printf("%v\n", err)
+		slog.ErrorContext(c.Request.Context(), "生成报告失败", logging.Err(err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成报告时时发生内部错误"})
 		return
 	}
@@ -270,5 +278,6 @@ func SpellUserReportToPerkUserReport(origin *SpellUserReport) *PerkUserReport {
 			Date:       origin.FirstEncounterBottom.Date,
 		}
 	}
+	target.Achievements = origin.Achievements
 	return &target
 }
@@ -11,7 +11,6 @@ import (
 	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/metadata"
 	"github.com/SlpAus/noita-spells-tier-backend/internal/spell"
 	"github.com/SlpAus/noita-spells-tier-backend/internal/user"
-	"github.com/redis/go-redis/v9"
 	"gorm.io/gorm"
 )
 
@@ -19,39 +18,66 @@ const (
 	// CacheKey 是一个 Redis Hash 的键，用于缓存序列化后的用户报告。
 	// Field: 用户的UUID
 	// Value: SpellUserReport 结构体的JSON序列化字符串
-	CacheKey = "report:cache"
+	CacheKey = database.KeyPrefix + "report:cache"
 )
 
+// cachedReport 是报告在缓存中的存储结构。
+// UserLastVoteID 记录了生成报告时用户最后一次被处理的投票ID，与当前值不一致时缓存即失效。
+type cachedReport struct {
+	UserLastVoteID uint             `json:"v"`
+	Report         *SpellUserReport `json:"r"`
+}
+
 // GetReportCache 从Redis缓存中获取用户报告。
+// 如果投票处理器在报告生成后又处理了该用户的投票，则视为缓存未命中。
 func GetReportCache(userID string) (*SpellUserReport, error) {
-	result, err := database.RDB.HGet(database.Ctx, CacheKey, userID).Result()
-	if err == redis.Nil {
-		return nil, nil // 缓存未命中，是正常情况，不返回错误
-	}
+	result, statsJSON, err := activeStore.cachedReport(userID)
 	if err != nil {
-		return nil, err // 其他Redis错误
+		return nil, err
+	}
+	if result == "" {
+		return nil, nil // 缓存未命中，是正常情况，不返回错误
 	}
 
-	var report SpellUserReport
-	if err := json.Unmarshal([]byte(result), &report); err != nil {
+	var cached cachedReport
+	if err := json.Unmarshal([]byte(result), &cached); err != nil {
 		return nil, err
 	}
-	return &report, nil
+	if cached.Report == nil {
+		return nil, nil // 旧格式的缓存
+	}
+
+	// 获取用户当前最后一次被处理的投票ID，用户不存在时为0
+	var currentLastVoteID uint
+	if statsJSON != "" {
+		var stats user.UserStats
+		if err := json.Unmarshal([]byte(statsJSON), &stats); err != nil {
+			return nil, err
+		}
+		currentLastVoteID = stats.LastVoteID
+	}
+
+	if cached.UserLastVoteID != currentLastVoteID {
+		return nil, nil // 用户有了新的投票，缓存已过时
+	}
+	return cached.Report, nil
 }
 
-// SetReportCache 将用户报告存入Redis缓存。
-func SetReportCache(report *SpellUserReport, expire time.Duration) error {
-	data, err := json.Marshal(report)
+// SetReportCache 将用户报告连同生成时用户最后一次被处理的投票ID存入Redis缓存。
+func SetReportCache(report *SpellUserReport, userLastVoteID uint, expire time.Duration) error {
+	data, err := json.Marshal(cachedReport{UserLastVoteID: userLastVoteID, Report: report})
 	if err != nil {
 		return err
 	}
+	return activeStore.setCachedReport(report.UserID, data, expire)
+}
 
-	// 使用Pipeline来原子地设置值和过期时间
-	pipe := database.RDB.Pipeline()
-	pipe.HSet(database.Ctx, CacheKey, report.UserID, data)
-	pipe.HExpire(database.Ctx, CacheKey, expire, report.UserID)
-	_, err = pipe.Exec(database.Ctx)
-	return err
+// InvalidateReportCache 删除指定用户的报告缓存。
+func InvalidateReportCache(userIDs ...string) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+	return activeStore.deleteCachedReports(userIDs...)
 }
 
 // --- 内存仓库 (用于Redis降级) ---
@@ -1,24 +1,45 @@
 package report
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"time"
 
+	"github.com/SlpAus/noita-spells-tier-backend/internal/achievement"
 	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
-	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/metadata"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/logging"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/metrics"
 	"github.com/SlpAus/noita-spells-tier-backend/internal/spell"
 	"github.com/SlpAus/noita-spells-tier-backend/internal/user"
 	"github.com/SlpAus/noita-spells-tier-backend/internal/vote"
-	"github.com/redis/go-redis/v9"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 const (
-	CacheTTL = 1 * time.Minute
+	// CacheTTL 是报告缓存的最长保留时间。
+	// 用户的新投票被处理后缓存会立即失效，这里只限制社区数据（如排名）变旧的程度。
+	CacheTTL = 1 * time.Hour
 )
 
+// cacheLookups 按命中与否统计报告缓存的查询次数，用于计算命中率
+var cacheLookups = metrics.Factory.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Subsystem: "report",
+	Name:      "cache_lookups_total",
+	Help:      "个人报告缓存的查询次数",
+}, []string{"result"})
+
+func init() {
+	cacheLookups.WithLabelValues("hit")
+	cacheLookups.WithLabelValues("miss")
+}
+
 // userVoteRecord 是一个内部结构体，用于从vote表中仅查询生成报告所需的最小字段。
 type userVoteRecord struct {
+	ID        uint
 	SpellA_ID string
 	SpellB_ID string
 	Result    vote.VoteResult
@@ -27,7 +48,8 @@ type userVoteRecord struct {
 
 // GenerateSpellUserReport 是生成用户报告的统一入口。
 // 它会检查Redis的健康状况，并相应地选择从Redis实时数据或从内存快照生成报告。
-func GenerateUserReport(userID string) (*SpellUserReport, error) {
+// ctx 只用于日志关联。
+func GenerateUserReport(ctx context.Context, userID string) (*SpellUserReport, error) {
 	// 用户无效，快速返回
 	if userID == "" {
 		return &SpellUserReport{
@@ -36,10 +58,31 @@ func GenerateUserReport(userID string) (*SpellUserReport, error) {
 		}, nil
 	}
 
+	var report *SpellUserReport
+	var err error
 	if database.IsRedisHealthy() {
-		return generateReportFromRedis(userID)
+		report, err = generateReportFromRedis(ctx, userID)
+	} else {
+		report, err = generateReportFromMirrorRepo(userID)
 	}
-	return generateReportFromMirrorRepo(userID)
+	if err != nil {
+		return nil, err
+	}
+	return withAchievements(ctx, report, userID), nil
+}
+
+// withAchievements 返回附带了用户已获得成就的报告副本。
+// 成就由后台任务异步授予，因此不随报告缓存，而是每次从SQLite读取；
+// 使用副本是因为原报告可能正在被缓存的goroutine序列化。
+func withAchievements(ctx context.Context, report *SpellUserReport, userID string) *SpellUserReport {
+	earned, err := achievement.GetEarned(userID)
+	if err != nil {
+		slog.WarnContext(ctx, "生成报告时获取成就失败", logging.Err(err))
+		return report
+	}
+	result := *report
+	result.Achievements = earned
+	return &result
 }
 
 // 填充 report 中 Name 字段用的辅助方法。
@@ -52,13 +95,43 @@ func getSpellNameByID(id string) (string, error) {
 	return info.Name, nil
 }
 
+// loadVoteRecords 一次性查询报告中引用到的投票。
+func loadVoteRecords(ids []uint) (map[uint]userVoteRecord, error) {
+	votes := make(map[uint]userVoteRecord, len(ids))
+	if len(ids) == 0 {
+		return votes, nil
+	}
+
+	// 里程碑等引用的投票可能已被归档
+	records, err := vote.FindVotes(ids)
+	if err != nil {
+		return nil, fmt.Errorf("查询报告引用的投票时出错: %w", err)
+	}
+	for id, record := range records {
+		votes[id] = userVoteRecord{
+			ID:        record.ID,
+			SpellA_ID: record.SpellA_ID,
+			SpellB_ID: record.SpellB_ID,
+			Result:    record.Result,
+			VoteTime:  record.VoteTime,
+		}
+	}
+	return votes, nil
+}
+
 // generateReportFromRedis 包含从Redis生成报告的完整逻辑，包括缓存。
-func generateReportFromRedis(userID string) (report *SpellUserReport, err error) {
-	// 1. 尝试从缓存获取
+func generateReportFromRedis(ctx context.Context, userID string) (report *SpellUserReport, err error) {
+	// 1. 尝试从缓存获取，缓存只在用户没有新的已处理投票时有效
 	cachedReport, err := GetReportCache(userID)
 	if err == nil && cachedReport != nil {
+		cacheLookups.WithLabelValues("hit").Inc()
+		slog.DebugContext(ctx, "报告缓存命中")
 		return cachedReport, nil
 	}
+	cacheLookups.WithLabelValues("miss").Inc()
+
+	// userLastVoteID 是生成报告时用户最后一次被处理的投票ID，作为缓存的版本
+	var userLastVoteID uint
 
 	// 2. 缓存未命中，生成新报告
 	report = &SpellUserReport{
@@ -74,79 +147,54 @@ func generateReportFromRedis(userID string) (report *SpellUserReport, err error)
 		go func() {
 			defer func() {
 				if r := recover(); r != nil {
-					fmt.Printf("严重错误: 缓存报告的goroutine发生panic: %v\n", r)
+					slog.ErrorContext(ctx, "严重错误: 缓存报告的goroutine发生panic", slog.Any("panic", r))
 				}
 			}()
-			_ = SetReportCache(report, CacheTTL)
+			if err := SetReportCache(report, userLastVoteID, CacheTTL); err != nil {
+				slog.WarnContext(ctx, "缓存报告失败", logging.Err(err))
+			}
 		}()
 	}()
 
 	// a. 从Redis获取数据
-	pipe := database.RDB.TxPipeline()
-	lastVoteIDCmd := pipe.Get(database.Ctx, metadata.RedisLastProcessedVoteIDKey)
-	userStatsCmd := pipe.HGet(database.Ctx, user.StatsKey, userID)
-	userRankCmd := pipe.ZRevRank(database.Ctx, user.RankingKey, userID)
-	totalStatsCmd := pipe.HGet(database.Ctx, user.StatsKey, user.TotalStatsKey)
-	totalVotersCmd := pipe.ZCard(database.Ctx, user.RankingKey)
-	spellRankingCmd := pipe.ZRevRangeWithScores(database.Ctx, spell.RankingKey, 0, -1)
-	_, err = pipe.Exec(database.Ctx)
-	// 这里可能返回 redis.Nil
-	if err != nil && err != redis.Nil {
+	data, err := activeStore.liveData(userID)
+	if err != nil {
 		return nil, fmt.Errorf("从Redis获取用户统计数据时出错: %w", err)
 	}
 
 	// b. 解析通用字段
-	lastVoteID, err := lastVoteIDCmd.Uint64()
-	if err != nil {
-		return nil, fmt.Errorf("获取 lastVoteID 的结果时出错: %w", err)
-	}
-
-	userStatsJSON, err := userStatsCmd.Result()
-	if err != nil {
-		if err == redis.Nil {
-			// 如果快照中没有该用户，则返回一个空的报告
-			report.VoteRankPercent = 1.0
-			return report, nil
-		}
-		return nil, fmt.Errorf("获取 userStatsJSON 时出错: %w", err)
+	if data.userStats == "" {
+		// 如果快照中没有该用户，则返回一个空的报告
+		report.VoteRankPercent = 1.0
+		return report, nil
 	}
 	var userStats user.UserStats
-	if err := json.Unmarshal([]byte(userStatsJSON), &userStats); err != nil {
+	if err := json.Unmarshal([]byte(data.userStats), &userStats); err != nil {
 		return nil, fmt.Errorf("解析 userStatsJSON 时出错: %w", err)
 	}
+	userLastVoteID = userStats.LastVoteID
 
-	userRank, err := userRankCmd.Result()
-	if err != nil {
-		return nil, fmt.Errorf("获取 userRank 的结果时出错: %w", err)
-	}
+	userRank := data.userRank
+	totalVoters := data.totalVoters
 
-	totalVoters, err := totalVotersCmd.Result()
-	if err != nil {
-		return nil, fmt.Errorf("获取 totalVoters 的结果时出错: %w", err)
+	// 获取法术排名和分数
+	spellRank := make(map[string]int, len(data.spellRanking))
+	spellRankScore := make(map[string]float64, len(data.spellRanking))
+	rankToSpell := make([]string, len(data.spellRanking))
+	for i, s := range data.spellRanking {
+		spellRank[s.id] = i + 1 // 转换为1-based
+		spellRankScore[s.id] = s.rankScore
+		rankToSpell[i] = s.id
 	}
 
-	// 获取法术排名和分数
-	spellRanking, err := spellRankingCmd.Result()
+	// c. 获取用户聚合数据，以及其中引用到的投票
+	userAgg, err := user.ParseUserAggregates(data.userAggregates)
 	if err != nil {
-		return nil, fmt.Errorf("获取 spellRanking 的结果时出错: %w", err)
-	}
-	spellRank := make(map[string]int, len(spellRanking))
-	spellRankScore := make(map[string]float64, len(spellRanking))
-	rankToSpell := make([]string, len(spellRanking))
-	for i, z := range spellRanking {
-		spellID := z.Member.(string)
-		spellRank[spellID] = i + 1 // 转换为1-based
-		spellRankScore[spellID] = z.Score
-		rankToSpell[i] = spellID
+		return nil, fmt.Errorf("解析 userAggJSON 时出错: %w", err)
 	}
-
-	// c. 获取用户投票历史
-	var userVotes []userVoteRecord
-	if err := database.DB.Model(&vote.Vote{}).
-		Where("user_identifier = ? AND id <= ?", userID, lastVoteID).
-		Order("id asc").
-		Find(&userVotes).Error; err != nil {
-		return nil, fmt.Errorf("查询用户投票历史时出错: %w", err)
+	referencedVotes, err := loadVoteRecords(referencedVoteIDs(&userAgg, rankToSpell))
+	if err != nil {
+		return nil, err
 	}
 
 	// d. 填充必选字段
@@ -157,11 +205,10 @@ func generateReportFromRedis(userID string) (report *SpellUserReport, err error)
 		Skip: userStats.Skip,
 	}
 
+	slog.DebugContext(ctx, "用户投票量排名", slog.Int64("rank", userRank), slog.Int64("total_voters", totalVoters))
 	if totalVoters > 0 {
-		fmt.Printf("%v %v %v", userID, userRank, totalVoters)
 		report.VoteRankPercent = float64(userRank) / float64(totalVoters)
 	} else {
-		fmt.Printf("%v %v %v", userID, userRank, totalVoters)
 		report.VoteRankPercent = 1.0
 	}
 
@@ -169,14 +216,13 @@ func generateReportFromRedis(userID string) (report *SpellUserReport, err error)
 
 	// 决断率
 	if report.TotalVotes >= MinVotesForDecisionRate {
-		totalStatsJSON, err := totalStatsCmd.Result()
 		// user.TotalStatsKey 对应的值默认存在
-		if err != nil {
-			return nil, fmt.Errorf("获取 totalStatsJSON 的值时出错: %w", err)
+		if data.totalStats == "" {
+			return nil, errors.New("获取 totalStatsJSON 的值时出错: 社区总统计数据不存在")
 		}
 
 		var totalStats user.UserStats
-		err = json.Unmarshal([]byte(totalStatsJSON), &totalStats)
+		err = json.Unmarshal([]byte(data.totalStats), &totalStats)
 		if err != nil {
 			return nil, fmt.Errorf("解析 totalStatsJSON 时出错: %w", err)
 		}
@@ -190,13 +236,10 @@ func generateReportFromRedis(userID string) (report *SpellUserReport, err error)
 
 	// 投票倾向
 	if userStats.Wins >= MinWinsForTendency {
-		consistencyIndex, err := calculateCommunityConsistencyIndex(userVotes, spellRank)
-		if err != nil {
-			return nil, err
-		}
+		consistencyIndex := calculateCommunityConsistencyIndex(userStats, &userAgg)
 		report.CommunityConsistencyIndex = &consistencyIndex
 
-		upsetTendency, err := calculateUpsetTendency(userVotes, spellRankScore)
+		upsetTendency, err := calculateUpsetTendency(userStats, &userAgg, spellRankScore)
 		if err != nil {
 			return nil, err
 		}
@@ -204,15 +247,15 @@ func generateReportFromRedis(userID string) (report *SpellUserReport, err error)
 	}
 
 	// 趣味高光时刻
-	mostChosen, err := calculateMostChosenSpell(userVotes)
+	mostChosen, err := calculateMostChosenSpell(&userAgg)
 	if err != nil {
 		return nil, err
 	}
 	report.MostChosen = mostChosen
 
-	spellWinRates := calcualteWinRateForSpells(userVotes)
+	spellWinRates := calcualteWinRateForSpells(&userAgg)
 
-	if len(userVotes) >= int(float64(spell.GetSpellCount())*TotalVotesToSpellsRatioForWinRate) {
+	if report.TotalVotes >= int(float64(spell.GetSpellCount())*TotalVotesToSpellsRatioForWinRate) {
 		highestWinRate, err := calculateHighestWinRateSpell(spellWinRates)
 		if err != nil {
 			return nil, err
@@ -232,34 +275,34 @@ func generateReportFromRedis(userID string) (report *SpellUserReport, err error)
 	}
 	report.Nemesis = nemesis
 
-	mostSubversive, err := calculateMostSubversiveVote(userVotes, spellRank)
+	mostSubversive, err := calculateMostSubversiveVote(&userAgg, referencedVotes, spellRank)
 	if err != nil {
 		return nil, err
 	}
 	report.MostSubversive = mostSubversive
 
 	// 里程碑与记录
-	firstVote, err := calculateFirstVote(userVotes)
+	firstVote, err := calculateFirstVote(&userAgg, referencedVotes)
 	if err != nil {
 		return nil, err
 	}
 	report.FirstVote = firstVote
 
-	milestones, err := calculateMilestones(userVotes)
+	milestones, err := calculateMilestones(&userAgg, referencedVotes)
 	if err != nil {
 		return nil, err
 	}
 	report.Milestones = milestones
 
-	report.BusiestDay = calculateBusiestDay(userVotes)
+	report.BusiestDay = calculateBusiestDay(&userAgg)
 
-	firstEncounterTop, err := calculateFirstEncounterTop(userVotes, spellRank, rankToSpell)
+	firstEncounterTop, err := calculateFirstEncounterTop(&userAgg, referencedVotes, spellRank, rankToSpell)
 	if err != nil {
 		return nil, err
 	}
 	report.FirstEncounterTop = firstEncounterTop
 
-	firstEncounterBottom, err := calculateFirstEncounterBottom(userVotes, spellRank, rankToSpell)
+	firstEncounterBottom, err := calculateFirstEncounterBottom(&userAgg, referencedVotes, spellRank, rankToSpell)
 	if err != nil {
 		return nil, err
 	}
@@ -289,13 +332,22 @@ func generateReportFromMirrorRepo(userID string) (*SpellUserReport, error) {
 		return report, nil
 	}
 
-	// b. 获取用户投票历史
-	var userVotes []userVoteRecord
-	if err := database.DB.Model(&vote.Vote{}).
-		Where("user_identifier = ? AND id <= ?", userID, mirrorRepo.snapshotVoteID).
-		Order("id asc").
-		Find(&userVotes).Error; err != nil {
-		return nil, fmt.Errorf("查询用户投票历史时出错: %w", err)
+	// b. 获取快照中的用户聚合数据，以及其中引用到的投票
+	var userAggJSONs []string
+	if err := database.DB.Model(&user.User{}).Where("uuid = ?", userID).Pluck("COALESCE(aggregates, '')", &userAggJSONs).Error; err != nil {
+		return nil, fmt.Errorf("查询用户聚合数据时出错: %w", err)
+	}
+	var userAggJSON string
+	if len(userAggJSONs) > 0 {
+		userAggJSON = userAggJSONs[0]
+	}
+	userAgg, err := user.ParseUserAggregates(userAggJSON)
+	if err != nil {
+		return nil, fmt.Errorf("解析用户聚合数据时出错: %w", err)
+	}
+	referencedVotes, err := loadVoteRecords(referencedVoteIDs(&userAgg, mirrorRepo.rankToSpell))
+	if err != nil {
+		return nil, err
 	}
 
 	// c. 填充必选字段
@@ -327,13 +379,10 @@ func generateReportFromMirrorRepo(userID string) (*SpellUserReport, error) {
 
 	// 投票倾向
 	if userStats.Wins >= MinWinsForTendency {
-		consistencyIndex, err := calculateCommunityConsistencyIndex(userVotes, mirrorRepo.spellRank)
-		if err != nil {
-			return nil, err
-		}
+		consistencyIndex := calculateCommunityConsistencyIndex(userStats, &userAgg)
 		report.CommunityConsistencyIndex = &consistencyIndex
 
-		upsetTendency, err := calculateUpsetTendency(userVotes, mirrorRepo.spellRankScore)
+		upsetTendency, err := calculateUpsetTendency(userStats, &userAgg, mirrorRepo.spellRankScore)
 		if err != nil {
 			return nil, err
 		}
@@ -341,15 +390,15 @@ func generateReportFromMirrorRepo(userID string) (*SpellUserReport, error) {
 	}
 
 	// 趣味高光时刻
-	mostChosen, err := calculateMostChosenSpell(userVotes)
+	mostChosen, err := calculateMostChosenSpell(&userAgg)
 	if err != nil {
 		return nil, err
 	}
 	report.MostChosen = mostChosen
 
-	spellWinRates := calcualteWinRateForSpells(userVotes)
+	spellWinRates := calcualteWinRateForSpells(&userAgg)
 
-	if len(userVotes) >= int(float64(spell.GetSpellCount())*TotalVotesToSpellsRatioForWinRate) {
+	if report.TotalVotes >= int(float64(spell.GetSpellCount())*TotalVotesToSpellsRatioForWinRate) {
 		highestWinRate, err := calculateHighestWinRateSpell(spellWinRates)
 		if err != nil {
 			return nil, err
@@ -369,34 +418,34 @@ func generateReportFromMirrorRepo(userID string) (*SpellUserReport, error) {
 	}
 	report.Nemesis = nemesis
 
-	mostSubversive, err := calculateMostSubversiveVote(userVotes, mirrorRepo.spellRank)
+	mostSubversive, err := calculateMostSubversiveVote(&userAgg, referencedVotes, mirrorRepo.spellRank)
 	if err != nil {
 		return nil, err
 	}
 	report.MostSubversive = mostSubversive
 
 	// 里程碑与记录
-	firstVote, err := calculateFirstVote(userVotes)
+	firstVote, err := calculateFirstVote(&userAgg, referencedVotes)
 	if err != nil {
 		return nil, err
 	}
 	report.FirstVote = firstVote
 
-	milestones, err := calculateMilestones(userVotes)
+	milestones, err := calculateMilestones(&userAgg, referencedVotes)
 	if err != nil {
 		return nil, err
 	}
 	report.Milestones = milestones
 
-	report.BusiestDay = calculateBusiestDay(userVotes)
+	report.BusiestDay = calculateBusiestDay(&userAgg)
 
-	firstEncounterTop, err := calculateFirstEncounterTop(userVotes, mirrorRepo.spellRank, mirrorRepo.rankToSpell)
+	firstEncounterTop, err := calculateFirstEncounterTop(&userAgg, referencedVotes, mirrorRepo.spellRank, mirrorRepo.rankToSpell)
 	if err != nil {
 		return nil, err
 	}
 	report.FirstEncounterTop = firstEncounterTop
 
-	firstEncounterBottom, err := calculateFirstEncounterBottom(userVotes, mirrorRepo.spellRank, mirrorRepo.rankToSpell)
+	firstEncounterBottom, err := calculateFirstEncounterBottom(&userAgg, referencedVotes, mirrorRepo.spellRank, mirrorRepo.rankToSpell)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,110 @@
+package report
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/achievement"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/config"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/testutil"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/vote"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+func TestMain(m *testing.M) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+// setupReportEnv 准备一个独立的测试环境（见 testutil.Setup），写入给定的投票，
+// 并通过与启动时相同的增量重建流程把它们应用到缓存中。
+func setupReportEnv(t *testing.T, votes []vote.Vote) {
+	t.Helper()
+
+	testutil.Setup(t, testutil.Options{
+		Configure: func() {
+			vote.UseRedisStore()
+			UseRedisStore()
+			vote.ConfigureModule(config.AppModeSpell, config.VoteConfig{TokenTTL: time.Hour, UndoWindow: time.Minute, BatchSize: 16, ReplayBackend: vote.ReplayBackendBucket})
+			ConfigureModule(config.AppModeSpell)
+		},
+		Seed: func(db *gorm.DB) error {
+			if err := db.AutoMigrate(&vote.Vote{}, &achievement.Award{}); err != nil {
+				return err
+			}
+			if len(votes) == 0 {
+				return nil
+			}
+			return db.Create(&votes).Error
+		},
+		Prime: vote.PrimeModule,
+	})
+}
+
+func TestGenerateUserReport(t *testing.T) {
+	userID := uuid.Must(uuid.NewV7()).String()
+	now := time.Now()
+	newVote := func(a, b string, result vote.VoteResult, offset time.Duration) vote.Vote {
+		return vote.Vote{SpellA_ID: a, SpellB_ID: b, Result: result, UserIdentifier: userID, Multiplier: 1, VoteTime: now.Add(offset)}
+	}
+	setupReportEnv(t, []vote.Vote{
+		newVote("BOMB", "LIGHT_BULLET", vote.ResultAWins, -5*time.Minute),
+		newVote("BOMB", "BLACK_HOLE", vote.ResultAWins, -4*time.Minute),
+		newVote("CHAINSAW", "BOMB", vote.ResultBWins, -3*time.Minute),
+		newVote("LIGHT_BULLET", "CHAINSAW", vote.ResultDraw, -2*time.Minute),
+		newVote("BLACK_HOLE", "CHAINSAW", vote.ResultSkip, -time.Minute),
+	})
+
+	report, err := GenerateUserReport(context.Background(), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.UserID != userID || report.TotalVotes != 5 {
+		t.Fatalf("报告: userID=%s totalVotes=%d, 期望 %s 和 5", report.UserID, report.TotalVotes, userID)
+	}
+	if report.Choices != (ChoiceCounts{Wins: 3, Draw: 1, Skip: 1}) {
+		t.Errorf("选择计数: %+v", report.Choices)
+	}
+	if report.MostChosen == nil || report.MostChosen.ID != "BOMB" {
+		t.Errorf("最多选择: %+v, 期望 BOMB", report.MostChosen)
+	}
+	if report.FirstVote == nil {
+		t.Error("报告缺少第一次投票")
+	}
+
+	// 报告在后台被写入缓存
+	deadline := time.Now().Add(time.Second)
+	for {
+		cached, err := GetReportCache(userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cached != nil {
+			if cached.TotalVotes != report.TotalVotes {
+				t.Errorf("缓存的报告: totalVotes=%d, 期望 %d", cached.TotalVotes, report.TotalVotes)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("报告没有被写入缓存")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestGenerateUserReportWithoutVotes(t *testing.T) {
+	setupReportEnv(t, nil)
+
+	report, err := GenerateUserReport(context.Background(), uuid.Must(uuid.NewV7()).String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.TotalVotes != 0 || report.VoteRankPercent != 1.0 {
+		t.Errorf("没有投票的用户的报告: %+v", report)
+	}
+}
@@ -0,0 +1,111 @@
+package report
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/spell"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/user"
+	"github.com/redis/go-redis/v9"
+)
+
+// store 抽象了report模块的缓存层，包括报告缓存和生成报告所需的实时数据。
+// 默认使用Redis，测试可以通过 UseMemoryStore 换成进程内的实现。
+type store interface {
+	// cachedReport 读取用户的报告缓存和用户当前的统计数据（JSON），不存在时分别为空字符串
+	cachedReport(userID string) (cached, userStats string, err error)
+	// setCachedReport 写入用户的报告缓存，并设置其过期时间
+	setCachedReport(userID string, data []byte, expire time.Duration) error
+	// deleteCachedReports 删除若干用户的报告缓存
+	deleteCachedReports(userIDs ...string) error
+	// liveData 原子地读取生成报告所需的实时数据
+	liveData(userID string) (liveReportData, error)
+}
+
+// activeStore 是当前使用的缓存层
+var activeStore store = redisStore{}
+
+// UseRedisStore 让report模块改回使用Redis缓存层，供测试在进程内的Redis上运行
+func UseRedisStore() {
+	activeStore = redisStore{}
+}
+
+// liveReportData 是生成报告时从缓存层一次性读取的实时数据
+type liveReportData struct {
+	// userStats 是用户统计数据的JSON，为空表示用户不存在，此时其余的用户字段没有意义
+	userStats string
+	// userAggregates 是用户聚合数据的JSON，为空表示没有任何投票
+	userAggregates string
+	// userRank 是用户在投票数排名中的位置（0-based）
+	userRank    int64
+	totalStats  string
+	totalVoters int64
+	// spellRanking 是按RankScore从高到低排列的全部法术
+	spellRanking []rankedSpell
+}
+
+// rankedSpell 是法术排名中的一项
+type rankedSpell struct {
+	id        string
+	rankScore float64
+}
+
+// redisStore 是基于Redis的缓存层
+type redisStore struct{}
+
+func (redisStore) cachedReport(userID string) (string, string, error) {
+	pipe := database.RDB.Pipeline()
+	cacheCmd := pipe.HGet(database.Ctx, CacheKey, userID)
+	statsCmd := pipe.HGet(database.Ctx, user.StatsKey, userID)
+	if _, err := pipe.Exec(database.Ctx); err != nil && err != redis.Nil {
+		return "", "", err
+	}
+	return cacheCmd.Val(), statsCmd.Val(), nil
+}
+
+func (redisStore) setCachedReport(userID string, data []byte, expire time.Duration) error {
+	// 使用Pipeline来原子地设置值和过期时间
+	pipe := database.RDB.Pipeline()
+	pipe.HSet(database.Ctx, CacheKey, userID, data)
+	pipe.HExpire(database.Ctx, CacheKey, expire, userID)
+	_, err := pipe.Exec(database.Ctx)
+	return err
+}
+
+func (redisStore) deleteCachedReports(userIDs ...string) error {
+	return database.RDB.HDel(database.Ctx, CacheKey, userIDs...).Err()
+}
+
+func (redisStore) liveData(userID string) (liveReportData, error) {
+	var data liveReportData
+
+	pipe := database.RDB.TxPipeline()
+	userStatsCmd := pipe.HGet(database.Ctx, user.StatsKey, userID)
+	userAggCmd := pipe.HGet(database.Ctx, user.AggregatesKey, userID)
+	userRankCmd := pipe.ZRevRank(database.Ctx, user.RankingKey, userID)
+	totalStatsCmd := pipe.HGet(database.Ctx, user.StatsKey, user.TotalStatsKey)
+	totalVotersCmd := pipe.ZCard(database.Ctx, user.RankingKey)
+	spellRankingCmd := pipe.ZRevRangeWithScores(database.Ctx, spell.RankingKey, 0, -1)
+	// 这里可能返回 redis.Nil
+	if _, err := pipe.Exec(database.Ctx); err != nil && err != redis.Nil {
+		return data, err
+	}
+
+	data.userStats = userStatsCmd.Val()
+	if data.userStats == "" {
+		return data, nil
+	}
+	data.userAggregates = userAggCmd.Val()
+	userRank, err := userRankCmd.Result()
+	if err != nil {
+		return data, fmt.Errorf("获取 userRank 的结果时出错: %w", err)
+	}
+	data.userRank = userRank
+	data.totalStats = totalStatsCmd.Val()
+	data.totalVoters = totalVotersCmd.Val()
+	for _, z := range spellRankingCmd.Val() {
+		data.spellRanking = append(data.spellRanking, rankedSpell{id: z.Member.(string), rankScore: z.Score})
+	}
+	return data, nil
+}
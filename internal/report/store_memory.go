@@ -0,0 +1,66 @@
+package report
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/memstore"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/spell"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/user"
+)
+
+// memoryStore 是基于进程内存储的缓存层，键名和编码与 redisStore 相同
+type memoryStore struct {
+	db *memstore.Store
+}
+
+// UseMemoryStore 让report模块改用进程内存储，供测试在没有Redis的环境下使用
+func UseMemoryStore(db *memstore.Store) {
+	activeStore = memoryStore{db: db}
+}
+
+func (s memoryStore) cachedReport(userID string) (cached, userStats string, err error) {
+	err = s.db.Do(func(tx *memstore.Tx) error {
+		cached, _ = tx.HGet(CacheKey, userID)
+		userStats, _ = tx.HGet(user.StatsKey, userID)
+		return nil
+	})
+	return cached, userStats, err
+}
+
+func (s memoryStore) setCachedReport(userID string, data []byte, expire time.Duration) error {
+	return s.db.Do(func(tx *memstore.Tx) error {
+		tx.HSet(CacheKey, userID, string(data))
+		tx.HExpireAt(CacheKey, userID, time.Now().Add(expire))
+		return nil
+	})
+}
+
+func (s memoryStore) deleteCachedReports(userIDs ...string) error {
+	return s.db.Do(func(tx *memstore.Tx) error {
+		tx.HDel(CacheKey, userIDs...)
+		return nil
+	})
+}
+
+func (s memoryStore) liveData(userID string) (data liveReportData, err error) {
+	err = s.db.Do(func(tx *memstore.Tx) error {
+		var ok bool
+		data.userStats, ok = tx.HGet(user.StatsKey, userID)
+		if !ok {
+			return nil
+		}
+		data.userAggregates, _ = tx.HGet(user.AggregatesKey, userID)
+		data.userRank, ok = tx.ZRevRank(user.RankingKey, userID)
+		if !ok {
+			return fmt.Errorf("获取 userRank 的结果时出错: 用户 %s 不在排名中", userID)
+		}
+		data.totalStats, _ = tx.HGet(user.StatsKey, user.TotalStatsKey)
+		data.totalVoters = tx.ZCard(user.RankingKey)
+		for _, z := range tx.ZRevRange(spell.RankingKey) {
+			data.spellRanking = append(data.spellRanking, rankedSpell{id: z.Member, rankScore: z.Score})
+		}
+		return nil
+	})
+	return data, err
+}
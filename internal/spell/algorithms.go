@@ -1,7 +1,7 @@
 package spell
 
 import (
-	"fmt"
+	"log/slog"
 	"math"
 	"sort"
 
@@ -93,7 +93,7 @@ func InitializeGaussianMatcher(n int) {
 		prefixSum:  prefixSum,
 		spellCount: n,
 	}
-	fmt.Printf("高斯匹配器初始化成功，法术数量: %d\n", n)
+	slog.Info("高斯匹配器初始化成功。", slog.Int("spells", n))
 }
 
 // GetMixtureFactor 根据系统总投票数(M)计算高斯权重和均匀权重的混合比例 f(M)。
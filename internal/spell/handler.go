@@ -6,9 +6,13 @@ import (
 
 	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/config"
 	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/user"
 	"github.com/gin-gonic/gin"
 )
 
+// ChallengeDifficultyKey 是Gin上下文中工作量证明难度的键，由投票模块的中间件设置
+const ChallengeDifficultyKey = "challengeDifficulty"
+
 // --- 模式 ---
 var appMode config.AppMode
 var imageBaseUrl string
@@ -43,7 +47,10 @@ type GetSpellPairAPIResponse struct {
 	SpellA    SpellPairResponse `json:"spellA"`
 	SpellB    SpellPairResponse `json:"spellB"`
 	PairID    string            `json:"pairId"`
+	IssuedAt  int64             `json:"issuedAt"`
 	Signature string            `json:"signature"`
+	// Difficulty 不为0时，投票需附带nonce，使 SHA-256(pairId + ":" + nonce) 至少有Difficulty个前导零比特
+	Difficulty int `json:"difficulty,omitempty"`
 }
 type SpellPairResponse struct {
 	ID          string `json:"id"`
@@ -74,7 +81,10 @@ type GetPerkPairAPIResponse struct {
 	SpellA    PerkPairResponse `json:"perkA"` // 改名
 	SpellB    PerkPairResponse `json:"perkB"` // 改名
 	PairID    string           `json:"pairId"`
+	IssuedAt  int64            `json:"issuedAt"`
 	Signature string           `json:"signature"`
+	// Difficulty 不为0时，投票需附带nonce，使 SHA-256(pairId + ":" + nonce) 至少有Difficulty个前导零比特
+	Difficulty int `json:"difficulty,omitempty"`
 }
 type PerkPairResponse struct {
 	ID          string `json:"id"`
@@ -182,8 +192,10 @@ func GetSpellPair(c *gin.Context) {
 		return
 	}
 
-	// 3. 调用服务层获取法术对和签名
-	responseDTO, err := GetNewSpellPair(excludeA, excludeB)
+	// 3. 调用服务层获取法术对和签名，凭证将绑定到当前用户和所需的工作量证明难度
+	userID := c.GetString(user.UserIDKey)
+	difficulty := c.GetInt(ChallengeDifficultyKey)
+	responseDTO, err := GetNewSpellPair(excludeA, excludeB, userID, difficulty)
 	if err != nil {
 		if err.Error() == "服务暂时不可用，请稍后重试" {
 			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
@@ -199,10 +211,12 @@ func GetSpellPair(c *gin.Context) {
 	switch appMode {
 	case config.AppModeSpell:
 		apiResponse := GetSpellPairAPIResponse{
-			SpellA:    formatForPair(responseDTO.SpellA, c),
-			SpellB:    formatForPair(responseDTO.SpellB, c),
-			PairID:    responseDTO.Payload.PairID,
-			Signature: responseDTO.Signature,
+			SpellA:     formatForPair(responseDTO.SpellA, c),
+			SpellB:     formatForPair(responseDTO.SpellB, c),
+			PairID:     responseDTO.Payload.PairID,
+			IssuedAt:   responseDTO.Payload.IssuedAt,
+			Signature:  responseDTO.Signature,
+			Difficulty: responseDTO.Payload.Difficulty,
 		}
 		apiResponse.SpellA.ID = responseDTO.Payload.SpellAID
 		apiResponse.SpellB.ID = responseDTO.Payload.SpellBID
@@ -210,10 +224,12 @@ func GetSpellPair(c *gin.Context) {
 		c.JSON(http.StatusOK, apiResponse)
 	case config.AppModePerk:
 		apiResponse := GetPerkPairAPIResponse{
-			SpellA:    PerkPairResponse(formatForPair(responseDTO.SpellA, c)),
-			SpellB:    PerkPairResponse(formatForPair(responseDTO.SpellB, c)),
-			PairID:    responseDTO.Payload.PairID,
-			Signature: responseDTO.Signature,
+			SpellA:     PerkPairResponse(formatForPair(responseDTO.SpellA, c)),
+			SpellB:     PerkPairResponse(formatForPair(responseDTO.SpellB, c)),
+			PairID:     responseDTO.Payload.PairID,
+			IssuedAt:   responseDTO.Payload.IssuedAt,
+			Signature:  responseDTO.Signature,
+			Difficulty: responseDTO.Payload.Difficulty,
 		}
 		apiResponse.SpellA.ID = responseDTO.Payload.SpellAID
 		apiResponse.SpellB.ID = responseDTO.Payload.SpellBID
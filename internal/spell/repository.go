@@ -2,6 +2,7 @@ package spell
 
 import (
 	"fmt"
+	"log/slog"
 	"sync"
 
 	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
@@ -13,9 +14,9 @@ import (
 
 const (
 	// StatsKey 是一个Redis Hash，存储所有法术的动态统计数据
-	StatsKey = "spell:stats"
+	StatsKey = database.KeyPrefix + "spell:stats"
 	// RankingKey 是一个Redis Sorted Set，用于按分数实时排序法术
-	RankingKey = "spell:ranking"
+	RankingKey = database.KeyPrefix + "spell:ranking"
 )
 
 // SpellStats 定义了在Redis spell:stats Hash中存储的法术动态数据
@@ -90,7 +91,7 @@ func InitializeRepository() error {
 
 	InitializeGaussianMatcher(size)
 
-	fmt.Printf("法术仓库 (Repository) 初始化成功，加载了 %d 个法术。\n", size)
+	slog.Info("法术仓库 (Repository) 初始化成功。", slog.Int("spells", size))
 	return nil
 }
 
@@ -1,17 +1,15 @@
 package spell
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"math/rand/v2"
 	"sort"
+	"time"
 
 	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
-	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/metadata"
 	"github.com/SlpAus/noita-spells-tier-backend/pkg/token"
 	"github.com/google/uuid"
-	"github.com/redis/go-redis/v9"
 )
 
 // --- Service-Level Data Transfer Objects (DTOs) ---
@@ -71,32 +69,17 @@ func GetRankedSpells() ([]RankedSpellDTO, error) {
 		return getRankedSpellsFromDB()
 	}
 
-	// 1. 使用 TxPipeline 原子地从Redis获取所需的排行信息和动态数据
-	pipe := database.RDB.TxPipeline()
-	spellIDsCmd := pipe.ZRevRange(database.Ctx, RankingKey, 0, -1)
-	spellStatsCmd := pipe.HGetAll(database.Ctx, StatsKey)
-	_, err := pipe.Exec(database.Ctx)
-
+	// 1. 原子地从Redis获取所需的排行信息和动态数据
+	spellIDs, spellStats, err := activeStore.rankedStats()
 	if err != nil {
 		return nil, fmt.Errorf("无法从Redis获取排行信息: %w", err)
 	}
 
-	// 2. 成功执行 TxPipeline 后，解析从Redis获得的数据
-	spellIDs, err := spellIDsCmd.Result()
-	if err != nil {
-		return nil, fmt.Errorf("无法从Redis批量获取法术排行: %w", err)
-	}
-
 	if len(spellIDs) == 0 {
 		return []RankedSpellDTO{}, nil
 	}
 
-	spellStats, err := spellStatsCmd.Result()
-	if err != nil {
-		return nil, fmt.Errorf("无法从Redis批量获取法术动态数据: %w", err)
-	}
-
-	// 3. 组合来自内存仓库的静态数据和来自Redis的动态数据
+	// 2. 组合来自内存仓库的静态数据和来自Redis的动态数据
 	rankedSpells := make([]RankedSpellDTO, 0, len(spellIDs))
 	for _, id := range spellIDs {
 		index, ok := GetSpellIndexByID(id)
@@ -105,12 +88,10 @@ func GetRankedSpells() ([]RankedSpellDTO, error) {
 		}
 		info, _ := GetSpellInfoByIndex(index)
 
-		var stats SpellStats
-		statsJSON, ok := spellStats[id]
+		stats, ok := spellStats[id]
 		if !ok {
 			return nil, fmt.Errorf("无法从Redis法术动态数据中获取ID为 %s 的法术", id)
 		}
-		_ = json.Unmarshal([]byte(statsJSON), &stats)
 
 		rankedSpells = append(rankedSpells, RankedSpellDTO{
 			ID:    id,
@@ -138,7 +119,9 @@ func GetSpellImageInfoByID(spellID string) (*SpellImageDTO, error) {
 }
 
 // GetNewSpellPair 实现了包含“冷门优先”和“实力接近”的智能匹配算法
-func GetNewSpellPair(excludeA, excludeB string) (*PairDataDTO, error) {
+// 签发的凭证会绑定到userID（匿名用户为空字符串）和当前的应用模式。
+// difficulty不为0时，凭证要求投票者附带对应难度的工作量证明。
+func GetNewSpellPair(excludeA, excludeB, userID string, difficulty int) (*PairDataDTO, error) {
 	if !database.IsRedisHealthy() {
 		return nil, errors.New("服务暂时不可用，请稍后重试")
 	}
@@ -218,37 +201,18 @@ func GetNewSpellPair(excludeA, excludeB string) (*PairDataDTO, error) {
 
 		// --- 阶段二: 选择第二候选法术 (实力接近) ---
 		// 1. 获取所需数据
-		pipe := database.RDB.Pipeline()
-		rank1Cmd := pipe.ZRevRank(database.Ctx, RankingKey, candidateID1)
-		var rankACmd, rankBCmd *redis.IntCmd
+		spellIDs := []string{candidateID1}
 		if handleExcludes {
-			rankACmd = pipe.ZRevRank(database.Ctx, RankingKey, excludeA)
-			rankBCmd = pipe.ZRevRank(database.Ctx, RankingKey, excludeB)
+			spellIDs = append(spellIDs, excludeA, excludeB)
 		}
-		totalVotesCmd := pipe.Get(database.Ctx, metadata.RedisTotalVotesKey)
-		_, err = pipe.Exec(database.Ctx)
+		ranks, totalVotes, err := activeStore.ranksAndTotalVotes(spellIDs...)
 		if err != nil {
-			return errors.New("查询法术排名失败")
-		}
-
-		candidateRank1, err = rank1Cmd.Result()
-		if err != nil {
-			return fmt.Errorf("查找排除法术排名失败: %w", err)
+			return fmt.Errorf("查询法术排名失败: %w", err)
 		}
+		candidateRank1 = ranks[0]
 		var rankA, rankB int64
 		if handleExcludes {
-			rankA, err = rankACmd.Result()
-			if err != nil {
-				return fmt.Errorf("查找排除法术排名失败: %w", err)
-			}
-			rankB, err = rankBCmd.Result()
-			if err != nil {
-				return fmt.Errorf("查找排除法术排名失败: %w", err)
-			}
-		}
-		totalVotes, err := totalVotesCmd.Float64()
-		if err != nil {
-			return errors.New("获取总投票数失败")
+			rankA, rankB = ranks[1], ranks[2]
 		}
 
 		// 2. 计算混合比例和总权重
@@ -314,11 +278,10 @@ func GetNewSpellPair(excludeA, excludeB string) (*PairDataDTO, error) {
 		}
 
 		// 7. 从排名获取ID
-		candidateIDs2, err := database.RDB.ZRevRange(database.Ctx, RankingKey, candidateRank2, candidateRank2).Result()
+		candidateID2, err = activeStore.spellAtRank(candidateRank2)
 		if err != nil {
 			return fmt.Errorf("无法从排名获取第二候选法术: %w", err)
 		}
-		candidateID2 = candidateIDs2[0]
 
 		return nil
 	}()
@@ -343,7 +306,15 @@ func GetNewSpellPair(excludeA, excludeB string) (*PairDataDTO, error) {
 	spellB := PairSpellDTO{Info: infoB, CurrentRank: candidateRank2 + 1}
 
 	pairID, _ := uuid.NewV7()
-	payload := token.TokenPayload{PairID: pairID.String(), SpellAID: candidateID1, SpellBID: candidateID2}
+	payload := token.TokenPayload{
+		PairID:     pairID.String(),
+		SpellAID:   candidateID1,
+		SpellBID:   candidateID2,
+		IssuedAt:   time.Now().UnixMilli(),
+		Mode:       string(appMode),
+		UserID:     userID,
+		Difficulty: difficulty,
+	}
 	signature, _ := token.GenerateVoteSignature(payload)
 	return &PairDataDTO{SpellA: spellA, SpellB: spellB, Payload: payload, Signature: signature}, nil
 }
@@ -1,12 +1,11 @@
 package spell
 
 import (
-	"encoding/json"
 	"fmt"
+	"log/slog"
 
 	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/config"
 	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
-	"github.com/redis/go-redis/v9"
 )
 
 func ConfigureModule(mode config.AppMode) {
@@ -36,7 +35,7 @@ func migrateDB() error {
 	if err := database.DB.AutoMigrate(&Spell{}); err != nil {
 		return fmt.Errorf("无法迁移spell表: %w", err)
 	}
-	fmt.Println("Spell数据库表迁移成功。")
+	slog.Info("Spell数据库表迁移成功。")
 	return nil
 }
 
@@ -48,29 +47,18 @@ func WarmupCache() error {
 		return fmt.Errorf("无法从SQLite读取法术数据: %w", err)
 	}
 
-	pipe := database.RDB.Pipeline()
-	// 只清空动态数据的Redis键
-	pipe.Del(database.Ctx, StatsKey, RankingKey)
-
+	// 准备动态统计数据 (spell:stats Hash 和 spell:ranking Sorted Set)
+	stats := make(map[string]SpellStats, len(spellsInDB))
 	// 准备用于重建权重树的初始权重
 	initialWeights := make([]float64, GetSpellCount())
 
 	for _, spell := range spellsInDB {
-		// 准备动态统计数据 (spell:stats Hash)
-		stats := SpellStats{
+		stats[spell.SpellID] = SpellStats{
 			Score:     spell.Score,
 			Total:     spell.Total,
 			Win:       spell.Win,
-			RankScore: spell.RankScore, // 增加新字段
+			RankScore: spell.RankScore, // 使用RankScore作为排名依据
 		}
-		statsJSON, _ := json.Marshal(stats)
-		pipe.HSet(database.Ctx, StatsKey, spell.SpellID, statsJSON)
-
-		// 准备排名数据 (spell:ranking Sorted Set)
-		pipe.ZAdd(database.Ctx, RankingKey, redis.Z{
-			Score:  spell.RankScore, // 使用RankScore作为排名依据
-			Member: spell.SpellID,
-		})
 
 		// 计算初始权重 (补充：这里是冷门优先算法的核心)
 		index, ok := GetSpellIndexByID(spell.SpellID)
@@ -79,8 +67,7 @@ func WarmupCache() error {
 		}
 	}
 
-	_, err := pipe.Exec(database.Ctx)
-	if err != nil {
+	if err := activeStore.replaceStats(stats); err != nil {
 		return fmt.Errorf("预热法术动态数据到Redis失败: %w", err)
 	}
 
@@ -89,6 +76,6 @@ func WarmupCache() error {
 		return fmt.Errorf("无法使用初始权重重建线段树: %w", err)
 	}
 
-	fmt.Printf("成功预热 %d 条法术的动态数据到Redis，并重建了权重树。\n", len(spellsInDB))
+	slog.Info("成功预热法术的动态数据到Redis，并重建了权重树。", slog.Int("spells", len(spellsInDB)))
 	return nil
 }
@@ -0,0 +1,102 @@
+package spell
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/metadata"
+	"github.com/redis/go-redis/v9"
+)
+
+// store 抽象了spell模块的缓存层。
+// 默认使用Redis，测试可以通过 UseMemoryStore 换成进程内的实现。
+type store interface {
+	// replaceStats 清空并重新写入全部法术的统计数据和排名
+	replaceStats(stats map[string]SpellStats) error
+	// rankedStats 原子地读取按RankScore从高到低排列的法术ID，以及全部法术的统计数据
+	rankedStats() ([]string, map[string]SpellStats, error)
+	// ranksAndTotalVotes 读取若干法术的当前排名（0-based）以及实时总投票数
+	ranksAndTotalVotes(spellIDs ...string) ([]int64, float64, error)
+	// spellAtRank 返回处于某个排名（0-based）的法术ID
+	spellAtRank(rank int64) (string, error)
+}
+
+// activeStore 是当前使用的缓存层
+var activeStore store = redisStore{}
+
+// UseRedisStore 让spell模块改回使用Redis缓存层，供测试在进程内的Redis上运行
+func UseRedisStore() {
+	activeStore = redisStore{}
+}
+
+// decodeStats 解析 StatsKey 中的全部法术统计数据，无法解析的条目按零值处理
+func decodeStats(raw map[string]string) map[string]SpellStats {
+	stats := make(map[string]SpellStats, len(raw))
+	for id, statsJSON := range raw {
+		var s SpellStats
+		_ = json.Unmarshal([]byte(statsJSON), &s)
+		stats[id] = s
+	}
+	return stats
+}
+
+// redisStore 是基于Redis的缓存层
+type redisStore struct{}
+
+func (redisStore) replaceStats(stats map[string]SpellStats) error {
+	pipe := database.RDB.Pipeline()
+	// 只清空动态数据的Redis键
+	pipe.Del(database.Ctx, StatsKey, RankingKey)
+	for id, s := range stats {
+		statsJSON, _ := json.Marshal(s)
+		pipe.HSet(database.Ctx, StatsKey, id, statsJSON)
+		// 使用RankScore作为排名依据
+		pipe.ZAdd(database.Ctx, RankingKey, redis.Z{Score: s.RankScore, Member: id})
+	}
+	_, err := pipe.Exec(database.Ctx)
+	return err
+}
+
+func (redisStore) rankedStats() ([]string, map[string]SpellStats, error) {
+	pipe := database.RDB.TxPipeline()
+	spellIDsCmd := pipe.ZRevRange(database.Ctx, RankingKey, 0, -1)
+	spellStatsCmd := pipe.HGetAll(database.Ctx, StatsKey)
+	if _, err := pipe.Exec(database.Ctx); err != nil {
+		return nil, nil, err
+	}
+	return spellIDsCmd.Val(), decodeStats(spellStatsCmd.Val()), nil
+}
+
+func (redisStore) ranksAndTotalVotes(spellIDs ...string) ([]int64, float64, error) {
+	pipe := database.RDB.Pipeline()
+	rankCmds := make([]*redis.IntCmd, len(spellIDs))
+	for i, id := range spellIDs {
+		rankCmds[i] = pipe.ZRevRank(database.Ctx, RankingKey, id)
+	}
+	totalVotesCmd := pipe.Get(database.Ctx, metadata.RedisTotalVotesKey)
+	if _, err := pipe.Exec(database.Ctx); err != nil {
+		return nil, 0, err
+	}
+
+	ranks := make([]int64, len(spellIDs))
+	for i, cmd := range rankCmds {
+		ranks[i] = cmd.Val()
+	}
+	totalVotes, err := totalVotesCmd.Float64()
+	if err != nil {
+		return nil, 0, fmt.Errorf("获取总投票数失败: %w", err)
+	}
+	return ranks, totalVotes, nil
+}
+
+func (redisStore) spellAtRank(rank int64) (string, error) {
+	ids, err := database.RDB.ZRevRange(database.Ctx, RankingKey, rank, rank).Result()
+	if err != nil {
+		return "", err
+	}
+	if len(ids) == 0 {
+		return "", fmt.Errorf("排名 %d 处没有法术", rank)
+	}
+	return ids[0], nil
+}
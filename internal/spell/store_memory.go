@@ -0,0 +1,74 @@
+package spell
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/memstore"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/metadata"
+)
+
+// memoryStore 是基于进程内存储的缓存层，键名和编码与 redisStore 相同
+type memoryStore struct {
+	db *memstore.Store
+}
+
+// UseMemoryStore 让spell模块改用进程内存储，供测试在没有Redis的环境下使用
+func UseMemoryStore(db *memstore.Store) {
+	activeStore = memoryStore{db: db}
+}
+
+func (s memoryStore) replaceStats(stats map[string]SpellStats) error {
+	return s.db.Do(func(tx *memstore.Tx) error {
+		tx.Del(StatsKey, RankingKey)
+		for id, spellStats := range stats {
+			statsJSON, _ := json.Marshal(spellStats)
+			tx.HSet(StatsKey, id, string(statsJSON))
+			tx.ZAdd(RankingKey, memstore.Z{Member: id, Score: spellStats.RankScore})
+		}
+		return nil
+	})
+}
+
+func (s memoryStore) rankedStats() (ids []string, stats map[string]SpellStats, err error) {
+	err = s.db.Do(func(tx *memstore.Tx) error {
+		for _, z := range tx.ZRevRange(RankingKey) {
+			ids = append(ids, z.Member)
+		}
+		stats = decodeStats(tx.HGetAll(StatsKey))
+		return nil
+	})
+	return ids, stats, err
+}
+
+func (s memoryStore) ranksAndTotalVotes(spellIDs ...string) (ranks []int64, totalVotes float64, err error) {
+	err = s.db.Do(func(tx *memstore.Tx) error {
+		for _, id := range spellIDs {
+			rank, ok := tx.ZRevRank(RankingKey, id)
+			if !ok {
+				return fmt.Errorf("法术 %s 不在排名中", id)
+			}
+			ranks = append(ranks, rank)
+		}
+		raw, _ := tx.Get(metadata.RedisTotalVotesKey)
+		totalVotes, err = strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("获取总投票数失败: %w", err)
+		}
+		return nil
+	})
+	return ranks, totalVotes, err
+}
+
+func (s memoryStore) spellAtRank(rank int64) (id string, err error) {
+	err = s.db.Do(func(tx *memstore.Tx) error {
+		ranking := tx.ZRevRange(RankingKey)
+		if rank < 0 || rank >= int64(len(ranking)) {
+			return fmt.Errorf("排名 %d 处没有法术", rank)
+		}
+		id = ranking[rank].Member
+		return nil
+	})
+	return id, err
+}
@@ -0,0 +1,106 @@
+// Package testutil 为各模块的测试提供共享的测试环境：内存中的SQLite数据库、运行在进程内的Redis（miniredis）
+// 以及预置的法术。它只应被 _test.go 文件导入。
+package testutil
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/config"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/metadata"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/spell"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/user"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// SpellIDs 是测试环境中预置的法术，排名按此顺序
+var SpellIDs = []string{"BOMB", "LIGHT_BULLET", "BLACK_HOLE", "TELEPORT_PROJECTILE", "CHAINSAW", "DIGGER"}
+
+// Env 是一个已完成初始化的测试环境
+type Env struct {
+	DB    *gorm.DB
+	Redis *miniredis.Miniredis
+}
+
+// Options 描述了调用方在共享流程之外需要的准备工作。
+// vote等模块不能被本包导入（它们自己的测试会形成循环导入），因此通过这些回调接入。
+type Options struct {
+	// Configure 在metadata、user、spell模块切换到Redis缓存层并完成配置之后调用，
+	// 用于切换和配置调用方自己的模块
+	Configure func()
+	// Seed 在法术写入之后、各模块初始化之前调用，用于写入测试数据
+	Seed func(db *gorm.DB) error
+	// Prime 在metadata、user、spell模块初始化之后调用，用于初始化依赖它们的模块
+	Prime func() error
+}
+
+// Setup 为一个测试准备独立的环境：以测试名命名的内存SQLite数据库、运行在进程内的Redis，
+// 以及预置的法术，然后执行与启动时相同的初始化流程。所有模块都使用Redis缓存层，
+// 因此投票由生产环境的Lua脚本应用。
+func Setup(t testing.TB, opts Options) *Env {
+	t.Helper()
+
+	// 1. 每个测试使用一个以测试名命名的内存数据库和一个独立的Redis
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", strings.NewReplacer("/", "_", " ", "_").Replace(t.Name()))
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("打开测试数据库失败: %v", err)
+	}
+	sqlDB, _ := db.DB()
+	t.Cleanup(func() { sqlDB.Close() })
+	database.DB = db
+
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	database.RDB = client
+
+	// 2. 切换缓存层并加载与生产环境相同的模块配置
+	metadata.UseRedisStore()
+	user.UseRedisStore()
+	spell.UseRedisStore()
+	spell.ConfigureModule(config.AppModeSpell)
+	if opts.Configure != nil {
+		opts.Configure()
+	}
+
+	// 3. 预置法术和调用方的测试数据
+	if err := db.AutoMigrate(&spell.Spell{}); err != nil {
+		t.Fatalf("迁移spell表失败: %v", err)
+	}
+	for i, id := range SpellIDs {
+		s := spell.Spell{SpellID: id, Name: id, Score: 1500, RankScore: 0.5, Rank: i + 1}
+		if err := db.Create(&s).Error; err != nil {
+			t.Fatalf("写入法术失败: %v", err)
+		}
+	}
+	if opts.Seed != nil {
+		if err := opts.Seed(db); err != nil {
+			t.Fatalf("写入测试数据失败: %v", err)
+		}
+	}
+
+	// 4. 执行与启动时相同的初始化流程
+	if err := metadata.PrimeCachedDB(); err != nil {
+		t.Fatal(err)
+	}
+	if err := user.PrimeCachedDB(); err != nil {
+		t.Fatal(err)
+	}
+	if err := spell.PrimeCachedDB(); err != nil {
+		t.Fatal(err)
+	}
+	if opts.Prime != nil {
+		if err := opts.Prime(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return &Env{DB: db, Redis: mr}
+}
@@ -0,0 +1,89 @@
+package user
+
+import (
+	"encoding/json"
+	"slices"
+)
+
+// MilestoneVoteNumbers 是聚合数据中记录的投票序号，第1票即用户的第一次投票。
+var MilestoneVoteNumbers = []int{1, 25, 50, 100, 250, 500, 1000}
+
+// VoteRef 引用了用户投票历史中的一次投票。
+type VoteRef struct {
+	// Number 是这次投票在用户有效投票中的序号，从1开始
+	Number int  `json:"n"`
+	VoteID uint `json:"id"`
+}
+
+// SpellTally 记录了一个用户的投票中与某个法术相关的计数。
+type SpellTally struct {
+	Wins   int `json:"w,omitempty"` // 被选为胜者的次数
+	Beaten int `json:"b,omitempty"` // 在胜负投票中落败的次数
+	Draws  int `json:"d,omitempty"` // 出现在双输投票中的次数
+
+	// FirstSeen 是用户首次遇到该法术的投票，跳过的投票也计入
+	FirstSeen VoteRef `json:"f"`
+}
+
+// IsEmpty 判断这个法术是否已不再出现在用户的任何有效投票中。
+func (t SpellTally) IsEmpty() bool {
+	return t.Wins == 0 && t.Beaten == 0 && t.Draws == 0 && t.FirstSeen.VoteID == 0
+}
+
+// SubversiveVote 记录了胜者排名低于败者最多的一次投票。
+type SubversiveVote struct {
+	VoteRef
+	// RankDiff 是处理这次投票时胜者与败者的排名差
+	RankDiff int `json:"diff"`
+}
+
+// LastVoteEffect 记录了最近一次投票中无法从计数反推的影响，
+// 使紧随其后的撤销事件可以被精确地抵消。
+type LastVoteEffect struct {
+	VoteID            uint            `json:"id"`
+	Day               string          `json:"day"`
+	Consistent        bool            `json:"consistent,omitempty"`
+	SubversiveChanged bool            `json:"subversiveChanged,omitempty"`
+	PrevSubversive    *SubversiveVote `json:"prevSubversive,omitempty"`
+}
+
+// UserAggregates 是由投票处理器增量维护的用户报告聚合数据，
+// 报告只需要它和当前的社区排名，而不必读取用户的全部投票历史。
+// 它以JSON格式存储在 AggregatesKey 中，并随用户表一起快照。
+type UserAggregates struct {
+	Spells map[string]SpellTally `json:"spells,omitempty"`
+	// Days 记录了每一天（YYYY-MM-DD，服务器本地时间）的投票数
+	Days map[string]int `json:"days,omitempty"`
+	// Consistent 是胜者在投票被处理时社区排名高于败者的胜负投票数
+	Consistent int `json:"consistent"`
+	// Subversive 是最具颠覆性的一票，排名以投票被处理时为准
+	Subversive *SubversiveVote `json:"subversive,omitempty"`
+	// Milestones 按序号升序记录了 MilestoneVoteNumbers 中已达成的投票
+	Milestones []VoteRef       `json:"milestones,omitempty"`
+	Last       *LastVoteEffect `json:"last,omitempty"`
+}
+
+// ParseUserAggregates 解析聚合数据的JSON，空字符串表示没有任何投票。
+func ParseUserAggregates(data string) (UserAggregates, error) {
+	var agg UserAggregates
+	if data == "" {
+		return agg, nil
+	}
+	err := json.Unmarshal([]byte(data), &agg)
+	return agg, err
+}
+
+// IsMilestoneVoteNumber 判断某个投票序号是否需要被记录为里程碑。
+func IsMilestoneVoteNumber(number int) bool {
+	return slices.Contains(MilestoneVoteNumbers, number)
+}
+
+// Milestone 返回序号为number的里程碑投票。
+func (a *UserAggregates) Milestone(number int) (VoteRef, bool) {
+	for _, ref := range a.Milestones {
+		if ref.Number == number {
+			return ref, true
+		}
+	}
+	return VoteRef{}, false
+}
@@ -1,9 +1,10 @@
 package user
 
 import (
-	"fmt"
+	"log/slog"
 	"net/http"
 
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/logging"
 	"github.com/gin-gonic/gin"
 )
 
@@ -15,6 +16,7 @@ const (
 
 // EnsureUserCookieMiddleware 确保用户的浏览器中有一个格式正确的user-id cookie。
 // 如果没有或格式不正确，它会生成一个新的临时ID并设置cookie。
+// 最终生效的用户ID（可能为空）会被放入Gin上下文中。
 func EnsureUserCookieMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userID, err := c.Cookie(CookieName)
@@ -22,16 +24,20 @@ func EnsureUserCookieMiddleware() gin.HandlerFunc {
 		// 如果Cookie不存在，或存在但格式不正确，则分发一个新的
 		if err != nil || !IsValidUUID(userID) {
 			if err != http.ErrNoCookie {
-				fmt.Printf("检测到无效的用户Cookie: %s, err: %v\n", userID, err)
+				slog.WarnContext(c.Request.Context(), "检测到无效的用户Cookie", slog.String("cookie", userID), logging.Err(err))
 			}
+			userID = ""
 			provisionalUserID, err := CreateProvisionalUser()
 			if err != nil {
-				fmt.Printf("创建临时用户ID时发生错误: %v\n", err)
+				slog.ErrorContext(c.Request.Context(), "创建临时用户ID时发生错误", logging.Err(err))
 			} else {
-				c.SetCookie(CookieName, provisionalUserID, CookieMaxAge, "/", "", false, true)
+				SetUserCookie(c, provisionalUserID)
+				userID = provisionalUserID
 			}
 		}
 
+		c.Set(UserIDKey, userID)
+		logging.SetUserID(c.Request.Context(), userID)
 		c.Next()
 	}
 }
@@ -41,6 +47,19 @@ func LoadUserMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userID, _ := c.Cookie(CookieName)
 		c.Set(UserIDKey, userID)
+		if IsValidUUID(userID) {
+			logging.SetUserID(c.Request.Context(), userID)
+		}
 		c.Next()
 	}
 }
+
+// SetUserCookie 将用户ID写入浏览器的user-id cookie。
+func SetUserCookie(c *gin.Context, userID string) {
+	c.SetCookie(CookieName, userID, CookieMaxAge, "/", "", false, true)
+}
+
+// ClearUserCookie 让浏览器删除user-id cookie。
+func ClearUserCookie(c *gin.Context) {
+	c.SetCookie(CookieName, "", -1, "/", "", false, true)
+}
@@ -21,6 +21,15 @@ type User struct {
 	// SkipCount 记录了用户选择跳过的总次数。
 	SkipCount int
 
+	// Nickname 是用户在公开排行榜上显示的昵称，已经过审核。
+	Nickname string `gorm:"type:varchar(64);not null;default:''"`
+
+	// LeaderboardOptIn 表示用户是否同意以昵称出现在公开排行榜上，否则显示为匿名。
+	LeaderboardOptIn bool `gorm:"not null;default:false"`
+
+	// Aggregates 是用户报告聚合数据（UserAggregates）的JSON序列化字符串。
+	Aggregates string `gorm:"type:text"`
+
 	// 部分gorm.Model，由GORM自动管理
 	CreatedAt time.Time
 	UpdatedAt time.Time
@@ -35,3 +44,13 @@ type TotalStats struct {
 	DrawCount int
 	SkipCount int
 }
+
+// RecoveryCode 记录了一个已签发、尚未兑换的恢复码。恢复码只有在这里有记录时才能兑换；
+// 兑换、签发新的恢复码、合并或删除用户都会删除记录，使对应的恢复码失效。
+type RecoveryCode struct {
+	// Nonce 是恢复码中的随机数，唯一标识一个恢复码
+	Nonce          string `gorm:"primarykey;type:varchar(32)"`
+	UserIdentifier string `gorm:"index;type:varchar(36)"`
+	ExpiresAt      time.Time
+	CreatedAt      time.Time
+}
@@ -0,0 +1,46 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrRecoveryCodeInvalid 表示恢复码没有签发记录、已被兑换或已过期
+var ErrRecoveryCodeInvalid = errors.New("恢复码无效或已失效")
+
+// ReplaceRecoveryCode 保存一个新签发的恢复码，并使该用户此前签发的恢复码全部失效
+func ReplaceRecoveryCode(db *gorm.DB, code RecoveryCode) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := DeleteRecoveryCodes(tx, code.UserIdentifier); err != nil {
+			return err
+		}
+		if err := tx.Create(&code).Error; err != nil {
+			return fmt.Errorf("保存恢复码失败: %w", err)
+		}
+		return nil
+	})
+}
+
+// ConsumeRecoveryCode 兑换一个恢复码并删除其记录，使它不能被再次使用。
+// 记录不存在、不属于userID或已过期时返回 ErrRecoveryCodeInvalid。
+func ConsumeRecoveryCode(tx *gorm.DB, userID, nonce string, now time.Time) error {
+	result := tx.Where("nonce = ? AND user_identifier = ? AND expires_at > ?", nonce, userID, now).Delete(&RecoveryCode{})
+	if result.Error != nil {
+		return fmt.Errorf("兑换恢复码失败: %w", result.Error)
+	}
+	if result.RowsAffected != 1 {
+		return ErrRecoveryCodeInvalid
+	}
+	return nil
+}
+
+// DeleteRecoveryCodes 使一个用户的全部恢复码失效，同时清理已过期的记录
+func DeleteRecoveryCodes(tx *gorm.DB, userID string) error {
+	if err := tx.Where("user_identifier = ? OR expires_at <= ?", userID, time.Now()).Delete(&RecoveryCode{}).Error; err != nil {
+		return fmt.Errorf("删除恢复码失败: %w", err)
+	}
+	return nil
+}
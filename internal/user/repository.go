@@ -2,6 +2,8 @@ package user
 
 import (
 	"sync"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
 )
 
 // --- Redis 键名常量 ---
@@ -10,20 +12,25 @@ const (
 	// StatsKey 是一个 Redis Hash 的键，用于存储每个用户的详细统计信息。
 	// Field: 用户的UUID 或 TotalStatsKey
 	// Value: UserStats 结构体的JSON序列化字符串
-	StatsKey = "user:stats"
+	StatsKey = database.KeyPrefix + "user:stats"
 
 	// RankingKey 是一个 Redis Sorted Set 的键，用于存储用户的投票数排名。
 	// Score: 用户的总投票数 (Wins + Draw + Skip)
 	// Member: 用户的UUID
-	RankingKey = "user:ranking"
+	RankingKey = database.KeyPrefix + "user:ranking"
+
+	// AggregatesKey 是一个 Redis Hash 的键，用于存储每个用户的报告聚合数据。
+	// Field: 用户的UUID
+	// Value: UserAggregates 结构体的JSON序列化字符串
+	AggregatesKey = database.KeyPrefix + "user:aggregates"
 
 	// DirtySetKey 是一个 Redis Set 的键，用于存储自上次快照以来，
 	// 统计数据发生变化的用户UUID。用于增量备份。
-	DirtySetKey = "user:dirty"
+	DirtySetKey = database.KeyPrefix + "user:dirty"
 
 	// ProcessingDirtySetKey 是一个 Redis Set 的键
 	// 保留它，只在备份逻辑中被使用
-	ProcessingDirtySetKey = "user:dirty:processing"
+	ProcessingDirtySetKey = database.KeyPrefix + "user:dirty:processing"
 )
 
 // --- 特殊键与常量 ---
@@ -43,6 +50,9 @@ type UserStats struct {
 	Wins int `json:"wins"`
 	Draw int `json:"draw"`
 	Skip int `json:"skip"`
+	// LastVoteID 是该用户最后一次被处理的投票ID，用于判断报告缓存是否过时。
+	// 它不会被持久化到SQLite，缓存重建后只对有增量投票的用户恢复。
+	LastVoteID uint `json:"lastVoteId,omitempty"`
 }
 
 // --- 并发控制 ---
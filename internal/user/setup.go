@@ -1,11 +1,10 @@
 package user
 
 import (
-	"encoding/json"
 	"fmt"
+	"log/slog"
 
 	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
-	"github.com/redis/go-redis/v9"
 	"gorm.io/gorm"
 )
 
@@ -27,10 +26,10 @@ func PrimeCachedDB() (err error) {
 
 // migrateDB 负责自动迁移数据库表结构
 func migrateDB() error {
-	if err := database.DB.AutoMigrate(&User{}, &TotalStats{}); err != nil {
-		return fmt.Errorf("无法迁移user或total_stats表: %w", err)
+	if err := database.DB.AutoMigrate(&User{}, &TotalStats{}, &RecoveryCode{}); err != nil {
+		return fmt.Errorf("无法迁移user、total_stats或recovery_codes表: %w", err)
 	}
-	fmt.Println("User和TotalStats数据库表迁移成功。")
+	slog.Info("User、TotalStats和RecoveryCode数据库表迁移成功。")
 	return nil
 }
 
@@ -38,17 +37,13 @@ func migrateDB() error {
 // 这个过程是破坏性的，会先清空旧的缓存数据。
 // 注意：此函数不包含锁，调用方需要确保在安全的时机（如单线程启动或重建大范围锁下）调用。
 func WarmupCache() error {
-	fmt.Println("开始预热user模块缓存...")
+	slog.Info("开始预热user模块缓存...")
 
 	// 1. 清空所有相关的Redis键
-	pipe := database.RDB.Pipeline()
-	pipe.Del(database.Ctx, StatsKey)
-	pipe.Del(database.Ctx, RankingKey)
-	pipe.Del(database.Ctx, DirtySetKey)
-	if _, err := pipe.Exec(database.Ctx); err != nil {
+	if err := activeStore.clear(); err != nil {
 		return fmt.Errorf("清空旧的user缓存失败: %w", err)
 	}
-	fmt.Println("旧的user缓存已清空.")
+	slog.Info("旧的user缓存已清空。")
 
 	// 2. 从SQLite加载社区总统计数据
 	var totalStatsRecord TotalStats
@@ -78,39 +73,26 @@ func WarmupCache() error {
 		}
 
 		// 准备当前批次写入Redis的数据
-		statsPayload := make(map[string]interface{})
-		rankingPayload := make([]redis.Z, 0, len(batch))
+		statsPayload := make(map[string]UserStats, len(batch))
+		aggregatesPayload := make(map[string]string)
 
 		for _, user := range batch {
-			// 准备 user:stats (Hash) 的数据
-			stats := UserStats{
+			// 准备 user:stats (Hash) 和 user:ranking (Sorted Set) 的数据
+			statsPayload[user.UUID] = UserStats{
 				Wins: user.WinsCount,
 				Draw: user.DrawCount,
 				Skip: user.SkipCount,
 			}
-			statsJSON, err := json.Marshal(stats)
-			if err != nil {
-				// 理论上这个错误不应该发生
-				return fmt.Errorf("警告：序列化用户 %s 的统计数据失败: %w", user.UUID, err)
+
+			// 准备 user:aggregates (Hash) 的数据，尚未回填的用户会由vote模块补齐
+			if user.Aggregates != "" {
+				aggregatesPayload[user.UUID] = user.Aggregates
 			}
-			statsPayload[user.UUID] = string(statsJSON)
-
-			// 准备 user:ranking (Sorted Set) 的数据
-			totalVotes := float64(user.WinsCount + user.DrawCount + user.SkipCount)
-			rankingPayload = append(rankingPayload, redis.Z{
-				Score:  totalVotes,
-				Member: user.UUID,
-			})
 		}
 
-		// 使用Pipeline写入当前批次的数据
-		if len(statsPayload) > 0 {
-			pipe := database.RDB.Pipeline()
-			pipe.HSet(database.Ctx, StatsKey, statsPayload)
-			pipe.ZAdd(database.Ctx, RankingKey, rankingPayload...)
-			if _, err := pipe.Exec(database.Ctx); err != nil {
-				return fmt.Errorf("写入批次到Redis失败 (uuid > %s): %w", lastID, err)
-			}
+		// 写入当前批次的数据
+		if err := activeStore.saveUsers(statsPayload, aggregatesPayload); err != nil {
+			return fmt.Errorf("写入批次到Redis失败 (uuid > %s): %w", lastID, err)
 		}
 
 		// 更新 lastID 为当前批次的最后一条记录的ID
@@ -120,14 +102,10 @@ func WarmupCache() error {
 	}
 
 	// 4. 将最终的社区总票数写入Redis
-	totalStatsJSON, err := json.Marshal(totalStats)
-	if err != nil {
-		return fmt.Errorf("序列化社区总统计数据失败: %w", err)
-	}
-	if err := database.RDB.HSet(database.Ctx, StatsKey, TotalStatsKey, string(totalStatsJSON)).Err(); err != nil {
+	if err := activeStore.saveTotalStats(totalStats); err != nil {
 		return fmt.Errorf("写入社区总统计数据到Redis失败: %w", err)
 	}
 
-	fmt.Println("user模块缓存预热完成.")
+	slog.Info("user模块缓存预热完成。")
 	return nil
 }
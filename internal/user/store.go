@@ -0,0 +1,79 @@
+package user
+
+import (
+	"encoding/json"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
+	"github.com/redis/go-redis/v9"
+)
+
+// store 抽象了user模块的缓存层。
+// 默认使用Redis，测试可以通过 UseMemoryStore 换成进程内的实现。
+type store interface {
+	// clear 清空本模块管理的全部缓存键
+	clear() error
+	// saveUsers 写入一批用户的统计数据、投票数排名和聚合数据（JSON），
+	// 尚未回填聚合数据的用户不在aggregates中
+	saveUsers(stats map[string]UserStats, aggregates map[string]string) error
+	// saveTotalStats 写入社区总统计数据
+	saveTotalStats(stats UserStats) error
+}
+
+// activeStore 是当前使用的缓存层
+var activeStore store = redisStore{}
+
+// UseRedisStore 让user模块改回使用Redis缓存层，供测试在进程内的Redis上运行
+func UseRedisStore() {
+	activeStore = redisStore{}
+}
+
+// redisStore 是基于Redis的缓存层
+type redisStore struct{}
+
+func (redisStore) clear() error {
+	pipe := database.RDB.Pipeline()
+	pipe.Del(database.Ctx, StatsKey)
+	pipe.Del(database.Ctx, RankingKey)
+	pipe.Del(database.Ctx, AggregatesKey)
+	pipe.Del(database.Ctx, DirtySetKey)
+	_, err := pipe.Exec(database.Ctx)
+	return err
+}
+
+func (redisStore) saveUsers(stats map[string]UserStats, aggregates map[string]string) error {
+	if len(stats) == 0 {
+		return nil
+	}
+
+	statsPayload := make(map[string]interface{}, len(stats))
+	rankingPayload := make([]redis.Z, 0, len(stats))
+	for id, s := range stats {
+		statsJSON, err := json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		statsPayload[id] = string(statsJSON)
+		rankingPayload = append(rankingPayload, redis.Z{Score: float64(s.Wins + s.Draw + s.Skip), Member: id})
+	}
+	aggregatesPayload := make(map[string]interface{}, len(aggregates))
+	for id, agg := range aggregates {
+		aggregatesPayload[id] = agg
+	}
+
+	pipe := database.RDB.Pipeline()
+	pipe.HSet(database.Ctx, StatsKey, statsPayload)
+	pipe.ZAdd(database.Ctx, RankingKey, rankingPayload...)
+	if len(aggregatesPayload) > 0 {
+		pipe.HSet(database.Ctx, AggregatesKey, aggregatesPayload)
+	}
+	_, err := pipe.Exec(database.Ctx)
+	return err
+}
+
+func (redisStore) saveTotalStats(stats UserStats) error {
+	totalStatsJSON, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+	return database.RDB.HSet(database.Ctx, StatsKey, TotalStatsKey, string(totalStatsJSON)).Err()
+}
@@ -0,0 +1,52 @@
+package user
+
+import (
+	"encoding/json"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/memstore"
+)
+
+// memoryStore 是基于进程内存储的缓存层，键名和编码与 redisStore 相同
+type memoryStore struct {
+	db *memstore.Store
+}
+
+// UseMemoryStore 让user模块改用进程内存储，供测试在没有Redis的环境下使用
+func UseMemoryStore(db *memstore.Store) {
+	activeStore = memoryStore{db: db}
+}
+
+func (s memoryStore) clear() error {
+	return s.db.Do(func(tx *memstore.Tx) error {
+		tx.Del(StatsKey, RankingKey, AggregatesKey, DirtySetKey)
+		return nil
+	})
+}
+
+func (s memoryStore) saveUsers(stats map[string]UserStats, aggregates map[string]string) error {
+	return s.db.Do(func(tx *memstore.Tx) error {
+		for id, userStats := range stats {
+			statsJSON, err := json.Marshal(userStats)
+			if err != nil {
+				return err
+			}
+			tx.HSet(StatsKey, id, string(statsJSON))
+			tx.ZAdd(RankingKey, memstore.Z{Member: id, Score: float64(userStats.Wins + userStats.Draw + userStats.Skip)})
+		}
+		for id, agg := range aggregates {
+			tx.HSet(AggregatesKey, id, agg)
+		}
+		return nil
+	})
+}
+
+func (s memoryStore) saveTotalStats(stats UserStats) error {
+	totalStatsJSON, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+	return s.db.Do(func(tx *memstore.Tx) error {
+		tx.HSet(StatsKey, TotalStatsKey, string(totalStatsJSON))
+		return nil
+	})
+}
@@ -0,0 +1,226 @@
+package vote
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/logging"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/metadata"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/user"
+	"gorm.io/gorm"
+)
+
+// aggregateDayLayout 是聚合数据中按天计数所用的日期格式
+const aggregateDayLayout = "2006-01-02"
+
+// applyVoteToAggregates 将一次投票计入用户的聚合数据。
+// voteNumber 是计入这次投票后用户的有效投票总数；spellRank 是处理时的社区排名（1-based），
+// 只用于胜负投票，缺失的法术不参与一致性和颠覆性的评估。
+func applyVoteToAggregates(agg *user.UserAggregates, vote Vote, voteNumber int, spellRank map[string]int) {
+	if vote.IsUndo() {
+		revertVoteFromAggregates(agg, vote)
+		return
+	}
+	if agg.Spells == nil {
+		agg.Spells = make(map[string]user.SpellTally)
+	}
+	if agg.Days == nil {
+		agg.Days = make(map[string]int)
+	}
+
+	ref := user.VoteRef{Number: voteNumber, VoteID: vote.ID}
+	effect := &user.LastVoteEffect{
+		VoteID: vote.ID,
+		Day:    vote.VoteTime.Local().Format(aggregateDayLayout),
+	}
+
+	// 1. 法术计数与首次遭遇
+	tallyA, tallyB := agg.Spells[vote.SpellA_ID], agg.Spells[vote.SpellB_ID]
+	switch vote.Result {
+	case ResultAWins:
+		tallyA.Wins++
+		tallyB.Beaten++
+	case ResultBWins:
+		tallyB.Wins++
+		tallyA.Beaten++
+	case ResultDraw:
+		tallyA.Draws++
+		tallyB.Draws++
+	}
+	if tallyA.FirstSeen.VoteID == 0 {
+		tallyA.FirstSeen = ref
+	}
+	if tallyB.FirstSeen.VoteID == 0 {
+		tallyB.FirstSeen = ref
+	}
+	agg.Spells[vote.SpellA_ID] = tallyA
+	agg.Spells[vote.SpellB_ID] = tallyB
+
+	// 2. 每日计数与里程碑
+	agg.Days[effect.Day]++
+	if user.IsMilestoneVoteNumber(voteNumber) {
+		agg.Milestones = append(agg.Milestones, ref)
+	}
+
+	// 3. 依赖处理时排名的一致性与颠覆性
+	if vote.Result == ResultAWins || vote.Result == ResultBWins {
+		winnerID, loserID := vote.SpellA_ID, vote.SpellB_ID
+		if vote.Result == ResultBWins {
+			winnerID, loserID = loserID, winnerID
+		}
+		winnerRank, okW := spellRank[winnerID]
+		loserRank, okL := spellRank[loserID]
+		if okW && okL {
+			if winnerRank < loserRank {
+				agg.Consistent++
+				effect.Consistent = true
+			}
+			rankDiff := winnerRank - loserRank
+			if rankDiff > 0 && (agg.Subversive == nil || rankDiff > agg.Subversive.RankDiff) {
+				effect.SubversiveChanged = true
+				effect.PrevSubversive = agg.Subversive
+				agg.Subversive = &user.SubversiveVote{VoteRef: ref, RankDiff: rankDiff}
+			}
+		}
+	}
+
+	agg.Last = effect
+}
+
+// revertVoteFromAggregates 从聚合数据中抵消一条撤销事件所撤销的投票。
+// 只有用户最近的一次投票可以被撤销，因此 Last 中记录的影响总是属于被撤销的投票。
+func revertVoteFromAggregates(agg *user.UserAggregates, undo Vote) {
+	originalID := undo.UndoOfID
+
+	// 1. 法术计数与首次遭遇
+	tallyA, tallyB := agg.Spells[undo.SpellA_ID], agg.Spells[undo.SpellB_ID]
+	switch undo.Result {
+	case ResultAWins:
+		tallyA.Wins--
+		tallyB.Beaten--
+	case ResultBWins:
+		tallyB.Wins--
+		tallyA.Beaten--
+	case ResultDraw:
+		tallyA.Draws--
+		tallyB.Draws--
+	}
+	for spellID, tally := range map[string]user.SpellTally{undo.SpellA_ID: tallyA, undo.SpellB_ID: tallyB} {
+		if tally.FirstSeen.VoteID == originalID {
+			tally.FirstSeen = user.VoteRef{}
+		}
+		if tally.IsEmpty() {
+			delete(agg.Spells, spellID)
+		} else {
+			agg.Spells[spellID] = tally
+		}
+	}
+
+	// 2. 里程碑
+	for i, ref := range agg.Milestones {
+		if ref.VoteID == originalID {
+			agg.Milestones = append(agg.Milestones[:i], agg.Milestones[i+1:]...)
+			break
+		}
+	}
+
+	// 3. 只能依靠 Last 精确抵消的部分
+	if agg.Last == nil || agg.Last.VoteID != originalID {
+		slog.Warn("撤销事件对应的投票不是用户最近处理的投票，聚合数据可能不精确", logging.VoteID(undo.ID), slog.Uint64("undo_of_id", uint64(originalID)))
+		agg.Last = nil
+		return
+	}
+	if agg.Days[agg.Last.Day] > 1 {
+		agg.Days[agg.Last.Day]--
+	} else {
+		delete(agg.Days, agg.Last.Day)
+	}
+	if agg.Last.Consistent {
+		agg.Consistent--
+	}
+	if agg.Last.SubversiveChanged {
+		agg.Subversive = agg.Last.PrevSubversive
+	}
+	agg.Last = nil
+}
+
+// currentSpellRanks 从Redis读取当前的法术排名（1-based）
+func currentSpellRanks() (map[string]int, error) {
+	spellIDs, err := activeStore.spellRanking()
+	if err != nil {
+		return nil, fmt.Errorf("无法从Redis获取法术排名: %w", err)
+	}
+	spellRank := make(map[string]int, len(spellIDs))
+	for i, spellID := range spellIDs {
+		spellRank[spellID] = i + 1
+	}
+	return spellRank, nil
+}
+
+// aggregateBatchSize 是重新计算聚合数据时每批读取的投票数
+const aggregateBatchSize = 10000
+
+// computeUserAggregates 从用户截至asOfVoteID的有效投票（包括已归档的投票）重新计算聚合数据。
+// 历史排名无从得知，一致性与颠覆性按传入的当前排名评估。
+func computeUserAggregates(tx *gorm.DB, userID string, asOfVoteID uint, spellRank map[string]int) (user.UserAggregates, error) {
+	var agg user.UserAggregates
+	if asOfVoteID == 0 {
+		return agg, nil
+	}
+	count := 0
+	err := forEachVote(tx, VoteFilter{UserID: userID, UpToID: asOfVoteID}, aggregateBatchSize, func(votes []Vote) error {
+		for _, vote := range votes {
+			if isEffectiveAsOf(vote, asOfVoteID) {
+				count++
+				applyVoteToAggregates(&agg, vote, count, spellRank)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return agg, fmt.Errorf("查询用户 %s 的投票历史失败: %w", userID, err)
+	}
+	return agg, nil
+}
+
+// backfillUserAggregates 为尚没有聚合数据的用户（如升级前的快照）从投票历史中补齐，
+// 结果以上次快照为准写回SQLite和Redis，之后的增量投票由 ApplyIncrementalVotes 计入。
+// 注意：此函数不包含锁，只应在单线程的启动流程中、user缓存预热之后调用。
+func backfillUserAggregates() error {
+	var userIDs []string
+	if err := database.DB.Model(&user.User{}).Where("aggregates IS NULL OR aggregates = ''").Pluck("uuid", &userIDs).Error; err != nil {
+		return fmt.Errorf("查询缺少聚合数据的用户失败: %w", err)
+	}
+	if len(userIDs) == 0 {
+		return nil
+	}
+	slog.Info("正在回填报告聚合数据...", slog.Int("users", len(userIDs)))
+
+	snapshotVoteID, err := metadata.GetLastSnapshotVoteID(database.DB)
+	if err != nil {
+		return fmt.Errorf("无法获取上一次快照的vote ID: %w", err)
+	}
+	spellRank, err := currentSpellRanks()
+	if err != nil {
+		return err
+	}
+
+	for _, userID := range userIDs {
+		agg, err := computeUserAggregates(database.DB, userID, snapshotVoteID, spellRank)
+		if err != nil {
+			return err
+		}
+		aggJSON, _ := json.Marshal(agg)
+		if err := database.DB.Model(&user.User{}).Where("uuid = ?", userID).Update("aggregates", string(aggJSON)).Error; err != nil {
+			return fmt.Errorf("写入用户 %s 的聚合数据失败: %w", userID, err)
+		}
+		if err := activeStore.setUserAggregates(userID, agg); err != nil {
+			return fmt.Errorf("写入用户 %s 的聚合数据到Redis失败: %w", userID, err)
+		}
+	}
+
+	slog.Info("报告聚合数据回填完成。")
+	return nil
+}
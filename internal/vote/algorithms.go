@@ -65,7 +65,7 @@ func calculateMultiplierForCount(count int64) float64 {
 
 // --- ELO计算 ---
 
-// calculateElo 计算对战后的新ELO分数。
+// calculateElo 计算对战后的新ELO分数，投票应用脚本中的 calculateElo 与它保持一致。
 func calculateElo(winnerScore, loserScore, multiplier float64) (newWinnerScore, newLoserScore float64) {
 	expectedWinner := 1.0 / (1.0 + math.Pow(10, (loserScore-winnerScore)/400.0))
 	newWinnerScore = winnerScore + eloKFactor*(1-expectedWinner)*multiplier
@@ -84,8 +84,8 @@ func calculateEloWeight(total float64) float64 {
 }
 
 // CalculateRankScore 计算最终用于排名的动态分数。
-// 它混合了归一化的ELO分数和原始胜率。
-func CalculateRankScore(tx *eloTrackerTx, score, total, win float64) float64 {
+// 它混合了归一化的ELO分数和原始胜率，投票应用脚本中的 rankScore 与它保持一致。
+func CalculateRankScore(bounds eloBounds, score, total, win float64) float64 {
 	// 1. 根据总场数计算ELO分数的混合权重
 	eloWeight := calculateEloWeight(total)
 
@@ -93,7 +93,7 @@ func CalculateRankScore(tx *eloTrackerTx, score, total, win float64) float64 {
 	var normalizedElo float64
 	if eloWeight > 0.0 {
 		// 获取当前的ELO分数范围
-		minScore, maxScore := globalEloTracker.GetMinMax(tx)
+		minScore, maxScore := bounds.minScore, bounds.maxScore
 
 		if maxScore == minScore {
 			// 如果所有分数都相同，则归一化ELO为0.5
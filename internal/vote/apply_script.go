@@ -0,0 +1,605 @@
+package vote
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/achievement"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/metadata"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/spell"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/user"
+	"github.com/redis/go-redis/v9"
+)
+
+// applyVoteBatchScript 在Redis服务端原子地应用一批按ID连续、升序的投票。
+//
+// 它先读取并校验所需的全部数据，再按顺序逐张计算：用户统计与聚合数据、ELO、胜场、总场次、
+// ELO边界和RankScore（边界变化时重算全部法术），最后写回所有修改并推进检查点。
+// ID不超过检查点的投票已被应用过，会被跳过，因此重复执行同一批投票是安全的；
+// 其余投票必须紧接检查点，否则整批拒绝。校验失败时不会写入任何数据。
+//
+// 计算逻辑与Go中的 calculateElo、CalculateRankScore、newEloBounds、
+// applyVoteToAggregates 和 revertVoteFromAggregates 保持一致，修改时需同步。
+// 浮点数一律以 %.17g 写回，避免Lua默认的14位精度丢失信息。
+//
+// 返回 {应用的投票数, 检查点, 触发ELO边界重建的投票ID, {法术ID, 新总场次, ...}, 聚合数据可能不精确的撤销事件ID}
+var applyVoteBatchScript = redis.NewScript(`
+local statsKey, rankingKey, boundsKey = KEYS[1], KEYS[2], KEYS[3]
+local userStatsKey, userRankingKey, userAggKey = KEYS[4], KEYS[5], KEYS[6]
+local dirtyKey, pendingKey = KEYS[7], KEYS[8]
+local totalVotesKey, checkpointKey = KEYS[9], KEYS[10]
+
+local votes = cjson.decode(ARGV[1])
+local totalStatsField = ARGV[2]
+local kFactor = tonumber(ARGV[3])
+local weightBase = tonumber(ARGV[4])
+local weightDecay = tonumber(ARGV[5])
+local milestones = {}
+for _, n in ipairs(cjson.decode(ARGV[6])) do
+  milestones[n] = true
+end
+
+local function num(x)
+  return string.format('%.17g', x)
+end
+
+local function isWins(v)
+  return v.r == 'A_WINS' or v.r == 'B_WINS'
+end
+
+-- 1. 校验检查点，只保留尚未应用的投票
+local checkpoint = tonumber(redis.call('GET', checkpointKey))
+if checkpoint == nil then
+  return redis.error_reply('投票处理检查点不存在')
+end
+if redis.call('EXISTS', boundsKey) == 0 then
+  return redis.error_reply('ELO边界不存在，缓存可能正在重建')
+end
+
+local pending = {}
+for _, v in ipairs(votes) do
+  if v.id > checkpoint then
+    if v.id ~= checkpoint + #pending + 1 then
+      return redis.error_reply(string.format('投票 %d 与检查点 %d 不连续', v.id, checkpoint))
+    end
+    table.insert(pending, v)
+  end
+end
+if #pending == 0 then
+  return {0, string.format('%d', checkpoint), {}, {}, {}}
+end
+
+-- 2. 读取并校验所需的全部数据，此阶段不写入任何数据
+local spells = {}
+local hasSpellVotes = false
+for _, v in ipairs(pending) do
+  if v.r ~= 'SKIP' then
+    hasSpellVotes = true
+  end
+end
+if hasSpellVotes then
+  local flat = redis.call('HGETALL', statsKey)
+  for i = 1, #flat, 2 do
+    spells[flat[i]] = cjson.decode(flat[i + 1])
+  end
+  for _, v in ipairs(pending) do
+    if v.r ~= 'SKIP' and (spells[v.a] == nil or spells[v.b] == nil) then
+      return redis.error_reply(string.format('法术对 (%s , %s) 的统计数据不存在', v.a, v.b))
+    end
+  end
+end
+
+local rawBounds = redis.call('HMGET', boundsKey, 'min', 'minCount', 'max', 'maxCount')
+local bounds = {
+  min = tonumber(rawBounds[1]), minCount = tonumber(rawBounds[2]),
+  max = tonumber(rawBounds[3]), maxCount = tonumber(rawBounds[4]),
+}
+
+local rawTotal = redis.call('HGET', userStatsKey, totalStatsField)
+if not rawTotal then
+  return redis.error_reply('用户总统计数据不存在')
+end
+local totalStats = cjson.decode(rawTotal)
+
+local userStats, userAggs, userIDs = {}, {}, {}
+for _, v in ipairs(pending) do
+  if v.u ~= '' and userStats[v.u] == nil then
+    local raw = redis.call('HGET', userStatsKey, v.u)
+    if raw then
+      userStats[v.u] = cjson.decode(raw)
+    else
+      userStats[v.u] = {}
+    end
+    raw = redis.call('HGET', userAggKey, v.u)
+    if raw then
+      userAggs[v.u] = cjson.decode(raw)
+    else
+      userAggs[v.u] = {}
+    end
+    table.insert(userIDs, v.u)
+  end
+end
+
+-- 3. 计算函数
+local function updateStats(stats, v)
+  local delta = 1
+  if v.undo ~= 0 then
+    delta = -1
+  end
+  if isWins(v) then
+    stats.wins = (stats.wins or 0) + delta
+  elseif v.r == 'DRAW' then
+    stats.draw = (stats.draw or 0) + delta
+  elseif v.r == 'SKIP' then
+    stats.skip = (stats.skip or 0) + delta
+  end
+end
+
+local function calculateElo(winner, loser, m)
+  local expected = 1 / (1 + 10 ^ ((loser - winner) / 400))
+  return winner + kFactor * (1 - expected) * m, loser - kFactor * expected * m
+end
+
+local function applyVoteToSpells(a, b, v)
+  local m = v.m
+  if v.r == 'A_WINS' then
+    a.score, b.score = calculateElo(a.score, b.score, m)
+    a.win = a.win + m
+    a.total = a.total + m
+    b.total = b.total + m
+  elseif v.r == 'B_WINS' then
+    b.score, a.score = calculateElo(b.score, a.score, m)
+    b.win = b.win + m
+    b.total = b.total + m
+    a.total = a.total + m
+  elseif v.r == 'DRAW' then
+    a.total = a.total + m
+    b.total = b.total + m
+  end
+end
+
+local function rankScore(s)
+  local weight = math.max(0, math.min(1, weightBase - weightDecay * s.total))
+  local normalized = 0
+  if weight > 0 then
+    if bounds.max == bounds.min then
+      normalized = 0.5
+    else
+      normalized = (s.score - bounds.min) / (bounds.max - bounds.min)
+    end
+  end
+  local winRate = 0
+  if weight < 1 then
+    if s.total == 0 then
+      winRate = 0.5
+    else
+      winRate = s.win / s.total
+    end
+  end
+  return normalized * weight + winRate * (1 - weight)
+end
+
+-- trackScore 在一个法术的分数变化后更新ELO边界，返回边界是否变化（需要全局重算）
+local function trackScore(old, new)
+  if old == new then
+    return false
+  end
+  if old == bounds.min then
+    bounds.minCount = bounds.minCount - 1
+    if bounds.minCount == 0 then
+      return true
+    end
+  elseif old == bounds.max then
+    bounds.maxCount = bounds.maxCount - 1
+    if bounds.maxCount == 0 then
+      return true
+    end
+  end
+  if new < bounds.min then
+    return true
+  elseif new == bounds.min then
+    bounds.minCount = bounds.minCount + 1
+  elseif new > bounds.max then
+    return true
+  elseif new == bounds.max then
+    bounds.maxCount = bounds.maxCount + 1
+  end
+  return false
+end
+
+local function resetBounds()
+  bounds = nil
+  for _, s in pairs(spells) do
+    local score = s.score
+    if bounds == nil then
+      bounds = {min = score, minCount = 0, max = score, maxCount = 0}
+    end
+    if score < bounds.min then
+      bounds.min = score
+      bounds.minCount = 1
+    else
+      if score == bounds.min then
+        bounds.minCount = bounds.minCount + 1
+      end
+      if score == bounds.max then
+        bounds.maxCount = bounds.maxCount + 1
+      elseif score > bounds.max then
+        bounds.max = score
+        bounds.maxCount = 1
+      end
+    end
+  end
+end
+
+local function addTally(tally, field, delta)
+  local value = (tally[field] or 0) + delta
+  if value == 0 then
+    value = nil
+  end
+  tally[field] = value
+end
+
+local function tallyIsEmpty(tally)
+  return tally.w == nil and tally.b == nil and tally.d == nil and (tally.f == nil or tally.f.id == 0)
+end
+
+local function tallyVote(tallyA, tallyB, v, delta)
+  if v.r == 'A_WINS' then
+    addTally(tallyA, 'w', delta)
+    addTally(tallyB, 'b', delta)
+  elseif v.r == 'B_WINS' then
+    addTally(tallyB, 'w', delta)
+    addTally(tallyA, 'b', delta)
+  elseif v.r == 'DRAW' then
+    addTally(tallyA, 'd', delta)
+    addTally(tallyB, 'd', delta)
+  end
+end
+
+-- revertAgg 抵消撤销事件所撤销的投票，返回聚合数据是否被精确还原
+local function revertAgg(agg, undo)
+  local originalID = undo.undo
+  agg.spells = agg.spells or {}
+
+  local tallyA = agg.spells[undo.a] or {}
+  local tallyB = agg.spells[undo.b] or {}
+  tallyVote(tallyA, tallyB, undo, -1)
+  for spellID, tally in pairs({[undo.a] = tallyA, [undo.b] = tallyB}) do
+    if tally.f ~= nil and tally.f.id == originalID then
+      tally.f = {n = 0, id = 0}
+    end
+    if tallyIsEmpty(tally) then
+      agg.spells[spellID] = nil
+    else
+      agg.spells[spellID] = tally
+    end
+  end
+
+  if agg.milestones ~= nil then
+    for i, ref in ipairs(agg.milestones) do
+      if ref.id == originalID then
+        table.remove(agg.milestones, i)
+        break
+      end
+    end
+  end
+
+  local last = agg.last
+  agg.last = nil
+  if last == nil or last.id ~= originalID then
+    return false
+  end
+  agg.days = agg.days or {}
+  if (agg.days[last.day] or 0) > 1 then
+    agg.days[last.day] = agg.days[last.day] - 1
+  else
+    agg.days[last.day] = nil
+  end
+  if last.consistent then
+    agg.consistent = (agg.consistent or 0) - 1
+  end
+  if last.subversiveChanged then
+    agg.subversive = last.prevSubversive
+  end
+  return true
+end
+
+-- applyAgg 将一次投票计入用户的聚合数据，winnerRank和loserRank是处理这张投票之前的社区排名
+local function applyAgg(agg, v, voteNumber, winnerRank, loserRank)
+  if v.undo ~= 0 then
+    return revertAgg(agg, v)
+  end
+  agg.spells = agg.spells or {}
+  agg.days = agg.days or {}
+
+  -- 每处引用都使用独立的表，共享的表会被部分cjson实现误判为循环引用
+  local function ref()
+    return {n = voteNumber, id = v.id}
+  end
+  local effect = {id = v.id, day = v.day}
+
+  -- a. 法术计数与首次遭遇
+  local tallyA = agg.spells[v.a] or {}
+  local tallyB = agg.spells[v.b] or {}
+  tallyVote(tallyA, tallyB, v, 1)
+  if tallyA.f == nil or tallyA.f.id == 0 then
+    tallyA.f = ref()
+  end
+  if tallyB.f == nil or tallyB.f.id == 0 then
+    tallyB.f = ref()
+  end
+  agg.spells[v.a] = tallyA
+  agg.spells[v.b] = tallyB
+
+  -- b. 每日计数与里程碑
+  agg.days[v.day] = (agg.days[v.day] or 0) + 1
+  if milestones[voteNumber] then
+    agg.milestones = agg.milestones or {}
+    table.insert(agg.milestones, ref())
+  end
+
+  -- c. 依赖处理时排名的一致性与颠覆性
+  if winnerRank and loserRank then
+    if winnerRank < loserRank then
+      agg.consistent = (agg.consistent or 0) + 1
+      effect.consistent = true
+    end
+    local rankDiff = winnerRank - loserRank
+    if rankDiff > 0 and (agg.subversive == nil or rankDiff > agg.subversive.diff) then
+      effect.subversiveChanged = true
+      effect.prevSubversive = agg.subversive
+      agg.subversive = {n = voteNumber, id = v.id, diff = rankDiff}
+    end
+  end
+
+  agg.last = effect
+  return true
+end
+
+-- encodeAgg 编码聚合数据；cjson会把空表编码为 {}，空的集合需要省略，与Go的omitempty一致
+local function encodeAgg(agg)
+  if agg.spells ~= nil and next(agg.spells) == nil then
+    agg.spells = nil
+  end
+  if agg.days ~= nil and next(agg.days) == nil then
+    agg.days = nil
+  end
+  if agg.milestones ~= nil and #agg.milestones == 0 then
+    agg.milestones = nil
+  end
+  agg.consistent = agg.consistent or 0
+  return cjson.encode(agg)
+end
+
+local function zaddSpells(ids)
+  for i = 1, #ids, 500 do
+    local args = {}
+    for j = i, math.min(i + 499, #ids) do
+      table.insert(args, num(spells[ids[j]].rankScore))
+      table.insert(args, ids[j])
+    end
+    redis.call('ZADD', rankingKey, unpack(args))
+  end
+end
+
+-- 4. 按顺序逐张应用投票；排名需要逐票更新，以便之后的投票按处理时的排名评估
+local touched, totalChanged = {}, {}
+local boundaryVotes, impreciseUndos = {}, {}
+local totalVotesIncrement = 0
+
+for _, v in ipairs(pending) do
+  -- a. 用户统计与聚合数据，聚合数据按这张投票被处理之前的排名评估
+  updateStats(totalStats, v)
+  if v.u ~= '' then
+    local stats = userStats[v.u]
+    updateStats(stats, v)
+    -- 推进用户的投票版本，使其报告缓存失效
+    stats.lastVoteId = v.id
+
+    local winnerRank, loserRank
+    if v.undo == 0 and isWins(v) then
+      local winnerID, loserID = v.a, v.b
+      if v.r == 'B_WINS' then
+        winnerID, loserID = v.b, v.a
+      end
+      local rawWinner = redis.call('ZREVRANK', rankingKey, winnerID)
+      local rawLoser = redis.call('ZREVRANK', rankingKey, loserID)
+      if rawWinner and rawLoser then
+        winnerRank = rawWinner + 1
+        loserRank = rawLoser + 1
+      end
+    end
+    local voteNumber = (stats.wins or 0) + (stats.draw or 0) + (stats.skip or 0)
+    if not applyAgg(userAggs[v.u], v, voteNumber, winnerRank, loserRank) then
+      table.insert(impreciseUndos, v.id)
+    end
+  end
+
+  if v.r ~= 'SKIP' then
+    -- b. 计算新的ELO, Win, Total
+    local a, b = spells[v.a], spells[v.b]
+    local oldA, oldB = a.score, b.score
+    applyVoteToSpells(a, b, v)
+    totalChanged[v.a], totalChanged[v.b] = true, true
+    totalVotesIncrement = totalVotesIncrement + v.m
+
+    -- c. 检查ELO边界是否变化，并选择性地更新或全局重算RankScore
+    if trackScore(oldA, a.score) or trackScore(oldB, b.score) then
+      table.insert(boundaryVotes, v.id)
+      resetBounds()
+      local ids = {}
+      for id, s in pairs(spells) do
+        s.rankScore = rankScore(s)
+        touched[id] = true
+        table.insert(ids, id)
+      end
+      zaddSpells(ids)
+    else
+      a.rankScore = rankScore(a)
+      b.rankScore = rankScore(b)
+      touched[v.a], touched[v.b] = true, true
+      zaddSpells({v.a, v.b})
+    end
+  end
+end
+
+-- 5. 写回其余的修改并推进检查点
+for id in pairs(touched) do
+  local s = spells[id]
+  redis.call('HSET', statsKey, id, string.format('{"score":%.17g,"total":%.17g,"win":%.17g,"rankScore":%.17g}', s.score, s.total, s.win, s.rankScore))
+end
+if hasSpellVotes then
+  redis.call('HSET', boundsKey, 'min', num(bounds.min), 'minCount', string.format('%d', bounds.minCount),
+    'max', num(bounds.max), 'maxCount', string.format('%d', bounds.maxCount))
+  redis.call('INCRBYFLOAT', totalVotesKey, num(totalVotesIncrement))
+end
+
+redis.call('HSET', userStatsKey, totalStatsField, cjson.encode(totalStats))
+for _, id in ipairs(userIDs) do
+  local stats = userStats[id]
+  stats.wins, stats.draw, stats.skip = stats.wins or 0, stats.draw or 0, stats.skip or 0
+  redis.call('HSET', userStatsKey, id, cjson.encode(stats))
+  redis.call('HSET', userAggKey, id, encodeAgg(userAggs[id]))
+  -- 更新用户排名，标记用户为“脏”用于增量备份，并标记用户待评估成就
+  redis.call('ZADD', userRankingKey, string.format('%d', stats.wins + stats.draw + stats.skip), id)
+  redis.call('SADD', dirtyKey, id)
+  redis.call('SADD', pendingKey, id)
+end
+
+local lastID = pending[#pending].id
+redis.call('SET', checkpointKey, string.format('%d', lastID))
+
+local totals = {}
+for id in pairs(totalChanged) do
+  table.insert(totals, id)
+  table.insert(totals, num(spells[id].total))
+end
+return {#pending, string.format('%d', lastID), boundaryVotes, totals, impreciseUndos}
+`)
+
+var errApplyScriptReply = errors.New("投票应用脚本返回了无法识别的结果")
+
+// scriptVote 是传给 applyVoteBatchScript 的投票，用户已按合并记录解析完毕
+type scriptVote struct {
+	ID         uint       `json:"id"`
+	SpellA     string     `json:"a"`
+	SpellB     string     `json:"b"`
+	Result     VoteResult `json:"r"`
+	Multiplier float64    `json:"m"`
+	User       string     `json:"u"`
+	Day        string     `json:"day"`
+	UndoOfID   uint       `json:"undo"`
+}
+
+// applyScriptResult 是 applyVoteBatchScript 的执行结果
+type applyScriptResult struct {
+	// applied 是本次实际应用的投票数，ID不超过检查点的投票不计入
+	applied    int
+	checkpoint uint
+	// boundaryVotes 是触发ELO边界变化、进而全局重算RankScore的投票
+	boundaryVotes []uint
+	// spellTotals 是这批投票涉及的法术的新总场次，用于更新内存权重树
+	spellTotals map[string]float64
+	// impreciseUndos 是对应投票并非用户最近处理的投票的撤销事件，其用户的聚合数据可能不精确
+	impreciseUndos []uint
+}
+
+// runApplyVoteBatchScript 执行 applyVoteBatchScript
+func runApplyVoteBatchScript(batch []Vote) (applyScriptResult, error) {
+	var result applyScriptResult
+
+	votes := make([]scriptVote, len(batch))
+	for i, vote := range batch {
+		votes[i] = scriptVote{
+			ID:         vote.ID,
+			SpellA:     vote.SpellA_ID,
+			SpellB:     vote.SpellB_ID,
+			Result:     vote.Result,
+			Multiplier: vote.Multiplier,
+			User:       vote.UserIdentifier,
+			Day:        vote.VoteTime.Local().Format(aggregateDayLayout),
+			UndoOfID:   vote.UndoOfID,
+		}
+	}
+	votesJSON, err := json.Marshal(votes)
+	if err != nil {
+		return result, fmt.Errorf("编码投票批次失败: %w", err)
+	}
+	milestonesJSON, _ := json.Marshal(user.MilestoneVoteNumbers)
+
+	keys := []string{
+		spell.StatsKey, spell.RankingKey, EloBoundsKey,
+		user.StatsKey, user.RankingKey, user.AggregatesKey,
+		user.DirtySetKey, achievement.PendingSetKey,
+		metadata.RedisTotalVotesKey, metadata.RedisLastProcessedVoteIDKey,
+	}
+	reply, err := applyVoteBatchScript.Run(database.Ctx, database.RDB, keys,
+		votesJSON, user.TotalStatsKey, eloKFactor, rankScoreEloWeightBase, rankScoreEloWeightDecay, milestonesJSON,
+	).Slice()
+	if err != nil {
+		return result, err
+	}
+	if len(reply) != 5 {
+		return result, errApplyScriptReply
+	}
+
+	applied, ok := reply[0].(int64)
+	if !ok {
+		return result, errApplyScriptReply
+	}
+	result.applied = int(applied)
+	checkpointStr, ok := reply[1].(string)
+	if !ok {
+		return result, errApplyScriptReply
+	}
+	checkpoint, err := strconv.ParseUint(checkpointStr, 10, 64)
+	if err != nil {
+		return result, errApplyScriptReply
+	}
+	result.checkpoint = uint(checkpoint)
+
+	if result.boundaryVotes, err = parseVoteIDs(reply[2]); err != nil {
+		return result, err
+	}
+	if result.impreciseUndos, err = parseVoteIDs(reply[4]); err != nil {
+		return result, err
+	}
+
+	totals, ok := reply[3].([]interface{})
+	if !ok || len(totals)%2 != 0 {
+		return result, errApplyScriptReply
+	}
+	result.spellTotals = make(map[string]float64, len(totals)/2)
+	for i := 0; i < len(totals); i += 2 {
+		id, okID := totals[i].(string)
+		totalStr, okTotal := totals[i+1].(string)
+		if !okID || !okTotal {
+			return result, errApplyScriptReply
+		}
+		total, err := strconv.ParseFloat(totalStr, 64)
+		if err != nil {
+			return result, errApplyScriptReply
+		}
+		result.spellTotals[id] = total
+	}
+	return result, nil
+}
+
+func parseVoteIDs(reply interface{}) ([]uint, error) {
+	items, ok := reply.([]interface{})
+	if !ok {
+		return nil, errApplyScriptReply
+	}
+	ids := make([]uint, 0, len(items))
+	for _, item := range items {
+		id, ok := item.(int64)
+		if !ok {
+			return nil, errApplyScriptReply
+		}
+		ids = append(ids, uint(id))
+	}
+	return ids, nil
+}
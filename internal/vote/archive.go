@@ -0,0 +1,458 @@
+package vote
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/config"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/logging"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/metadata"
+	"gorm.io/gorm"
+)
+
+// --- 数据模型 ---
+
+// archiveEntry 描述了一个归档文件，以JSON形式保存在metadata表中。
+// 归档文件按ID首尾相接：每个文件包含 (上一个文件的LastID, LastID] 内的全部投票。
+type archiveEntry struct {
+	File    string `json:"file"`
+	Month   string `json:"month"` // 文件中第一张投票所在的月份，YYYY-MM
+	FirstID uint   `json:"firstId"`
+	LastID  uint   `json:"lastId"`
+	Count   int    `json:"count"`
+	SHA256  string `json:"sha256"` // 压缩后文件内容的校验和
+}
+
+// archivedVote 是投票在归档文件中的格式，每行一个JSON对象
+type archivedVote struct {
+	ID             uint       `json:"id"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	SpellAID       string     `json:"spellA"`
+	SpellBID       string     `json:"spellB"`
+	Result         VoteResult `json:"result"`
+	UserIdentifier string     `json:"userId,omitempty"`
+	UserIP         string     `json:"ip,omitempty"`
+	Multiplier     float64    `json:"multiplier"`
+	VoteTime       time.Time  `json:"voteTime"`
+	UndoOfID       uint       `json:"undoOf,omitempty"`
+	UndoneByID     uint       `json:"undoneBy,omitempty"`
+}
+
+func toArchivedVote(v Vote) archivedVote {
+	return archivedVote{
+		ID:             v.ID,
+		CreatedAt:      v.CreatedAt,
+		SpellAID:       v.SpellA_ID,
+		SpellBID:       v.SpellB_ID,
+		Result:         v.Result,
+		UserIdentifier: v.UserIdentifier,
+		UserIP:         v.UserIP,
+		Multiplier:     v.Multiplier,
+		VoteTime:       v.VoteTime,
+		UndoOfID:       v.UndoOfID,
+		UndoneByID:     v.UndoneByID,
+	}
+}
+
+func (a archivedVote) toVote() Vote {
+	v := Vote{
+		SpellA_ID:      a.SpellAID,
+		SpellB_ID:      a.SpellBID,
+		Result:         a.Result,
+		UserIdentifier: a.UserIdentifier,
+		UserIP:         a.UserIP,
+		Multiplier:     a.Multiplier,
+		VoteTime:       a.VoteTime,
+		UndoOfID:       a.UndoOfID,
+		UndoneByID:     a.UndoneByID,
+	}
+	v.ID = a.ID
+	v.CreatedAt = a.CreatedAt
+	v.UpdatedAt = a.CreatedAt
+	return v
+}
+
+// --- 常量与全局变量 ---
+
+var (
+	archiveEnabled  bool
+	archiveDir      string
+	archiveMinAge   time.Duration
+	archiveInterval time.Duration
+
+	// archiveMutex 串行化所有写入归档文件的操作：归档任务、合并和删除用户时的改写
+	archiveMutex sync.Mutex
+)
+
+func loadArchiveConfig(cfg config.VoteConfig) {
+	archiveEnabled = cfg.Archive.Enabled
+	archiveDir = cfg.Archive.Dir
+	archiveMinAge = cfg.Archive.MinAge
+	archiveInterval = cfg.Archive.Interval
+}
+
+// --- 清单 ---
+
+// loadArchiveEntries 从metadata读取所有归档文件的描述，按ID升序排列
+func loadArchiveEntries(db *gorm.DB) ([]archiveEntry, error) {
+	values, err := metadata.GetValuesWithPrefix(db, metadata.ArchiveFileKeyPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("读取归档清单失败: %w", err)
+	}
+	entries := make([]archiveEntry, 0, len(values))
+	for _, value := range values {
+		var entry archiveEntry
+		if err := json.Unmarshal([]byte(value), &entry); err != nil {
+			return nil, fmt.Errorf("解析归档清单时出错: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// saveArchiveEntry 在metadata中记录一个归档文件，同一段ID的旧记录会被覆盖
+func saveArchiveEntry(tx *gorm.DB, entry archiveEntry) error {
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	key := fmt.Sprintf("%s%010d", metadata.ArchiveFileKeyPrefix, entry.FirstID)
+	if err := metadata.SetValue(tx, key, string(value)); err != nil {
+		return fmt.Errorf("记录归档文件 %s 失败: %w", entry.File, err)
+	}
+	return nil
+}
+
+// --- 文件读写 ---
+
+// writeArchiveFile 把投票写入一个新的压缩归档文件，文件名包含校验和的前缀，因此改写后的文件不会覆盖原文件。
+// votes 必须按ID升序排列。返回的描述尚未记录到metadata中。
+func writeArchiveFile(month string, firstID, lastID uint, votes []Vote) (archiveEntry, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	encoder := json.NewEncoder(zw)
+	for _, v := range votes {
+		if err := encoder.Encode(toArchivedVote(v)); err != nil {
+			return archiveEntry{}, fmt.Errorf("编码归档投票 %d 失败: %w", v.ID, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return archiveEntry{}, fmt.Errorf("压缩归档文件失败: %w", err)
+	}
+
+	sum := sha256.Sum256(buf.Bytes())
+	entry := archiveEntry{
+		File:    fmt.Sprintf("votes-%s-%d-%s.jsonl.gz", month, firstID, hex.EncodeToString(sum[:4])),
+		Month:   month,
+		FirstID: firstID,
+		LastID:  lastID,
+		Count:   len(votes),
+		SHA256:  hex.EncodeToString(sum[:]),
+	}
+
+	// 先写入临时文件再重命名，避免留下写了一半的归档文件
+	if err := os.MkdirAll(archiveDir, 0o755); err != nil {
+		return archiveEntry{}, fmt.Errorf("创建归档目录失败: %w", err)
+	}
+	path := filepath.Join(archiveDir, entry.File)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+		return archiveEntry{}, fmt.Errorf("写入归档文件失败: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return archiveEntry{}, fmt.Errorf("写入归档文件失败: %w", err)
+	}
+
+	// 读回校验，确认文件完整后才允许调用方删除原始投票
+	if _, err := readArchiveFile(entry); err != nil {
+		os.Remove(path)
+		return archiveEntry{}, err
+	}
+	return entry, nil
+}
+
+// readArchiveFile 读取并校验一个归档文件，返回其中按ID升序排列的投票
+func readArchiveFile(entry archiveEntry) ([]Vote, error) {
+	data, err := os.ReadFile(filepath.Join(archiveDir, entry.File))
+	if err != nil {
+		return nil, fmt.Errorf("读取归档文件失败: %w", err)
+	}
+	if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != entry.SHA256 {
+		return nil, fmt.Errorf("归档文件 %s 的校验和不匹配", entry.File)
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("解压归档文件 %s 失败: %w", entry.File, err)
+	}
+	votes := make([]Vote, 0, entry.Count)
+	decoder := json.NewDecoder(bufio.NewReader(zr))
+	for {
+		var a archivedVote
+		if err := decoder.Decode(&a); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("解析归档文件 %s 失败: %w", entry.File, err)
+		}
+		votes = append(votes, a.toVote())
+	}
+	if len(votes) != entry.Count {
+		return nil, fmt.Errorf("归档文件 %s 包含 %d 张投票, 清单记录为 %d 张", entry.File, len(votes), entry.Count)
+	}
+	return votes, nil
+}
+
+// rewriteArchives 对所有归档投票调用change，并把有改动的文件改写为新文件，记录到事务tx中。
+// 返回被替换的原文件，调用方应在事务提交后用 removeArchiveFiles 删除它们；
+// 事务失败时新文件会成为孤立文件，由归档任务清理。调用方需持有archiveMutex。
+func rewriteArchives(tx *gorm.DB, change func(*Vote) bool) ([]string, error) {
+	entries, err := loadArchiveEntries(tx)
+	if err != nil {
+		return nil, err
+	}
+	var replaced []string
+	for _, entry := range entries {
+		votes, err := readArchiveFile(entry)
+		if err != nil {
+			return nil, err
+		}
+		changed := false
+		for i := range votes {
+			if change(&votes[i]) {
+				changed = true
+			}
+		}
+		if !changed {
+			continue
+		}
+		rewritten, err := writeArchiveFile(entry.Month, entry.FirstID, entry.LastID, votes)
+		if err != nil {
+			return nil, err
+		}
+		if err := saveArchiveEntry(tx, rewritten); err != nil {
+			return nil, err
+		}
+		replaced = append(replaced, entry.File)
+	}
+	return replaced, nil
+}
+
+// removeArchiveFiles 删除不再被清单引用的归档文件，失败时只记录日志，文件会在之后作为孤立文件被清理
+func removeArchiveFiles(files []string) {
+	for _, file := range files {
+		if err := os.Remove(filepath.Join(archiveDir, file)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.Warn("删除被替换的归档文件失败", slog.String("file", file), logging.Err(err))
+		}
+	}
+}
+
+// isEffectiveAsOf 是 EffectiveVotesAsOf 在Go中的等价判断，用于从归档文件读出的投票
+func isEffectiveAsOf(v Vote, asOfVoteID uint) bool {
+	return v.UndoOfID == 0 && (v.UndoneByID == 0 || (asOfVoteID != 0 && v.UndoneByID > asOfVoteID))
+}
+
+// VerifyArchives 校验db的归档清单引用的每个归档文件都存在且内容完整，返回文件数。
+// 用于在恢复备份前确认备份中的归档清单仍然可用。
+func VerifyArchives(db *gorm.DB) (int, error) {
+	entries, err := loadArchiveEntries(db)
+	if err != nil {
+		return 0, err
+	}
+	for _, entry := range entries {
+		if _, err := readArchiveFile(entry); err != nil {
+			return 0, err
+		}
+	}
+	return len(entries), nil
+}
+
+// --- 透明读取 ---
+
+// VoteFilter 描述了需要读取的投票，同时作用于归档文件和votes表
+type VoteFilter struct {
+	// UserID 不为空时只读取该用户的投票
+	UserID string
+	// AfterID 表示只读取ID大于它的投票
+	AfterID uint
+	// UpToID 不为0时只读取ID不超过它的投票
+	UpToID uint
+}
+
+func (f VoteFilter) match(v Vote) bool {
+	return v.ID > f.AfterID && (f.UpToID == 0 || v.ID <= f.UpToID) && (f.UserID == "" || v.UserIdentifier == f.UserID)
+}
+
+func (f VoteFilter) apply(db *gorm.DB) *gorm.DB {
+	db = db.Where("id > ?", f.AfterID)
+	if f.UpToID != 0 {
+		db = db.Where("id <= ?", f.UpToID)
+	}
+	if f.UserID != "" {
+		db = db.Where("user_identifier = ?", f.UserID)
+	}
+	return db
+}
+
+// voteCursor 按ID升序分批读取投票：ID不超过归档水位的投票来自归档文件，其余来自votes表。
+type voteCursor struct {
+	db        *gorm.DB
+	filter    VoteFilter
+	entries   []archiveEntry // 尚未读取的归档文件
+	throughID uint           // 归档水位
+	pending   []Vote         // 已从归档文件读出、尚未返回的投票
+}
+
+// newVoteCursor 创建一个读取游标。db可以是一个事务，游标会看到事务中对归档清单的改动。
+func newVoteCursor(db *gorm.DB, filter VoteFilter) (*voteCursor, error) {
+	throughID, err := metadata.GetArchivedThroughVoteID(db)
+	if err != nil {
+		return nil, fmt.Errorf("无法获取归档水位: %w", err)
+	}
+	c := &voteCursor{db: db, filter: filter, throughID: throughID}
+	if filter.AfterID < throughID {
+		entries, err := loadArchiveEntries(db)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.LastID > filter.AfterID && (filter.UpToID == 0 || entry.FirstID <= filter.UpToID) {
+				c.entries = append(c.entries, entry)
+			}
+		}
+	}
+	return c, nil
+}
+
+// next 返回接下来最多limit张投票，返回空切片时表示读取完毕
+func (c *voteCursor) next(limit int) ([]Vote, error) {
+	// 1. 先读完所有相关的归档文件
+	for len(c.pending) == 0 && len(c.entries) > 0 {
+		votes, err := readArchiveFile(c.entries[0])
+		if err != nil {
+			return nil, err
+		}
+		c.entries = c.entries[1:]
+		for _, v := range votes {
+			if c.filter.match(v) {
+				c.pending = append(c.pending, v)
+			}
+		}
+	}
+	if len(c.pending) > 0 {
+		n := min(limit, len(c.pending))
+		batch := c.pending[:n:n]
+		c.pending = c.pending[n:]
+		c.filter.AfterID = batch[n-1].ID
+		return batch, nil
+	}
+
+	// 2. 再读取votes表中水位之后的投票
+	c.filter.AfterID = max(c.filter.AfterID, c.throughID)
+	var batch []Vote
+	if err := c.filter.apply(c.db).Order("id asc").Limit(limit).Find(&batch).Error; err != nil {
+		return nil, fmt.Errorf("读取投票失败 (id > %d): %w", c.filter.AfterID, err)
+	}
+	if len(batch) > 0 {
+		c.filter.AfterID = batch[len(batch)-1].ID
+	}
+	return batch, nil
+}
+
+// ForEachVote 按ID升序分批遍历满足条件的投票，调用方无需关心投票是否已被归档。
+// fn返回错误时停止遍历。
+func ForEachVote(filter VoteFilter, batchSize int, fn func([]Vote) error) error {
+	return forEachVote(database.DB, filter, batchSize, fn)
+}
+
+// forEachVote 与 ForEachVote 相同，但在给定的数据库连接或事务中读取
+func forEachVote(db *gorm.DB, filter VoteFilter, batchSize int, fn func([]Vote) error) error {
+	cursor, err := newVoteCursor(db, filter)
+	if err != nil {
+		return err
+	}
+	for {
+		batch, err := cursor.next(batchSize)
+		if err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := fn(batch); err != nil {
+			return err
+		}
+	}
+}
+
+// FindVotes 按ID查询投票，已归档的投票从归档文件中读取。找不到的ID会被忽略。
+func FindVotes(ids []uint) (map[uint]Vote, error) {
+	found := make(map[uint]Vote, len(ids))
+	if len(ids) == 0 {
+		return found, nil
+	}
+
+	throughID, err := metadata.GetArchivedThroughVoteID(database.DB)
+	if err != nil {
+		return nil, fmt.Errorf("无法获取归档水位: %w", err)
+	}
+	var live, archived []uint
+	for _, id := range ids {
+		if id > throughID {
+			live = append(live, id)
+		} else {
+			archived = append(archived, id)
+		}
+	}
+
+	if len(live) > 0 {
+		var votes []Vote
+		if err := database.DB.Where("id IN ?", live).Find(&votes).Error; err != nil {
+			return nil, fmt.Errorf("查询投票失败: %w", err)
+		}
+		for _, v := range votes {
+			found[v.ID] = v
+		}
+	}
+
+	if len(archived) > 0 {
+		entries, err := loadArchiveEntries(database.DB)
+		if err != nil {
+			return nil, err
+		}
+		sort.Slice(archived, func(i, j int) bool { return archived[i] < archived[j] })
+		for _, entry := range entries {
+			// 只读取包含所需ID的文件
+			i := sort.Search(len(archived), func(i int) bool { return archived[i] >= entry.FirstID })
+			if i == len(archived) || archived[i] > entry.LastID {
+				continue
+			}
+			votes, err := readArchiveFile(entry)
+			if err != nil {
+				return nil, err
+			}
+			for _, v := range votes {
+				j := sort.Search(len(archived), func(j int) bool { return archived[j] >= v.ID })
+				if j < len(archived) && archived[j] == v.ID {
+					found[v.ID] = v
+				}
+			}
+		}
+	}
+	return found, nil
+}
@@ -0,0 +1,257 @@
+package vote
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/achievement"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/metadata"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/spell"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/user"
+)
+
+// useTestArchiveDir 让归档文件写入测试的临时目录
+func useTestArchiveDir(t *testing.T) {
+	t.Helper()
+	archiveDir = t.TempDir()
+	archiveMinAge = 24 * time.Hour
+}
+
+// insertTestVote 直接向votes表写入一张指定时间的投票
+func insertTestVote(t *testing.T, userID, spellA, spellB string, result VoteResult, voteTime time.Time) Vote {
+	t.Helper()
+	v := Vote{SpellA_ID: spellA, SpellB_ID: spellB, Result: result, UserIdentifier: userID, UserIP: testIP, Multiplier: 1, VoteTime: voteTime}
+	if err := database.DB.Create(&v).Error; err != nil {
+		t.Fatalf("写入投票失败: %v", err)
+	}
+	return v
+}
+
+// collectVotes 通过 ForEachVote 读取满足条件的全部投票ID
+func collectVotes(t *testing.T, filter VoteFilter) []uint {
+	t.Helper()
+	var ids []uint
+	err := ForEachVote(filter, 2, func(batch []Vote) error {
+		for _, v := range batch {
+			ids = append(ids, v.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("读取投票失败: %v", err)
+	}
+	return ids
+}
+
+func TestArchiveOldVotes(t *testing.T) {
+	setupTestEnv(t)
+	useTestArchiveDir(t)
+	alice, bob := newUserID(), newUserID()
+
+	jan := time.Date(2025, time.January, 10, 12, 0, 0, 0, time.UTC)
+	feb := time.Date(2025, time.February, 3, 12, 0, 0, 0, time.UTC)
+	insertTestVote(t, alice, "BOMB", "DIGGER", ResultAWins, jan)
+	insertTestVote(t, bob, "BOMB", "CHAINSAW", ResultBWins, jan)
+	insertTestVote(t, alice, "DIGGER", "CHAINSAW", ResultDraw, jan)
+	insertTestVote(t, bob, "BLACK_HOLE", "DIGGER", ResultAWins, feb)
+	insertTestVote(t, alice, "BLACK_HOLE", "BOMB", ResultSkip, feb)
+	insertTestVote(t, alice, "BOMB", "LIGHT_BULLET", ResultAWins, time.Now())
+
+	// 1. 尚未被快照覆盖的投票不会被归档
+	if err := metadata.SetLastSnapshotVoteID(database.DB, 2); err != nil {
+		t.Fatal(err)
+	}
+	if archived, err := ArchiveOldVotes(time.Now()); err != nil || archived != 0 {
+		t.Fatalf("归档了 %d 张未被快照覆盖的投票 (%v)", archived, err)
+	}
+
+	// 2. 每个月写入一个归档文件，最新的投票始终保留在表中
+	if err := metadata.SetLastSnapshotVoteID(database.DB, 6); err != nil {
+		t.Fatal(err)
+	}
+	archived, err := ArchiveOldVotes(time.Now())
+	if err != nil || archived != 5 {
+		t.Fatalf("归档了 %d 张投票 (%v), 期望 5", archived, err)
+	}
+	var remaining int64
+	database.DB.Model(&Vote{}).Count(&remaining)
+	if remaining != 1 {
+		t.Errorf("votes表中剩余 %d 张投票, 期望 1", remaining)
+	}
+	entries, err := loadArchiveEntries(database.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Month != "2025-01" || entries[0].LastID != 3 || entries[1].FirstID != 4 || entries[1].LastID != 5 {
+		t.Fatalf("归档清单: %+v", entries)
+	}
+	if through, _ := metadata.GetArchivedThroughVoteID(database.DB); through != 5 {
+		t.Errorf("归档水位为 %d, 期望 5", through)
+	}
+
+	// 3. 读取时透明地合并归档文件与votes表
+	if ids := collectVotes(t, VoteFilter{}); len(ids) != 6 || ids[0] != 1 || ids[5] != 6 {
+		t.Errorf("全部投票: %v", ids)
+	}
+	if ids := collectVotes(t, VoteFilter{UserID: alice}); len(ids) != 4 || ids[0] != 1 || ids[1] != 3 || ids[3] != 6 {
+		t.Errorf("alice的投票: %v", ids)
+	}
+	if ids := collectVotes(t, VoteFilter{AfterID: 2, UpToID: 4}); len(ids) != 2 || ids[0] != 3 || ids[1] != 4 {
+		t.Errorf("ID范围内的投票: %v", ids)
+	}
+	found, err := FindVotes([]uint{2, 6, 99})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 2 || found[2].UserIdentifier != bob || found[2].Result != ResultBWins || !found[2].VoteTime.Equal(jan) || found[6].ID != 6 {
+		t.Errorf("按ID查询: %+v", found)
+	}
+
+	// 4. 校验和不匹配的归档文件被拒绝
+	path := filepath.Join(archiveDir, entries[0].File)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xff
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ForEachVote(VoteFilter{}, 10, func([]Vote) error { return nil }); err == nil {
+		t.Error("期望读取被篡改的归档文件时失败")
+	}
+}
+
+func TestIdentityChangesRewriteArchives(t *testing.T) {
+	setupTestEnv(t)
+	useTestArchiveDir(t)
+	if err := database.DB.AutoMigrate(&achievement.Award{}); err != nil {
+		t.Fatal(err)
+	}
+	alice, bob := newUserID(), newUserID()
+
+	old := time.Date(2025, time.March, 1, 0, 0, 0, 0, time.UTC)
+	insertTestVote(t, alice, "BOMB", "DIGGER", ResultAWins, old)
+	insertTestVote(t, bob, "BOMB", "CHAINSAW", ResultAWins, old)
+	insertTestVote(t, bob, "DIGGER", "CHAINSAW", ResultAWins, time.Now())
+	if err := metadata.SetLastSnapshotVoteID(database.DB, 3); err != nil {
+		t.Fatal(err)
+	}
+	if archived, err := ArchiveOldVotes(time.Now()); err != nil || archived != 2 {
+		t.Fatalf("归档了 %d 张投票 (%v), 期望 2", archived, err)
+	}
+	before, _ := loadArchiveEntries(database.DB)
+
+	// 1. 合并用户会改写归档文件中的投票归属，并删除被替换的文件
+	if err := MergeUsers(alice, bob); err != nil {
+		t.Fatal(err)
+	}
+	if ids := collectVotes(t, VoteFilter{UserID: bob}); len(ids) != 3 {
+		t.Errorf("合并后bob的投票: %v", ids)
+	}
+	if ids := collectVotes(t, VoteFilter{UserID: alice}); len(ids) != 0 {
+		t.Errorf("合并后alice仍有投票: %v", ids)
+	}
+	if _, err := os.Stat(filepath.Join(archiveDir, before[0].File)); !os.IsNotExist(err) {
+		t.Errorf("被替换的归档文件没有被删除: %v", err)
+	}
+
+	// 2. 删除用户会匿名化归档文件中的投票
+	if err := ForgetUser(bob); err != nil {
+		t.Fatal(err)
+	}
+	err := ForEachVote(VoteFilter{}, 10, func(batch []Vote) error {
+		for _, v := range batch {
+			if v.UserIdentifier != "" || v.UserIP != "" {
+				t.Errorf("投票 %d 没有被匿名化: %+v", v.ID, v)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 3. 没有被清单引用的文件在保留时长后被清理
+	files, _ := os.ReadDir(archiveDir)
+	if len(files) != 1 {
+		t.Errorf("归档目录中有 %d 个文件, 期望 1", len(files))
+	}
+	orphan := filepath.Join(archiveDir, "votes-2025-03-1-deadbeef.jsonl.gz")
+	if err := os.WriteFile(orphan, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := removeOrphanArchiveFiles(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(orphan); err != nil {
+		t.Error("保留时长内的孤立文件被删除了")
+	}
+	if err := removeOrphanArchiveFiles(time.Now().Add(2 * orphanArchiveGrace)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Error("孤立的归档文件没有被清理")
+	}
+	if files, _ := os.ReadDir(archiveDir); len(files) != 1 {
+		t.Errorf("清理后归档目录中有 %d 个文件, 期望 1", len(files))
+	}
+}
+
+// TestRebuildReplaysArchivedVotes 验证快照早于归档水位时，缓存重建会从归档文件回放投票
+func TestRebuildReplaysArchivedVotes(t *testing.T) {
+	setupTestEnv(t)
+	useTestArchiveDir(t)
+	alice := newUserID()
+
+	submitVote(t, alice, "BOMB", "LIGHT_BULLET", ResultAWins)
+	submitVote(t, alice, "BLACK_HOLE", "BOMB", ResultAWins)
+	submitVote(t, alice, "CHAINSAW", "DIGGER", ResultDraw)
+	submitVote(t, alice, "BOMB", "DIGGER", ResultAWins)
+	processQueuedVotes(t)
+	liveSpells := mustSpellStats(t)
+	liveUser := mustUserStats(t, alice)
+
+	// 1. 把除最新一张以外的投票归档
+	if err := metadata.SetLastSnapshotVoteID(database.DB, 4); err != nil {
+		t.Fatal(err)
+	}
+	if archived, err := ArchiveOldVotes(time.Now().AddDate(1, 0, 0)); err != nil || archived != 3 {
+		t.Fatalf("归档了 %d 张投票 (%v), 期望 3", archived, err)
+	}
+
+	// 2. 快照回到归档之前（如从旧备份恢复），重建缓存
+	if err := metadata.SetLastSnapshotVoteID(database.DB, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := InvalidateEloBounds(); err != nil {
+		t.Fatal(err)
+	}
+	if err := metadata.WarmupCache(); err != nil {
+		t.Fatal(err)
+	}
+	if err := spell.WarmupCache(); err != nil {
+		t.Fatal(err)
+	}
+	if err := user.WarmupCache(); err != nil {
+		t.Fatal(err)
+	}
+	if err := RebuildAndApplyVotes(); err != nil {
+		t.Fatal(err)
+	}
+
+	rebuiltSpells := mustSpellStats(t)
+	for id, live := range liveSpells {
+		rebuilt := rebuiltSpells[id]
+		if rebuilt.Total != live.Total || rebuilt.Win != live.Win || math.Abs(rebuilt.Score-live.Score) > 1e-9 {
+			t.Errorf("%s: 重建后 %+v, 实时处理 %+v", id, rebuilt, live)
+		}
+	}
+	if got := mustUserStats(t, alice); got != liveUser {
+		t.Errorf("用户统计: 重建后 %+v, 实时处理 %+v", got, liveUser)
+	}
+}
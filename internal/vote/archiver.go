@@ -0,0 +1,197 @@
+package vote
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/logging"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/metadata"
+	"github.com/SlpAus/noita-spells-tier-backend/pkg/lifecycle"
+	"gorm.io/gorm"
+)
+
+const (
+	// archiveReadBatchSize 是归档时每次从votes表读取的投票数
+	archiveReadBatchSize = 10000
+	// orphanArchiveGrace 是未被清单引用的归档文件被删除前的保留时长，
+	// 避免删除其他实例刚写入、尚未提交到metadata的文件
+	orphanArchiveGrace = time.Hour
+)
+
+// StartArchiver 启动一个后台Goroutine，定期把旧投票从votes表移入归档文件。
+// 接收一个lifecycle.Handle来管理其生命周期。
+func StartArchiver(handle *lifecycle.Handle) {
+	defer handle.Close()
+	slog.Info("投票归档任务已启动。", slog.String("dir", archiveDir))
+
+	for {
+		archived, err := ArchiveOldVotes(time.Now())
+		if err != nil {
+			slog.Error("投票归档任务错误", logging.Err(err))
+		} else if archived > 0 {
+			slog.Info("投票归档任务: 已归档旧投票。", slog.Int("votes", archived))
+		}
+		if err := removeOrphanArchiveFiles(time.Now()); err != nil {
+			slog.Warn("投票归档任务: 清理孤立的归档文件失败", logging.Err(err))
+		}
+
+		if err := handle.Sleep(archiveInterval); err != nil {
+			slog.Info("投票归档任务: 休眠被中断，正在关闭...")
+			return
+		}
+	}
+}
+
+// ArchiveOldVotes 把所有满足条件的自然月逐一归档，返回归档的投票数。
+// 一个月的投票只有在该月结束后经过archiveMinAge、并且全部被快照覆盖时才会被归档。
+func ArchiveOldVotes(now time.Time) (int, error) {
+	total := 0
+	for {
+		archived, err := archiveNextMonth(now)
+		if err != nil {
+			return total, err
+		}
+		if archived == 0 {
+			return total, nil
+		}
+		total += archived
+	}
+}
+
+// archiveNextMonth 归档votes表中最早的一个月，没有可归档的投票时返回0
+func archiveNextMonth(now time.Time) (int, error) {
+	archiveMutex.Lock()
+	defer archiveMutex.Unlock()
+
+	// 1. 以votes表中ID最小的投票确定要归档的月份
+	var first Vote
+	err := database.DB.Order("id asc").Limit(1).Find(&first).Error
+	if err != nil {
+		return 0, fmt.Errorf("读取最早的投票失败: %w", err)
+	}
+	if first.ID == 0 {
+		return 0, nil
+	}
+	monthStart := time.Date(first.VoteTime.Year(), first.VoteTime.Month(), 1, 0, 0, 0, 0, time.UTC)
+	monthEnd := monthStart.AddDate(0, 1, 0)
+	if monthEnd.After(now.Add(-archiveMinAge)) {
+		return 0, nil
+	}
+
+	// 2. 确定本次归档的ID范围，并确认它可以安全地移出votes表
+	var lastID uint
+	if err := database.DB.Model(&Vote{}).Select("COALESCE(MAX(id), 0)").Where("vote_time < ?", monthEnd).Scan(&lastID).Error; err != nil {
+		return 0, fmt.Errorf("确定归档范围失败: %w", err)
+	}
+	snapshotVoteID, err := metadata.GetLastSnapshotVoteID(database.DB)
+	if err != nil {
+		return 0, fmt.Errorf("无法获取上一次快照的vote ID: %w", err)
+	}
+	newestID, err := maxVoteID(database.DB)
+	if err != nil {
+		return 0, err
+	}
+	// 尚未被快照覆盖的投票在重启时仍需回放，留到之后再归档
+	if lastID > snapshotVoteID {
+		slog.Info("投票归档任务: 该月的投票尚未全部被快照覆盖，稍后再试。", slog.String("month", monthStart.Format("2006-01")), slog.Uint64("last_vote_id", uint64(lastID)))
+		return 0, nil
+	}
+	// 始终保留最新的一张投票，使新投票的ID分配不会回退
+	if lastID >= newestID {
+		lastID = newestID - 1
+	}
+	if lastID < first.ID {
+		return 0, nil
+	}
+
+	// 3. 读取该范围内的全部投票，写入并校验归档文件
+	var votes []Vote
+	var batch []Vote
+	for afterID := first.ID - 1; ; {
+		batch = batch[:0]
+		if err := database.DB.Where("id > ? AND id <= ?", afterID, lastID).Order("id asc").Limit(archiveReadBatchSize).Find(&batch).Error; err != nil {
+			return 0, fmt.Errorf("读取待归档的投票失败 (id > %d): %w", afterID, err)
+		}
+		if len(batch) == 0 {
+			break
+		}
+		votes = append(votes, batch...)
+		afterID = batch[len(batch)-1].ID
+	}
+	entry, err := writeArchiveFile(monthStart.Format("2006-01"), first.ID, lastID, votes)
+	if err != nil {
+		return 0, err
+	}
+
+	// 4. 在一个事务中删除已归档的投票，并记录归档文件和新的水位
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Unscoped().Where("id <= ?", lastID).Delete(&Vote{})
+		if result.Error != nil {
+			return fmt.Errorf("删除已归档的投票失败: %w", result.Error)
+		}
+		if result.RowsAffected != int64(len(votes)) {
+			return fmt.Errorf("删除了 %d 张投票, 归档文件中有 %d 张", result.RowsAffected, len(votes))
+		}
+		if err := saveArchiveEntry(tx, entry); err != nil {
+			return err
+		}
+		return metadata.SetArchivedThroughVoteID(tx, lastID)
+	})
+	if err != nil {
+		removeArchiveFiles([]string{entry.File})
+		return 0, err
+	}
+
+	slog.Info("投票归档任务: 已写入归档文件。", slog.String("file", entry.File), slog.Uint64("first_vote_id", uint64(entry.FirstID)), slog.Uint64("last_vote_id", uint64(entry.LastID)), slog.Int("votes", entry.Count))
+	return len(votes), nil
+}
+
+// removeOrphanArchiveFiles 删除归档目录中未被清单引用、且超过保留时长的归档文件，
+// 它们来自失败的归档、或是被合并和删除用户时改写替换的文件。
+func removeOrphanArchiveFiles(now time.Time) error {
+	dirEntries, err := os.ReadDir(archiveDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	archiveMutex.Lock()
+	defer archiveMutex.Unlock()
+
+	entries, err := loadArchiveEntries(database.DB)
+	if err != nil {
+		return err
+	}
+	referenced := make(map[string]struct{}, len(entries))
+	for _, entry := range entries {
+		referenced[entry.File] = struct{}{}
+	}
+
+	var orphans []string
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
+		if !strings.HasPrefix(name, "votes-") || !(strings.HasSuffix(name, ".jsonl.gz") || strings.HasSuffix(name, ".jsonl.gz.tmp")) {
+			continue
+		}
+		if _, ok := referenced[name]; ok {
+			continue
+		}
+		info, err := dirEntry.Info()
+		if err != nil || now.Sub(info.ModTime()) < orphanArchiveGrace {
+			continue
+		}
+		orphans = append(orphans, name)
+	}
+	if len(orphans) > 0 {
+		slog.Info("投票归档任务: 正在删除孤立的归档文件。", slog.Any("files", orphans))
+		removeArchiveFiles(orphans)
+	}
+	return nil
+}
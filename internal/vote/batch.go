@@ -0,0 +1,78 @@
+package vote
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/config"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/logging"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/spell"
+)
+
+// voteBatchSize 是投票处理器一次合并应用的最大连续投票数
+var voteBatchSize int
+
+func loadBatchPolicy(cfg config.VoteConfig) {
+	voteBatchSize = cfg.BatchSize
+}
+
+// applyVoteBatch 将一批按ID连续、升序的投票应用到Redis和内存仓库。
+// 统计数据的读取、ELO与RankScore的计算以及全部写入都由 applyVoteBatchScript 在Redis中原子地完成，
+// 脚本会跳过检查点之前已应用的投票，因此失败重试或多个写入者重复提交同一批投票都不会重复计数。
+func (vp *voteProcessor) applyVoteBatch(votes []Vote) error {
+	// Redis中数据的一致性不依赖这里的锁；spell锁用于保护内存权重树，
+	// 并与缓存重建、合并用户等从SQLite整体改写Redis的流程互斥
+	spell.LockRepository()
+	defer spell.UnlockRepository()
+
+	vp.processMutex.Lock()
+	currentID := vp.lastProcessedVoteID
+	vp.processMutex.Unlock()
+
+	// 缓存重建可能已经计入了其中一部分投票；
+	// 复制一份再改写用户，失败时放回暂存区的仍是原始投票
+	batch := make([]Vote, 0, len(votes))
+	for _, vote := range votes {
+		if vote.ID <= currentID {
+			continue
+		}
+		// 投票写入后，其用户可能已被合并到另一个用户
+		vote.UserIdentifier = resolveMergedUser(vote, currentID)
+		batch = append(batch, vote)
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+
+	// 1. 在Redis中原子地应用整批投票
+	result, err := runApplyVoteBatchScript(batch)
+	if err != nil {
+		return fmt.Errorf("执行投票应用脚本失败: %w", err)
+	}
+	if result.applied < len(batch) {
+		slog.Info("部分投票已被应用过，已跳过", batchRange(batch), slog.Int("applied", result.applied), slog.Uint64("checkpoint", uint64(result.checkpoint)))
+	}
+	for _, voteID := range result.boundaryVotes {
+		eloBoundaryRebuilds.Inc()
+		slog.Info("检测到ELO边界变化，已执行全局RankScore重建", logging.VoteID(voteID))
+	}
+	for _, voteID := range result.impreciseUndos {
+		slog.Warn("撤销事件对应的投票不是用户最近处理的投票，聚合数据可能不精确", logging.VoteID(voteID))
+	}
+
+	// 2. Redis写入成功后，更新内存权重树
+	for id, total := range result.spellTotals {
+		if index, ok := spell.GetSpellIndexByID(id); ok {
+			spell.UpdateWeightUnsafe(index, spell.CalculateWeightForTotal(total))
+		}
+	}
+
+	// 3. 逐票记录处理结果，日志通过请求ID与提交投票的请求关联
+	if slog.Default().Enabled(database.Ctx, slog.LevelDebug) {
+		for _, vote := range batch {
+			slog.DebugContext(vote.logContext(), "投票已处理", logging.VoteID(vote.ID), logging.UserID(vote.UserIdentifier), slog.Float64("multiplier", vote.Multiplier))
+		}
+	}
+	return nil
+}
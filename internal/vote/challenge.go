@@ -0,0 +1,55 @@
+package vote
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/config"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/logging"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/spell"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/user"
+	"github.com/SlpAus/noita-spells-tier-backend/pkg/pow"
+	"github.com/gin-gonic/gin"
+)
+
+// challengePolicy 决定了何时以及以何种难度要求投票者附带工作量证明
+var challengePolicy config.ChallengeConfig
+
+func loadChallengePolicy(cfg config.VoteConfig) {
+	challengePolicy = cfg.Challenge
+}
+
+// difficultyForVolume 根据近期投票量计算所需的工作量证明难度。
+// 未超过阈值时为0；超过后从基础难度开始，每多DifficultyStep票增加1比特，直到上限。
+func difficultyForVolume(volume int64) int {
+	if !challengePolicy.Enabled || volume < challengePolicy.Threshold {
+		return 0
+	}
+	extra := int((volume - challengePolicy.Threshold) / challengePolicy.DifficultyStep)
+	return min(challengePolicy.BaseDifficulty+extra, challengePolicy.MaxDifficulty)
+}
+
+// PairChallengeMiddleware 评估请求者的近期投票量，并将所需的工作量证明难度放入Gin上下文，
+// 由签发凭证的处理器写入签名内容。它需要位于EnsureUserCookieMiddleware之后。
+func PairChallengeMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if challengePolicy.Enabled {
+			volume, err := RecentVoteVolume(c.ClientIP(), c.GetString(user.UserIDKey), time.Now())
+			if err != nil {
+				// 查询失败时不设置挑战，后续的处理器会自行处理Redis不可用的情况
+				slog.ErrorContext(c.Request.Context(), "获取近期投票量失败", logging.Err(err))
+			} else {
+				c.Set(spell.ChallengeDifficultyKey, difficultyForVolume(volume))
+			}
+		}
+		c.Next()
+	}
+}
+
+// verifyChallenge 检查投票是否附带了凭证所要求的工作量证明。挑战绑定在PairID上。
+func verifyChallenge(payload SubmitSpellVoteRequestBody) bool {
+	if payload.Difficulty > pow.MaxDifficulty {
+		return false
+	}
+	return pow.Verify(payload.PairID, payload.Nonce, payload.Difficulty)
+}
@@ -0,0 +1,127 @@
+package vote
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
+	"github.com/redis/go-redis/v9"
+)
+
+// EloBoundsKey 是一个Redis Hash，保存所有法术的最低和最高ELO分数及其持有者数量，
+// 字段为 min, minCount, max, maxCount。投票应用脚本依赖它判断ELO边界是否变化，
+// 它不存在时脚本拒绝应用任何投票，因此缓存重建开始时会先删除它。
+const EloBoundsKey = database.KeyPrefix + "spell:elo_bounds"
+
+// eloBounds 记录了所有法术中的最低和最高ELO分数，以及持有这两个分数的法术数量。
+type eloBounds struct {
+	minScore float64
+	minCount int
+	maxScore float64
+	maxCount int
+}
+
+// newEloBounds 从一个给定的分数切片（无序）中计算ELO边界。
+// 投票应用脚本中的 resetBounds 与它保持一致。
+func newEloBounds(scores []float64) (eloBounds, error) {
+	var b eloBounds
+	if len(scores) < 1 {
+		return b, fmt.Errorf("计算ELO边界所需的分数列表长度至少为1")
+	}
+
+	b.minScore = scores[0]
+	b.maxScore = scores[0]
+
+	// 计算最高分和最低分的数量
+	for _, score := range scores {
+		if score < b.minScore {
+			b.minScore = score
+			b.minCount = 1
+			continue
+		}
+		if score == b.minScore {
+			b.minCount++
+		}
+		if score == b.maxScore {
+			b.maxCount++
+			continue
+		}
+		if score > b.maxScore {
+			b.maxScore = score
+			b.maxCount = 1
+		}
+	}
+
+	slog.Debug("ELO边界已重新计算", slog.Float64("min_score", b.minScore), slog.Int("min_count", b.minCount), slog.Float64("max_score", b.maxScore), slog.Int("max_count", b.maxCount))
+	return b, nil
+}
+
+// save 将ELO边界写入Redis，分数以能精确还原的格式保存，供脚本逐位比较
+func (b eloBounds) save(pipe redis.Pipeliner) {
+	pipe.HSet(database.Ctx, EloBoundsKey, b.fields())
+}
+
+// fields 返回ELO边界在 EloBoundsKey 中的各字段
+func (b eloBounds) fields() map[string]string {
+	return map[string]string{
+		"min":      strconv.FormatFloat(b.minScore, 'g', -1, 64),
+		"minCount": strconv.Itoa(b.minCount),
+		"max":      strconv.FormatFloat(b.maxScore, 'g', -1, 64),
+		"maxCount": strconv.Itoa(b.maxCount),
+	}
+}
+
+// parseEloBounds 从 EloBoundsKey 的各字段还原ELO边界
+func parseEloBounds(fields map[string]string) (eloBounds, error) {
+	var b eloBounds
+	var errs [4]error
+	b.minScore, errs[0] = strconv.ParseFloat(fields["min"], 64)
+	b.minCount, errs[1] = strconv.Atoi(fields["minCount"])
+	b.maxScore, errs[2] = strconv.ParseFloat(fields["max"], 64)
+	b.maxCount, errs[3] = strconv.Atoi(fields["maxCount"])
+	if err := errors.Join(errs[:]...); err != nil {
+		return b, fmt.Errorf("解析ELO边界时出错: %w", err)
+	}
+	return b, nil
+}
+
+// track 在一个法术的分数从old变为new之后更新边界，返回边界是否因此失效、需要全局重算。
+// 投票应用脚本中的 trackScore 与它保持一致。
+func (b *eloBounds) track(old, new float64) bool {
+	if old == new {
+		return false
+	}
+	if old == b.minScore {
+		b.minCount--
+		if b.minCount == 0 {
+			return true
+		}
+	} else if old == b.maxScore {
+		b.maxCount--
+		if b.maxCount == 0 {
+			return true
+		}
+	}
+	switch {
+	case new < b.minScore:
+		return true
+	case new == b.minScore:
+		b.minCount++
+	case new > b.maxScore:
+		return true
+	case new == b.maxScore:
+		b.maxCount++
+	}
+	return false
+}
+
+// InvalidateEloBounds 删除Redis中的ELO边界，使投票应用脚本在缓存重建完成之前拒绝应用投票。
+// 重建结束时 ApplyIncrementalVotes 会重新写入它。
+func InvalidateEloBounds() error {
+	if err := activeStore.invalidateEloBounds(); err != nil {
+		return fmt.Errorf("无法删除Redis中的ELO边界: %w", err)
+	}
+	return nil
+}
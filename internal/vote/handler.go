@@ -1,15 +1,18 @@
 package vote
 
 import (
-	"fmt"
+	"errors"
+	"log/slog"
 	"net/http"
 	"time"
 
 	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/config"
 	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/logging"
 	"github.com/SlpAus/noita-spells-tier-backend/internal/user"
 	"github.com/SlpAus/noita-spells-tier-backend/pkg/token"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 // --- 模式 ---
@@ -25,7 +28,13 @@ type SubmitSpellVoteRequestBody struct {
 	SpellBID  string     `json:"spellB" binding:"required"`
 	Result    VoteResult `json:"result" binding:"required"`
 	PairID    string     `json:"pairId" binding:"required"`
+	IssuedAt  int64      `json:"issuedAt" binding:"required"`
 	Signature string     `json:"signature" binding:"required"`
+	// Difficulty 和 Nonce 仅在凭证附带工作量证明挑战时需要
+	Difficulty int    `json:"difficulty"`
+	Nonce      string `json:"nonce"`
+	// UserID 是凭证绑定的用户ID，即 /pair 响应的payload中的 u。为空时按当前用户验证签名
+	UserID string `json:"userId"`
 }
 
 type SubmitPerkVoteRequestBody struct {
@@ -33,13 +42,20 @@ type SubmitPerkVoteRequestBody struct {
 	SpellBID  string     `json:"perkB" binding:"required"` // 改名
 	Result    VoteResult `json:"result" binding:"required"`
 	PairID    string     `json:"pairId" binding:"required"`
+	IssuedAt  int64      `json:"issuedAt" binding:"required"`
 	Signature string     `json:"signature" binding:"required"`
+	// Difficulty 和 Nonce 仅在凭证附带工作量证明挑战时需要
+	Difficulty int    `json:"difficulty"`
+	Nonce      string `json:"nonce"`
+	// UserID 是凭证绑定的用户ID，即 /pair 响应的payload中的 u。为空时按当前用户验证签名
+	UserID string `json:"userId"`
 }
 
 // SubmitVote 处理前端提交的投票结果
 func SubmitVote(c *gin.Context) {
 	// 1. 服务降级检查
 	if !database.IsRedisHealthy() {
+		voteSubmissions.WithLabelValues(outcomeUnavailable).Inc()
 		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "服务暂时不可用，请稍后重试"})
 		return
 	}
@@ -48,58 +64,102 @@ func SubmitVote(c *gin.Context) {
 	switch appMode {
 	case config.AppModeSpell:
 		if err := c.ShouldBindJSON(&body); err != nil {
+			voteSubmissions.WithLabelValues(outcomeBadRequest).Inc()
 			c.JSON(http.StatusBadRequest, gin.H{"error": "请求格式错误: " + err.Error()})
 			return
 		}
 	case config.AppModePerk:
 		var perkBody SubmitPerkVoteRequestBody
 		if err := c.ShouldBindJSON(&perkBody); err != nil {
+			voteSubmissions.WithLabelValues(outcomeBadRequest).Inc()
 			c.JSON(http.StatusBadRequest, gin.H{"error": "请求格式错误: " + err.Error()})
 			return
 		}
 		body = SubmitSpellVoteRequestBody(perkBody)
 	}
 
-	// 2. 签名验证
-	payloadToValidate := token.TokenPayload{PairID: body.PairID, SpellAID: body.SpellAID, SpellBID: body.SpellBID}
+	// 2. 识别用户和IP
+	userID := c.GetString(user.UserIDKey)
+	if !user.IsValidUUID(userID) {
+		userID = ""
+	}
+	ip := c.ClientIP()
+	voteTime := time.Now()
+
+	// 3. 签名验证
+	// 凭证绑定了应用模式和用户ID。先按凭证声明的用户验证签名，再与当前用户比较，
+	// 使在用户之间共享的凭证能与伪造的凭证区分开
+	if body.UserID == "" {
+		body.UserID = userID
+	}
+	payloadToValidate := token.TokenPayload{
+		PairID:     body.PairID,
+		SpellAID:   body.SpellAID,
+		SpellBID:   body.SpellBID,
+		IssuedAt:   body.IssuedAt,
+		Mode:       string(appMode),
+		UserID:     body.UserID,
+		Difficulty: body.Difficulty,
+	}
 	if !token.ValidateVoteSignature(payloadToValidate, body.Signature) {
-		c.JSON(http.StatusOK, gin.H{"message": "投票已记录"}) // 静默失败
+		recordRejectedVote(c.Request.Context(), RejectBadSignature, body, userID, ip, voteTime)
+		c.JSON(http.StatusForbidden, gin.H{"error": "投票凭证无效，请刷新后重试"})
+		return
+	}
+	if body.UserID != userID {
+		recordRejectedVote(c.Request.Context(), RejectWrongUser, body, userID, ip, voteTime)
+		c.JSON(http.StatusForbidden, gin.H{"error": "投票凭证不属于当前用户，请刷新后重试"})
 		return
 	}
 
-	// 3. 防重放攻击检查
+	// 4. 工作量证明检查，难度已由签名保证未被篡改
+	if !verifyChallenge(body) {
+		recordRejectedVote(c.Request.Context(), RejectBadProof, body, userID, ip, voteTime)
+		c.JSON(http.StatusForbidden, gin.H{"error": "工作量证明无效，请重新计算后提交"})
+		return
+	}
+
+	// 5. 凭证时效检查
+	switch checkTokenTiming(payloadToValidate, voteTime) {
+	case RejectExpired:
+		recordRejectedVote(c.Request.Context(), RejectExpired, body, userID, ip, voteTime)
+		c.JSON(http.StatusGone, gin.H{"error": "投票凭证已过期，请刷新后重试"})
+		return
+	case RejectTooFast:
+		recordRejectedVote(c.Request.Context(), RejectTooFast, body, userID, ip, voteTime)
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "投票过快，请稍后重试"})
+		return
+	}
+
+	// 6. 防重放攻击检查
 	isReplay, err := CheckAndUsePairID(body.PairID)
 	if err != nil {
-		fmt.Printf("检查PairID %s 时发生错误: %v\n", body.PairID, err)
+		voteSubmissions.WithLabelValues(outcomeError).Inc()
+		slog.ErrorContext(c.Request.Context(), "检查PairID时发生错误", slog.String("pair_id", body.PairID), logging.Err(err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "验证投票时发生内部错误"})
 		return
 	}
 	if isReplay {
-		c.JSON(http.StatusOK, gin.H{"message": "投票已记录"}) // 静默失败
+		// 同一凭证的投票已被记录过，对客户端而言结果是一致的
+		recordRejectedVote(c.Request.Context(), RejectReplay, body, userID, ip, voteTime)
+		c.JSON(http.StatusOK, gin.H{"message": "投票已记录"})
 		return
 	}
 
-	// 4. IP频率限制 (带补偿操作)
-	ip := c.ClientIP()
-	voteTime := time.Now()
-	count, compensator, err := IncrementIPVoteCount(ip, voteTime)
+	// 7. IP频率限制 (带补偿操作)
+	count, compensator, err := IncrementIPVoteCount(ip, userID, voteTime)
 	if err != nil {
-		fmt.Printf("IP计数器失败 for IP %s: %v\n", ip, err)
+		voteSubmissions.WithLabelValues(outcomeError).Inc()
+		slog.ErrorContext(c.Request.Context(), "IP计数器失败", logging.IP(ip), logging.Err(err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "处理投票时发生内部错误"})
 		return
 	}
 	defer compensator.RollbackUnlessCommitted() // 默认在函数结束时执行回滚
 
-	// 5. 计算投票权重
+	// 8. 计算投票权重
 	multiplier := calculateMultiplierForCount(count)
 
-	// 6. 验证用户
-	userID := c.GetString(user.UserIDKey)
-	if !user.IsValidUUID(userID) {
-		userID = ""
-	}
-
-	// 7. 构造最终的投票记录
+	// 9. 构造最终的投票记录
 	newVote := Vote{
 		SpellA_ID:      body.SpellAID,
 		SpellB_ID:      body.SpellBID,
@@ -108,15 +168,18 @@ func SubmitVote(c *gin.Context) {
 		UserIP:         ip,
 		Multiplier:     multiplier,
 		VoteTime:       voteTime,
+		RequestID:      logging.RequestIDFromContext(c.Request.Context()),
 	}
 
-	// 8. 持久化投票事件到SQLite (带重试)
+	// 10. 持久化投票事件到SQLite (带重试)
 	const maxRetry = 3
 	const delay = 50 * time.Millisecond
 
 	var createErr error
 	for i := 0; i < maxRetry; i++ {
-		createErr = database.DB.Create(&newVote).Error
+		createErr = database.DB.Transaction(func(tx *gorm.DB) error {
+			return insertVote(tx, &newVote)
+		})
 		if createErr == nil {
 			break
 		}
@@ -126,18 +189,70 @@ func SubmitVote(c *gin.Context) {
 		time.Sleep(delay)
 	}
 	if createErr != nil {
-		fmt.Printf("严重错误: 无法将vote写入SQLite: %v\n", createErr)
+		voteSubmissions.WithLabelValues(outcomeError).Inc()
+		slog.ErrorContext(c.Request.Context(), "严重错误: 无法将vote写入SQLite", logging.IP(ip), logging.Err(createErr))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法记录投票"})
 		// IP计数器的补偿操作将在这里被defer自动调用
 		return
 	}
 
-	// 9. 确认IP计数器的更改
+	// 11. 确认IP计数器的更改
 	compensator.Commit()
 
-	// 10. 提交到后台处理器
+	// 12. 提交到后台处理器
 	submitVoteToQueue(newVote)
 
-	// 11. 成功返回
+	// 13. 成功返回
+	voteSubmissions.WithLabelValues(outcomeAccepted).Inc()
 	c.JSON(http.StatusOK, gin.H{"message": "投票成功"})
 }
+
+// UndoVote 撤销当前用户最近的一次投票
+func UndoVote(c *gin.Context) {
+	// 1. 服务降级检查
+	if !database.IsRedisHealthy() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "服务暂时不可用，请稍后重试"})
+		return
+	}
+	if undoWindow <= 0 {
+		c.JSON(http.StatusForbidden, gin.H{"error": "撤销功能未启用"})
+		return
+	}
+
+	// 2. 识别用户，匿名投票无法撤销
+	userID := c.GetString(user.UserIDKey)
+	if !user.IsValidUUID(userID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "没有可以撤销的投票"})
+		return
+	}
+
+	// 与投票写入相同，持有IP计数读锁直到撤销事件写入SQLite，避免与用户合并或删除交错
+	ipMutex.RLock()
+	defer ipMutex.RUnlock()
+
+	// 3. 写入撤销事件
+	original, undo, err := undoLatestVote(userID, time.Now())
+	switch {
+	case errors.Is(err, errNothingToUndo):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, errUndoExpired):
+		c.JSON(http.StatusGone, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		slog.ErrorContext(c.Request.Context(), "严重错误: 撤销投票失败", logging.Err(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "撤销投票失败"})
+		return
+	}
+
+	// 4. 归还IP计数（尽力而为）
+	if err := releaseIPVoteCount(original.UserIP, original.UserIdentifier, original.VoteTime); err != nil {
+		slog.WarnContext(c.Request.Context(), "撤销投票时归还IP计数失败", logging.VoteID(original.ID), logging.Err(err))
+	}
+
+	// 5. 提交到后台处理器，按顺序抵消原投票
+	undo.RequestID = logging.RequestIDFromContext(c.Request.Context())
+	submitVoteToQueue(undo)
+
+	c.JSON(http.StatusOK, gin.H{"message": "投票已撤销", "voteId": original.ID})
+}
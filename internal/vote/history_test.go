@@ -0,0 +1,105 @@
+package vote_test
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/account"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/config"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/metadata"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/testutil"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/vote"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// historyIDs 返回一页投票历史中的投票ID
+func historyIDs(t *testing.T, query account.VoteHistoryQuery) ([]uint, uint) {
+	t.Helper()
+	page, err := account.GetVoteHistory(query)
+	if err != nil {
+		t.Fatalf("查询投票历史失败: %v", err)
+	}
+	ids := make([]uint, 0, len(page.Items))
+	for _, item := range page.Items {
+		ids = append(ids, item.ID)
+	}
+	return ids, page.NextCursor
+}
+
+func TestVoteHistoryIncludesArchivedVotes(t *testing.T) {
+	alice, bob := uuid.Must(uuid.NewV7()).String(), uuid.Must(uuid.NewV7()).String()
+	jan := time.Date(2025, time.January, 10, 12, 0, 0, 0, time.UTC)
+	feb := time.Date(2025, time.February, 3, 12, 0, 0, 0, time.UTC)
+	newVote := func(userID, a, b string, result vote.VoteResult, voteTime time.Time) vote.Vote {
+		return vote.Vote{SpellA_ID: a, SpellB_ID: b, Result: result, UserIdentifier: userID, Multiplier: 1, VoteTime: voteTime}
+	}
+	votes := []vote.Vote{
+		newVote(alice, "BOMB", "DIGGER", vote.ResultAWins, jan),
+		newVote(bob, "BOMB", "CHAINSAW", vote.ResultBWins, jan),
+		newVote(alice, "DIGGER", "CHAINSAW", vote.ResultDraw, jan),
+		newVote(alice, "BLACK_HOLE", "BOMB", vote.ResultAWins, feb),
+		newVote(alice, "BOMB", "LIGHT_BULLET", vote.ResultAWins, time.Now()),
+		newVote(alice, "CHAINSAW", "DIGGER", vote.ResultBWins, time.Now()),
+	}
+
+	testutil.Setup(t, testutil.Options{
+		Configure: func() {
+			vote.UseRedisStore()
+			vote.ConfigureModule(config.AppModeSpell, config.VoteConfig{
+				TokenTTL:      time.Hour,
+				UndoWindow:    time.Minute,
+				BatchSize:     16,
+				ReplayBackend: vote.ReplayBackendBucket,
+				Archive:       config.ArchiveConfig{Enabled: true, Dir: t.TempDir(), MinAge: 24 * time.Hour},
+			})
+		},
+		Seed: func(db *gorm.DB) error {
+			if err := db.AutoMigrate(&vote.Vote{}); err != nil {
+				return err
+			}
+			return db.Create(&votes).Error
+		},
+		Prime: vote.PrimeModule,
+	})
+
+	// 1. 一月和二月的投票被移入归档文件，水位为4
+	if err := metadata.SetLastSnapshotVoteID(database.DB, 6); err != nil {
+		t.Fatal(err)
+	}
+	if archived, err := vote.ArchiveOldVotes(time.Now()); err != nil || archived != 4 {
+		t.Fatalf("归档了 %d 张投票 (%v), 期望 4", archived, err)
+	}
+
+	// 2. 逐页读取，跨过归档水位
+	pages := []struct {
+		before uint
+		want   []uint
+		next   uint
+	}{
+		{0, []uint{6, 5}, 5},
+		{5, []uint{4, 3}, 3},
+		{3, []uint{1}, 0},
+	}
+	for _, page := range pages {
+		ids, next := historyIDs(t, account.VoteHistoryQuery{UserID: alice, Before: page.before, Limit: 2})
+		if !slices.Equal(ids, page.want) || next != page.next {
+			t.Errorf("before=%d: %v (next %d), 期望 %v (next %d)", page.before, ids, next, page.want, page.next)
+		}
+	}
+
+	// 3. 同一页中既有votes表中的投票，也有归档的投票
+	if ids, next := historyIDs(t, account.VoteHistoryQuery{UserID: alice, Limit: 3}); !slices.Equal(ids, []uint{6, 5, 4}) || next != 4 {
+		t.Errorf("跨水位的一页: %v (next %d)", ids, next)
+	}
+
+	// 4. 结果和法术过滤同样作用于归档的投票
+	if ids, _ := historyIDs(t, account.VoteHistoryQuery{UserID: alice, Result: vote.ResultAWins}); !slices.Equal(ids, []uint{5, 4, 1}) {
+		t.Errorf("按结果过滤: %v", ids)
+	}
+	if ids, _ := historyIDs(t, account.VoteHistoryQuery{UserID: alice, SpellID: "DIGGER"}); !slices.Equal(ids, []uint{6, 3, 1}) {
+		t.Errorf("按法术过滤: %v", ids)
+	}
+}
@@ -0,0 +1,334 @@
+package vote
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/achievement"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/backup"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/logging"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/metadata"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/spell"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/user"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// userMerge 记录一次用户合并或删除，供投票处理器改写此前已写入SQLite、但尚未被处理的投票
+type userMerge struct {
+	targetID string
+	// barrierVoteID 是合并时SQLite中最大的投票ID，ID不超过它的投票才需要改写
+	barrierVoteID uint
+}
+
+var (
+	mergedUsersMutex sync.Mutex
+	mergedUsers      = make(map[string]userMerge)
+)
+
+// lockForIdentityChange 获取改写用户身份所需的全部锁，并返回按相反顺序释放它们的函数。
+// 锁顺序与缓存重建保持一致: 快照 -> spell -> user -> IP计数；
+// 持有IP计数的写锁可以确保没有正在写入SQLite的投票。
+// 归档锁最先获取，避免在等待归档任务时就已经阻塞了投票的写入。
+func lockForIdentityChange() func() {
+	archiveMutex.Lock()
+	backup.LockSnapshot()
+	spell.LockRepository()
+	user.LockRepository()
+	ipMutex.Lock()
+	return func() {
+		ipMutex.Unlock()
+		user.UnlockRepository()
+		spell.UnlockRepository()
+		backup.UnlockSnapshot()
+		archiveMutex.Unlock()
+	}
+}
+
+// recordUserRedirect 让处理器把sourceID在边界之前、尚未处理的投票计入targetID（为空表示匿名）
+func recordUserRedirect(sourceID, targetID string, barrierVoteID uint) {
+	mergedUsersMutex.Lock()
+	defer mergedUsersMutex.Unlock()
+	mergedUsers[sourceID] = userMerge{targetID: targetID, barrierVoteID: barrierVoteID}
+}
+
+// maxVoteID 返回SQLite中当前最大的投票ID
+func maxVoteID(tx *gorm.DB) (uint, error) {
+	var id uint
+	if err := tx.Model(&Vote{}).Select("COALESCE(MAX(id), 0)").Scan(&id).Error; err != nil {
+		return 0, fmt.Errorf("无法获取最大投票ID: %w", err)
+	}
+	return id, nil
+}
+
+// resolveMergedUser 返回一次投票在合并后应计入的用户ID。
+// 只由投票处理器调用；处理进度越过合并边界后，对应的记录会被清除。
+func resolveMergedUser(vote Vote, lastProcessedVoteID uint) string {
+	mergedUsersMutex.Lock()
+	defer mergedUsersMutex.Unlock()
+
+	userID := vote.UserIdentifier
+	for {
+		merge, ok := mergedUsers[userID]
+		if !ok {
+			return userID
+		}
+		if lastProcessedVoteID >= merge.barrierVoteID {
+			delete(mergedUsers, userID)
+			return userID
+		}
+		if vote.ID > merge.barrierVoteID {
+			return userID
+		}
+		userID = merge.targetID
+		if userID == "" {
+			return userID
+		}
+	}
+}
+
+// ErrUserGone 表示目标用户已被删除或已被合并到其他用户中
+var ErrUserGone = errors.New("目标用户已被删除或合并")
+
+// isUserGone 判断一个用户是否刚被删除或合并。调用方需持有身份变更锁。
+// 持久的保证来自删除和合并时一并删除的恢复码，这里只是额外的检查。
+func isUserGone(userID string) bool {
+	mergedUsersMutex.Lock()
+	defer mergedUsersMutex.Unlock()
+	_, ok := mergedUsers[userID]
+	return ok
+}
+
+// RedeemUser 让当前浏览器取得targetID的身份：在身份变更锁内执行redeem（如兑换恢复码），
+// 并在sourceID不为空且不同于targetID时，把sourceID合并到targetID中。
+// redeem与合并的SQLite改写在同一个事务中执行，redeem失败时不会合并；
+// 持有锁可以确保targetID不会在兑换期间被删除。返回是否发生了合并。
+func RedeemUser(sourceID, targetID string, redeem func(tx *gorm.DB) error) (bool, error) {
+	merging := sourceID != "" && sourceID != targetID
+	if merging && !database.IsRedisHealthy() {
+		return false, errors.New("服务暂时不可用，请稍后重试")
+	}
+
+	unlock := lockForIdentityChange()
+	defer unlock()
+
+	if isUserGone(targetID) {
+		return false, ErrUserGone
+	}
+	if !merging {
+		return false, database.DB.Transaction(redeem)
+	}
+	if err := mergeUsersLocked(sourceID, targetID, redeem); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// MergeUsers 将sourceID的所有投票历史和统计数据合并到targetID中，之后sourceID不再存在。
+// 它会改写SQLite中历史投票的UserIdentifier、合并User行，并同步更新user:stats和user:ranking。
+func MergeUsers(sourceID, targetID string) error {
+	if sourceID == targetID {
+		return nil
+	}
+	if !database.IsRedisHealthy() {
+		return errors.New("服务暂时不可用，请稍后重试")
+	}
+
+	unlock := lockForIdentityChange()
+	defer unlock()
+	return mergeUsersLocked(sourceID, targetID, nil)
+}
+
+// mergeUsersLocked 执行MergeUsers的合并，调用方需持有身份变更锁。
+// within 不为空时，在改写SQLite的同一个事务中执行。
+func mergeUsersLocked(sourceID, targetID string, within func(tx *gorm.DB) error) error {
+	// 1. 读取双方在Redis中的实时统计
+	statsByUser, err := activeStore.userStats(sourceID, targetID)
+	if err != nil {
+		return fmt.Errorf("无法从Redis获取用户统计数据: %w", err)
+	}
+	var merged user.UserStats
+	_, sourceHasStats := statsByUser[sourceID]
+	for _, stats := range statsByUser {
+		merged.Wins += stats.Wins
+		merged.Draw += stats.Draw
+		merged.Skip += stats.Skip
+	}
+
+	// 合并后的聚合数据无法由双方的聚合数据直接合成，需要按当前排名从投票历史重新计算
+	spellRank, err := currentSpellRanks()
+	if err != nil {
+		return err
+	}
+
+	// 2. 在一个事务中改写SQLite: 投票归属和用户快照行
+	var barrierVoteID uint
+	var replacedArchives []string
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if within != nil {
+			if err := within(tx); err != nil {
+				return err
+			}
+		}
+		if barrierVoteID, err = maxVoteID(tx); err != nil {
+			return err
+		}
+		if err := tx.Model(&Vote{}).Where("user_identifier = ?", sourceID).Update("user_identifier", targetID).Error; err != nil {
+			return fmt.Errorf("改写投票归属失败: %w", err)
+		}
+		replacedArchives, err = rewriteArchives(tx, func(v *Vote) bool {
+			if v.UserIdentifier != sourceID {
+				return false
+			}
+			v.UserIdentifier = targetID
+			return true
+		})
+		if err != nil {
+			return fmt.Errorf("改写已归档投票的归属失败: %w", err)
+		}
+		if err := tx.Model(&RejectedVote{}).Where("user_identifier = ?", sourceID).Update("user_identifier", targetID).Error; err != nil {
+			return fmt.Errorf("改写被拒投票归属失败: %w", err)
+		}
+		if err := achievement.ReassignAwards(tx, sourceID, targetID); err != nil {
+			return err
+		}
+		// 被合并的身份不再存在，它的恢复码随之失效
+		if err := user.DeleteRecoveryCodes(tx, sourceID); err != nil {
+			return err
+		}
+
+		var rows []user.User
+		if err := tx.Where("uuid IN ?", []string{sourceID, targetID}).Find(&rows).Error; err != nil {
+			return fmt.Errorf("读取用户快照失败: %w", err)
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		snapshotVoteID, err := metadata.GetLastSnapshotVoteID(tx)
+		if err != nil {
+			return fmt.Errorf("无法获取上一次快照的vote ID: %w", err)
+		}
+		snapshotAgg, err := computeUserAggregates(tx, targetID, snapshotVoteID, spellRank)
+		if err != nil {
+			return err
+		}
+		snapshotAggJSON, _ := json.Marshal(snapshotAgg)
+
+		mergedRow := user.User{UUID: targetID, Aggregates: string(snapshotAggJSON)}
+		for i, row := range rows {
+			if i == 0 || row.CreatedAt.Before(mergedRow.CreatedAt) {
+				mergedRow.CreatedAt = row.CreatedAt
+			}
+			mergedRow.WinsCount += row.WinsCount
+			mergedRow.DrawCount += row.DrawCount
+			mergedRow.SkipCount += row.SkipCount
+			// 排行榜昵称以恢复的身份为准，它没有昵称时沿用被合并身份的昵称
+			if row.Nickname != "" && (row.UUID == targetID || mergedRow.Nickname == "") {
+				mergedRow.Nickname = row.Nickname
+				mergedRow.LeaderboardOptIn = row.LeaderboardOptIn
+			}
+		}
+		if err := tx.Unscoped().Where("uuid = ?", sourceID).Delete(&user.User{}).Error; err != nil {
+			return fmt.Errorf("删除被合并的用户失败: %w", err)
+		}
+		return tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "uuid"}},
+			DoUpdates: clause.AssignmentColumns([]string{"wins_count", "draw_count", "skip_count", "aggregates", "nickname", "leaderboard_opt_in", "created_at", "updated_at"}),
+		}).Create(&mergedRow).Error
+	})
+	if err != nil {
+		return err
+	}
+	removeArchiveFiles(replacedArchives)
+
+	// 3. 让处理器把尚未处理的旧投票计入合并后的用户
+	recordUserRedirect(sourceID, targetID, barrierVoteID)
+
+	// 4. 原子地更新Redis缓存，聚合数据截至处理器已处理的投票
+	var target *mergedUser
+	if sourceHasStats {
+		globalVoteProcessor.processMutex.Lock()
+		lastProcessedVoteID := globalVoteProcessor.lastProcessedVoteID
+		globalVoteProcessor.processMutex.Unlock()
+		liveAgg, err := computeUserAggregates(database.DB, targetID, lastProcessedVoteID, spellRank)
+		if err != nil {
+			return err
+		}
+		target = &mergedUser{stats: merged, aggregates: liveAgg}
+	}
+
+	if err := activeStore.mergeUser(sourceID, targetID, target); err != nil {
+		// SQLite已是合并后的状态，下一次缓存重建会修正Redis
+		slog.Error("严重错误: 合并用户后更新Redis失败", slog.String("source_user_id", sourceID), slog.String("target_user_id", targetID), logging.Err(err))
+		return fmt.Errorf("更新用户缓存失败: %w", err)
+	}
+
+	slog.Info("用户已合并。", slog.String("source_user_id", sourceID), slog.String("target_user_id", targetID))
+	return nil
+}
+
+// ForgetUser 删除一个用户的个人数据：匿名化其所有投票（保留投票本身以维持评分的完整性），
+// 并删除User行、已获得的成就、恢复码以及user:stats、user:ranking等缓存中的条目。
+func ForgetUser(userID string) error {
+	if !database.IsRedisHealthy() {
+		return errors.New("服务暂时不可用，请稍后重试")
+	}
+
+	unlock := lockForIdentityChange()
+	defer unlock()
+
+	// 1. 在一个事务中匿名化投票并删除用户快照行
+	var barrierVoteID uint
+	var replacedArchives []string
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if barrierVoteID, err = maxVoteID(tx); err != nil {
+			return err
+		}
+		anonymized := map[string]interface{}{"user_identifier": "", "user_ip": ""}
+		if err := tx.Model(&Vote{}).Where("user_identifier = ?", userID).Updates(anonymized).Error; err != nil {
+			return fmt.Errorf("匿名化投票失败: %w", err)
+		}
+		replacedArchives, err = rewriteArchives(tx, func(v *Vote) bool {
+			if v.UserIdentifier != userID {
+				return false
+			}
+			v.UserIdentifier, v.UserIP = "", ""
+			return true
+		})
+		if err != nil {
+			return fmt.Errorf("匿名化已归档的投票失败: %w", err)
+		}
+		if err := tx.Model(&RejectedVote{}).Where("user_identifier = ?", userID).Updates(anonymized).Error; err != nil {
+			return fmt.Errorf("匿名化被拒投票失败: %w", err)
+		}
+		if err := tx.Unscoped().Where("uuid = ?", userID).Delete(&user.User{}).Error; err != nil {
+			return fmt.Errorf("删除用户失败: %w", err)
+		}
+		if err := user.DeleteRecoveryCodes(tx, userID); err != nil {
+			return err
+		}
+		return achievement.DeleteAwards(tx, userID)
+	})
+	if err != nil {
+		return err
+	}
+	// 原归档文件中仍有该用户的数据，立即删除而不是等待孤立文件清理
+	removeArchiveFiles(replacedArchives)
+
+	// 2. 尚未处理的投票将作为匿名投票计入
+	recordUserRedirect(userID, "", barrierVoteID)
+
+	// 3. 原子地清除Redis缓存
+	if err := activeStore.forgetUser(userID); err != nil {
+		slog.Error("严重错误: 删除用户后清除Redis缓存失败", logging.UserID(userID), logging.Err(err))
+		return fmt.Errorf("清除用户缓存失败: %w", err)
+	}
+
+	slog.Info("用户的个人数据已删除。", logging.UserID(userID))
+	return nil
+}
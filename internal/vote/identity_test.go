@@ -0,0 +1,85 @@
+package vote
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/achievement"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/user"
+	"github.com/SlpAus/noita-spells-tier-backend/pkg/token"
+	"gorm.io/gorm"
+)
+
+// issueRecoveryCode 为用户签发并记录一个恢复码，返回兑换它的函数
+func issueRecoveryCode(t *testing.T, userID string) func(tx *gorm.DB) error {
+	t.Helper()
+	code, claims, err := token.GenerateRecoveryCode(userID, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := user.ReplaceRecoveryCode(database.DB, user.RecoveryCode{Nonce: claims.Nonce, UserIdentifier: userID, ExpiresAt: claims.ExpiresAt}); err != nil {
+		t.Fatal(err)
+	}
+	parsed, ok := token.ParseRecoveryCode(code, time.Now())
+	if !ok || parsed != claims {
+		t.Fatalf("无法解析刚签发的恢复码: %+v", parsed)
+	}
+	return func(tx *gorm.DB) error {
+		return user.ConsumeRecoveryCode(tx, userID, claims.Nonce, time.Now())
+	}
+}
+
+func TestRedeemUserConsumesRecoveryCode(t *testing.T) {
+	setupTestEnv(t)
+	if err := database.DB.AutoMigrate(&achievement.Award{}); err != nil {
+		t.Fatal(err)
+	}
+	alice, bob, carol := newUserID(), newUserID(), newUserID()
+	submitVote(t, alice, "BOMB", "LIGHT_BULLET", ResultAWins)
+	submitVote(t, bob, "BOMB", "DIGGER", ResultBWins)
+	processQueuedVotes(t)
+
+	// 1. 恢复码兑换后即失效，兑换时把当前身份合并到恢复的身份中
+	redeem := issueRecoveryCode(t, alice)
+	if merged, err := RedeemUser(bob, alice, redeem); err != nil || !merged {
+		t.Fatalf("兑换恢复码: merged=%v err=%v", merged, err)
+	}
+	if got := mustUserStats(t, alice); got.Wins != 2 {
+		t.Errorf("合并后alice的统计: %+v", got)
+	}
+	if _, err := RedeemUser("", alice, redeem); !errors.Is(err, user.ErrRecoveryCodeInvalid) {
+		t.Errorf("重复兑换恢复码: %v", err)
+	}
+
+	// 2. 签发新的恢复码使旧的失效
+	old := issueRecoveryCode(t, alice)
+	current := issueRecoveryCode(t, alice)
+	if _, err := RedeemUser("", alice, old); !errors.Is(err, user.ErrRecoveryCodeInvalid) {
+		t.Errorf("兑换被替换的恢复码: %v", err)
+	}
+	if _, err := RedeemUser("", alice, current); err != nil {
+		t.Errorf("兑换最新的恢复码: %v", err)
+	}
+
+	// 3. 删除用户后，此前签发的恢复码不能再把任何身份合并进来
+	redeem = issueRecoveryCode(t, alice)
+	if err := ForgetUser(alice); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RedeemUser(carol, alice, redeem); !errors.Is(err, ErrUserGone) {
+		t.Errorf("兑换已删除用户的恢复码: %v", err)
+	}
+	mergedUsersMutex.Lock()
+	delete(mergedUsers, alice)
+	mergedUsersMutex.Unlock()
+	if _, err := RedeemUser(carol, alice, redeem); !errors.Is(err, user.ErrRecoveryCodeInvalid) {
+		t.Errorf("删除用户后兑换恢复码: %v", err)
+	}
+	var users int64
+	database.DB.Model(&user.User{}).Where("uuid = ?", alice).Count(&users)
+	if users != 0 {
+		t.Error("已删除的用户被重新创建了")
+	}
+}
@@ -7,11 +7,13 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
-	"net"
+	"log/slog"
 	"sync"
 	"time"
 
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/clientip"
 	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/logging"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -19,13 +21,17 @@ import (
 // 它被设计为在业务流程失败时，通过defer语句安全地执行补偿。
 type IPVoteCompensator struct {
 	ip        string
+	key       string
+	userKey   string // 为空表示本次投票没有计入用户计数
 	member    string
 	committed bool
 }
 
 const (
 	// ipVoteKeyPrefix 是Redis中有序集合的键名前缀
-	ipVoteKeyPrefix = "ip_votes:"
+	ipVoteKeyPrefix = database.KeyPrefix + "ip_votes:"
+	// userVoteKeyPrefix 是按用户统计近期投票数的有序集合键名前缀，与IP计数共用窗口
+	userVoteKeyPrefix = database.KeyPrefix + "user_votes:"
 	// ipVoteWindow 定义了IP投票计数的时间窗口
 	ipVoteWindow = 60 * time.Minute
 	// ipVoteTTL 是每个IP记录在Redis中的生存时间，比窗口稍长以作缓冲
@@ -36,30 +42,37 @@ var (
 	ipMutex sync.RWMutex // 借用读写锁的概念，IncrementIPVoteCount可以并发执行
 )
 
-// deleteKeysByPrefix 是一个辅助函数，用于安全地删除key
-func deleteKeysByPrefix(ctx context.Context, rdb *redis.Client, prefix string) error {
-	var cursor uint64
+// deleteKeysByPrefix 是一个辅助函数，用于安全地删除key。
+// SCAN只遍历单个节点，因此集群模式下需要在每个主节点上分别执行；
+// 逐个删除键，使不在同一槽位的键也能在集群中被删除。
+func deleteKeysByPrefix(ctx context.Context, prefix string) error {
 	matchPattern := prefix + "*"
 	const batchSize = 500 // 每次SCAN和DEL的数量
 
-	for {
-		keys, nextCursor, err := rdb.Scan(ctx, cursor, matchPattern, batchSize).Result()
-		if err != nil {
-			return err
-		}
-
-		if len(keys) > 0 {
-			if err := rdb.Del(ctx, keys...).Err(); err != nil {
+	return database.ForEachMaster(ctx, func(ctx context.Context, client *redis.Client) error {
+		var cursor uint64
+		for {
+			keys, nextCursor, err := client.Scan(ctx, cursor, matchPattern, batchSize).Result()
+			if err != nil {
 				return err
 			}
-		}
 
-		cursor = nextCursor
-		if cursor == 0 {
-			break
+			if len(keys) > 0 {
+				pipe := database.RDB.Pipeline()
+				for _, key := range keys {
+					pipe.Del(ctx, key)
+				}
+				if _, err := pipe.Exec(ctx); err != nil {
+					return err
+				}
+			}
+
+			cursor = nextCursor
+			if cursor == 0 {
+				return nil
+			}
 		}
-	}
-	return nil
+	})
 }
 
 // GenerateUniqueID 根据给定的时间生成一个16字节的、抗冲突的ID，并将其编码为Base64字符串。
@@ -85,74 +98,77 @@ func generateUniqueID(t time.Time) (string, error) {
 // RebuildIPVoteCache 从SQLite重建过去ipVoteWindow内的IP投票缓存。
 // 这个方法也用于应用启动时的初始化。
 func RebuildIPVoteCache() error {
-	fmt.Println("正在从SQLite重建IP投票频率缓存...")
+	slog.Info("正在从SQLite重建IP投票频率缓存...")
 
 	ipMutex.Lock()
 	defer ipMutex.Unlock()
 
 	// 1. 从SQLite中获取ipVoteWindow内的投票记录
 	var recentVotes []struct {
-		UserIP   string
-		VoteTime time.Time
+		UserIP         string
+		UserIdentifier string
+		VoteTime       time.Time
 	}
 	beginTime := time.Now().Add(-ipVoteWindow)
-	err := database.DB.Model(&Vote{}).Where("vote_time > ?", beginTime).Find(&recentVotes).Error
+	err := database.DB.Model(&Vote{}).Scopes(EffectiveVotesAsOf(0)).Where("vote_time > ?", beginTime).Find(&recentVotes).Error
 	if err != nil {
 		return fmt.Errorf("无法从SQLite读取近期投票: %w", err)
 	}
 
 	if len(recentVotes) == 0 {
-		fmt.Println("IP频率限制：无近期投票数据需要恢复。")
+		slog.Info("IP频率限制：无近期投票数据需要恢复。")
 		return nil
 	}
 
-	// 我们将相同IP的记录分组，以减少Pipeline的调用次数
-	ipVoteMap := make(map[string][]redis.Z)
+	// 我们将相同来源（按网段聚合后）的记录分组，以减少Pipeline的调用次数
+	counts := make(map[string][]voteCount)
+	sources := 0
 	for _, vote := range recentVotes {
+		timestamp := float64(vote.VoteTime.UnixMicro())
+		memberID, err := generateUniqueID(vote.VoteTime)
+		if err != nil {
+			slog.Error("生成 memberID 失败", logging.Err(err))
+			continue
+		}
 		if vote.UserIP != "" {
-			key := ipVoteKeyPrefix + vote.UserIP
-			timestamp := float64(vote.VoteTime.UnixMicro())
-			memberID, err := generateUniqueID(vote.VoteTime)
-			if err != nil {
-				fmt.Printf("生成 memberID 失败: %v\n", err)
-				continue
+			if subnet, err := clientip.SubnetKey(vote.UserIP); err == nil {
+				key := ipVoteKeyPrefix + subnet
+				if _, exists := counts[key]; !exists {
+					sources++
+				}
+				counts[key] = append(counts[key], voteCount{score: timestamp, member: memberID})
 			}
-			ipVoteMap[key] = append(ipVoteMap[key], redis.Z{Score: timestamp, Member: memberID})
+		}
+		if vote.UserIdentifier != "" {
+			key := userVoteKeyPrefix + vote.UserIdentifier
+			counts[key] = append(counts[key], voteCount{score: timestamp, member: memberID})
 		}
 	}
 
-	// 2. 安全地删除所有旧的IP记录
-	if err := deleteKeysByPrefix(database.Ctx, database.RDB, ipVoteKeyPrefix); err != nil {
-		return fmt.Errorf("删除旧的IP键失败: %w", err)
-	}
-	fmt.Println("已删除所有旧的IP缓存记录。")
-
-	// 3. 批量将记录写回Redis
-	pipe := database.RDB.Pipeline()
-	for key, members := range ipVoteMap {
-		pipe.ZAdd(database.Ctx, key, members...)
-		pipe.Expire(database.Ctx, key, ipVoteTTL)
-	}
-	if _, err := pipe.Exec(database.Ctx); err != nil {
-		return fmt.Errorf("批量写回IP投票数据到Redis失败: %w", err)
+	// 2. 删除所有旧的IP和用户计数记录，并批量写回
+	if err := activeStore.resetVoteCounts(counts); err != nil {
+		return err
 	}
 
-	fmt.Printf("IP频率限制：成功从SQLite恢复了 %d 个IP的投票数据到缓存。\n", len(ipVoteMap))
+	slog.Info("IP频率限制：成功从SQLite恢复了投票数据到缓存。", slog.Int("sources", sources))
 	return nil
 }
 
-// IncrementIPVoteCount 在Redis中为一个IP原子地记录一次新的投票，并返回其在过去ipVoteWindow内的总投票数。
+// IncrementIPVoteCount 在Redis中为一个IP所在的来源网段原子地记录一次新的投票，并返回其在过去ipVoteWindow内的总投票数。
+// userID不为空时，同一次投票也会计入该用户的近期投票数，供工作量证明挑战评估使用。
 // 返回最新的计数值和一个补偿句柄，用于在业务流程失败时回滚此次计数增加。当返回error时，补偿句柄为nil。
-func IncrementIPVoteCount(ip string, voteTime time.Time) (int64, *IPVoteCompensator, error) {
+func IncrementIPVoteCount(ip, userID string, voteTime time.Time) (int64, *IPVoteCompensator, error) {
 	if ip == "" {
 		return 0, nil, errors.New("投票缺少IP")
 	}
 
-	if net.ParseIP(ip) == nil {
+	// IPv6地址（以及按配置的IPv4地址）按网段聚合计数，避免同一网段内轮换地址绕过限制
+	subnet, err := clientip.SubnetKey(ip)
+	if err != nil {
 		return 0, nil, errors.New("投票IP无效")
 	}
 
-	key := ipVoteKeyPrefix + ip
+	key := ipVoteKeyPrefix + subnet
 	// 1. 计算ipVoteWindow前的时间戳，作为清理的边界
 	minTimestamp := float64(voteTime.Add(-ipVoteWindow).UnixMicro())
 
@@ -171,33 +187,20 @@ func IncrementIPVoteCount(ip string, voteTime time.Time) (int64, *IPVoteCompensa
 		return 0, nil, errors.New("服务暂时不可用，无法获取投票频率")
 	}
 
-	// 3. 使用Redis事务(TxPipeline)来保证所有操作的原子性
-	pipe := database.RDB.TxPipeline()
-	// a. 移除所有旧记录
-	pipe.ZRemRangeByScore(database.Ctx, key, "-inf", fmt.Sprintf("(%f", minTimestamp))
-	// b. 添加新记录
-	pipe.ZAdd(database.Ctx, key, redis.Z{Score: scoreTime, Member: memberID})
-	// c. 刷新过期时间
-	pipe.Expire(database.Ctx, key, ipVoteTTL)
-	// d. 获取更新后的总数
-	countCmd := pipe.ZCard(database.Ctx, key)
-
-	// 4. 执行事务
-	_, err = pipe.Exec(database.Ctx)
-	if err != nil {
-		ipMutex.RUnlock()
-		return 0, nil, fmt.Errorf("执行IP计数事务失败: %w", err)
+	// 3. 原子地清理旧记录、添加新记录并获取更新后的总数，同步记录用户的近期投票
+	keys := []string{key}
+	userKey := ""
+	if userID != "" {
+		userKey = userVoteKeyPrefix + userID
+		keys = append(keys, userKey)
 	}
-
-	// 5. 返回最新的计数值
-	count, err := countCmd.Result()
+	count, err := activeStore.recordVoteCount(keys, voteCount{score: scoreTime, member: memberID}, minTimestamp)
 	if err != nil {
-		database.RDB.ZRem(database.Ctx, key, memberID)
 		ipMutex.RUnlock()
-		return 0, nil, fmt.Errorf("获取IP计数结果失败: %w", err)
+		return 0, nil, fmt.Errorf("执行IP计数事务失败: %w", err)
 	}
 
-	return count, &IPVoteCompensator{ip: ip, member: memberID}, nil
+	return count, &IPVoteCompensator{ip: ip, key: key, userKey: userKey, member: memberID}, nil
 }
 
 // Commit 标记上层业务事务已成功，阻止后续的回滚操作。
@@ -219,15 +222,43 @@ func (c *IPVoteCompensator) RollbackUnlessCommitted() {
 
 	if !database.IsRedisHealthy() {
 		// TODO: 这会导致多记一个次数
-		fmt.Printf("严重警告: IP投票计数补偿操作时Redis不健康。 IP: %s, Member: %s", c.ip, c.member)
+		slog.Error("严重警告: IP投票计数补偿操作时Redis不健康", logging.IP(c.ip), slog.String("member", c.member))
 		return
 	}
 
 	// 执行补偿：从有序集合中移除本次投票对应的成员
-	key := ipVoteKeyPrefix + c.ip
+	err := activeStore.removeVoteCount(c.key, c.member)
+	if err != nil {
+		slog.Error("严重警告: IP投票计数补偿操作失败", logging.IP(c.ip), slog.String("member", c.member), logging.Err(err))
+	}
+	if c.userKey != "" {
+		if err := activeStore.removeVoteCount(c.userKey, c.member); err != nil {
+			slog.Warn("用户投票计数补偿操作失败", slog.String("key", c.userKey), slog.String("member", c.member), logging.Err(err))
+		}
+	}
+}
 
-	err := database.RDB.ZRem(database.Ctx, key, c.member).Err()
+// RecentVoteVolume 返回一个IP所在网段和一个用户在过去ipVoteWindow内投票数中的较大者。
+// 这是一个只读查询，不会修改任何计数。
+func RecentVoteVolume(ip, userID string, now time.Time) (int64, error) {
+	var keys []string
+	if subnet, err := clientip.SubnetKey(ip); err == nil {
+		keys = append(keys, ipVoteKeyPrefix+subnet)
+	}
+	if userID != "" {
+		keys = append(keys, userVoteKeyPrefix+userID)
+	}
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	counts, err := activeStore.countVotesSince(keys, float64(now.Add(-ipVoteWindow).UnixMicro()))
 	if err != nil {
-		fmt.Printf("严重警告: IP投票计数补偿操作失败! IP: %s, Member: %s, 错误: %v\n", c.ip, c.member, err)
+		return 0, err
+	}
+
+	var volume int64
+	for _, count := range counts {
+		volume = max(volume, count)
 	}
+	return volume, nil
 }
@@ -0,0 +1,80 @@
+package vote
+
+import (
+	"testing"
+	"time"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
+)
+
+func TestIncrementIPVoteCount(t *testing.T) {
+	setupTestEnv(t)
+	userID := newUserID()
+	now := time.Now()
+
+	for i := int64(1); i <= 3; i++ {
+		count, compensator, err := IncrementIPVoteCount(testIP, userID, now.Add(time.Duration(i)*time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+		compensator.Commit()
+		if count != i {
+			t.Errorf("第 %d 次计数返回 %d", i, count)
+		}
+	}
+
+	// 不带用户的投票同样计入IP计数
+	count, compensator, err := IncrementIPVoteCount(testIP, "", now.Add(4*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 未提交的计数在回滚后被移除
+	compensator.RollbackUnlessCommitted()
+	if count != 4 {
+		t.Errorf("回滚前的计数为 %d, 期望 4", count)
+	}
+
+	volume, err := RecentVoteVolume(testIP, userID, now.Add(5*time.Second))
+	if err != nil || volume != 3 {
+		t.Errorf("近期投票量为 %d (%v), 期望 3", volume, err)
+	}
+
+	// 窗口之外的投票不再计数
+	volume, err = RecentVoteVolume(testIP, userID, now.Add(ipVoteWindow+2*time.Second))
+	if err != nil || volume != 2 {
+		t.Errorf("窗口移动后的近期投票量为 %d (%v), 期望 2", volume, err)
+	}
+}
+
+func TestRebuildIPVoteCache(t *testing.T) {
+	setupTestEnv(t)
+	userID := newUserID()
+	now := time.Now()
+
+	votes := []Vote{
+		{SpellA_ID: "BOMB", SpellB_ID: "DIGGER", UserIP: testIP, UserIdentifier: userID, VoteTime: now.Add(-time.Minute)},
+		{SpellA_ID: "BOMB", SpellB_ID: "DIGGER", UserIP: testIP, UserIdentifier: userID, VoteTime: now.Add(-2 * time.Minute)},
+		{SpellA_ID: "BOMB", SpellB_ID: "DIGGER", UserIP: "198.51.100.1", VoteTime: now.Add(-3 * time.Minute)},
+		// 窗口之外的投票不会被恢复
+		{SpellA_ID: "BOMB", SpellB_ID: "DIGGER", UserIP: testIP, UserIdentifier: userID, VoteTime: now.Add(-2 * ipVoteWindow)},
+	}
+	for i := range votes {
+		votes[i].Multiplier = 1
+	}
+	if err := database.DB.Create(&votes).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RebuildIPVoteCache(); err != nil {
+		t.Fatal(err)
+	}
+	if volume, err := RecentVoteVolume(testIP, "", now); err != nil || volume != 2 {
+		t.Errorf("IP的近期投票量为 %d (%v), 期望 2", volume, err)
+	}
+	if volume, err := RecentVoteVolume("", userID, now); err != nil || volume != 2 {
+		t.Errorf("用户的近期投票量为 %d (%v), 期望 2", volume, err)
+	}
+	if volume, err := RecentVoteVolume("198.51.100.1", "", now); err != nil || volume != 1 {
+		t.Errorf("其他IP的近期投票量为 %d (%v), 期望 1", volume, err)
+	}
+}
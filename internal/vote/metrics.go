@@ -0,0 +1,116 @@
+package vote
+
+import (
+	"strings"
+	"sync/atomic"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// 投票提交结果中，拒绝原因之外的几种结果
+const (
+	outcomeAccepted    = "accepted"
+	outcomeBadRequest  = "bad_request"
+	outcomeUnavailable = "unavailable"
+	outcomeError       = "error"
+)
+
+var (
+	voteSubmissions = metrics.Factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "vote",
+		Name:      "submissions_total",
+		Help:      "按结果统计的投票提交次数",
+	}, []string{"outcome"})
+
+	patrollerRequeues = metrics.Factory.NewCounter(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "vote",
+		Name:      "patroller_requeued_total",
+		Help:      "巡查员从SQLite重新提交的被遗漏投票数",
+	})
+
+	rejectionsUnrecorded = metrics.Factory.NewCounter(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "vote",
+		Name:      "rejections_unrecorded_total",
+		Help:      "超出来源IP网段限额、没有写入rejected_votes表的被拒绝投票数",
+	})
+
+	eloBoundaryRebuilds = metrics.Factory.NewCounter(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "vote",
+		Name:      "elo_boundary_rebuilds_total",
+		Help:      "因ELO边界变化触发的全局RankScore重算次数",
+	})
+
+	// newestVoteID 是已写入SQLite并提交给处理器的最大投票ID，用于计算处理延迟
+	newestVoteID atomic.Uint64
+)
+
+func init() {
+	for _, outcome := range []string{outcomeAccepted, outcomeBadRequest, outcomeUnavailable, outcomeError} {
+		voteSubmissions.WithLabelValues(outcome)
+	}
+	for _, reason := range []RejectionReason{RejectBadSignature, RejectWrongUser, RejectBadProof, RejectExpired, RejectTooFast, RejectReplay} {
+		voteSubmissions.WithLabelValues(rejectionOutcome(reason))
+	}
+
+	metrics.Factory.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "vote",
+		Name:      "processor_lag",
+		Help:      "已写入的最大投票ID与处理器已处理的投票ID之差",
+	}, func() float64 {
+		return float64(ProcessorLag())
+	})
+	metrics.Factory.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "vote",
+		Name:      "processor_buffer_size",
+		Help:      "处理器暂存区（最小堆）中等待前序投票的投票数",
+	}, func() float64 {
+		globalVoteProcessor.processMutex.Lock()
+		defer globalVoteProcessor.processMutex.Unlock()
+		if globalVoteProcessor.buffer == nil {
+			return 0
+		}
+		return float64(globalVoteProcessor.buffer.Len())
+	})
+	metrics.Factory.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "vote",
+		Name:      "processor_queue_length",
+		Help:      "处理器channel中排队的投票数",
+	}, func() float64 {
+		return float64(len(globalVoteProcessor.voteChan))
+	})
+}
+
+// rejectionOutcome 把拒绝原因转换为指标中的结果标签，例如 BAD_SIGNATURE -> bad_signature
+func rejectionOutcome(reason RejectionReason) string {
+	return strings.ToLower(string(reason))
+}
+
+// ProcessorLag 返回已写入SQLite的最大投票ID与处理器已处理的投票ID之差
+func ProcessorLag() uint64 {
+	globalVoteProcessor.processMutex.Lock()
+	lastProcessed := uint64(globalVoteProcessor.lastProcessedVoteID)
+	globalVoteProcessor.processMutex.Unlock()
+	newest := newestVoteID.Load()
+	if newest <= lastProcessed {
+		return 0
+	}
+	return newest - lastProcessed
+}
+
+// observeVoteID 记录一个已写入SQLite的投票ID
+func observeVoteID(id uint) {
+	for {
+		current := newestVoteID.Load()
+		if uint64(id) <= current || newestVoteID.CompareAndSwap(current, uint64(id)) {
+			return
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
 	"gorm.io/gorm"
 )
 
@@ -51,4 +52,94 @@ type Vote struct {
 	UserIP         string
 	Multiplier     float64
 	VoteTime       time.Time `gorm:"index"`
+
+	// UndoOfID 不为0时，这是一条撤销事件，用于抵消ID为UndoOfID的投票。
+	// 撤销事件的Multiplier为原投票的相反数，处理器会以负权重重放这对法术的结果。
+	UndoOfID uint `gorm:"index;not null;default:0"`
+	// UndoneByID 不为0时，表示这条投票已被ID为UndoneByID的撤销事件抵消
+	UndoneByID uint `gorm:"not null;default:0"`
+
+	// RequestID 是提交这张投票的HTTP请求ID，不落库，只用于在处理器日志中关联请求。
+	// 巡查员从SQLite补交的投票没有请求ID。
+	RequestID string `gorm:"-" json:"-"`
+}
+
+// IsUndo 判断这是否是一条撤销事件
+func (v Vote) IsUndo() bool {
+	return v.UndoOfID != 0
+}
+
+// IsEffective 判断这是否是一张当前仍然有效的普通投票，与 EffectiveVotesAsOf(0) 的条件相同
+func (v Vote) IsEffective() bool {
+	return isEffectiveAsOf(v, 0)
+}
+
+// EffectiveVotesAsOf 是一个GORM作用域，只保留在处理到asOfVoteID时仍然有效的普通投票：
+// 排除所有撤销事件，以及撤销事件ID不超过asOfVoteID的投票。asOfVoteID为0时表示当前时刻。
+func EffectiveVotesAsOf(asOfVoteID uint) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		db = db.Where("undo_of_id = 0")
+		if asOfVoteID == 0 {
+			return db.Where("undone_by_id = 0")
+		}
+		return db.Where("(undone_by_id = 0 OR undone_by_id > ?)", asOfVoteID)
+	}
+}
+
+// voteInsertLockID 是在PostgreSQL中分配投票ID时使用的事务级锁
+const voteInsertLockID = 0x766f7465 // "vote"
+
+// insertVote 在事务tx中写入一张投票。投票处理器要求投票ID按提交顺序连续分配：
+// SQLite的写入天然串行，而PostgreSQL的序列在并发事务和回滚下会产生乱序和空洞，
+// 因此在写锁下显式分配 MAX(id)+1。
+func insertVote(tx *gorm.DB, vote *Vote) error {
+	if !database.IsPostgres() {
+		return tx.Create(vote).Error
+	}
+	if err := database.SerializeWrites(tx, voteInsertLockID); err != nil {
+		return err
+	}
+	id, err := maxVoteID(tx)
+	if err != nil {
+		return err
+	}
+	vote.ID = id + 1
+	return tx.Create(vote).Error
+}
+
+// RejectionReason 定义了投票被拒绝的原因
+type RejectionReason string
+
+const (
+	// RejectBadSignature 表示签名无效，包括凭证被篡改或跨模式使用
+	RejectBadSignature RejectionReason = "BAD_SIGNATURE"
+	// RejectWrongUser 表示签名有效，但凭证绑定的用户不是提交投票的用户，通常意味着凭证在用户之间共享
+	RejectWrongUser RejectionReason = "WRONG_USER"
+	// RejectExpired 表示凭证已超过有效期
+	RejectExpired RejectionReason = "EXPIRED"
+	// RejectTooFast 表示从签发到提交的间隔短于人类可能达到的最短思考时间
+	RejectTooFast RejectionReason = "TOO_FAST"
+	// RejectReplay 表示凭证已被使用过
+	RejectReplay RejectionReason = "REPLAY"
+	// RejectBadProof 表示凭证要求工作量证明，但投票未附带有效的解
+	RejectBadProof RejectionReason = "BAD_PROOF"
+)
+
+// RejectedVote 记录了一次被拒绝的投票请求，仅用于事后分析，不参与任何统计
+type RejectedVote struct {
+	gorm.Model
+
+	Reason RejectionReason `gorm:"index"`
+
+	PairID    string
+	SpellA_ID string
+	SpellB_ID string
+	Result    VoteResult
+
+	UserIdentifier string `gorm:"index"`
+	// TokenUserIdentifier 是凭证绑定的用户ID，只在 WRONG_USER 时记录
+	TokenUserIdentifier string `gorm:"index"`
+	UserIP              string
+	IssuedAt            time.Time
+	RejectTime          time.Time `gorm:"index"`
 }
@@ -0,0 +1,194 @@
+package vote
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/metadata"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/user"
+)
+
+func TestVotePipeline(t *testing.T) {
+	env := setupTestEnv(t)
+	userID := newUserID()
+
+	submitVote(t, userID, "BOMB", "LIGHT_BULLET", ResultAWins)
+	submitVote(t, userID, "BOMB", "BLACK_HOLE", ResultBWins)
+	submitVote(t, userID, "CHAINSAW", "DIGGER", ResultDraw)
+	submitVote(t, userID, "TELEPORT_PROJECTILE", "DIGGER", ResultSkip)
+	processQueuedVotes(t)
+
+	// 1. 法术统计
+	stats := mustSpellStats(t)
+	if got := stats["BOMB"]; got.Total != 2 || got.Win != 1 {
+		t.Errorf("BOMB: total=%v win=%v, 期望 total=2 win=1", got.Total, got.Win)
+	}
+	if got := stats["BLACK_HOLE"]; got.Total != 1 || got.Win != 1 || got.Score <= 1500 {
+		t.Errorf("BLACK_HOLE: %+v, 期望赢得一场并提高分数", got)
+	}
+	if got := stats["LIGHT_BULLET"]; got.Total != 1 || got.Win != 0 || got.Score >= 1500 {
+		t.Errorf("LIGHT_BULLET: %+v, 期望输掉一场并降低分数", got)
+	}
+	if got := stats["CHAINSAW"]; got.Total != 1 || got.Score != 1500 {
+		t.Errorf("CHAINSAW: %+v, 期望平局不改变分数", got)
+	}
+	if got := stats["TELEPORT_PROJECTILE"]; got.Total != 0 {
+		t.Errorf("TELEPORT_PROJECTILE: %+v, 期望跳过的投票不计入场次", got)
+	}
+
+	// 2. 排名与RankScore一致
+	ranking, err := activeStore.spellRanking()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sort.SliceIsSorted(ranking, func(i, j int) bool {
+		return stats[ranking[i]].RankScore > stats[ranking[j]].RankScore
+	}) {
+		t.Errorf("法术排名没有按RankScore降序排列: %v", ranking)
+	}
+
+	// 3. 用户统计、聚合数据和待处理标记
+	userStats := mustUserStats(t, userID)
+	if userStats.Wins != 2 || userStats.Draw != 1 || userStats.Skip != 1 || userStats.LastVoteID != 4 {
+		t.Errorf("用户统计: %+v", userStats)
+	}
+	aggs, err := activeStore.userAggregates(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tally := aggs[userID].Spells["BOMB"]; tally.Wins != 1 || tally.Beaten != 1 || tally.FirstSeen.VoteID != 1 {
+		t.Errorf("BOMB的个人计数: %+v", tally)
+	}
+	total, err := activeStore.totalStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total.Wins != 2 || total.Draw != 1 || total.Skip != 1 {
+		t.Errorf("社区总统计: %+v", total)
+	}
+	if ok, _ := env.Redis.SIsMember(user.DirtySetKey, userID); !ok {
+		t.Error("用户没有被标记为待备份")
+	}
+
+	// 4. 检查点与总投票数
+	if checkpoint, _ := env.Redis.Get(metadata.RedisLastProcessedVoteIDKey); checkpoint != "4" {
+		t.Errorf("检查点为 %s, 期望 4", checkpoint)
+	}
+	if totalVotes, _ := env.Redis.Get(metadata.RedisTotalVotesKey); totalVotes != "3" {
+		t.Errorf("总投票数为 %s, 期望 3", totalVotes)
+	}
+}
+
+func TestVotePipelineUndo(t *testing.T) {
+	setupTestEnv(t)
+	userID := newUserID()
+
+	submitVote(t, userID, "BOMB", "LIGHT_BULLET", ResultAWins)
+	processQueuedVotes(t)
+
+	w := performRequest(UndoVote, userID, testIP, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("撤销投票失败: %d %s", w.Code, w.Body.String())
+	}
+	processQueuedVotes(t)
+
+	stats := mustSpellStats(t)
+	for _, id := range []string{"BOMB", "LIGHT_BULLET"} {
+		if got := stats[id]; got.Total != 0 || got.Win != 0 {
+			t.Errorf("%s 在撤销后: %+v, 期望场次归零", id, got)
+		}
+	}
+	if got := mustUserStats(t, userID); got.Wins != 0 || got.LastVoteID != 2 {
+		t.Errorf("用户统计在撤销后: %+v", got)
+	}
+	aggs, err := activeStore.userAggregates(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if agg := aggs[userID]; len(agg.Spells) != 0 || len(agg.Days) != 0 {
+		t.Errorf("聚合数据在撤销后没有被精确还原: %+v", agg)
+	}
+
+	// 撤销会归还近期投票计数
+	if volume, err := RecentVoteVolume(testIP, userID, time.Now()); err != nil || volume != 0 {
+		t.Errorf("撤销后的近期投票量为 %d (%v), 期望 0", volume, err)
+	}
+
+	// 已撤销的投票不能再次撤销
+	if w := performRequest(UndoVote, userID, testIP, nil); w.Code != http.StatusNotFound {
+		t.Errorf("重复撤销返回 %d, 期望 %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestApplyVoteBatchIsIdempotent(t *testing.T) {
+	setupTestEnv(t)
+
+	batch := []Vote{
+		{SpellA_ID: "BOMB", SpellB_ID: "DIGGER", Result: ResultAWins, Multiplier: 1},
+		{SpellA_ID: "BOMB", SpellB_ID: "CHAINSAW", Result: ResultAWins, Multiplier: 1},
+	}
+	for i := range batch {
+		batch[i].ID = uint(i + 1)
+	}
+
+	// 与检查点不连续的批次被整体拒绝
+	if _, err := runApplyVoteBatchScript(batch[1:]); err == nil {
+		t.Fatal("期望不连续的批次被拒绝")
+	}
+	if got := mustSpellStats(t)["BOMB"]; got.Total != 0 {
+		t.Fatalf("被拒绝的批次写入了数据: %+v", got)
+	}
+
+	result, err := runApplyVoteBatchScript(batch)
+	if err != nil || result.applied != 2 || result.checkpoint != 2 {
+		t.Fatalf("首次应用: %+v, %v", result, err)
+	}
+	before := mustSpellStats(t)["BOMB"]
+
+	// 重复提交同一批投票不会重复计数
+	result, err = runApplyVoteBatchScript(batch)
+	if err != nil || result.applied != 0 || result.checkpoint != 2 {
+		t.Fatalf("重复应用: %+v, %v", result, err)
+	}
+	if after := mustSpellStats(t)["BOMB"]; after != before {
+		t.Errorf("重复应用改变了统计数据: %+v -> %+v", before, after)
+	}
+
+	// 缓存重建期间ELO边界不存在，批次被拒绝
+	if err := InvalidateEloBounds(); err != nil {
+		t.Fatal(err)
+	}
+	next := Vote{SpellA_ID: "BOMB", SpellB_ID: "DIGGER", Result: ResultDraw, Multiplier: 1}
+	next.ID = 3
+	if _, err := runApplyVoteBatchScript([]Vote{next}); err == nil {
+		t.Error("期望在ELO边界不存在时拒绝应用投票")
+	}
+}
+
+func TestEloBoundaryChangeRecomputesRankScores(t *testing.T) {
+	setupTestEnv(t)
+	userID := newUserID()
+
+	// 所有法术初始同分，第一张胜负投票必然改变边界
+	submitVote(t, userID, "BOMB", "LIGHT_BULLET", ResultAWins)
+	processQueuedVotes(t)
+
+	stats := mustSpellStats(t)
+	var scores []float64
+	for _, s := range stats {
+		scores = append(scores, s.Score)
+	}
+	bounds, err := newEloBounds(scores)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, s := range stats {
+		want := CalculateRankScore(bounds, s.Score, s.Total, s.Win)
+		if math.Abs(s.RankScore-want) > 1e-12 {
+			t.Errorf("%s 的RankScore为 %v, 期望 %v", id, s.RankScore, want)
+		}
+	}
+}
@@ -3,18 +3,15 @@ package vote
 import (
 	"container/heap"
 	"context"
-	"encoding/json"
 	"errors"
-	"fmt"
+	"log/slog"
 	"sync"
 	"time"
 
 	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
-	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/metadata"
-	"github.com/SlpAus/noita-spells-tier-backend/internal/spell"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/logging"
 	"github.com/SlpAus/noita-spells-tier-backend/internal/user"
 	"github.com/SlpAus/noita-spells-tier-backend/pkg/lifecycle"
-	"github.com/redis/go-redis/v9"
 )
 
 // voteMinHeap 实现了 container/heap 接口
@@ -59,7 +56,7 @@ func initializeProcessor(startID uint) {
 func startProcessor(gracefulHandle, forcefulHandle *lifecycle.Handle) {
 	defer gracefulHandle.Close()
 	defer forcefulHandle.Close()
-	fmt.Println("投票处理器 (Vote Processor) 已启动。")
+	slog.Info("投票处理器 (Vote Processor) 已启动。")
 
 	// 立刻收集缺失的投票
 	globalVoteProcessor.checkAndRequeueMissedVotes(gracefulHandle.Ctx())
@@ -73,10 +70,11 @@ func startProcessor(gracefulHandle, forcefulHandle *lifecycle.Handle) {
 
 // submitVoteToQueue 供Handler调用的方法，用于提交新的投票任务，返回是否成功
 func submitVoteToQueue(vote Vote) {
+	observeVoteID(vote.ID)
 	globalVoteProcessor.shutdownMutex.Lock()
 	if globalVoteProcessor.isShutdown {
 		globalVoteProcessor.shutdownMutex.Unlock()
-		fmt.Printf("警告: 投票处理队列已满，放弃处理 vote ID: %d\n", vote.ID)
+		slog.WarnContext(vote.logContext(), "投票处理器已停机，放弃实时处理", logging.VoteID(vote.ID))
 		return
 	}
 	select {
@@ -84,23 +82,37 @@ func submitVoteToQueue(vote Vote) {
 		globalVoteProcessor.shutdownMutex.Unlock()
 	default:
 		globalVoteProcessor.shutdownMutex.Unlock()
-		fmt.Printf("警告: 投票处理队列已满，暂时放弃实时处理 vote ID: %d\n", vote.ID)
+		slog.WarnContext(vote.logContext(), "投票处理队列已满，暂时放弃实时处理", logging.VoteID(vote.ID))
 	}
 }
 
+// logContext 返回携带投票请求ID的上下文，用于把处理器的日志与提交投票的请求关联起来
+func (v Vote) logContext() context.Context {
+	return logging.WithRequestID(context.Background(), v.RequestID)
+}
+
+// batchRange 返回描述一批投票ID范围的日志字段
+func batchRange(batch []Vote) slog.Attr {
+	return slog.Group("batch",
+		slog.Uint64("first_vote_id", uint64(batch[0].ID)),
+		slog.Uint64("last_vote_id", uint64(batch[len(batch)-1].ID)),
+		slog.Int("size", len(batch)),
+	)
+}
+
 // runMainLoop 是处理器的主事件循环，现在响应两阶段停机
 func (vp *voteProcessor) runMainLoop(gracefulHandle, forcefulHandle *lifecycle.Handle) {
 	for {
 		select {
 		case <-gracefulHandle.Done():
 			// 收到第一停机信号，进入“排空队列”模式
-			fmt.Println("Vote Processor: 收到优雅停机信号，正在处理剩余任务...")
+			slog.Info("Vote Processor: 收到优雅停机信号，正在处理剩余任务...")
 			vp.drainQueue(forcefulHandle) // 使用强制停机handle来中断排空过程
-			fmt.Println("Vote Processor: 优雅停机完成，主循环退出。")
+			slog.Info("Vote Processor: 优雅停机完成，主循环退出。")
 			return
 		default:
 			// 正常处理流程
-			vp.processSingleVote(gracefulHandle)
+			vp.processNextBatch(gracefulHandle)
 		}
 	}
 }
@@ -110,7 +122,7 @@ func (vp *voteProcessor) drainQueue(forcefulHandle *lifecycle.Handle) {
 	vp.checkAndRequeueMissedVotes(forcefulHandle.Ctx())
 	select {
 	case <-forcefulHandle.Done():
-		fmt.Println("Vote Processor: 收到强制停机信号，排空队列被中断。")
+		slog.Warn("Vote Processor: 收到强制停机信号，排空队列被中断。")
 		return
 	default:
 	}
@@ -132,37 +144,40 @@ func (vp *voteProcessor) drainQueue(forcefulHandle *lifecycle.Handle) {
 	for {
 		select {
 		case <-forcefulHandle.Done():
-			fmt.Println("Vote Processor: 收到强制停机信号，排空队列被中断。")
+			slog.Warn("Vote Processor: 收到强制停机信号，排空队列被中断。")
 			return
 		default:
 		}
 
 		vp.processMutex.Lock()
+		for vp.buffer.Len() > 0 && (*vp.buffer)[0].ID <= vp.lastProcessedVoteID {
+			heap.Pop(vp.buffer)
+		}
 		if vp.buffer.Len() == 0 {
 			vp.processMutex.Unlock()
 			return // 队列已空，完成
 		}
 		// 我们只处理连续的任务
-		if (*vp.buffer)[0].ID == vp.lastProcessedVoteID+1 {
-			vote := heap.Pop(vp.buffer).(Vote)
-			vp.processMutex.Unlock()
-			// 在排空模式下，我们简化重试逻辑，如果失败则放弃
-			if err := vp.applyVoteToRepository(vote); err == nil {
-				vp.processMutex.Lock()
-				vp.lastProcessedVoteID = vote.ID
-				vp.processMutex.Unlock()
-			} else {
-				fmt.Printf("排空队列时处理 vote ID %d 失败，已放弃: %v\n", vote.ID, err)
-			}
-		} else {
+		if (*vp.buffer)[0].ID != vp.lastProcessedVoteID+1 {
 			vp.processMutex.Unlock()
 			// 如果不连续，说明有任务丢失，排空结束
 			return
 		}
+		first := heap.Pop(vp.buffer).(Vote)
+		vp.processMutex.Unlock()
+
+		batch := vp.collectContinuousVotes(first)
+		// 在排空模式下，我们简化重试逻辑，如果失败则放弃
+		if err := vp.applyVoteBatch(batch); err != nil {
+			slog.Error("排空队列时处理投票失败，已放弃", batchRange(batch), logging.Err(err))
+			return
+		}
+		vp.advanceLastProcessedVoteID(batch[len(batch)-1].ID)
 	}
 }
 
-func (vp *voteProcessor) processSingleVote(gracefulHandle *lifecycle.Handle) {
+// processNextBatch 等待下一张连续的投票，并与其后已经就绪的连续投票一起批量应用
+func (vp *voteProcessor) processNextBatch(gracefulHandle *lifecycle.Handle) {
 	nextVote, err := vp.getNextContinuousVote(gracefulHandle)
 	if err != nil {
 		return
@@ -170,39 +185,92 @@ func (vp *voteProcessor) processSingleVote(gracefulHandle *lifecycle.Handle) {
 
 	// 检查Redis健康状态
 	if !database.IsRedisHealthy() {
-		fmt.Println("Vote Processor: 检测到Redis不可用或正在重建，暂停处理...")
+		slog.Warn("Vote Processor: 检测到Redis不可用或正在重建，暂停处理...")
 		gracefulHandle.Sleep(5 * time.Second) // 与健康检查器同步休眠
 		// 将取出的任务放回暂存区，以便在Redis恢复后能被重新处理
-		vp.processMutex.Lock()
-		heap.Push(vp.buffer, nextVote)
-		vp.processMutex.Unlock()
+		vp.requeueVotes([]Vote{nextVote})
 		return
 	}
 
+	batch := vp.collectContinuousVotes(nextVote)
+
 	select {
 	case <-gracefulHandle.Done():
+		vp.requeueVotes(batch)
 		return
 	default:
 	}
 
 	// 处理投票，现在包含了精细化的重试逻辑
-	err = vp.applyVoteToRepositoryWithRetry(gracefulHandle, nextVote)
+	err = vp.applyVoteBatchWithRetry(gracefulHandle, batch)
 	if err != nil {
 		// 可能是Redis不健康了
 		if err != context.Canceled && err != context.DeadlineExceeded {
-			fmt.Printf("错误: 处理 vote ID %d 失败，已放回队列: %v\n", nextVote.ID, err)
+			slog.Error("处理投票失败，已放回队列", batchRange(batch), logging.Err(err))
 		}
 		// 将任务放回暂存区，并由外层循环处理休眠
-		vp.processMutex.Lock()
-		heap.Push(vp.buffer, nextVote)
-		vp.processMutex.Unlock()
+		vp.requeueVotes(batch)
 		return
 	}
 
 	// 只有在成功处理后才更新ID
+	vp.advanceLastProcessedVoteID(batch[len(batch)-1].ID)
+}
+
+// collectContinuousVotes 在取得下一张投票后，不阻塞地从暂存区和channel中继续收集紧随其后的连续投票，
+// 批次最多包含 voteBatchSize 张投票。收集过程中遇到的不连续投票会被放入暂存区。
+func (vp *voteProcessor) collectContinuousVotes(first Vote) []Vote {
+	batch := []Vote{first}
+	nextID := first.ID + 1
+
 	vp.processMutex.Lock()
-	vp.lastProcessedVoteID = nextVote.ID
-	vp.processMutex.Unlock()
+	defer vp.processMutex.Unlock()
+	for len(batch) < voteBatchSize {
+		// 丢弃过时或重复的堆顶元素
+		for vp.buffer.Len() > 0 && (*vp.buffer)[0].ID < nextID {
+			heap.Pop(vp.buffer)
+		}
+		if vp.buffer.Len() > 0 && (*vp.buffer)[0].ID == nextID {
+			batch = append(batch, heap.Pop(vp.buffer).(Vote))
+			nextID++
+			continue
+		}
+
+		select {
+		case vote, ok := <-vp.voteChan:
+			if !ok {
+				return batch // channel已在排空时关闭
+			}
+			if vote.ID == nextID {
+				batch = append(batch, vote)
+				nextID++
+			} else if vote.ID > nextID {
+				heap.Push(vp.buffer, vote)
+			}
+		default:
+			return batch // 没有更多就绪的投票
+		}
+	}
+	return batch
+}
+
+// requeueVotes 将未能处理的投票放回暂存区
+func (vp *voteProcessor) requeueVotes(votes []Vote) {
+	vp.processMutex.Lock()
+	defer vp.processMutex.Unlock()
+	for _, vote := range votes {
+		heap.Push(vp.buffer, vote)
+	}
+}
+
+// advanceLastProcessedVoteID 在一批投票被成功应用后推进处理进度。
+// 缓存重建可能已经把进度推进到更靠后的位置，此时保持不变。
+func (vp *voteProcessor) advanceLastProcessedVoteID(voteID uint) {
+	vp.processMutex.Lock()
+	defer vp.processMutex.Unlock()
+	if voteID > vp.lastProcessedVoteID {
+		vp.lastProcessedVoteID = voteID
+	}
 }
 
 // getNextContinuousVote 是一个阻塞函数，它会一直等待直到获取到下一个连续的投票
@@ -244,14 +312,14 @@ func (vp *voteProcessor) getNextContinuousVote(gracefulHandle *lifecycle.Handle)
 	}
 }
 
-// applyVoteToRepositoryWithRetry 包含了您设计的、带有指数退避和健康检查的重试逻辑
-func (vp *voteProcessor) applyVoteToRepositoryWithRetry(gracefulHandle *lifecycle.Handle, vote Vote) error {
+// applyVoteBatchWithRetry 包含了您设计的、带有指数退避和健康检查的重试逻辑
+func (vp *voteProcessor) applyVoteBatchWithRetry(gracefulHandle *lifecycle.Handle, batch []Vote) error {
 	initialDelay := 8 * time.Millisecond
 	maxDelay := 2 * time.Second
 
 	delay := initialDelay
 	for delay < maxDelay { // 短循环重试
-		err := vp.applyVoteToRepository(vote)
+		err := vp.applyVoteBatch(batch)
 		if err == nil {
 			return nil // 成功
 		}
@@ -268,12 +336,12 @@ func (vp *voteProcessor) applyVoteToRepositoryWithRetry(gracefulHandle *lifecycl
 			return errors.New("redis became unhealthy during retry")
 		}
 
-		err := vp.applyVoteToRepository(vote)
+		err := vp.applyVoteBatch(batch)
 		if err == nil {
 			return nil // 最终成功
 		}
 
-		fmt.Printf("告警: Redis持续写入失败，将在%v后重试 vote ID %d\n", maxDelay, vote.ID)
+		slog.Warn("Redis持续写入失败，稍后重试", batchRange(batch), slog.Duration("retry_after", maxDelay), logging.Err(err))
 		if err := gracefulHandle.Sleep(maxDelay); err != nil {
 			return err
 		}
@@ -329,13 +397,14 @@ func (vp *voteProcessor) checkAndRequeueMissedVotes(ctx context.Context) {
 			return
 		}
 
-		fmt.Printf("巡查员: 发现 %d 条被遗漏的投票，正在提交处理...\n", len(missedVotes))
+		slog.Info("巡查员: 发现被遗漏的投票，正在提交处理...", slog.Int("count", len(missedVotes)))
 		for _, vote := range missedVotes {
 			select {
 			case <-ctx.Done():
 				return
 			default:
 				if vote.ID > currentID {
+					patrollerRequeues.Inc()
 					submitVoteToQueue(vote)
 				}
 			}
@@ -343,275 +412,18 @@ func (vp *voteProcessor) checkAndRequeueMissedVotes(ctx context.Context) {
 	}
 }
 
-// applyVoteToRepository 将单个投票的计算结果原子地更新到Redis和内存仓库
-func (vp *voteProcessor) applyVoteToRepository(vote Vote) error {
-	// hack: 目前spell锁的范围会完全阻止常规流程和恢复流程的冲突
-	// 如果未来不再是这样，vote模块就需要自己的锁
-
-	// 1. 加写锁，保护对Redis和内存权重树的联合更新
-	spell.LockRepository()
-	defer spell.UnlockRepository()
-
-	vp.processMutex.Lock()
-	currentID := vp.lastProcessedVoteID
-	vp.processMutex.Unlock()
-	if currentID > vote.ID {
-		return nil
+// updateStatsByVote 是一个辅助函数，根据投票结果更新UserStats对象。撤销事件会减少对应的计数。
+func updateStatsByVote(stats *user.UserStats, vote Vote) {
+	delta := 1
+	if vote.IsUndo() {
+		delta = -1
 	}
-
-	if vote.Result == ResultSkip {
-		// 进入user临界区
-		user.LockRepository()
-		defer user.UnlockRepository()
-
-		// 1. 获得并更新用户统计
-		userStats, err := getNewUserStats(vote)
-		if err != nil {
-			return err
-		}
-
-		// 2. 更新检查点
-		pipe := database.RDB.TxPipeline()
-		pipe.Set(database.Ctx, metadata.RedisLastProcessedVoteIDKey, vote.ID, 0)
-
-		// 3. 更新用户统计
-		updateUserStats(pipe, userStats)
-
-		_, err = pipe.Exec(database.Ctx)
-		return err
-	}
-
-	// 2. 从Redis获取当前统计数据
-	keys := []string{vote.SpellA_ID, vote.SpellB_ID}
-	statsJSONs, err := database.RDB.HMGet(database.Ctx, spell.StatsKey, keys...).Result()
-	if err != nil {
-		return fmt.Errorf("无法从Redis获取法术统计数据: %w", err)
-	}
-	if statsJSONs[0] == nil || statsJSONs[1] == nil {
-		return fmt.Errorf("无法从Redis获取法术JSON数据")
-	}
-
-	var statsA, statsB spell.SpellStats
-	_ = json.Unmarshal([]byte(statsJSONs[0].(string)), &statsA)
-	_ = json.Unmarshal([]byte(statsJSONs[1].(string)), &statsB)
-	oldScoreA, oldScoreB := statsA.Score, statsB.Score
-
-	// 3. 计算新的ELO, Win, Total
 	switch vote.Result {
-	case ResultAWins:
-		statsA.Score, statsB.Score = calculateElo(statsA.Score, statsB.Score, vote.Multiplier)
-		statsA.Win += vote.Multiplier
-		statsA.Total += vote.Multiplier
-		statsB.Total += vote.Multiplier
-	case ResultBWins:
-		statsB.Score, statsA.Score = calculateElo(statsB.Score, statsA.Score, vote.Multiplier)
-		statsB.Win += vote.Multiplier
-		statsB.Total += vote.Multiplier
-		statsA.Total += vote.Multiplier
-	case ResultDraw:
-		statsA.Total += vote.Multiplier
-		statsB.Total += vote.Multiplier
-	}
-
-	eloTrackerTx := globalEloTracker.BeginUpdate()
-	defer eloTrackerTx.RollbackUnlessCommitted()
-
-	// 4. 检查ELO边界是否变化
-	boundaryChanged := globalEloTracker.Update(eloTrackerTx, oldScoreA, statsA.Score) || globalEloTracker.Update(eloTrackerTx, oldScoreB, statsB.Score)
-
-	// 5. 根据边界变化情况，选择性地更新或全局重建
-	if boundaryChanged {
-		err = rebuildAllRankScores(eloTrackerTx, vote, statsA, statsB)
-	} else {
-		err = updateRankScores(eloTrackerTx, vote, statsA, statsB)
-	}
-
-	if err != nil {
-		return err
-	}
-
-	// 更新内存权重树
-	indexA, _ := spell.GetSpellIndexByID(vote.SpellA_ID)
-	indexB, _ := spell.GetSpellIndexByID(vote.SpellB_ID)
-	spell.UpdateWeightUnsafe(indexA, spell.CalculateWeightForTotal(statsA.Total))
-	spell.UpdateWeightUnsafe(indexB, spell.CalculateWeightForTotal(statsB.Total))
-
-	eloTrackerTx.Commit()
-	return nil
-}
-
-// rebuildAllRankScores 在ELO边界变化时，执行全局的RankScore重算和批量更新
-func rebuildAllRankScores(tx *eloTrackerTx, vote Vote, currentStatsA, currentStatsB spell.SpellStats) error {
-	fmt.Println("检测到ELO边界变化，正在执行全局RankScore重建...")
-
-	// 1. 获取所有法术的统计数据
-	allStatsJSON, err := database.RDB.HGetAll(database.Ctx, spell.StatsKey).Result()
-	if err != nil {
-		return fmt.Errorf("无法获取所有法术统计数据以进行重建: %w", err)
-	}
-
-	updatedStats := make(map[string]spell.SpellStats)
-
-	for id, statsJSON := range allStatsJSON {
-		var stats spell.SpellStats
-		_ = json.Unmarshal([]byte(statsJSON), &stats)
-		updatedStats[id] = stats
-	}
-
-	// 2. 将当前投票的最新结果应用到全量数据中
-	updatedStats[vote.SpellA_ID] = currentStatsA
-	updatedStats[vote.SpellB_ID] = currentStatsB
-
-	// 3. 重新计算所有法术的RankScore
-	allScores := make([]float64, 0, len(allStatsJSON))
-	for _, stats := range updatedStats {
-		allScores = append(allScores, stats.Score)
-	}
-
-	// 重置ELO追踪器
-	globalEloTracker.Reset(tx, allScores)
-
-	// 现在用更新后的边界，为所有法术计算新的RankScore
-	for id, stats := range updatedStats {
-		stats.RankScore = CalculateRankScore(tx, stats.Score, stats.Total, stats.Win)
-		updatedStats[id] = stats
-	}
-
-	// 进入user临界区
-	user.LockRepository()
-	defer user.UnlockRepository()
-
-	// 4. 获得并更新用户统计
-	userStats, err := getNewUserStats(vote)
-	if err != nil {
-		return err
-	}
-
-	// 5. 原子地将所有更新写回Redis
-	pipe := database.RDB.TxPipeline()
-	newRanking := make([]redis.Z, 0, len(updatedStats))
-	for id, stats := range updatedStats {
-		statsJSON, _ := json.Marshal(stats)
-		pipe.HSet(database.Ctx, spell.StatsKey, id, statsJSON)
-		newRanking = append(newRanking, redis.Z{Score: stats.RankScore, Member: id})
-	}
-	pipe.ZAdd(database.Ctx, spell.RankingKey, newRanking...) // 批量更新排名
-
-	pipe.IncrByFloat(database.Ctx, metadata.RedisTotalVotesKey, vote.Multiplier)
-	pipe.Set(database.Ctx, metadata.RedisLastProcessedVoteIDKey, vote.ID, 0)
-
-	// 6. 更新用户统计
-	updateUserStats(pipe, userStats)
-
-	_, err = pipe.Exec(database.Ctx)
-	return err
-}
-
-// updateRankScores 在ELO边界未变化时，执行常规的RankScore更新和批量写入
-func updateRankScores(tx *eloTrackerTx, vote Vote, statsA, statsB spell.SpellStats) error {
-	// 1. 计算新的RankScore
-	statsA.RankScore = CalculateRankScore(tx, statsA.Score, statsA.Total, statsA.Win)
-	statsB.RankScore = CalculateRankScore(tx, statsB.Score, statsB.Total, statsB.Win)
-
-	statsAJSON, _ := json.Marshal(statsA)
-	statsBJSON, _ := json.Marshal(statsB)
-
-	// 进入user临界区
-	user.LockRepository()
-	defer user.UnlockRepository()
-
-	// 2. 获得并更新用户统计
-	userStats, err := getNewUserStats(vote)
-	if err != nil {
-		return err
-	}
-
-	// 3. 原子地写入Redis
-	pipe := database.RDB.TxPipeline()
-	pipe.HSet(database.Ctx, spell.StatsKey, vote.SpellA_ID, statsAJSON)
-	pipe.HSet(database.Ctx, spell.StatsKey, vote.SpellB_ID, statsBJSON)
-	pipe.ZAdd(database.Ctx, spell.RankingKey, redis.Z{Score: statsA.RankScore, Member: vote.SpellA_ID})
-	pipe.ZAdd(database.Ctx, spell.RankingKey, redis.Z{Score: statsB.RankScore, Member: vote.SpellB_ID})
-
-	pipe.IncrByFloat(database.Ctx, metadata.RedisTotalVotesKey, vote.Multiplier)
-	pipe.Set(database.Ctx, metadata.RedisLastProcessedVoteIDKey, vote.ID, 0)
-
-	// 4. 更新用户统计
-	updateUserStats(pipe, userStats)
-
-	_, err = pipe.Exec(database.Ctx)
-	return err
-}
-
-// updateUserStats 负责在从Redis事务中获取用户和全局的投票统计数据并更新
-func getNewUserStats(vote Vote) (map[string]user.UserStats, error) {
-	isNamedUserVote := vote.UserIdentifier != ""
-
-	// 定义需要获取统计数据的键
-	keysToFetch := []string{user.TotalStatsKey}
-	if isNamedUserVote {
-		keysToFetch = append(keysToFetch, vote.UserIdentifier)
-	}
-	// 一次性从Redis获取所有相关统计
-	statsData, err := database.RDB.HMGet(database.Ctx, user.StatsKey, keysToFetch...).Result()
-	if err != nil {
-		return nil, fmt.Errorf("无法从Redis获取用户统计数据: %w\n", err)
-	}
-
-	// 解析、更新准备写回的数据
-	statsMap := make(map[string]user.UserStats)
-
-	totalStats := user.UserStats{}
-	if statsData[0] == nil {
-		return nil, fmt.Errorf("从Redis获取用户总统计数据时出错: %w\n", err) // 不应为nil
-	}
-	_ = json.Unmarshal([]byte(statsData[0].(string)), &totalStats)
-	updateStatsByResult(&totalStats, vote.Result)
-	statsMap[user.TotalStatsKey] = totalStats
-
-	if isNamedUserVote {
-		thisUserStats := user.UserStats{}
-		if statsData[1] != nil {
-			_ = json.Unmarshal([]byte(statsData[1].(string)), &thisUserStats)
-		}
-		updateStatsByResult(&thisUserStats, vote.Result)
-		statsMap[vote.UserIdentifier] = thisUserStats
-	}
-
-	return statsMap, nil
-}
-
-// updateStatsByResult 是一个辅助函数，根据投票结果更新UserStats对象
-func updateStatsByResult(stats *user.UserStats, result VoteResult) {
-	switch result {
 	case ResultAWins, ResultBWins:
-		stats.Wins++
+		stats.Wins += delta
 	case ResultDraw:
-		stats.Draw++
+		stats.Draw += delta
 	case ResultSkip:
-		stats.Skip++
+		stats.Skip += delta
 	}
 }
-
-// updateUserStats 负责在Redis事务中应用用户和全局投票统计数据的更新
-func updateUserStats(pipe redis.Pipeliner, userStats map[string]user.UserStats) {
-	statsMap := make(map[string]interface{})
-
-	for key, stats := range userStats {
-		// 处理用户个人统计
-		if key != user.TotalStatsKey {
-			// 更新用户排名
-			totalUserVotes := stats.Wins + stats.Draw + stats.Skip
-			pipe.ZAdd(database.Ctx, user.RankingKey, redis.Z{Score: float64(totalUserVotes), Member: key})
-			// 标记用户为“脏”，用于增量备份
-			pipe.SAdd(database.Ctx, user.DirtySetKey, key)
-		}
-
-		// 处理全局统计
-		statsJSON, _ := json.Marshal(stats)
-		statsMap[key] = statsJSON
-	}
-
-	// 将更新后的统计数据批量加入事务
-	pipe.HSet(database.Ctx, user.StatsKey, statsMap)
-}
@@ -1,18 +1,18 @@
 package vote
 
 import (
-	"encoding/json"
 	"fmt"
+	"log/slog"
 
 	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
 	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/metadata"
 	"github.com/SlpAus/noita-spells-tier-backend/internal/spell"
 	"github.com/SlpAus/noita-spells-tier-backend/internal/user"
-	"github.com/redis/go-redis/v9"
 )
 
 // ApplyIncrementalVotes 在缓存重建时，处理自上次快照以来的所有新投票
 // 注意：此函数不包含锁，调用方需要确保在安全的时机（如单线程启动或重建大范围锁下）调用。
+// 它总是在最后写入ELO边界，投票应用脚本在此之前会拒绝应用投票。
 func ApplyIncrementalVotes() error {
 	lastSnapshotVoteID, err := metadata.GetLastSnapshotVoteID(database.DB)
 	if err != nil {
@@ -21,45 +21,44 @@ func ApplyIncrementalVotes() error {
 
 	const batchSize = 10000
 
-	var incrementalVotes []Vote
-	if err := database.DB.Where("id > ?", lastSnapshotVoteID).Order("id asc").Limit(batchSize).Find(&incrementalVotes).Error; err != nil {
-		return fmt.Errorf("无法从SQLite读取增量投票: %w", err)
+	// 快照可能早于归档水位（例如从旧的备份恢复后），此时增量投票的开头部分来自归档文件
+	cursor, err := newVoteCursor(database.DB, VoteFilter{AfterID: lastSnapshotVoteID})
+	if err != nil {
+		return err
+	}
+	incrementalVotes, err := cursor.next(batchSize)
+	if err != nil {
+		return fmt.Errorf("无法读取增量投票: %w", err)
 	}
 
 	if len(incrementalVotes) == 0 {
-		fmt.Println("没有新的投票记录需要处理。")
-		return nil
+		slog.Info("没有新的投票记录需要处理。")
+		return initializeEloBounds()
 	}
 
-	fmt.Printf("正在处理 %d 条自上次快照以来的新投票...\n", len(incrementalVotes))
+	slog.Info("正在处理自上次快照以来的新投票...", slog.Int("count", len(incrementalVotes)))
 
 	// 1. 一次性从Redis获取所有法术的当前统计数据到内存中
-	statsMapJSON, err := database.RDB.HGetAll(database.Ctx, spell.StatsKey).Result()
+	inMemoryStats, err := activeStore.spellStats()
 	if err != nil {
 		return fmt.Errorf("无法从Redis获取完整的法术统计数据: %w", err)
 	}
-	inMemoryStats := make(map[string]spell.SpellStats)
-	for id, jsonStr := range statsMapJSON {
-		var stats spell.SpellStats
-		if err := json.Unmarshal([]byte(jsonStr), &stats); err == nil {
-			inMemoryStats[id] = stats
-		}
-	}
 
 	// 2. 在内存中批量计算所有增量投票
 	var lastProcessedID uint = 0
 	var totalVotesIncrement float64 = 0
 
-	// a. 获取用户总统计数据
+	// a. 获取用户总统计数据，以及评估用户聚合数据所用的法术排名
+	// 增量投票期间的排名变化不会被逐票追踪，统一按重建开始时的排名评估
 	userStatsAggregator := make(map[string]user.UserStats)
-	totalStatsJSON, err := database.RDB.HGet(database.Ctx, user.StatsKey, user.TotalStatsKey).Result()
+	userAggAggregator := make(map[string]user.UserAggregates)
+	spellRank, err := currentSpellRanks()
 	if err != nil {
-		return fmt.Errorf("无法从Redis获取用户总统计数据: %w", err)
+		return err
 	}
-	var totalStats user.UserStats
-	err = json.Unmarshal([]byte(totalStatsJSON), &totalStats)
+	totalStats, err := activeStore.totalStats()
 	if err != nil {
-		return fmt.Errorf("解析从Redis获取的用户总统计数据时出错: %w", err)
+		return err
 	}
 
 	for {
@@ -77,29 +76,32 @@ func ApplyIncrementalVotes() error {
 			for id := range newUsersInBatch {
 				newUserIDs = append(newUserIDs, id)
 			}
-			newStatsData, err := database.RDB.HMGet(database.Ctx, user.StatsKey, newUserIDs...).Result()
+			newStats, err := activeStore.userStats(newUserIDs...)
 			if err != nil {
 				return fmt.Errorf("从Redis批量获取用户统计数据时出错: %w", err)
 			}
-			for i, data := range newStatsData {
-				var stats user.UserStats
-				if data != nil {
-					err = json.Unmarshal([]byte(data.(string)), &stats)
-					if err != nil {
-						return fmt.Errorf("解析用户 %s 的统计数据时出错: %w", newUserIDs[i], err)
-					}
-				}
-				userStatsAggregator[newUserIDs[i]] = stats
+			newAggs, err := activeStore.userAggregates(newUserIDs...)
+			if err != nil {
+				return fmt.Errorf("从Redis批量获取用户聚合数据时出错: %w", err)
+			}
+			for _, id := range newUserIDs {
+				userStatsAggregator[id] = newStats[id]
+				userAggAggregator[id] = newAggs[id]
 			}
 		}
 
 		for _, vote := range incrementalVotes {
 			// c. 批量更新用户统计数据
-			updateStatsByResult(&totalStats, vote.Result)
+			updateStatsByVote(&totalStats, vote)
 			if vote.UserIdentifier != "" {
 				userStats := userStatsAggregator[vote.UserIdentifier]
-				updateStatsByResult(&userStats, vote.Result)
+				updateStatsByVote(&userStats, vote)
+				userStats.LastVoteID = vote.ID
 				userStatsAggregator[vote.UserIdentifier] = userStats
+
+				userAgg := userAggAggregator[vote.UserIdentifier]
+				applyVoteToAggregates(&userAgg, vote, userStats.Wins+userStats.Draw+userStats.Skip, spellRank)
+				userAggAggregator[vote.UserIdentifier] = userAgg
 			}
 
 			if vote.Result != ResultSkip {
@@ -109,21 +111,7 @@ func ApplyIncrementalVotes() error {
 					return fmt.Errorf("法术对 (%s , %s) 不存在", vote.SpellA_ID, vote.SpellB_ID)
 				}
 
-				switch vote.Result {
-				case ResultAWins:
-					statsA.Score, statsB.Score = calculateElo(statsA.Score, statsB.Score, vote.Multiplier)
-					statsA.Win += vote.Multiplier
-					statsA.Total += vote.Multiplier
-					statsB.Total += vote.Multiplier
-				case ResultBWins:
-					statsB.Score, statsA.Score = calculateElo(statsB.Score, statsA.Score, vote.Multiplier)
-					statsB.Win += vote.Multiplier
-					statsB.Total += vote.Multiplier
-					statsA.Total += vote.Multiplier
-				case ResultDraw:
-					statsA.Total += vote.Multiplier
-					statsB.Total += vote.Multiplier
-				}
+				applyVoteToSpellStats(&statsA, &statsB, vote)
 				inMemoryStats[vote.SpellA_ID] = statsA
 				inMemoryStats[vote.SpellB_ID] = statsB
 				totalVotesIncrement += vote.Multiplier
@@ -131,29 +119,27 @@ func ApplyIncrementalVotes() error {
 			lastProcessedID = vote.ID
 		}
 
-		if len(incrementalVotes) < batchSize {
-			break
+		if incrementalVotes, err = cursor.next(batchSize); err != nil {
+			return fmt.Errorf("无法读取增量投票: %w", err)
 		}
-
-		incrementalVotes = incrementalVotes[:0]
-		if err := database.DB.Where("id > ?", lastProcessedID).Order("id asc").Limit(batchSize).Find(&incrementalVotes).Error; err != nil {
-			return fmt.Errorf("无法从SQLite读取增量投票: %w", err)
+		if len(incrementalVotes) == 0 {
+			break
 		}
 	}
 
-	// 3. 批量计算完成后，一次性重置ELO追踪器
-	eloTrackerTx := globalEloTracker.BeginUpdate()
-	defer eloTrackerTx.RollbackUnlessCommitted()
-
+	// 3. 批量计算完成后，一次性重新计算ELO边界
 	allScores := make([]float64, 0, len(inMemoryStats))
 	for _, stats := range inMemoryStats {
 		allScores = append(allScores, stats.Score)
 	}
-	globalEloTracker.Reset(eloTrackerTx, allScores)
+	bounds, err := newEloBounds(allScores)
+	if err != nil {
+		return err
+	}
 
 	// 4. 使用更新后的边界，为所有法术计算新的RankScore并更新权重树
 	for id, stats := range inMemoryStats {
-		stats.RankScore = CalculateRankScore(eloTrackerTx, stats.Score, stats.Total, stats.Win)
+		stats.RankScore = CalculateRankScore(bounds, stats.Score, stats.Total, stats.Win)
 		inMemoryStats[id] = stats
 		index, ok := spell.GetSpellIndexByID(id)
 		if ok {
@@ -161,46 +147,17 @@ func ApplyIncrementalVotes() error {
 		}
 	}
 
-	eloTrackerTx.Commit()
-
-	// 5. 使用Pipeline一次性将所有更新后的数据写回Redis
-
-	// a. 法术数据部分
-	pipe := database.RDB.TxPipeline()
-	newRanking := make([]redis.Z, 0, len(inMemoryStats))
-	for id, stats := range inMemoryStats {
-		statsJSON, _ := json.Marshal(stats)
-		pipe.HSet(database.Ctx, spell.StatsKey, id, statsJSON)
-		newRanking = append(newRanking, redis.Z{Score: stats.RankScore, Member: id})
-	}
-	pipe.ZAdd(database.Ctx, spell.RankingKey, newRanking...)
-
-	// b. 元数据部分
-	if totalVotesIncrement > 0 {
-		pipe.IncrByFloat(database.Ctx, metadata.RedisTotalVotesKey, totalVotesIncrement)
-	}
-	if lastProcessedID > 0 {
-		pipe.Set(database.Ctx, metadata.RedisLastProcessedVoteIDKey, lastProcessedID, 0)
-	}
-
-	// c. 用户数据部分
-	finalTotalStatsJSON, _ := json.Marshal(totalStats)
-	pipe.HSet(database.Ctx, user.StatsKey, user.TotalStatsKey, finalTotalStatsJSON)
-
-	userStatsToWrite := make(map[string]interface{})
-	for id, stats := range userStatsAggregator {
-		statsJSON, _ := json.Marshal(stats)
-		userStatsToWrite[id] = statsJSON
-
-		totalVotes := stats.Wins + stats.Draw + stats.Skip
-		pipe.ZAdd(database.Ctx, user.RankingKey, redis.Z{Score: float64(totalVotes), Member: id})
-		pipe.SAdd(database.Ctx, user.DirtySetKey, id)
-	}
-	if len(userStatsToWrite) > 0 {
-		pipe.HSet(database.Ctx, user.StatsKey, userStatsToWrite)
-	}
-
-	if _, err := pipe.Exec(database.Ctx); err != nil {
+	// 5. 一次性将所有更新后的数据写回Redis
+	err = activeStore.saveRebuild(rebuildResult{
+		spellStats:          inMemoryStats,
+		bounds:              bounds,
+		totalVotesIncrement: totalVotesIncrement,
+		lastProcessedID:     lastProcessedID,
+		totalStats:          totalStats,
+		userStats:           userStatsAggregator,
+		userAggregates:      userAggAggregator,
+	})
+	if err != nil {
 		return fmt.Errorf("批量更新Redis失败: %w", err)
 	}
 
@@ -209,8 +166,28 @@ func ApplyIncrementalVotes() error {
 		globalVoteProcessor.processMutex.Lock()
 		globalVoteProcessor.lastProcessedVoteID = lastProcessedID
 		globalVoteProcessor.processMutex.Unlock()
-		fmt.Printf("增量投票处理完成，Vote Processor将从 ID %d 继续。\n", lastProcessedID)
+		slog.Info("增量投票处理完成。", slog.Uint64("last_processed_vote_id", uint64(lastProcessedID)))
 	}
 
 	return nil
 }
+
+// applyVoteToSpellStats 根据一张非跳过的投票更新双方的ELO、胜场和总场次，
+// 投票应用脚本中的 applyVoteToSpells 与它保持一致。
+func applyVoteToSpellStats(statsA, statsB *spell.SpellStats, vote Vote) {
+	switch vote.Result {
+	case ResultAWins:
+		statsA.Score, statsB.Score = calculateElo(statsA.Score, statsB.Score, vote.Multiplier)
+		statsA.Win += vote.Multiplier
+		statsA.Total += vote.Multiplier
+		statsB.Total += vote.Multiplier
+	case ResultBWins:
+		statsB.Score, statsA.Score = calculateElo(statsB.Score, statsA.Score, vote.Multiplier)
+		statsB.Win += vote.Multiplier
+		statsB.Total += vote.Multiplier
+		statsA.Total += vote.Multiplier
+	case ResultDraw:
+		statsA.Total += vote.Multiplier
+		statsB.Total += vote.Multiplier
+	}
+}
@@ -0,0 +1,89 @@
+package vote
+
+import (
+	"math"
+	"testing"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/metadata"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/spell"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/user"
+)
+
+// TestRebuildMatchesLiveProcessing 验证从SQLite重建缓存得到的结果与逐批实时处理的结果一致
+func TestRebuildMatchesLiveProcessing(t *testing.T) {
+	setupTestEnv(t)
+	alice, bob := newUserID(), newUserID()
+
+	submitVote(t, alice, "BOMB", "LIGHT_BULLET", ResultAWins)
+	submitVote(t, bob, "BLACK_HOLE", "BOMB", ResultAWins)
+	submitVote(t, alice, "CHAINSAW", "DIGGER", ResultBWins)
+	submitVote(t, bob, "TELEPORT_PROJECTILE", "LIGHT_BULLET", ResultDraw)
+	submitVote(t, alice, "DIGGER", "BLACK_HOLE", ResultSkip)
+	submitVote(t, bob, "BOMB", "DIGGER", ResultAWins)
+	processQueuedVotes(t)
+
+	liveSpells := mustSpellStats(t)
+	liveUsers := map[string]user.UserStats{alice: mustUserStats(t, alice), bob: mustUserStats(t, bob)}
+	liveAggs, err := activeStore.userAggregates(alice, bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 与运行时热重建相同的流程
+	if err := InvalidateEloBounds(); err != nil {
+		t.Fatal(err)
+	}
+	if err := metadata.WarmupCache(); err != nil {
+		t.Fatal(err)
+	}
+	if err := spell.WarmupCache(); err != nil {
+		t.Fatal(err)
+	}
+	if err := user.WarmupCache(); err != nil {
+		t.Fatal(err)
+	}
+	if err := RebuildAndApplyVotes(); err != nil {
+		t.Fatal(err)
+	}
+
+	rebuiltSpells := mustSpellStats(t)
+	for id, live := range liveSpells {
+		rebuilt := rebuiltSpells[id]
+		if rebuilt.Total != live.Total || rebuilt.Win != live.Win ||
+			math.Abs(rebuilt.Score-live.Score) > 1e-9 || math.Abs(rebuilt.RankScore-live.RankScore) > 1e-9 {
+			t.Errorf("%s: 重建后 %+v, 实时处理 %+v", id, rebuilt, live)
+		}
+	}
+
+	rebuiltAggs, err := activeStore.userAggregates(alice, bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{alice, bob} {
+		if got := mustUserStats(t, id); got != liveUsers[id] {
+			t.Errorf("用户 %s: 重建后 %+v, 实时处理 %+v", id, got, liveUsers[id])
+		}
+		// 排名相关的字段按重建开始时的排名评估，只比较计数
+		live, rebuilt := liveAggs[id], rebuiltAggs[id]
+		if len(rebuilt.Spells) != len(live.Spells) {
+			t.Errorf("用户 %s 的法术计数: 重建后 %v, 实时处理 %v", id, rebuilt.Spells, live.Spells)
+		}
+		for spellID, tally := range live.Spells {
+			if rebuilt.Spells[spellID] != tally {
+				t.Errorf("用户 %s 的 %s 计数: 重建后 %+v, 实时处理 %+v", id, spellID, rebuilt.Spells[spellID], tally)
+			}
+		}
+		for day, count := range live.Days {
+			if rebuilt.Days[day] != count {
+				t.Errorf("用户 %s 在 %s 的投票数: 重建后 %d, 实时处理 %d", id, day, rebuilt.Days[day], count)
+			}
+		}
+	}
+
+	// 重建后的投票仍能被实时处理
+	submitVote(t, alice, "BOMB", "CHAINSAW", ResultAWins)
+	processQueuedVotes(t)
+	if got := mustSpellStats(t)["BOMB"]; got.Total != liveSpells["BOMB"].Total+1 {
+		t.Errorf("重建后应用的投票没有生效: %+v", got)
+	}
+}
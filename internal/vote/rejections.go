@@ -0,0 +1,111 @@
+package vote
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/clientip"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/config"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/logging"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/ratelimit"
+	"github.com/SlpAus/noita-spells-tier-backend/pkg/lifecycle"
+)
+
+var (
+	// rejectionRule 是每个来源IP网段写入拒绝记录的令牌桶
+	rejectionRule ratelimit.Rule
+	// rejectionRetention 是拒绝记录的保留时长
+	rejectionRetention time.Duration
+	// rejectionPruneInterval 是清理过期拒绝记录的频率
+	rejectionPruneInterval time.Duration
+)
+
+func loadRejectionPolicy(cfg config.VoteConfig) {
+	rejectionRule = ratelimit.Rule{Rate: cfg.Rejections.PerIP.Rate, Burst: cfg.Rejections.PerIP.Burst}
+	rejectionRetention = cfg.Rejections.Retention
+	rejectionPruneInterval = cfg.Rejections.PruneInterval
+}
+
+// recordRejectedVote 将一次被拒绝的投票写入数据库以供分析。
+// 这是尽力而为的操作，失败时只打印日志，不影响对请求的响应。
+// 它同时负责按拒绝原因累加投票提交指标。
+//
+// 被拒绝的请求可能来自未经认证的调用方，因此每个来源IP网段的写入受令牌桶限制，
+// 超出的拒绝只计入指标；签名无效的请求内容不可信，只记录原因、IP和时间；
+// 凭证绑定到其他用户时，额外记录凭证的用户，以便分析凭证共享。
+func recordRejectedVote(ctx context.Context, reason RejectionReason, body SubmitSpellVoteRequestBody, userID, ip string, rejectTime time.Time) {
+	voteSubmissions.WithLabelValues(rejectionOutcome(reason)).Inc()
+
+	subnet, err := clientip.SubnetKey(ip)
+	if err != nil {
+		subnet = ip
+	}
+	if !ratelimit.Allow("rejected_votes:ip:"+subnet, rejectionRule, rejectTime) {
+		rejectionsUnrecorded.Inc()
+		return
+	}
+
+	rejected := RejectedVote{
+		Reason:     reason,
+		UserIP:     ip,
+		RejectTime: rejectTime,
+	}
+	if reason != RejectBadSignature {
+		rejected.PairID = body.PairID
+		rejected.SpellA_ID = body.SpellAID
+		rejected.SpellB_ID = body.SpellBID
+		rejected.Result = body.Result
+		rejected.UserIdentifier = userID
+		rejected.IssuedAt = time.UnixMilli(body.IssuedAt)
+	}
+	if reason == RejectWrongUser {
+		rejected.TokenUserIdentifier = body.UserID
+	}
+	if err := database.DB.Create(&rejected).Error; err != nil {
+		slog.WarnContext(ctx, "无法记录被拒绝的投票", slog.String("pair_id", rejected.PairID), slog.String("reason", string(reason)), logging.Err(err))
+	}
+}
+
+// PruneRejectedVotes 分批删除早于保留时长的拒绝记录，返回被删除的记录数
+func PruneRejectedVotes(now time.Time) (int64, error) {
+	const batchSize = 5000
+
+	cutoff := now.Add(-rejectionRetention)
+	var pruned int64
+	for {
+		expiredIDs := database.DB.Model(&RejectedVote{}).Unscoped().Select("id").Where("reject_time < ?", cutoff).Limit(batchSize)
+		result := database.DB.Unscoped().Where("id IN (?)", expiredIDs).Delete(&RejectedVote{})
+		if result.Error != nil {
+			return pruned, fmt.Errorf("修剪过期拒绝记录失败: %w", result.Error)
+		}
+		pruned += result.RowsAffected
+		if result.RowsAffected < batchSize {
+			break
+		}
+	}
+	return pruned, nil
+}
+
+// StartRejectionPruner 启动一个后台Goroutine来定期删除过期的拒绝记录。
+// 接收一个lifecycle.Handle来管理其生命周期。
+func StartRejectionPruner(handle *lifecycle.Handle) {
+	defer handle.Close()
+	slog.Info("拒绝记录修剪器已启动。", slog.Duration("retention", rejectionRetention))
+
+	for {
+		pruned, err := PruneRejectedVotes(time.Now())
+		if err != nil {
+			slog.Error("拒绝记录修剪器错误", logging.Err(err))
+		} else if pruned > 0 {
+			slog.Info("拒绝记录修剪器: 已删除过期记录。", slog.Int64("pruned", pruned))
+		}
+
+		if err := handle.Sleep(rejectionPruneInterval); err != nil {
+			slog.Info("拒绝记录修剪器: 休眠被中断，正在关闭...")
+			return
+		}
+	}
+}
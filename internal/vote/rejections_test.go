@@ -0,0 +1,83 @@
+package vote
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/ratelimit"
+)
+
+func TestRecordRejectedVoteLimitsAndPrunes(t *testing.T) {
+	setupTestEnv(t)
+	rejectionRule = ratelimit.Rule{Rate: 0.001, Burst: 2}
+	rejectionRetention = 24 * time.Hour
+	t.Cleanup(func() { rejectionRule = ratelimit.Rule{} })
+
+	body := SubmitSpellVoteRequestBody{PairID: "forged", SpellAID: "BOMB", SpellBID: "DIGGER", Result: ResultAWins}
+	userID := newUserID()
+	now := time.Now()
+
+	// 1. 签名无效的请求只记录原因、IP和时间
+	recordRejectedVote(context.Background(), RejectBadSignature, body, userID, testIP, now)
+	// 2. 签名有效的请求保留完整内容
+	recordRejectedVote(context.Background(), RejectTooFast, body, userID, testIP, now)
+	// 3. 同一IP网段超出限额的拒绝不再写入数据库
+	recordRejectedVote(context.Background(), RejectExpired, body, userID, testIP, now)
+
+	var rows []RejectedVote
+	if err := database.DB.Order("id asc").Find(&rows).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("写入了 %d 条拒绝记录, 期望 2", len(rows))
+	}
+	if rows[0].PairID != "" || rows[0].UserIdentifier != "" || rows[0].UserIP != testIP {
+		t.Errorf("签名无效的拒绝记录: %+v", rows[0])
+	}
+	if rows[1].PairID != "forged" || rows[1].UserIdentifier != userID {
+		t.Errorf("签名有效的拒绝记录: %+v", rows[1])
+	}
+	if pruned, err := PruneRejectedVotes(now); err != nil || pruned != 0 {
+		t.Fatalf("删除了 %d 条保留时长内的记录 (%v)", pruned, err)
+	}
+
+	// 4. 超过保留时长的记录被删除
+	if pruned, err := PruneRejectedVotes(now.Add(rejectionRetention).Add(time.Second)); err != nil || pruned != 2 {
+		t.Fatalf("删除了 %d 条过期记录 (%v), 期望 2", pruned, err)
+	}
+}
+
+func TestWrongUserTokenIsRecorded(t *testing.T) {
+	setupTestEnv(t)
+	alice, bob := newUserID(), newUserID()
+
+	// 1. 签名有效但绑定到其他用户的凭证以 WRONG_USER 拒绝，而不是 BAD_SIGNATURE
+	body := signedVote(t, alice, "BOMB", "DIGGER", ResultAWins)
+	if w := performRequest(SubmitVote, bob, testIP, body); w.Code != http.StatusForbidden {
+		t.Fatalf("使用其他用户的凭证: %d %s", w.Code, w.Body.String())
+	}
+
+	// 2. 篡改过的凭证仍然是 BAD_SIGNATURE
+	forged := signedVote(t, alice, "BOMB", "DIGGER", ResultAWins)
+	forged.UserID = bob
+	if w := performRequest(SubmitVote, bob, testIP, forged); w.Code != http.StatusForbidden {
+		t.Fatalf("篡改过的凭证: %d %s", w.Code, w.Body.String())
+	}
+
+	var rows []RejectedVote
+	if err := database.DB.Order("id asc").Find(&rows).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("写入了 %d 条拒绝记录, 期望 2", len(rows))
+	}
+	if r := rows[0]; r.Reason != RejectWrongUser || r.PairID != body.PairID || r.UserIdentifier != bob || r.TokenUserIdentifier != alice {
+		t.Errorf("WRONG_USER 拒绝记录: %+v", r)
+	}
+	if r := rows[1]; r.Reason != RejectBadSignature || r.TokenUserIdentifier != "" {
+		t.Errorf("BAD_SIGNATURE 拒绝记录: %+v", r)
+	}
+}
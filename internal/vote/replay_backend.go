@@ -0,0 +1,298 @@
+package vote
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/config"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/logging"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// --- 防重放缓存后端 ---
+
+// 可配置的防重放后端名称
+const (
+	ReplayBackendAuto   = "auto"
+	ReplayBackendBloom  = "bloom"
+	ReplayBackendBucket = "bucket"
+)
+
+// replayBackend 抽象了防重放系统位于Redis中的缓存层。
+// SQLite中的UsedPairID表始终是最终的事实来源，后端只负责快速判断和记录。
+type replayBackend interface {
+	// Name 返回后端的名称，用于日志
+	Name() string
+	// Reset 擦除后端在Redis中的所有数据，并创建一个全新的、空的结构
+	Reset() error
+	// Contains 只读地检查一个PairID是否已被记录
+	Contains(pairID string) (bool, error)
+	// Add 原子地记录一个PairID
+	Add(pairID string) error
+	// AddBatch 批量记录PairID，用于从SQLite恢复
+	AddBatch(pairIDs []string) error
+}
+
+var (
+	// configuredReplayBackend 是配置中指定的后端名称
+	configuredReplayBackend string
+	// activeReplayBackend 是当前使用的防重放后端，在首次初始化时选定
+	activeReplayBackend replayBackend
+)
+
+func loadReplayConfig(cfg config.VoteConfig) {
+	configuredReplayBackend = cfg.ReplayBackend
+}
+
+// selectReplayBackend 根据配置选择防重放后端。
+// 配置为auto时，检测Redis是否加载了RedisBloom模块。
+func selectReplayBackend(name string) (replayBackend, error) {
+	switch name {
+	case ReplayBackendBloom:
+		return bloomReplayBackend{}, nil
+	case ReplayBackendBucket:
+		return bucketReplayBackend{}, nil
+	case ReplayBackendAuto, "":
+		supported, err := detectBloomSupport()
+		if err != nil {
+			return nil, fmt.Errorf("检测RedisBloom模块失败: %w", err)
+		}
+		if supported {
+			return bloomReplayBackend{}, nil
+		}
+		return bucketReplayBackend{}, nil
+	default:
+		return nil, fmt.Errorf("未知的防重放后端: %s", name)
+	}
+}
+
+// detectBloomSupport 通过 COMMAND INFO 检查Redis是否支持 BF.RESERVE 命令
+func detectBloomSupport() (bool, error) {
+	res, err := database.RDB.Do(database.Ctx, "COMMAND", "INFO", "BF.RESERVE").Slice()
+	if err != nil {
+		return false, err
+	}
+	return len(res) > 0 && res[0] != nil, nil
+}
+
+// execPipeline 将queue加入的命令在pipe中一次性执行
+func execPipeline(pipe redis.Pipeliner, queue func(pipe redis.Pipeliner)) error {
+	queue(pipe)
+	_, err := pipe.Exec(database.Ctx)
+	return err
+}
+
+// --- 时间分片 ---
+
+const (
+	// replaySliceWidth 是每个时间分片覆盖的时间跨度。
+	// 每个PairID按其UUIDv7中的时间戳落入一个分片，分片在其覆盖的凭证全部过期后由Redis自动删除。
+	replaySliceWidth = 10 * time.Minute
+)
+
+// pairIDTime 从UUIDv7格式的PairID中提取其生成时间
+func pairIDTime(pairID string) (time.Time, error) {
+	parsed, err := uuid.Parse(pairID)
+	if err != nil || parsed.Version() != 7 {
+		return time.Time{}, fmt.Errorf("PairID %s 不是有效的UUIDv7", pairID)
+	}
+	sec, nsec := parsed.Time().UnixTime()
+	return time.Unix(sec, nsec), nil
+}
+
+// replaySliceFor 返回PairID所属分片的后缀（起始时间的Unix秒数）和分片的过期时间
+func replaySliceFor(pairID string) (string, time.Time, error) {
+	t, err := pairIDTime(pairID)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	start := t.Truncate(replaySliceWidth)
+	// 分片内最晚的凭证也过期后，分片才可以被删除
+	expireAt := start.Add(replaySliceWidth + tokenTTL)
+	return strconv.FormatInt(start.Unix(), 10), expireAt, nil
+}
+
+// groupBySlice 将一批PairID按分片分组，并跳过无效或已过期的PairID
+func groupBySlice(pairIDs []string) (map[string][]interface{}, map[string]time.Time) {
+	now := time.Now()
+	slices := make(map[string][]interface{})
+	expireAts := make(map[string]time.Time)
+	for _, id := range pairIDs {
+		slice, expireAt, err := replaySliceFor(id)
+		if err != nil || !expireAt.After(now) {
+			continue
+		}
+		slices[slice] = append(slices[slice], id)
+		expireAts[slice] = expireAt
+	}
+	return slices, expireAts
+}
+
+// --- 时间分桶后端 (纯Redis) ---
+
+const (
+	// pairIDBucketKeyPrefix 是时间分桶Set的键名前缀，后接分片起始时间的Unix秒数
+	pairIDBucketKeyPrefix = database.KeyPrefix + "pairid_bucket:"
+)
+
+// pairIDBucketKey 返回一个时间分片的分桶Set键名。它与投票数据集的其他键共用哈希标签，
+// 位于集群的同一槽位，因此健康检查通过该槽位主节点的run_id就能发现防重放缓存的丢失。
+func pairIDBucketKey(slice string) string {
+	return pairIDBucketKeyPrefix + slice
+}
+
+// bucketReplayBackend 将PairID记录在按时间分片的Redis Set中
+type bucketReplayBackend struct{}
+
+func (bucketReplayBackend) Name() string { return ReplayBackendBucket }
+
+func (bucketReplayBackend) Reset() error {
+	if err := deleteKeysByPrefix(database.Ctx, pairIDBucketKeyPrefix); err != nil {
+		return fmt.Errorf("擦除旧的Redis防重放数据失败: %w", err)
+	}
+	return nil
+}
+
+func (bucketReplayBackend) Contains(pairID string) (bool, error) {
+	slice, _, err := replaySliceFor(pairID)
+	if err != nil {
+		return false, err
+	}
+	exists, err := database.RDB.SIsMember(database.Ctx, pairIDBucketKey(slice), pairID).Result()
+	if err != nil {
+		return false, fmt.Errorf("查询Redis分桶缓存失败: %w", err)
+	}
+	return exists, nil
+}
+
+func (b bucketReplayBackend) Add(pairID string) error {
+	return execPipeline(database.RDB.TxPipeline(), func(pipe redis.Pipeliner) { b.queueAdd(pipe, pairID) })
+}
+
+func (b bucketReplayBackend) AddBatch(pairIDs []string) error {
+	return execPipeline(database.RDB.Pipeline(), func(pipe redis.Pipeliner) { b.queueAddBatch(pipe, pairIDs) })
+}
+
+// queueAdd 将记录一个PairID的命令加入到给定的Redis事务中
+func (bucketReplayBackend) queueAdd(pipe redis.Pipeliner, pairID string) {
+	slice, expireAt, err := replaySliceFor(pairID)
+	if err != nil {
+		// PairID经过签名验证，理论上总是有效的UUIDv7
+		slog.Warn("无法为PairID确定时间分片", slog.String("pair_id", pairID), logging.Err(err))
+		return
+	}
+	key := pairIDBucketKey(slice)
+	pipe.SAdd(database.Ctx, key, pairID)
+	pipe.ExpireAt(database.Ctx, key, expireAt)
+}
+
+// queueAddBatch 将批量记录PairID的命令加入到给定的Pipeline中
+func (bucketReplayBackend) queueAddBatch(pipe redis.Pipeliner, pairIDs []string) {
+	slices, expireAts := groupBySlice(pairIDs)
+	for slice, members := range slices {
+		key := pairIDBucketKey(slice)
+		pipe.SAdd(database.Ctx, key, members...)
+		pipe.ExpireAt(database.Ctx, key, expireAts[slice])
+	}
+}
+
+// --- 布隆过滤器后端 (需要RedisBloom) ---
+
+const (
+	// pairIDBloomKeyPrefix 是时间分片布隆过滤器的键名前缀，后接分片起始时间的Unix秒数
+	pairIDBloomKeyPrefix = database.KeyPrefix + "pairid_bloom:"
+
+	// 旧版本使用的、不分片的键，仅在重置时清理
+	legacyBloomFilterKey = "pairid_bloom_filter"
+	legacyCacheSetKey    = "pairid_cache_set"
+
+	// 每个分片的布隆过滤器参数。分片随时间轮换，因此误判率不会随总量无限增长。
+	bloomFilterErrorRate     = 0.001
+	bloomFilterSliceCapacity = 100000
+)
+
+// pairIDBloomKey 返回一个时间分片的布隆过滤器键名，与 pairIDBucketKey 位于同一槽位
+func pairIDBloomKey(slice string) string {
+	return pairIDBloomKeyPrefix + slice
+}
+
+// bloomReplayBackend 在时间分桶Set之前增加一层同样按时间分片的布隆过滤器，
+// 使绝大多数新的PairID只需一次BF.EXISTS即可确认未被使用。
+type bloomReplayBackend struct {
+	bucketReplayBackend
+}
+
+func (bloomReplayBackend) Name() string { return ReplayBackendBloom }
+
+func (b bloomReplayBackend) Reset() error {
+	// 两个旧键不在同一槽位，分别删除
+	for _, key := range []string{legacyBloomFilterKey, legacyCacheSetKey} {
+		if err := database.RDB.Del(database.Ctx, key).Err(); err != nil {
+			return fmt.Errorf("擦除旧的Redis防重放数据失败: %w", err)
+		}
+	}
+	if err := deleteKeysByPrefix(database.Ctx, pairIDBloomKeyPrefix); err != nil {
+		return fmt.Errorf("擦除旧的Redis防重放数据失败: %w", err)
+	}
+	return b.bucketReplayBackend.Reset()
+}
+
+func (b bloomReplayBackend) Contains(pairID string) (bool, error) {
+	slice, _, err := replaySliceFor(pairID)
+	if err != nil {
+		return false, err
+	}
+
+	// Tier 1: 布隆过滤器 (分片不存在时返回false)
+	existsInBF, err := database.RDB.BFExists(database.Ctx, pairIDBloomKey(slice), pairID).Result()
+	if err != nil {
+		return false, fmt.Errorf("查询布隆过滤器失败: %w", err)
+	}
+	if !existsInBF {
+		return false, nil
+	}
+
+	// Tier 2: 时间分桶 Set 缓存
+	return b.bucketReplayBackend.Contains(pairID)
+}
+
+// bloomInsertOptions 用于在分片首次写入时以指定参数创建布隆过滤器
+var bloomInsertOptions = &redis.BFInsertOptions{
+	Capacity: bloomFilterSliceCapacity,
+	Error:    bloomFilterErrorRate,
+}
+
+// Add 与 AddBatch 需要重新定义，否则嵌入的 bucketReplayBackend 只会调用它自己的 queueAdd
+func (b bloomReplayBackend) Add(pairID string) error {
+	return execPipeline(database.RDB.TxPipeline(), func(pipe redis.Pipeliner) { b.queueAdd(pipe, pairID) })
+}
+
+func (b bloomReplayBackend) AddBatch(pairIDs []string) error {
+	return execPipeline(database.RDB.Pipeline(), func(pipe redis.Pipeliner) { b.queueAddBatch(pipe, pairIDs) })
+}
+
+func (b bloomReplayBackend) queueAdd(pipe redis.Pipeliner, pairID string) {
+	slice, expireAt, err := replaySliceFor(pairID)
+	if err != nil {
+		slog.Warn("无法为PairID确定时间分片", slog.String("pair_id", pairID), logging.Err(err))
+		return
+	}
+	key := pairIDBloomKey(slice)
+	pipe.BFInsert(database.Ctx, key, bloomInsertOptions, pairID)
+	pipe.ExpireAt(database.Ctx, key, expireAt)
+	b.bucketReplayBackend.queueAdd(pipe, pairID)
+}
+
+func (b bloomReplayBackend) queueAddBatch(pipe redis.Pipeliner, pairIDs []string) {
+	slices, expireAts := groupBySlice(pairIDs)
+	for slice, members := range slices {
+		key := pairIDBloomKey(slice)
+		pipe.BFInsert(database.Ctx, key, bloomInsertOptions, members...)
+		pipe.ExpireAt(database.Ctx, key, expireAts[slice])
+	}
+	b.bucketReplayBackend.queueAddBatch(pipe, pairIDs)
+}
@@ -3,68 +3,127 @@ package vote
 import (
 	"errors"
 	"fmt"
+	"log/slog"
 	"sync"
 	"time"
 
 	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/logging"
+	"github.com/SlpAus/noita-spells-tier-backend/pkg/lifecycle"
 	"gorm.io/gorm"
 )
 
 // --- 数据模型 ---
 
 // UsedPairID 定义了已使用的PairID在数据库中的存储结构
+// 记录只需保留到对应凭证过期为止，之后由修剪任务删除。
 type UsedPairID struct {
-	PairID    string `gorm:"primaryKey;type:varchar(36)"`
+	PairID    string    `gorm:"primaryKey;type:varchar(36)"`
+	IssuedAt  time.Time `gorm:"index"` // 从PairID(UUIDv7)中解析出的签发时间
 	CreatedAt time.Time
 }
 
 // --- 常量与全局变量 ---
 
 const (
-	bloomFilterKey = "pairid_bloom_filter"
-	cacheSetKey    = "pairid_cache_set"
-
-	bloomFilterErrorRate = 0.001
-	bloomFilterCapacity  = 1000000
+	// replayPruneInterval 是修剪过期PairID记录的频率
+	replayPruneInterval = 10 * time.Minute
+	// replayRetentionMargin 是在凭证有效期之外额外保留记录的时间，以容忍多实例间的时钟偏差
+	replayRetentionMargin = 1 * time.Minute
 )
 
 var (
 	replayMutex sync.Mutex
+
+	// errPairIDUsed 表示PairID已记录在SQLite中，而Redis缓存中没有它
+	errPairIDUsed = errors.New("PairID已被使用")
 )
 
 // --- 核心功能 ---
 
-// InitializeReplayDefense 擦除所有旧数据，并创建一个全新的、干净的防重放系统。
+// InitializeReplayDefense 初始化防重放系统：修剪已过期的记录，并用仍然有效的记录重建Redis缓存。
+// 首次调用时会根据配置选定防重放后端。
 func InitializeReplayDefense() error {
-	fmt.Println("正在初始化防重放攻击系统...")
+	slog.Info("正在初始化防重放攻击系统...")
 
-	// 1. 擦除旧的Redis数据
-	pipe := database.RDB.Pipeline()
-	pipe.Del(database.Ctx, bloomFilterKey)
-	pipe.Del(database.Ctx, cacheSetKey)
-	if _, err := pipe.Exec(database.Ctx); err != nil {
-		return fmt.Errorf("擦除旧的Redis防重放数据失败: %w", err)
+	// 0. 选定后端
+	if activeReplayBackend == nil {
+		backend, err := selectReplayBackend(configuredReplayBackend)
+		if err != nil {
+			return err
+		}
+		activeReplayBackend = backend
+		slog.Info("防重放攻击系统后端已选定。", slog.String("backend", backend.Name()))
 	}
 
-	// 2. 擦除旧的SQLite数据
+	// 1. 迁移SQLite表结构
 	if err := database.DB.AutoMigrate(&UsedPairID{}); err != nil {
 		return fmt.Errorf("无法迁移PairID表: %w", err)
 	}
-	if err := database.DB.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&UsedPairID{}).Error; err != nil {
-		return fmt.Errorf("擦除旧的SQLite PairID表失败: %w", err)
+
+	// 2. 删除所有已过期的记录
+	if _, err := PruneExpiredPairIDs(); err != nil {
+		return err
 	}
 
-	// 3. 创建一个新的布隆过滤器
-	// BF.RESERVE [error_rate] [capacity]
-	err := database.RDB.BFReserve(database.Ctx, bloomFilterKey, bloomFilterErrorRate, bloomFilterCapacity).Err()
-	if err != nil {
-		return fmt.Errorf("创建布隆过滤器失败: %w", err)
+	// 3. 从SQLite中恢复尚未过期的记录
+	if err := RecoverReplayDefense(); err != nil {
+		return err
 	}
 
-	fmt.Println("防重放攻击系统初始化成功。")
+	slog.Info("防重放攻击系统初始化成功。")
 	return nil
 }
 
+// replayRetentionCutoff 返回记录保留的时间边界，签发时间早于此边界的PairID已无需记住
+func replayRetentionCutoff() time.Time {
+	return time.Now().Add(-tokenTTL - replayRetentionMargin)
+}
+
+// PruneExpiredPairIDs 分批删除SQLite中签发时间已超出凭证有效期的PairID记录。
+// 返回被删除的记录数。Redis中的缓存依靠TTL自动过期，无需在此处理。
+func PruneExpiredPairIDs() (int64, error) {
+	const batchSize = 5000
+
+	cutoff := replayRetentionCutoff()
+	var pruned int64
+	for {
+		expiredIDs := database.DB.Model(&UsedPairID{}).Select("pair_id").Where("issued_at < ? OR issued_at IS NULL", cutoff).Limit(batchSize)
+		result := database.DB.Where("pair_id IN (?)", expiredIDs).Delete(&UsedPairID{})
+		if result.Error != nil {
+			return pruned, fmt.Errorf("修剪过期PairID记录失败: %w", result.Error)
+		}
+		pruned += result.RowsAffected
+		if result.RowsAffected < batchSize {
+			break
+		}
+	}
+	return pruned, nil
+}
+
+// StartReplayPruner 启动一个后台Goroutine来定期修剪过期的PairID记录。
+// 接收一个lifecycle.Handle来管理其生命周期。
+func StartReplayPruner(handle *lifecycle.Handle) {
+	defer handle.Close()
+	slog.Info("PairID修剪器已启动。")
+
+	for {
+		if err := handle.Sleep(replayPruneInterval); err != nil {
+			slog.Info("PairID修剪器: 休眠被中断，正在关闭...")
+			return
+		}
+
+		pruned, err := PruneExpiredPairIDs()
+		if err != nil {
+			slog.Error("PairID修剪器错误", logging.Err(err))
+			continue
+		}
+		if pruned > 0 {
+			slog.Info("PairID修剪器: 已删除过期记录。", slog.Int64("pruned", pruned))
+		}
+	}
+}
+
 // CheckAndUsePairID 检查一个PairID是否是首次使用，如果是，则将其原子地记录到三层系统中。
 // 返回值: isReplay bool, err error
 func CheckAndUsePairID(pairID string) (bool, error) {
@@ -74,22 +133,12 @@ func CheckAndUsePairID(pairID string) (bool, error) {
 	}
 
 	// --- 只读检查 ---
-	// Tier 1: 布隆过滤器
-	existsInBF, err := database.RDB.BFExists(database.Ctx, bloomFilterKey, pairID).Result()
+	exists, err := activeReplayBackend.Contains(pairID)
 	if err != nil {
-		return false, fmt.Errorf("查询布隆过滤器失败: %w", err)
+		return false, err
 	}
-
-	if existsInBF {
-		// Tier 2: Redis Set 缓存
-		existsInSet, err := database.RDB.SIsMember(database.Ctx, cacheSetKey, pairID).Result()
-		if err != nil {
-			return false, fmt.Errorf("查询Redis Set缓存失败: %w", err)
-		}
-
-		if existsInSet {
-			return true, nil // Set缓存确认是重放
-		}
+	if exists {
+		return true, nil // 缓存确认是重放
 	}
 
 	// --- 写入逻辑 ---
@@ -105,38 +154,38 @@ func insertNewPairID(pairID string) (bool, error) {
 		return false, errors.New("服务暂时不可用，无法验证投票")
 	}
 
-	// 在持有锁之后，再次检查Set缓存，防止在等待锁的过程中ID已被其他请求插入
-	isMember, _ := database.RDB.SIsMember(database.Ctx, cacheSetKey, pairID).Result()
+	// 在持有锁之后，再次检查缓存，防止在等待锁的过程中ID已被其他请求插入
+	isMember, _ := activeReplayBackend.Contains(pairID)
 	if isMember {
 		return true, nil
 	}
 
+	issuedAt, err := pairIDTime(pairID)
+	if err != nil {
+		// PairID经过签名验证，理论上总是有效的UUIDv7；退回到当前时间以确保记录至少保留一个有效期
+		issuedAt = time.Now()
+	}
+
 	redisWriteSucceeded := false
-	var err error
 
 	const maxRetry = 3
 	const delay = 50 * time.Millisecond
 	for i := 0; i < maxRetry; i++ { // 短间隔重试
 		err = database.DB.Transaction(func(tx *gorm.DB) error {
 			// 2. 在事务中插入SQLite
-			newID := UsedPairID{PairID: pairID}
-			if err := tx.Create(&newID).Error; err != nil {
+			// 插入放在嵌套事务（保存点）中：PostgreSQL里失败的语句会使整个事务中止，主键冲突后事务将无法提交
+			newID := UsedPairID{PairID: pairID, IssuedAt: issuedAt}
+			if err := tx.Transaction(func(tx *gorm.DB) error { return tx.Create(&newID).Error }); err != nil {
 				if database.IsDuplicateKeyError(err) {
-					// 这几乎是不可能的，说明Redis中的状态曾丢失
-					// 尽管马上要触发重建了，我们可以信任SQLite
-					return nil
+					// 这说明Redis中的状态曾丢失，SQLite中的记录才是准确的：这个PairID已被使用过
+					return errPairIDUsed
 				}
 				return err
 			}
 
 			if !redisWriteSucceeded {
-				// 3. 开启Redis事务
-				pipe := database.RDB.TxPipeline()
-				pipe.BFAdd(database.Ctx, bloomFilterKey, pairID)
-				pipe.SAdd(database.Ctx, cacheSetKey, pairID)
-				_, err := pipe.Exec(database.Ctx)
-
-				if err != nil {
+				// 3. 原子地写入Redis
+				if err := activeReplayBackend.Add(pairID); err != nil {
 					// Redis失败，SQLite事务将自动回滚
 					return err
 				}
@@ -147,6 +196,16 @@ func insertNewPairID(pairID string) (bool, error) {
 		})
 		if err == nil {
 			return false, nil // 完美成功
+		} else if errors.Is(err, errPairIDUsed) {
+			if redisWriteSucceeded {
+				// 上一次尝试的提交实际上已经成功，这条记录是本次请求自己写入的
+				return false, nil
+			}
+			// 顺便把它补回Redis，后续的重放在只读检查中就能被发现
+			if err := activeReplayBackend.Add(pairID); err != nil {
+				slog.Warn("防重放: 无法把SQLite中已存在的PairID补回Redis", slog.String("pair_id", pairID), logging.Err(err))
+			}
+			return true, nil
 		} else if !database.IsRetryableError(err) {
 			break
 		}
@@ -155,7 +214,7 @@ func insertNewPairID(pairID string) (bool, error) {
 
 	if redisWriteSucceeded {
 		// 这是一个严重问题，SQLite提交失败但Redis已写入
-		fmt.Printf("严重告警: SQLite提交失败但Redis已写入, PairID: %s\n", pairID)
+		slog.Error("严重告警: SQLite提交失败但Redis已写入", slog.String("pair_id", pairID), logging.Err(err))
 		// 尽管这里出现内部不一致，应当以不存在的结果静默返回成功
 		// 如果后续Redis不崩溃，则此PairID已不可再次使用，如果后续Redis崩溃，则无法阻止此PairID被重复使用
 		return false, nil
@@ -164,36 +223,28 @@ func insertNewPairID(pairID string) (bool, error) {
 	}
 }
 
-// RecoverReplayDefense 从SQLite重建布隆过滤器和缓存
+// RecoverReplayDefense 从SQLite重建Redis中的防重放缓存，只恢复尚未过期的PairID
 func RecoverReplayDefense() error {
-	fmt.Println("正在从SQLite重建防重放攻击缓存...")
+	slog.Info("正在从SQLite重建防重放攻击缓存...")
 
 	replayMutex.Lock()
 	defer replayMutex.Unlock()
 
-	// 1. 擦除旧的Redis数据
-	pipe := database.RDB.Pipeline()
-	pipe.Del(database.Ctx, bloomFilterKey)
-	pipe.Del(database.Ctx, cacheSetKey)
-	if _, err := pipe.Exec(database.Ctx); err != nil {
-		return fmt.Errorf("擦除旧的Redis防重放数据失败: %w", err)
+	// 1-2. 擦除旧的Redis数据，并重新创建后端结构
+	if err := activeReplayBackend.Reset(); err != nil {
+		return err
 	}
 
-	// 2. 重新创建布隆过滤器
-	err := database.RDB.BFReserve(database.Ctx, bloomFilterKey, bloomFilterErrorRate, bloomFilterCapacity).Err()
-	if err != nil {
-		return fmt.Errorf("创建布隆过滤器失败: %w", err)
-	}
-
-	// 3. 从SQLite分批读取所有已存在的ID并处理
+	// 3. 从SQLite分批读取所有未过期的ID并处理
 	const batchSize = 10000
 
+	cutoff := replayRetentionCutoff()
 	pairCount := 0
 	var lastProcessedID string // 在字符串UUID上分页，按字母顺序
 	var batch []string
 
 	for i := 1; ; i++ {
-		if err := database.DB.Model(&UsedPairID{}).Where("pair_id > ?", lastProcessedID).Order("pair_id asc").Limit(batchSize).Pluck("pair_id", &batch).Error; err != nil {
+		if err := database.DB.Model(&UsedPairID{}).Where("pair_id > ? AND issued_at >= ?", lastProcessedID, cutoff).Order("pair_id asc").Limit(batchSize).Pluck("pair_id", &batch).Error; err != nil {
 			return fmt.Errorf("分批从SQLite读取PairID失败 (batch %d): %w", i, err)
 		}
 
@@ -201,17 +252,8 @@ func RecoverReplayDefense() error {
 			break
 		}
 
-		// 将string切片转换为interface{}切片
-		interfaceBatch := make([]interface{}, len(batch))
-		for j, id := range batch {
-			interfaceBatch[j] = id
-		}
-
 		// 4. 将这一批次的ID写回Redis
-		pipe := database.RDB.Pipeline()
-		pipe.SAdd(database.Ctx, cacheSetKey, interfaceBatch...)
-		pipe.BFMAdd(database.Ctx, bloomFilterKey, interfaceBatch...)
-		if _, err := pipe.Exec(database.Ctx); err != nil {
+		if err := activeReplayBackend.AddBatch(batch); err != nil {
 			return fmt.Errorf("批量写回Redis失败 (batch %d): %w", i, err)
 		}
 
@@ -224,6 +266,6 @@ func RecoverReplayDefense() error {
 		batch = batch[:0]
 	}
 
-	fmt.Printf("防重放攻击：成功从SQLite恢复了 %d 个PairID到缓存。\n", pairCount)
+	slog.Info("防重放攻击：成功从SQLite恢复了PairID到缓存。", slog.Int("count", pairCount))
 	return nil
 }
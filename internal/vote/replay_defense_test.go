@@ -0,0 +1,68 @@
+package vote
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
+	"github.com/google/uuid"
+)
+
+func TestCheckAndUsePairID(t *testing.T) {
+	setupTestEnv(t)
+	pairID := uuid.Must(uuid.NewV7()).String()
+
+	if replay, err := CheckAndUsePairID(pairID); err != nil || replay {
+		t.Fatalf("首次使用: replay=%v err=%v, 期望不是重放", replay, err)
+	}
+	if replay, err := CheckAndUsePairID(pairID); err != nil || !replay {
+		t.Fatalf("再次使用: replay=%v err=%v, 期望识别为重放", replay, err)
+	}
+
+	// 缓存丢失后，从SQLite恢复的记录仍然能识别重放
+	if err := activeReplayBackend.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if err := RecoverReplayDefense(); err != nil {
+		t.Fatal(err)
+	}
+	if replay, err := CheckAndUsePairID(pairID); err != nil || !replay {
+		t.Errorf("恢复后: replay=%v err=%v, 期望识别为重放", replay, err)
+	}
+
+	// 缓存丢失且尚未恢复时，SQLite中已有的记录同样判定为重放，并被补回缓存
+	if err := activeReplayBackend.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if replay, err := CheckAndUsePairID(pairID); err != nil || !replay {
+		t.Errorf("缓存丢失后: replay=%v err=%v, 期望识别为重放", replay, err)
+	}
+	if cached, err := activeReplayBackend.Contains(pairID); err != nil || !cached {
+		t.Errorf("缓存丢失后: cached=%v err=%v, 期望PairID被补回缓存", cached, err)
+	}
+}
+
+func TestReplayedVoteIsNotRecorded(t *testing.T) {
+	setupTestEnv(t)
+	userID := newUserID()
+	body := signedVote(t, userID, "BOMB", "DIGGER", ResultAWins)
+
+	for i := 0; i < 2; i++ {
+		// 重放的投票同样返回成功，但不会被记录
+		if w := performRequest(SubmitVote, userID, testIP, body); w.Code != http.StatusOK {
+			t.Fatalf("第 %d 次提交返回 %d: %s", i+1, w.Code, w.Body.String())
+		}
+	}
+	processQueuedVotes(t)
+
+	var count int64
+	if err := database.DB.Model(&Vote{}).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("记录了 %d 张投票, 期望 1", count)
+	}
+	if got := mustSpellStats(t)["BOMB"]; got.Total != 1 {
+		t.Errorf("BOMB: %+v, 期望只计入一次", got)
+	}
+}
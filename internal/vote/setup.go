@@ -1,64 +1,71 @@
 package vote
 
 import (
-	"encoding/json"
 	"fmt"
+	"log/slog"
 
 	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/config"
 	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
 	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/metadata"
-	"github.com/SlpAus/noita-spells-tier-backend/internal/spell"
 	"github.com/SlpAus/noita-spells-tier-backend/pkg/lifecycle"
 )
 
-func ConfigureModule(mode config.AppMode) {
+func ConfigureModule(mode config.AppMode, voteCfg config.VoteConfig) {
 	loadAlgorithmConsts(mode)
 	initHandlerMode(mode)
+	loadTokenPolicy(voteCfg)
+	loadRejectionPolicy(voteCfg)
+	loadReplayConfig(voteCfg)
+	loadChallengePolicy(voteCfg)
+	loadUndoPolicy(voteCfg)
+	loadBatchPolicy(voteCfg)
+	loadArchiveConfig(voteCfg)
 }
 
-// initializeEloTracker 从Redis获取所有法术的ELO分数，并用它们来初始化全局的eloTracker。
-func initializeEloTracker() error {
+// initializeEloBounds 从Redis获取所有法术的ELO分数，计算ELO边界并写入Redis。
+func initializeEloBounds() error {
 	// 1. 从Redis的spell:stats Hash中获取所有法术的统计数据
-	statsMapJSON, err := database.RDB.HGetAll(database.Ctx, spell.StatsKey).Result()
+	statsMap, err := activeStore.spellStats()
 	if err != nil {
 		return fmt.Errorf("无法从Redis获取法术统计数据: %w", err)
 	}
 
-	if len(statsMapJSON) == 0 {
-		fmt.Println("ELO追踪器: 无法术数据，跳过初始化。")
+	if len(statsMap) == 0 {
+		slog.Info("ELO边界: 无法术数据，跳过初始化。")
 		return nil
 	}
 
 	// 2. 提取所有的Score值
-	scores := make([]float64, 0, len(statsMapJSON))
-	for _, jsonStr := range statsMapJSON {
-		var stats spell.SpellStats
-		err := json.Unmarshal([]byte(jsonStr), &stats)
-		if err != nil {
-			return fmt.Errorf("解析从Redis获取的JSON时出错: %w", err)
-		}
+	scores := make([]float64, 0, len(statsMap))
+	for _, stats := range statsMap {
 		scores = append(scores, stats.Score)
 	}
 
-	// 3. 使用分数列表重置eloTracker
-	// 我们传入nil作为事务句柄，因为它是在单线程的启动流程中被调用的
-	return globalEloTracker.Reset(nil, scores)
+	// 3. 计算边界并写回Redis
+	bounds, err := newEloBounds(scores)
+	if err != nil {
+		return err
+	}
+	if err := activeStore.saveEloBounds(bounds); err != nil {
+		return fmt.Errorf("无法将ELO边界写入Redis: %w", err)
+	}
+	return nil
 }
 
 // PrimeModule 负责初始化vote模块的所有部分：数据库、用户同步和辅助组件。
 func PrimeModule() error {
 	// 1. 迁移自己的表结构
-	if err := database.DB.AutoMigrate(&Vote{}); err != nil {
-		return fmt.Errorf("无法迁移vote表: %w", err)
+	if err := database.DB.AutoMigrate(&Vote{}, &RejectedVote{}); err != nil {
+		return fmt.Errorf("无法迁移vote或rejected_vote表: %w", err)
 	}
-	fmt.Println("Vote数据库表迁移成功。")
+	slog.Info("Vote和RejectedVote数据库表迁移成功。")
 
-	// 2. 初始化内部辅助组件
-	if err := initializeEloTracker(); err != nil {
-		return fmt.Errorf("初始化ELO追踪器失败: %w", err)
+	// 2. 为升级前的用户快照补齐报告聚合数据
+	if err := backfillUserAggregates(); err != nil {
+		return fmt.Errorf("回填用户聚合数据失败: %w", err)
 	}
 
-	// 3. 准备Redis数据
+	// 3. 准备Redis数据，包括投票应用脚本所需的ELO边界
 	if err := RebuildAndApplyVotes(); err != nil {
 		return fmt.Errorf("初始化时: %w", err)
 	}
@@ -73,6 +80,12 @@ func StartVoteProcessor(gracefulHandle, forcefulHandle *lifecycle.Handle) error
 		return fmt.Errorf("无法获取启动Vote Processor所需的快照ID: %w", err)
 	}
 
+	newestID, err := maxVoteID(database.DB)
+	if err != nil {
+		return fmt.Errorf("无法获取当前最大投票ID: %w", err)
+	}
+	observeVoteID(newestID)
+
 	initializeProcessor(startID)
 	go startProcessor(gracefulHandle, forcefulHandle)
 
@@ -0,0 +1,381 @@
+package vote
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/achievement"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/metadata"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/spell"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/user"
+	"github.com/redis/go-redis/v9"
+)
+
+// store 抽象了vote模块对缓存层的读写，防重放缓存由 replayBackend 单独抽象。
+// 默认使用Redis，测试可以通过 UseMemoryStore 换成进程内的实现。
+// 投票的应用不经过 store：它总是由 applyVoteBatchScript 在Redis中执行，没有其他实现。
+type store interface {
+	// --- 法术统计与ELO边界 ---
+
+	// spellStats 读取全部法术的统计数据
+	spellStats() (map[string]spell.SpellStats, error)
+	// spellRanking 读取按RankScore从高到低排列的法术ID
+	spellRanking() ([]string, error)
+	// saveEloBounds 写入ELO边界
+	saveEloBounds(bounds eloBounds) error
+	// invalidateEloBounds 删除ELO边界，使 applyVoteBatch 在缓存重建完成之前拒绝应用投票
+	invalidateEloBounds() error
+
+	// --- 缓存重建 ---
+
+	// saveRebuild 原子地写回缓存重建的计算结果
+	saveRebuild(result rebuildResult) error
+
+	// --- 用户 ---
+
+	// totalStats 读取社区总统计数据，它不存在时返回错误
+	totalStats() (user.UserStats, error)
+	// userStats 读取若干用户的统计数据，不存在的用户不在结果中
+	userStats(userIDs ...string) (map[string]user.UserStats, error)
+	// userAggregates 读取若干用户的聚合数据，不存在的用户不在结果中
+	userAggregates(userIDs ...string) (map[string]user.UserAggregates, error)
+	// setUserAggregates 写入一个用户的聚合数据
+	setUserAggregates(userID string, agg user.UserAggregates) error
+	// mergeUser 原子地把sourceID的缓存合并到targetID，target为nil表示sourceID没有统计数据，只需清除
+	mergeUser(sourceID, targetID string, target *mergedUser) error
+	// forgetUser 原子地清除一个用户的全部缓存
+	forgetUser(userID string) error
+
+	// --- 近期投票计数 ---
+
+	// resetVoteCounts 删除全部近期投票计数，并写入从SQLite恢复的记录
+	resetVoteCounts(counts map[string][]voteCount) error
+	// recordVoteCount 原子地清理窗口之外的旧记录，并向每个键写入同一条新记录，返回第一个键的记录数
+	recordVoteCount(keys []string, entry voteCount, windowStart float64) (int64, error)
+	// removeVoteCount 从一个键中删除一条记录
+	removeVoteCount(key, member string) error
+	// removeVoteCountsAt 原子地从每个键中删除时间戳恰好为score的记录
+	removeVoteCountsAt(keys []string, score float64) error
+	// countVotesSince 返回每个键中时间戳不早于minScore的记录数
+	countVotesSince(keys []string, minScore float64) ([]int64, error)
+}
+
+// activeStore 是当前使用的缓存层
+var activeStore store = redisStore{}
+
+// UseRedisStore 让vote模块（包括防重放缓存）改回使用Redis缓存层，防重放后端在下次初始化时按配置重新选定，供测试在进程内的Redis上运行
+func UseRedisStore() {
+	activeStore = redisStore{}
+	activeReplayBackend = nil
+}
+
+// rebuildResult 是缓存重建时在内存中计算完成、需要一次性写回的数据
+type rebuildResult struct {
+	spellStats          map[string]spell.SpellStats
+	bounds              eloBounds
+	totalVotesIncrement float64
+	// lastProcessedID 为0表示没有处理任何增量投票，检查点保持不变
+	lastProcessedID uint
+	totalStats      user.UserStats
+	userStats       map[string]user.UserStats
+	userAggregates  map[string]user.UserAggregates
+}
+
+// mergedUser 是合并用户后目标用户的缓存数据
+type mergedUser struct {
+	stats      user.UserStats
+	aggregates user.UserAggregates
+}
+
+// voteCount 是近期投票计数中的一条记录，score是投票时间的微秒时间戳
+type voteCount struct {
+	score  float64
+	member string
+}
+
+// decodeSpellStats 解析 spell.StatsKey 中的法术统计数据
+func decodeSpellStats(raw map[string]string) (map[string]spell.SpellStats, error) {
+	stats := make(map[string]spell.SpellStats, len(raw))
+	for id, statsJSON := range raw {
+		var s spell.SpellStats
+		if err := json.Unmarshal([]byte(statsJSON), &s); err != nil {
+			return nil, fmt.Errorf("解析法术 %s 的统计数据时出错: %w", id, err)
+		}
+		stats[id] = s
+	}
+	return stats, nil
+}
+
+// decodeUserStats 解析 user.StatsKey 中的用户统计数据
+func decodeUserStats(raw map[string]string) (map[string]user.UserStats, error) {
+	stats := make(map[string]user.UserStats, len(raw))
+	for id, statsJSON := range raw {
+		var s user.UserStats
+		if err := json.Unmarshal([]byte(statsJSON), &s); err != nil {
+			return nil, fmt.Errorf("解析用户 %s 的统计数据时出错: %w", id, err)
+		}
+		stats[id] = s
+	}
+	return stats, nil
+}
+
+// decodeUserAggregates 解析 user.AggregatesKey 中的用户聚合数据
+func decodeUserAggregates(raw map[string]string) (map[string]user.UserAggregates, error) {
+	aggs := make(map[string]user.UserAggregates, len(raw))
+	for id, aggJSON := range raw {
+		agg, err := user.ParseUserAggregates(aggJSON)
+		if err != nil {
+			return nil, fmt.Errorf("解析用户 %s 的聚合数据时出错: %w", id, err)
+		}
+		aggs[id] = agg
+	}
+	return aggs, nil
+}
+
+// --- Redis ---
+
+// redisStore 是基于Redis的缓存层
+type redisStore struct{}
+
+// hmget 读取Hash中的若干字段，不存在的字段不在结果中
+func (redisStore) hmget(key string, fields []string) (map[string]string, error) {
+	raw := make(map[string]string, len(fields))
+	if len(fields) == 0 {
+		return raw, nil
+	}
+	values, err := database.RDB.HMGet(database.Ctx, key, fields...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for i, value := range values {
+		if value != nil {
+			raw[fields[i]] = value.(string)
+		}
+	}
+	return raw, nil
+}
+
+func (redisStore) spellStats() (map[string]spell.SpellStats, error) {
+	raw, err := database.RDB.HGetAll(database.Ctx, spell.StatsKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	return decodeSpellStats(raw)
+}
+
+func (redisStore) spellRanking() ([]string, error) {
+	return database.RDB.ZRevRange(database.Ctx, spell.RankingKey, 0, -1).Result()
+}
+
+func (redisStore) saveEloBounds(bounds eloBounds) error {
+	pipe := database.RDB.Pipeline()
+	bounds.save(pipe)
+	_, err := pipe.Exec(database.Ctx)
+	return err
+}
+
+func (redisStore) invalidateEloBounds() error {
+	return database.RDB.Del(database.Ctx, EloBoundsKey).Err()
+}
+
+func (redisStore) saveRebuild(result rebuildResult) error {
+	pipe := database.RDB.TxPipeline()
+
+	// a. 法术数据部分
+	newRanking := make([]redis.Z, 0, len(result.spellStats))
+	for id, stats := range result.spellStats {
+		statsJSON, _ := json.Marshal(stats)
+		pipe.HSet(database.Ctx, spell.StatsKey, id, statsJSON)
+		newRanking = append(newRanking, redis.Z{Score: stats.RankScore, Member: id})
+	}
+	pipe.ZAdd(database.Ctx, spell.RankingKey, newRanking...)
+	result.bounds.save(pipe)
+
+	// b. 元数据部分
+	if result.totalVotesIncrement > 0 {
+		pipe.IncrByFloat(database.Ctx, metadata.RedisTotalVotesKey, result.totalVotesIncrement)
+	}
+	if result.lastProcessedID > 0 {
+		pipe.Set(database.Ctx, metadata.RedisLastProcessedVoteIDKey, result.lastProcessedID, 0)
+	}
+
+	// c. 用户数据部分
+	totalStatsJSON, _ := json.Marshal(result.totalStats)
+	pipe.HSet(database.Ctx, user.StatsKey, user.TotalStatsKey, totalStatsJSON)
+
+	userStatsToWrite := make(map[string]interface{}, len(result.userStats))
+	for id, stats := range result.userStats {
+		statsJSON, _ := json.Marshal(stats)
+		userStatsToWrite[id] = statsJSON
+
+		totalVotes := stats.Wins + stats.Draw + stats.Skip
+		pipe.ZAdd(database.Ctx, user.RankingKey, redis.Z{Score: float64(totalVotes), Member: id})
+		pipe.SAdd(database.Ctx, user.DirtySetKey, id)
+		pipe.SAdd(database.Ctx, achievement.PendingSetKey, id)
+	}
+	if len(userStatsToWrite) > 0 {
+		pipe.HSet(database.Ctx, user.StatsKey, userStatsToWrite)
+	}
+
+	userAggToWrite := make(map[string]interface{}, len(result.userAggregates))
+	for id, agg := range result.userAggregates {
+		aggJSON, _ := json.Marshal(agg)
+		userAggToWrite[id] = aggJSON
+	}
+	if len(userAggToWrite) > 0 {
+		pipe.HSet(database.Ctx, user.AggregatesKey, userAggToWrite)
+	}
+
+	_, err := pipe.Exec(database.Ctx)
+	return err
+}
+
+func (redisStore) totalStats() (user.UserStats, error) {
+	var stats user.UserStats
+	totalStatsJSON, err := database.RDB.HGet(database.Ctx, user.StatsKey, user.TotalStatsKey).Result()
+	if err != nil {
+		return stats, fmt.Errorf("无法从Redis获取用户总统计数据: %w", err)
+	}
+	if err := json.Unmarshal([]byte(totalStatsJSON), &stats); err != nil {
+		return stats, fmt.Errorf("解析从Redis获取的用户总统计数据时出错: %w", err)
+	}
+	return stats, nil
+}
+
+func (s redisStore) userStats(userIDs ...string) (map[string]user.UserStats, error) {
+	raw, err := s.hmget(user.StatsKey, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	return decodeUserStats(raw)
+}
+
+func (s redisStore) userAggregates(userIDs ...string) (map[string]user.UserAggregates, error) {
+	raw, err := s.hmget(user.AggregatesKey, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	return decodeUserAggregates(raw)
+}
+
+func (redisStore) setUserAggregates(userID string, agg user.UserAggregates) error {
+	aggJSON, _ := json.Marshal(agg)
+	return database.RDB.HSet(database.Ctx, user.AggregatesKey, userID, aggJSON).Err()
+}
+
+func (redisStore) mergeUser(sourceID, targetID string, target *mergedUser) error {
+	pipe := database.RDB.TxPipeline()
+	if target != nil {
+		statsJSON, _ := json.Marshal(target.stats)
+		aggJSON, _ := json.Marshal(target.aggregates)
+		pipe.HSet(database.Ctx, user.StatsKey, targetID, statsJSON)
+		pipe.HSet(database.Ctx, user.AggregatesKey, targetID, aggJSON)
+		pipe.ZAdd(database.Ctx, user.RankingKey, redis.Z{Score: float64(target.stats.Wins + target.stats.Draw + target.stats.Skip), Member: targetID})
+		pipe.SAdd(database.Ctx, user.DirtySetKey, targetID)
+		// 合并后的统计可能达成新的成就
+		pipe.SAdd(database.Ctx, achievement.PendingSetKey, targetID)
+	}
+	pipe.HDel(database.Ctx, user.StatsKey, sourceID)
+	pipe.HDel(database.Ctx, user.AggregatesKey, sourceID)
+	pipe.ZRem(database.Ctx, user.RankingKey, sourceID)
+	pipe.SRem(database.Ctx, user.DirtySetKey, sourceID)
+	pipe.SRem(database.Ctx, achievement.PendingSetKey, sourceID)
+	pipe.ZUnionStore(database.Ctx, userVoteKeyPrefix+targetID, &redis.ZStore{
+		Keys: []string{userVoteKeyPrefix + targetID, userVoteKeyPrefix + sourceID},
+	})
+	pipe.Expire(database.Ctx, userVoteKeyPrefix+targetID, ipVoteTTL)
+	pipe.Del(database.Ctx, userVoteKeyPrefix+sourceID)
+	_, err := pipe.Exec(database.Ctx)
+	return err
+}
+
+func (redisStore) forgetUser(userID string) error {
+	pipe := database.RDB.TxPipeline()
+	pipe.HDel(database.Ctx, user.StatsKey, userID)
+	pipe.HDel(database.Ctx, user.AggregatesKey, userID)
+	pipe.ZRem(database.Ctx, user.RankingKey, userID)
+	pipe.SRem(database.Ctx, user.DirtySetKey, userID)
+	pipe.SRem(database.Ctx, achievement.PendingSetKey, userID)
+	pipe.Del(database.Ctx, userVoteKeyPrefix+userID)
+	_, err := pipe.Exec(database.Ctx)
+	return err
+}
+
+func (redisStore) resetVoteCounts(counts map[string][]voteCount) error {
+	// 1. 安全地删除所有旧的IP和用户计数记录
+	if err := deleteKeysByPrefix(database.Ctx, ipVoteKeyPrefix); err != nil {
+		return fmt.Errorf("删除旧的IP键失败: %w", err)
+	}
+	if err := deleteKeysByPrefix(database.Ctx, userVoteKeyPrefix); err != nil {
+		return fmt.Errorf("删除旧的用户计数键失败: %w", err)
+	}
+
+	// 2. 批量将记录写回Redis
+	pipe := database.RDB.Pipeline()
+	for key, entries := range counts {
+		members := make([]redis.Z, len(entries))
+		for i, entry := range entries {
+			members[i] = redis.Z{Score: entry.score, Member: entry.member}
+		}
+		pipe.ZAdd(database.Ctx, key, members...)
+		pipe.Expire(database.Ctx, key, ipVoteTTL)
+	}
+	if _, err := pipe.Exec(database.Ctx); err != nil {
+		return fmt.Errorf("批量写回IP投票数据到Redis失败: %w", err)
+	}
+	return nil
+}
+
+func (redisStore) recordVoteCount(keys []string, entry voteCount, windowStart float64) (int64, error) {
+	// 使用Redis事务(TxPipeline)来保证所有操作的原子性
+	pipe := database.RDB.TxPipeline()
+	var countCmd *redis.IntCmd
+	for i, key := range keys {
+		// a. 移除所有旧记录
+		pipe.ZRemRangeByScore(database.Ctx, key, "-inf", fmt.Sprintf("(%f", windowStart))
+		// b. 添加新记录
+		pipe.ZAdd(database.Ctx, key, redis.Z{Score: entry.score, Member: entry.member})
+		// c. 刷新过期时间
+		pipe.Expire(database.Ctx, key, ipVoteTTL)
+		// d. 获取更新后的总数
+		if i == 0 {
+			countCmd = pipe.ZCard(database.Ctx, key)
+		}
+	}
+	if _, err := pipe.Exec(database.Ctx); err != nil {
+		return 0, err
+	}
+	return countCmd.Val(), nil
+}
+
+func (redisStore) removeVoteCount(key, member string) error {
+	return database.RDB.ZRem(database.Ctx, key, member).Err()
+}
+
+func (redisStore) removeVoteCountsAt(keys []string, score float64) error {
+	s := fmt.Sprintf("%f", score)
+	pipe := database.RDB.TxPipeline()
+	for _, key := range keys {
+		pipe.ZRemRangeByScore(database.Ctx, key, s, s)
+	}
+	_, err := pipe.Exec(database.Ctx)
+	return err
+}
+
+func (redisStore) countVotesSince(keys []string, minScore float64) ([]int64, error) {
+	s := fmt.Sprintf("%f", minScore)
+	pipe := database.RDB.Pipeline()
+	cmds := make([]*redis.IntCmd, len(keys))
+	for i, key := range keys {
+		cmds[i] = pipe.ZCount(database.Ctx, key, s, "+inf")
+	}
+	if _, err := pipe.Exec(database.Ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+	counts := make([]int64, len(keys))
+	for i, cmd := range cmds {
+		counts[i] = cmd.Val()
+	}
+	return counts, nil
+}
@@ -0,0 +1,331 @@
+package vote
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/achievement"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/logging"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/memstore"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/metadata"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/spell"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/user"
+)
+
+// ReplayBackendMemory 是测试使用的进程内防重放后端，不能通过配置选择
+const ReplayBackendMemory = "memory"
+
+// memoryStore 是基于进程内存储的缓存层，键名和编码与 redisStore 相同
+type memoryStore struct {
+	db *memstore.Store
+}
+
+// UseMemoryStore 让vote模块（包括防重放缓存）改用进程内存储，供测试在没有Redis的环境下使用。
+// 投票的应用不在其中，它总是由Redis中的Lua脚本执行。
+func UseMemoryStore(db *memstore.Store) {
+	activeStore = memoryStore{db: db}
+	activeReplayBackend = memoryReplayBackend{db: db}
+}
+
+func (s memoryStore) spellStats() (stats map[string]spell.SpellStats, err error) {
+	err = s.db.Do(func(tx *memstore.Tx) error {
+		stats, err = decodeSpellStats(tx.HGetAll(spell.StatsKey))
+		return err
+	})
+	return stats, err
+}
+
+func (s memoryStore) spellRanking() ([]string, error) {
+	var spellIDs []string
+	err := s.db.Do(func(tx *memstore.Tx) error {
+		for _, z := range tx.ZRevRange(spell.RankingKey) {
+			spellIDs = append(spellIDs, z.Member)
+		}
+		return nil
+	})
+	return spellIDs, err
+}
+
+// writeEloBounds 覆盖写入ELO边界
+func writeEloBounds(tx *memstore.Tx, bounds eloBounds) {
+	for field, value := range bounds.fields() {
+		tx.HSet(EloBoundsKey, field, value)
+	}
+}
+
+func (s memoryStore) saveEloBounds(bounds eloBounds) error {
+	return s.db.Do(func(tx *memstore.Tx) error {
+		writeEloBounds(tx, bounds)
+		return nil
+	})
+}
+
+func (s memoryStore) invalidateEloBounds() error {
+	return s.db.Do(func(tx *memstore.Tx) error {
+		tx.Del(EloBoundsKey)
+		return nil
+	})
+}
+
+// writeSpellStats 写入一个法术的统计数据并更新其排名
+func writeSpellStats(tx *memstore.Tx, id string, stats spell.SpellStats) error {
+	statsJSON, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+	tx.HSet(spell.StatsKey, id, string(statsJSON))
+	tx.ZAdd(spell.RankingKey, memstore.Z{Member: id, Score: stats.RankScore})
+	return nil
+}
+
+// writeUser 写入一个用户的统计和聚合数据，更新其排名，并标记其待备份和待评估成就
+func writeUser(tx *memstore.Tx, id string, stats user.UserStats, agg user.UserAggregates) error {
+	statsJSON, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+	aggJSON, err := json.Marshal(agg)
+	if err != nil {
+		return err
+	}
+	tx.HSet(user.StatsKey, id, string(statsJSON))
+	tx.HSet(user.AggregatesKey, id, string(aggJSON))
+	tx.ZAdd(user.RankingKey, memstore.Z{Member: id, Score: float64(stats.Wins + stats.Draw + stats.Skip)})
+	tx.SAdd(user.DirtySetKey, id)
+	tx.SAdd(achievement.PendingSetKey, id)
+	return nil
+}
+
+func (s memoryStore) saveRebuild(result rebuildResult) error {
+	return s.db.Do(func(tx *memstore.Tx) error {
+		// a. 法术数据部分
+		for id, stats := range result.spellStats {
+			if err := writeSpellStats(tx, id, stats); err != nil {
+				return err
+			}
+		}
+		writeEloBounds(tx, result.bounds)
+
+		// b. 元数据部分
+		if result.totalVotesIncrement > 0 {
+			tx.IncrByFloat(metadata.RedisTotalVotesKey, result.totalVotesIncrement)
+		}
+		if result.lastProcessedID > 0 {
+			tx.Set(metadata.RedisLastProcessedVoteIDKey, strconv.FormatUint(uint64(result.lastProcessedID), 10))
+		}
+
+		// c. 用户数据部分
+		totalStatsJSON, err := json.Marshal(result.totalStats)
+		if err != nil {
+			return err
+		}
+		tx.HSet(user.StatsKey, user.TotalStatsKey, string(totalStatsJSON))
+		for id, stats := range result.userStats {
+			if err := writeUser(tx, id, stats, result.userAggregates[id]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s memoryStore) totalStats() (user.UserStats, error) {
+	var stats user.UserStats
+	err := s.db.Do(func(tx *memstore.Tx) error {
+		totalStatsJSON, ok := tx.HGet(user.StatsKey, user.TotalStatsKey)
+		if !ok {
+			return errors.New("无法从Redis获取用户总统计数据: 社区总统计数据不存在")
+		}
+		if err := json.Unmarshal([]byte(totalStatsJSON), &stats); err != nil {
+			return fmt.Errorf("解析从Redis获取的用户总统计数据时出错: %w", err)
+		}
+		return nil
+	})
+	return stats, err
+}
+
+// hmget 读取Hash中的若干字段，不存在的字段不在结果中
+func (s memoryStore) hmget(key string, fields []string) map[string]string {
+	raw := make(map[string]string, len(fields))
+	s.db.Do(func(tx *memstore.Tx) error {
+		for _, field := range fields {
+			if value, ok := tx.HGet(key, field); ok {
+				raw[field] = value
+			}
+		}
+		return nil
+	})
+	return raw
+}
+
+func (s memoryStore) userStats(userIDs ...string) (map[string]user.UserStats, error) {
+	return decodeUserStats(s.hmget(user.StatsKey, userIDs))
+}
+
+func (s memoryStore) userAggregates(userIDs ...string) (map[string]user.UserAggregates, error) {
+	return decodeUserAggregates(s.hmget(user.AggregatesKey, userIDs))
+}
+
+func (s memoryStore) setUserAggregates(userID string, agg user.UserAggregates) error {
+	aggJSON, err := json.Marshal(agg)
+	if err != nil {
+		return err
+	}
+	return s.db.Do(func(tx *memstore.Tx) error {
+		tx.HSet(user.AggregatesKey, userID, string(aggJSON))
+		return nil
+	})
+}
+
+// dropUser 删除一个用户在user模块中的全部缓存
+func dropUser(tx *memstore.Tx, userID string) {
+	tx.HDel(user.StatsKey, userID)
+	tx.HDel(user.AggregatesKey, userID)
+	tx.ZRem(user.RankingKey, userID)
+	tx.SRem(user.DirtySetKey, userID)
+	tx.SRem(achievement.PendingSetKey, userID)
+}
+
+func (s memoryStore) mergeUser(sourceID, targetID string, target *mergedUser) error {
+	return s.db.Do(func(tx *memstore.Tx) error {
+		if target != nil {
+			if err := writeUser(tx, targetID, target.stats, target.aggregates); err != nil {
+				return err
+			}
+		}
+		dropUser(tx, sourceID)
+		targetKey := userVoteKeyPrefix + targetID
+		tx.ZUnionStore(targetKey, targetKey, userVoteKeyPrefix+sourceID)
+		tx.ExpireAt(targetKey, time.Now().Add(ipVoteTTL))
+		tx.Del(userVoteKeyPrefix + sourceID)
+		return nil
+	})
+}
+
+func (s memoryStore) forgetUser(userID string) error {
+	return s.db.Do(func(tx *memstore.Tx) error {
+		dropUser(tx, userID)
+		tx.Del(userVoteKeyPrefix + userID)
+		return nil
+	})
+}
+
+func (s memoryStore) resetVoteCounts(counts map[string][]voteCount) error {
+	return s.db.Do(func(tx *memstore.Tx) error {
+		tx.DelPrefix(ipVoteKeyPrefix)
+		tx.DelPrefix(userVoteKeyPrefix)
+		expireAt := time.Now().Add(ipVoteTTL)
+		for key, entries := range counts {
+			for _, entry := range entries {
+				tx.ZAdd(key, memstore.Z{Member: entry.member, Score: entry.score})
+			}
+			tx.ExpireAt(key, expireAt)
+		}
+		return nil
+	})
+}
+
+func (s memoryStore) recordVoteCount(keys []string, entry voteCount, windowStart float64) (int64, error) {
+	var count int64
+	err := s.db.Do(func(tx *memstore.Tx) error {
+		expireAt := time.Now().Add(ipVoteTTL)
+		for i, key := range keys {
+			tx.ZRemRangeByScore(key, math.Inf(-1), memstore.Before(windowStart))
+			tx.ZAdd(key, memstore.Z{Member: entry.member, Score: entry.score})
+			tx.ExpireAt(key, expireAt)
+			if i == 0 {
+				count = tx.ZCard(key)
+			}
+		}
+		return nil
+	})
+	return count, err
+}
+
+func (s memoryStore) removeVoteCount(key, member string) error {
+	return s.db.Do(func(tx *memstore.Tx) error {
+		tx.ZRem(key, member)
+		return nil
+	})
+}
+
+func (s memoryStore) removeVoteCountsAt(keys []string, score float64) error {
+	return s.db.Do(func(tx *memstore.Tx) error {
+		for _, key := range keys {
+			tx.ZRemRangeByScore(key, score, score)
+		}
+		return nil
+	})
+}
+
+func (s memoryStore) countVotesSince(keys []string, minScore float64) ([]int64, error) {
+	counts := make([]int64, len(keys))
+	err := s.db.Do(func(tx *memstore.Tx) error {
+		for i, key := range keys {
+			counts[i] = tx.ZCount(key, minScore, math.Inf(1))
+		}
+		return nil
+	})
+	return counts, err
+}
+
+// --- 防重放缓存 ---
+
+// memoryReplayBackend 与 bucketReplayBackend 相同，将PairID记录在按时间分片的集合中
+type memoryReplayBackend struct {
+	db *memstore.Store
+}
+
+func (memoryReplayBackend) Name() string { return ReplayBackendMemory }
+
+func (b memoryReplayBackend) Reset() error {
+	return b.db.Do(func(tx *memstore.Tx) error {
+		tx.DelPrefix(pairIDBucketKeyPrefix)
+		return nil
+	})
+}
+
+func (b memoryReplayBackend) Contains(pairID string) (bool, error) {
+	slice, _, err := replaySliceFor(pairID)
+	if err != nil {
+		return false, err
+	}
+	var exists bool
+	err = b.db.Do(func(tx *memstore.Tx) error {
+		exists = tx.SIsMember(pairIDBucketKey(slice), pairID)
+		return nil
+	})
+	return exists, err
+}
+
+func (b memoryReplayBackend) Add(pairID string) error {
+	slice, expireAt, err := replaySliceFor(pairID)
+	if err != nil {
+		slog.Warn("无法为PairID确定时间分片", slog.String("pair_id", pairID), logging.Err(err))
+		return nil
+	}
+	return b.db.Do(func(tx *memstore.Tx) error {
+		tx.SAdd(pairIDBucketKey(slice), pairID)
+		tx.ExpireAt(pairIDBucketKey(slice), expireAt)
+		return nil
+	})
+}
+
+func (b memoryReplayBackend) AddBatch(pairIDs []string) error {
+	slices, expireAts := groupBySlice(pairIDs)
+	return b.db.Do(func(tx *memstore.Tx) error {
+		for slice, members := range slices {
+			key := pairIDBucketKey(slice)
+			for _, member := range members {
+				tx.SAdd(key, member.(string))
+			}
+			tx.ExpireAt(key, expireAts[slice])
+		}
+		return nil
+	})
+}
@@ -0,0 +1,162 @@
+package vote
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/config"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/spell"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/testutil"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/user"
+	"github.com/SlpAus/noita-spells-tier-backend/pkg/token"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const testIP = "203.0.113.7"
+
+func TestMain(m *testing.M) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	gin.SetMode(gin.TestMode)
+	if err := token.InitializeKeyring("", ""); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// setupTestEnv 为每个测试准备一个独立的环境（见 testutil.Setup），
+// 以及一个已完成启动流程、但不在后台运行的投票处理器。
+func setupTestEnv(t *testing.T) *testutil.Env {
+	t.Helper()
+
+	env := testutil.Setup(t, testutil.Options{
+		Configure: func() {
+			UseRedisStore()
+			ConfigureModule(config.AppModeSpell, config.VoteConfig{
+				TokenTTL:      time.Hour,
+				UndoWindow:    time.Minute,
+				BatchSize:     16,
+				ReplayBackend: ReplayBackendBucket,
+			})
+		},
+		Prime: PrimeModule,
+	})
+
+	// 重置投票处理器，投票由 processQueuedVotes 同步应用
+	globalVoteProcessor.voteChan = make(chan Vote, 1000)
+	globalVoteProcessor.isShutdown = false
+	initializeProcessor(0)
+	mergedUsersMutex.Lock()
+	mergedUsers = make(map[string]userMerge)
+	mergedUsersMutex.Unlock()
+
+	return env
+}
+
+// newUserID 生成一个有效的用户ID
+func newUserID() string {
+	return uuid.Must(uuid.NewV7()).String()
+}
+
+// performRequest 以给定的用户和IP调用一个处理器
+func performRequest(handler gin.HandlerFunc, userID, ip string, body any) *httptest.ResponseRecorder {
+	r := gin.New()
+	r.POST("/", func(c *gin.Context) {
+		if userID != "" {
+			c.Set(user.UserIDKey, userID)
+		}
+		c.Next()
+	}, handler)
+
+	var reader io.Reader = http.NoBody
+	if body != nil {
+		data, _ := json.Marshal(body)
+		reader = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/", reader)
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = ip + ":12345"
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// signedVote 构造一张带有有效凭证的投票请求
+func signedVote(t *testing.T, userID, spellA, spellB string, result VoteResult) SubmitSpellVoteRequestBody {
+	t.Helper()
+	payload := token.TokenPayload{
+		PairID:   uuid.Must(uuid.NewV7()).String(),
+		SpellAID: spellA,
+		SpellBID: spellB,
+		IssuedAt: time.Now().Add(-time.Second).UnixMilli(),
+		Mode:     string(config.AppModeSpell),
+		UserID:   userID,
+	}
+	signature, err := token.GenerateVoteSignature(payload)
+	if err != nil {
+		t.Fatalf("签名失败: %v", err)
+	}
+	return SubmitSpellVoteRequestBody{
+		SpellAID:  spellA,
+		SpellBID:  spellB,
+		Result:    result,
+		PairID:    payload.PairID,
+		IssuedAt:  payload.IssuedAt,
+		Signature: signature,
+		UserID:    userID,
+	}
+}
+
+// submitVote 通过 SubmitVote 处理器提交一张投票，并断言请求成功
+func submitVote(t *testing.T, userID, spellA, spellB string, result VoteResult) {
+	t.Helper()
+	w := performRequest(SubmitVote, userID, testIP, signedVote(t, userID, spellA, spellB, result))
+	if w.Code != http.StatusOK {
+		t.Fatalf("提交投票失败: %d %s", w.Code, w.Body.String())
+	}
+}
+
+// processQueuedVotes 代替后台的处理器，同步地批量应用队列中所有的投票
+func processQueuedVotes(t *testing.T) {
+	t.Helper()
+	vp := &globalVoteProcessor
+	for {
+		select {
+		case first := <-vp.voteChan:
+			batch := vp.collectContinuousVotes(first)
+			if err := vp.applyVoteBatch(batch); err != nil {
+				t.Fatalf("应用投票失败: %v", err)
+			}
+			vp.advanceLastProcessedVoteID(batch[len(batch)-1].ID)
+		default:
+			return
+		}
+	}
+}
+
+// mustSpellStats 读取全部法术的统计数据
+func mustSpellStats(t *testing.T) map[string]spell.SpellStats {
+	t.Helper()
+	stats, err := activeStore.spellStats()
+	if err != nil {
+		t.Fatalf("读取法术统计数据失败: %v", err)
+	}
+	return stats
+}
+
+// mustUserStats 读取一个用户的统计数据
+func mustUserStats(t *testing.T, userID string) user.UserStats {
+	t.Helper()
+	stats, err := activeStore.userStats(userID)
+	if err != nil {
+		t.Fatalf("读取用户统计数据失败: %v", err)
+	}
+	return stats[userID]
+}
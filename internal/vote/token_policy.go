@@ -0,0 +1,33 @@
+package vote
+
+import (
+	"time"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/config"
+	"github.com/SlpAus/noita-spells-tier-backend/pkg/token"
+)
+
+var (
+	// tokenTTL 是投票凭证自签发起的有效期
+	tokenTTL time.Duration
+	// minThinkTime 是从签发到提交投票之间允许的最短间隔
+	minThinkTime time.Duration
+)
+
+func loadTokenPolicy(cfg config.VoteConfig) {
+	tokenTTL = cfg.TokenTTL
+	minThinkTime = cfg.MinThinkTime
+}
+
+// checkTokenTiming 根据签发时间检查凭证是否过期或提交过快。
+// 返回空字符串表示通过检查。
+func checkTokenTiming(payload token.TokenPayload, voteTime time.Time) RejectionReason {
+	elapsed := voteTime.Sub(payload.IssuedTime())
+	if elapsed > tokenTTL {
+		return RejectExpired
+	}
+	if elapsed < minThinkTime {
+		return RejectTooFast
+	}
+	return ""
+}
@@ -0,0 +1,81 @@
+package vote
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/clientip"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/config"
+	"github.com/SlpAus/noita-spells-tier-backend/internal/platform/database"
+	"gorm.io/gorm"
+)
+
+var (
+	// undoWindow 是投票后允许撤销的时长，为0时禁用撤销
+	undoWindow time.Duration
+
+	errNothingToUndo = errors.New("没有可以撤销的投票")
+	errUndoExpired   = errors.New("投票已超过可撤销的时间")
+)
+
+func loadUndoPolicy(cfg config.VoteConfig) {
+	undoWindow = cfg.UndoWindow
+}
+
+// undoLatestVote 为用户最近的一次投票写入撤销事件。
+// 只有最近的一次投票可以撤销，且必须在undoWindow之内；已被撤销的投票不能再次撤销。
+func undoLatestVote(userID string, now time.Time) (original, undo Vote, err error) {
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		// 1. 找到用户最近的一次普通投票
+		result := tx.Where("user_identifier = ? AND undo_of_id = 0", userID).Order("id desc").Limit(1).Find(&original)
+		if result.Error != nil {
+			return fmt.Errorf("查询最近的投票失败: %w", result.Error)
+		}
+		if result.RowsAffected == 0 || original.UndoneByID != 0 {
+			return errNothingToUndo
+		}
+		if now.Sub(original.VoteTime) > undoWindow {
+			return errUndoExpired
+		}
+
+		// 2. 写入撤销事件，以负权重重放同一对法术
+		undo = Vote{
+			SpellA_ID:      original.SpellA_ID,
+			SpellB_ID:      original.SpellB_ID,
+			Result:         original.Result,
+			UserIdentifier: original.UserIdentifier,
+			UserIP:         original.UserIP,
+			Multiplier:     -original.Multiplier,
+			VoteTime:       now,
+			UndoOfID:       original.ID,
+		}
+		if err := insertVote(tx, &undo); err != nil {
+			return fmt.Errorf("写入撤销事件失败: %w", err)
+		}
+
+		// 3. 标记原投票，条件更新防止并发的重复撤销
+		marked := tx.Model(&Vote{}).Where("id = ? AND undone_by_id = 0", original.ID).Update("undone_by_id", undo.ID)
+		if marked.Error != nil {
+			return fmt.Errorf("标记被撤销的投票失败: %w", marked.Error)
+		}
+		if marked.RowsAffected != 1 {
+			return errNothingToUndo
+		}
+		return nil
+	})
+	return
+}
+
+// releaseIPVoteCount 从IP和用户的近期投票计数中移除一次投票，调用方需持有ipMutex。
+// 计数成员与投票之间没有直接关联，这里移除与投票时间戳完全相同的成员。
+func releaseIPVoteCount(ip, userID string, voteTime time.Time) error {
+	var keys []string
+	if subnet, err := clientip.SubnetKey(ip); err == nil {
+		keys = append(keys, ipVoteKeyPrefix+subnet)
+	}
+	if userID != "" {
+		keys = append(keys, userVoteKeyPrefix+userID)
+	}
+	return activeStore.removeVoteCountsAt(keys, float64(voteTime.UnixMicro()))
+}
@@ -3,6 +3,7 @@ package lifecycle
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"sync"
 	"time"
 )
@@ -41,7 +42,7 @@ func (m *Manager) NewServiceHandle(name string) (*Handle, error) {
 	}
 	m.services[name] = true
 	m.wg.Add(1)
-	fmt.Printf("生命周期管理器: 服务 [%s] 已注册。\n", name)
+	slog.Debug("生命周期管理器: 服务已注册。", slog.String("service", name))
 
 	return &Handle{
 		ctx: m.ctx,
@@ -58,7 +59,7 @@ func (m *Manager) NewServiceHandle(name string) (*Handle, error) {
 }
 
 func (m *Manager) Shutdown() {
-	fmt.Println("生命周期管理器: 广播停机信号...")
+	slog.Info("生命周期管理器: 广播停机信号...")
 	m.cancel()
 }
 
@@ -0,0 +1,57 @@
+// Package pow 实现了hashcash风格的工作量证明。
+// 客户端需要找到一个nonce，使 SHA-256(challenge + ":" + nonce) 至少有指定数量的前导零比特。
+package pow
+
+import (
+	"crypto/sha256"
+	"math/bits"
+	"strconv"
+)
+
+const (
+	// MaxDifficulty 是允许的最大难度（前导零比特数）
+	MaxDifficulty = 32
+	// maxNonceLength 限制nonce的长度，防止超大输入浪费服务器算力
+	maxNonceLength = 64
+)
+
+// digest 计算challenge和nonce拼接后的SHA-256
+func digest(challenge, nonce string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(challenge + ":" + nonce))
+}
+
+// leadingZeroBits 返回哈希值的前导零比特数
+func leadingZeroBits(sum [sha256.Size]byte) int {
+	count := 0
+	for _, b := range sum {
+		if b == 0 {
+			count += 8
+			continue
+		}
+		count += bits.LeadingZeros8(b)
+		break
+	}
+	return count
+}
+
+// Verify 检查nonce是否为challenge在给定难度下的有效解。难度不为正时总是通过。
+func Verify(challenge, nonce string, difficulty int) bool {
+	if difficulty <= 0 {
+		return true
+	}
+	if nonce == "" || len(nonce) > maxNonceLength {
+		return false
+	}
+	return leadingZeroBits(digest(challenge, nonce)) >= difficulty
+}
+
+// Solve 暴力搜索一个满足难度的nonce，供工具和调试使用。
+// 期望的尝试次数为 2^difficulty。
+func Solve(challenge string, difficulty int) string {
+	for i := uint64(0); ; i++ {
+		nonce := strconv.FormatUint(i, 10)
+		if Verify(challenge, nonce, difficulty) {
+			return nonce
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package token
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SlpAus/noita-spells-tier-backend/pkg/lifecycle"
+)
+
+// minSecretLength 是可接受的最短密钥长度（字节）
+const minSecretLength = 32
+
+// Key 是密钥环中的一把HMAC密钥。
+type Key struct {
+	// ID 是密钥的唯一标识，会被嵌入到每个签名中
+	ID string `json:"id"`
+	// Secret 是Base64(标准编码)形式的密钥内容
+	Secret string `json:"secret"`
+	// CreatedAt 是密钥的生成时间，仅供运维参考
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// KeyringFile 是密钥环在磁盘或环境变量中的JSON表示。
+// ActiveKeyID 指向用于签发新签名的密钥，其余密钥仅用于验证。
+type KeyringFile struct {
+	ActiveKeyID string `json:"activeKeyId"`
+	Keys        []Key  `json:"keys"`
+}
+
+// keyring 是运行时使用的、已解码的密钥环
+type keyring struct {
+	activeID string
+	secrets  map[string][]byte
+}
+
+var (
+	ringMu sync.RWMutex
+	ring   *keyring
+)
+
+// NewKey 生成一把新的、密码学安全的随机密钥。
+// ID 由生成日期和随机后缀组成，例如 "20250101-1a2b3c4d"。
+func NewKey() (Key, error) {
+	secret := make([]byte, minSecretLength)
+	if _, err := rand.Read(secret); err != nil {
+		return Key{}, fmt.Errorf("无法生成安全的密钥: %w", err)
+	}
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return Key{}, fmt.Errorf("无法生成密钥ID: %w", err)
+	}
+	now := time.Now().UTC()
+	return Key{
+		ID:        now.Format("20060102") + "-" + hex.EncodeToString(suffix),
+		Secret:    base64.StdEncoding.EncodeToString(secret),
+		CreatedAt: now,
+	}, nil
+}
+
+// Validate 检查密钥环的结构是否合法。
+func (kf *KeyringFile) Validate() error {
+	if len(kf.Keys) == 0 {
+		return errors.New("密钥环中没有任何密钥")
+	}
+	seen := make(map[string]bool, len(kf.Keys))
+	for _, k := range kf.Keys {
+		if k.ID == "" || strings.Contains(k.ID, ".") {
+			return fmt.Errorf("密钥ID '%s' 无效", k.ID)
+		}
+		if seen[k.ID] {
+			return fmt.Errorf("密钥ID '%s' 重复", k.ID)
+		}
+		seen[k.ID] = true
+		secret, err := base64.StdEncoding.DecodeString(k.Secret)
+		if err != nil {
+			return fmt.Errorf("无法解码密钥 '%s': %w", k.ID, err)
+		}
+		if len(secret) < minSecretLength {
+			return fmt.Errorf("密钥 '%s' 长度不足 %d 字节", k.ID, minSecretLength)
+		}
+	}
+	if !seen[kf.ActiveKeyID] {
+		return fmt.Errorf("活跃密钥 '%s' 不在密钥环中", kf.ActiveKeyID)
+	}
+	return nil
+}
+
+// Add 向密钥环中加入一把仅用于验证的新密钥。
+func (kf *KeyringFile) Add(key Key) {
+	kf.Keys = append(kf.Keys, key)
+}
+
+// Activate 将指定的密钥设为签发新签名所用的活跃密钥。
+func (kf *KeyringFile) Activate(id string) error {
+	for _, k := range kf.Keys {
+		if k.ID == id {
+			kf.ActiveKeyID = id
+			return nil
+		}
+	}
+	return fmt.Errorf("密钥 '%s' 不存在", id)
+}
+
+// Retire 从密钥环中移除一把密钥，活跃密钥不能被移除。
+// 移除后，由该密钥签发的所有凭证都将无法通过验证。
+func (kf *KeyringFile) Retire(id string) error {
+	if id == kf.ActiveKeyID {
+		return fmt.Errorf("不能移除活跃密钥 '%s'", id)
+	}
+	for i, k := range kf.Keys {
+		if k.ID == id {
+			kf.Keys = append(kf.Keys[:i], kf.Keys[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("密钥 '%s' 不存在", id)
+}
+
+// ParseKeyring 解析并校验JSON格式的密钥环。
+func ParseKeyring(data []byte) (*KeyringFile, error) {
+	var kf KeyringFile
+	if err := json.Unmarshal(data, &kf); err != nil {
+		return nil, fmt.Errorf("解析密钥环失败: %w", err)
+	}
+	if err := kf.Validate(); err != nil {
+		return nil, err
+	}
+	return &kf, nil
+}
+
+// LoadKeyringFile 从磁盘读取并校验密钥环文件。
+func LoadKeyringFile(path string) (*KeyringFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("无法读取密钥环文件 %s: %w", path, err)
+	}
+	return ParseKeyring(data)
+}
+
+// SaveKeyringFile 将密钥环原子地写入磁盘（先写临时文件再重命名），文件权限为0600。
+func SaveKeyringFile(path string, kf *KeyringFile) error {
+	if err := kf.Validate(); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(kf, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化密钥环失败: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("无法创建临时文件: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return fmt.Errorf("无法设置密钥环文件权限: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("写入密钥环失败: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("写入密钥环失败: %w", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// InstallKeyring 将一个已校验的密钥环设为全局使用的密钥环。
+func InstallKeyring(kf *KeyringFile) error {
+	if err := kf.Validate(); err != nil {
+		return err
+	}
+	newRing := &keyring{
+		activeID: kf.ActiveKeyID,
+		secrets:  make(map[string][]byte, len(kf.Keys)),
+	}
+	for _, k := range kf.Keys {
+		secret, _ := base64.StdEncoding.DecodeString(k.Secret)
+		newRing.secrets[k.ID] = secret
+	}
+
+	ringMu.Lock()
+	ring = newRing
+	ringMu.Unlock()
+	return nil
+}
+
+// InitializeKeyring 在应用启动时加载签名密钥。
+// 优先使用内联的JSON密钥环（通常来自环境变量），其次使用密钥环文件。
+// 两者都未配置时，退回到生成一把临时密钥，此时重启后所有旧凭证都会失效。
+func InitializeKeyring(inlineJSON, path string) error {
+	var kf *KeyringFile
+	var err error
+	switch {
+	case inlineJSON != "":
+		kf, err = ParseKeyring([]byte(inlineJSON))
+		if err != nil {
+			return fmt.Errorf("内联密钥环无效: %w", err)
+		}
+		slog.Info("HMAC密钥环已从内联配置加载。")
+	case path != "":
+		kf, err = LoadKeyringFile(path)
+		if err != nil {
+			return err
+		}
+		slog.Info("HMAC密钥环已从文件加载。", slog.String("path", path))
+	default:
+		key, err := NewKey()
+		if err != nil {
+			return err
+		}
+		kf = &KeyringFile{ActiveKeyID: key.ID, Keys: []Key{key}}
+		slog.Warn("未配置HMAC密钥环，已生成临时密钥。重启后所有已签发的凭证都将失效。")
+	}
+
+	if err := InstallKeyring(kf); err != nil {
+		return err
+	}
+	slog.Info("HMAC密钥环就绪。", slog.String("active_key_id", kf.ActiveKeyID), slog.Int("keys", len(kf.Keys)))
+	return nil
+}
+
+// activeKey 返回当前用于签名的密钥ID和密钥内容。
+func activeKey() (string, []byte, error) {
+	ringMu.RLock()
+	defer ringMu.RUnlock()
+	if ring == nil {
+		return "", nil, errors.New("HMAC密钥环尚未初始化")
+	}
+	return ring.activeID, ring.secrets[ring.activeID], nil
+}
+
+// verificationKey 返回指定ID的验证密钥。
+func verificationKey(id string) ([]byte, bool) {
+	ringMu.RLock()
+	defer ringMu.RUnlock()
+	if ring == nil {
+		return nil, false
+	}
+	secret, ok := ring.secrets[id]
+	return secret, ok
+}
+
+// StartKeyringWatcher 定期检查密钥环文件的修改时间，并在文件变化后重新加载。
+// 多实例部署时，各实例通过共享同一份密钥环文件来完成轮换。
+// 接收一个lifecycle.Handle来管理其生命周期。
+func StartKeyringWatcher(handle *lifecycle.Handle, path string, interval time.Duration) {
+	defer handle.Close()
+
+	var lastModTime time.Time
+	if info, err := os.Stat(path); err == nil {
+		lastModTime = info.ModTime()
+	}
+	slog.Info("HMAC密钥环监视器已启动。")
+
+	for {
+		if err := handle.Sleep(interval); err != nil {
+			slog.Info("密钥环监视器: 休眠被中断，正在关闭...")
+			return
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			slog.Warn("密钥环监视器: 无法访问密钥环文件", slog.String("path", path), slog.Any("error", err))
+			continue
+		}
+		if info.ModTime().Equal(lastModTime) {
+			continue
+		}
+
+		kf, err := LoadKeyringFile(path)
+		if err != nil {
+			// 保留旧的密钥环继续服务
+			slog.Error("密钥环监视器: 重新加载失败，继续使用旧密钥环", slog.Any("error", err))
+			continue
+		}
+		if err := InstallKeyring(kf); err != nil {
+			slog.Error("密钥环监视器: 安装新密钥环失败", slog.Any("error", err))
+			continue
+		}
+		lastModTime = info.ModTime()
+		slog.Info("密钥环监视器: 已重新加载密钥环。", slog.String("active_key_id", kf.ActiveKeyID), slog.Int("keys", len(kf.Keys)))
+	}
+}
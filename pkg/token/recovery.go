@@ -0,0 +1,86 @@
+package token
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// recoveryDomain 用于区分恢复码和投票凭证的签名，防止一种签名被当作另一种使用
+const recoveryDomain = "recovery:"
+
+// recoveryNonceSize 是恢复码中随机数的字节数
+const recoveryNonceSize = 16
+
+// RecoveryClaims 是恢复码中经过签名的内容
+type RecoveryClaims struct {
+	UserID string
+	// Nonce 是签发时随机生成的，使同一用户每次获得的恢复码都不同，服务端以它记录和撤销恢复码
+	Nonce     string
+	ExpiresAt time.Time
+}
+
+// computeRecoveryMAC 计算恢复码内容的HMAC
+func computeRecoveryMAC(claims RecoveryClaims, secret []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(recoveryDomain + claims.UserID + signatureSeparator + claims.Nonce + signatureSeparator + strconv.FormatInt(claims.ExpiresAt.Unix(), 10)))
+	return mac.Sum(nil)
+}
+
+// GenerateRecoveryCode 为一个用户ID生成签名的恢复码，
+// 形如 "<userID>.<nonce>.<过期时间的Unix秒数>.<keyID>.<Base64编码的HMAC>"。
+// 签名只保证恢复码未被篡改，它是否仍可兑换还取决于服务端保存的签发记录。
+// 恢复码在签发它的密钥被退役后同样失效。
+func GenerateRecoveryCode(userID string, expiresAt time.Time) (string, RecoveryClaims, error) {
+	keyID, secret, err := activeKey()
+	if err != nil {
+		return "", RecoveryClaims{}, err
+	}
+	nonce := make([]byte, recoveryNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", RecoveryClaims{}, err
+	}
+	claims := RecoveryClaims{
+		UserID:    userID,
+		Nonce:     base64.RawURLEncoding.EncodeToString(nonce),
+		ExpiresAt: time.Unix(expiresAt.Unix(), 0),
+	}
+	signature := base64.RawURLEncoding.EncodeToString(computeRecoveryMAC(claims, secret))
+	code := strings.Join([]string{claims.UserID, claims.Nonce, strconv.FormatInt(claims.ExpiresAt.Unix(), 10), keyID, signature}, signatureSeparator)
+	return code, claims, nil
+}
+
+// ParseRecoveryCode 验证恢复码的签名和有效期，并返回其中的内容。
+func ParseRecoveryCode(code string, now time.Time) (RecoveryClaims, bool) {
+	parts := strings.Split(strings.TrimSpace(code), signatureSeparator)
+	if len(parts) != 5 {
+		return RecoveryClaims{}, false
+	}
+	userID, nonce, expiresStr, keyID, signatureB64 := parts[0], parts[1], parts[2], parts[3], parts[4]
+
+	expiresUnix, err := strconv.ParseInt(expiresStr, 10, 64)
+	if err != nil {
+		return RecoveryClaims{}, false
+	}
+	claims := RecoveryClaims{UserID: userID, Nonce: nonce, ExpiresAt: time.Unix(expiresUnix, 0)}
+	if !now.Before(claims.ExpiresAt) {
+		return RecoveryClaims{}, false
+	}
+
+	secret, ok := verificationKey(keyID)
+	if !ok {
+		return RecoveryClaims{}, false
+	}
+	actualSignature, err := base64.RawURLEncoding.DecodeString(signatureB64)
+	if err != nil {
+		return RecoveryClaims{}, false
+	}
+	if !hmac.Equal(computeRecoveryMAC(claims, secret), actualSignature) {
+		return RecoveryClaims{}, false
+	}
+	return claims, true
+}
@@ -2,16 +2,16 @@ package token
 
 import (
 	"crypto/hmac"
-	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
-	"fmt"
+	"strings"
+	"time"
 )
 
-// secretKey 是一个全局变量，用于存储服务器在启动时生成的32字节密钥。
-var secretKey []byte
+// signatureSeparator 分隔签名中的密钥ID和HMAC值: "<keyID>.<base64(hmac)>"
+const signatureSeparator = "."
 
 // TokenPayload 定义了需要被签名的数据结构。
 // 它将在 /pair 请求的响应中和 /vote 请求的请求体中被序列化和反序列化。
@@ -19,50 +19,66 @@ type TokenPayload struct {
 	PairID   string `json:"p"`
 	SpellAID string `json:"a"`
 	SpellBID string `json:"b"`
+	IssuedAt int64  `json:"t"` // 签发时间 (Unix毫秒)
+	Mode     string `json:"m"` // 签发时的应用模式，防止凭证跨模式使用
+	UserID   string `json:"u"` // 请求者的用户ID，匿名用户为空字符串
+	// Difficulty 是投票时必须附带的工作量证明难度（前导零比特数），为0时无需证明
+	Difficulty int `json:"d,omitempty"`
 }
 
-// GenerateSecretKey 生成一个密码学安全的32字节随机密钥。
-func GenerateSecretKey() {
-	key := make([]byte, 32)
-	_, err := rand.Read(key)
+// IssuedTime 返回凭证的签发时间。
+func (p TokenPayload) IssuedTime() time.Time {
+	return time.UnixMilli(p.IssuedAt)
+}
+
+// computeMAC 使用给定的密钥计算payload的HMAC-SHA256。
+func computeMAC(payload TokenPayload, secret []byte) ([]byte, error) {
+	payloadBytes, err := json.Marshal(payload)
 	if err != nil {
-		panic("无法生成安全的密钥: " + err.Error())
+		return nil, errors.New("无法序列化Token payload")
 	}
-	secretKey = key
-	fmt.Println("HMAC密钥已成功生成。")
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payloadBytes)
+	return mac.Sum(nil), nil
 }
 
-// GenerateVoteSignature 为一个给定的TokenPayload生成一个HMAC签名。
-// 它返回的是签名的Base64编码字符串。
+// GenerateVoteSignature 使用当前的活跃密钥为一个给定的TokenPayload生成HMAC签名。
+// 它返回的签名形如 "<keyID>.<Base64编码的HMAC>"，以便在密钥轮换后仍能找到对应的验证密钥。
 func GenerateVoteSignature(payload TokenPayload) (string, error) {
-	// 1. 将payload序列化为JSON字符串
-	payloadBytes, err := json.Marshal(payload)
+	// 1. 获取活跃密钥
+	keyID, secret, err := activeKey()
 	if err != nil {
-		return "", errors.New("无法序列化Token payload")
+		return "", err
 	}
 
 	// 2. 使用HMAC-SHA256和密钥对payload进行签名
-	mac := hmac.New(sha256.New, secretKey)
-	mac.Write(payloadBytes)
-	signature := mac.Sum(nil)
+	signature, err := computeMAC(payload, secret)
+	if err != nil {
+		return "", err
+	}
 
-	// 3. 对签名进行Base64编码，并返回
-	encodedSignature := base64.RawURLEncoding.EncodeToString(signature)
-	return encodedSignature, nil
+	// 3. 对签名进行Base64编码，并附上密钥ID
+	return keyID + signatureSeparator + base64.RawURLEncoding.EncodeToString(signature), nil
 }
 
 // ValidateVoteSignature 验证一个给定的payload和签名是否匹配。
-func ValidateVoteSignature(payload TokenPayload, signatureB64 string) bool {
-	// 1. 将传入的payload重新序列化，以确保与签名时的数据格式完全一致
-	payloadBytes, err := json.Marshal(payload)
-	if err != nil {
-		return false // 如果序列化失败，则验证失败
+// 签名中的密钥ID必须指向密钥环中仍然有效的某把验证密钥。
+func ValidateVoteSignature(payload TokenPayload, signature string) bool {
+	// 1. 拆分密钥ID和HMAC值，并找到对应的验证密钥
+	keyID, signatureB64, found := strings.Cut(signature, signatureSeparator)
+	if !found {
+		return false
+	}
+	secret, ok := verificationKey(keyID)
+	if !ok {
+		return false // 未知或已退役的密钥
 	}
 
 	// 2. 重新计算预期的签名
-	mac := hmac.New(sha256.New, secretKey)
-	mac.Write(payloadBytes)
-	expectedSignature := mac.Sum(nil)
+	expectedSignature, err := computeMAC(payload, secret)
+	if err != nil {
+		return false // 如果序列化失败，则验证失败
+	}
 
 	// 3. 解码前端传来的签名
 	actualSignature, err := base64.RawURLEncoding.DecodeString(signatureB64)